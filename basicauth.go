@@ -0,0 +1,38 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// unauthenticatedPaths stay reachable without credentials, so liveness and
+// readiness probes keep working with basic auth
+var unauthenticatedPaths = map[string]bool{
+	"/health": true,
+	"/ready":  true,
+}
+
+// basicAuth requires username and a password matching the bcrypt passwordHash
+// on all requests to next except unauthenticatedPaths
+func basicAuth(username string, passwordHash []byte, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unauthenticatedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Check the password even for a wrong username, so the response time
+		// does not tell whether the username exists
+		user, password, ok := r.BasicAuth()
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+		passwordOK := bcrypt.CompareHashAndPassword(passwordHash, []byte(password)) == nil
+		if !ok || !userOK || !passwordOK {
+			w.Header().Set("WWW-Authenticate", `Basic realm="sonnenbatterie-exporter", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword() error = %v", err)
+	}
+	mux := http.NewServeMux()
+	for _, path := range []string{"/metrics", "/debug", "/health", "/ready"} {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("OK"))
+		})
+	}
+	handler := basicAuth("prometheus", hash, mux)
+
+	tests := []struct {
+		name       string
+		path       string
+		username   string
+		password   string
+		noAuth     bool
+		wantStatus int
+	}{
+		{name: "correct credentials", path: "/metrics", username: "prometheus", password: "s3cret", wantStatus: http.StatusOK},
+		{name: "debug endpoint", path: "/debug", username: "prometheus", password: "s3cret", wantStatus: http.StatusOK},
+		{name: "wrong password", path: "/metrics", username: "prometheus", password: "guess", wantStatus: http.StatusUnauthorized},
+		{name: "wrong username", path: "/metrics", username: "admin", password: "s3cret", wantStatus: http.StatusUnauthorized},
+		{name: "no credentials", path: "/debug", noAuth: true, wantStatus: http.StatusUnauthorized},
+		{name: "health is exempt", path: "/health", noAuth: true, wantStatus: http.StatusOK},
+		{name: "ready is exempt", path: "/ready", noAuth: true, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if !tt.noAuth {
+				req.SetBasicAuth(tt.username, tt.password)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			challenge := rec.Header().Get("WWW-Authenticate")
+			if (tt.wantStatus == http.StatusUnauthorized) != (challenge != "") {
+				t.Errorf("WWW-Authenticate = %q with status %d", challenge, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Set at build time via -ldflags "-X main.version=... -X main.revision=..."
+var (
+	version  = "dev"
+	revision = "unknown"
+)
+
+// buildInfoCollector exposes the exporter version, so version skew across a
+// fleet of exporters is visible
+type buildInfoCollector struct {
+	desc *prometheus.Desc
+}
+
+func newBuildInfoCollector() *buildInfoCollector {
+	return &buildInfoCollector{
+		desc: prometheus.NewDesc(
+			"sonnenbatterie_exporter_build_info",
+			"Exporter build information (always 1)",
+			[]string{"version", "revision", "goversion"},
+			nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *buildInfoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector
+func (c *buildInfoCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1, version, revision, runtime.Version())
+}
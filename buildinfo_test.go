@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestBuildInfoCollector(t *testing.T) {
+	oldVersion, oldRevision := version, revision
+	t.Cleanup(func() { version, revision = oldVersion, oldRevision })
+	version, revision = "1.2.3", "abc1234"
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(newBuildInfoCollector())
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+
+	if len(families) != 1 || families[0].GetName() != "sonnenbatterie_exporter_build_info" {
+		t.Fatalf("Gather() = %v, want only sonnenbatterie_exporter_build_info", families)
+	}
+	metrics := families[0].GetMetric()
+	if len(metrics) != 1 {
+		t.Fatalf("got %d series, want 1", len(metrics))
+	}
+
+	m := metrics[0]
+	if m.GetGauge().GetValue() != 1 {
+		t.Errorf("value = %f, want 1", m.GetGauge().GetValue())
+	}
+	want := map[string]string{"version": "1.2.3", "revision": "abc1234"}
+	for name, value := range want {
+		if got := labelValue(m, name); got != value {
+			t.Errorf("label %s = %q, want %q", name, got, value)
+		}
+	}
+	if labelValue(m, "goversion") == "" {
+		t.Error("goversion label is empty")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import "log"
+
+const (
+	capacityUnitWh  = "wh"
+	capacityUnitMWh = "mwh"
+
+	// mWhThreshold is the FullChargeCapacity above which the value is taken
+	// as milliwatt-hours; no home battery holds 100 kWh
+	mWhThreshold = 100000
+)
+
+// fullChargeCapacityWh converts a FullChargeCapacity reading to watt-hours.
+// Some models report the field in milliwatt-hours, so unless unit overrides
+// it, readings above mWhThreshold are taken as mWh. It also returns the unit
+// the reading was interpreted in.
+func fullChargeCapacityWh(reading int, unit string) (int, string) {
+	if unit == "" {
+		unit = capacityUnitWh
+		if reading > mWhThreshold {
+			unit = capacityUnitMWh
+		}
+	}
+	if unit == capacityUnitMWh {
+		return reading / 1000, unit
+	}
+	return reading, unit
+}
+
+// normalizeCapacity converts the FullChargeCapacity of latestData to
+// watt-hours in place and logs the interpretation the first time it is made
+// and whenever it changes
+func (c *Collector) normalizeCapacity(battery Battery, latestData *LatestData) {
+	reading := latestData.FullChargeCapacity
+	wh, unit := fullChargeCapacityWh(reading, battery.CapacityUnit)
+	latestData.FullChargeCapacity = wh
+
+	c.mu.Lock()
+	state := c.batteryState(battery.Name)
+	changed := state.capacityUnit != unit
+	state.capacityUnit = unit
+	c.mu.Unlock()
+
+	if changed {
+		source := "detected"
+		if battery.CapacityUnit != "" {
+			source = "configured"
+		}
+		log.Printf("Reading FullChargeCapacity of %s in %s (%s, %d reported, %d Wh)", battery.Name, unit, source, reading, wh)
+	}
+}
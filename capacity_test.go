@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestFullChargeCapacityWh(t *testing.T) {
+	tests := []struct {
+		name     string
+		reading  int
+		unit     string
+		wantWh   int
+		wantUnit string
+	}{
+		{name: "watt-hours", reading: 4882, wantWh: 4882, wantUnit: "wh"},
+		{name: "milliwatt-hours", reading: 9530000, wantWh: 9530, wantUnit: "mwh"},
+		{name: "threshold is watt-hours", reading: 100000, wantWh: 100000, wantUnit: "wh"},
+		{name: "not reported", reading: 0, wantWh: 0, wantUnit: "wh"},
+		{name: "override wh", reading: 150000, unit: "wh", wantWh: 150000, wantUnit: "wh"},
+		{name: "override mwh", reading: 95300, unit: "mwh", wantWh: 95, wantUnit: "mwh"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh, unit := fullChargeCapacityWh(tt.reading, tt.unit)
+			if wh != tt.wantWh || unit != tt.wantUnit {
+				t.Errorf("fullChargeCapacityWh(%d, %q) = %d, %q, want %d, %q", tt.reading, tt.unit, wh, unit, tt.wantWh, tt.wantUnit)
+			}
+		})
+	}
+}
+
+func TestCollector_FullChargeCapacityUnit(t *testing.T) {
+	tests := []struct {
+		name    string
+		reading int
+		unit    string
+		want    float64
+	}{
+		{name: "eco 8 in Wh", reading: 4882, want: 4882},
+		{name: "hybrid 9.53 in mWh", reading: 9530000, want: 9530},
+		{name: "override", reading: 95300, unit: "mwh", want: 95},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMockBatteryServer(&LatestData{FullChargeCapacity: tt.reading}, &Status{})
+			defer server.Close()
+
+			collector := NewCollector(
+				[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token", CapacityUnit: tt.unit}},
+				CollectorOptions{},
+			)
+
+			got := -1.0
+			for _, m := range collectAll(collector) {
+				if m.Desc() == collector.fullChargeCapacity {
+					got = writeMetric(t, m).GetGauge().GetValue()
+				}
+			}
+			if got != tt.want {
+				t.Errorf("full charge capacity = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const cardinalityLimitExceeded = "__cardinality_limit_exceeded__"
+
+// CardinalityGuard limits how many distinct label value combinations are
+// exported per metric, protecting Prometheus from API values that change on
+// every scrape
+type CardinalityGuard struct {
+	limit int // Maximum combinations per metric, 0 disables the guard
+
+	mu   sync.Mutex
+	seen map[string]map[string]struct{}
+
+	exceeded *prometheus.CounterVec
+}
+
+// NewCardinalityGuard creates a guard allowing up to limit label value
+// combinations per metric
+func NewCardinalityGuard(limit int) *CardinalityGuard {
+	return &CardinalityGuard{
+		limit: limit,
+		seen:  make(map[string]map[string]struct{}),
+		exceeded: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_cardinality_limit_exceeded_total",
+				Help: "Number of times label values were replaced because a metric reached the label value limit",
+			},
+			[]string{"metric"},
+		),
+	}
+}
+
+// Check returns values unchanged if the combination is already known or fits
+// within the limit for metric. Otherwise every value is replaced with a
+// placeholder so all excess combinations collapse into a single series.
+func (g *CardinalityGuard) Check(metric string, values ...string) []string {
+	if g.limit <= 0 {
+		return values
+	}
+
+	key := strings.Join(values, "\xff")
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	combinations, ok := g.seen[metric]
+	if !ok {
+		combinations = make(map[string]struct{})
+		g.seen[metric] = combinations
+	}
+	if _, ok := combinations[key]; ok {
+		return values
+	}
+	if len(combinations) < g.limit {
+		combinations[key] = struct{}{}
+		return values
+	}
+
+	g.exceeded.WithLabelValues(metric).Inc()
+	replaced := make([]string, len(values))
+	for i := range replaced {
+		replaced[i] = cardinalityLimitExceeded
+	}
+	return replaced
+}
+
+// Describe implements prometheus.Collector
+func (g *CardinalityGuard) Describe(ch chan<- *prometheus.Desc) {
+	g.exceeded.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (g *CardinalityGuard) Collect(ch chan<- prometheus.Metric) {
+	g.exceeded.Collect(ch)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCardinalityGuard_Check(t *testing.T) {
+	guard := NewCardinalityGuard(50)
+
+	for i := 0; i < 100; i++ {
+		state := fmt.Sprintf("state-%d", i)
+		got := guard.Check("sonnenbatterie_info", state, "running")
+
+		want := state
+		if i >= 50 {
+			want = cardinalityLimitExceeded
+		}
+		if got[0] != want {
+			t.Fatalf("Check() value %d = %q, want %q", i, got[0], want)
+		}
+	}
+
+	if got := testutil.ToFloat64(guard.exceeded.WithLabelValues("sonnenbatterie_info")); got != 50 {
+		t.Errorf("limit exceeded counter = %f, want 50", got)
+	}
+
+	// Known combinations keep passing and other metrics have their own budget
+	if got := guard.Check("sonnenbatterie_info", "state-0", "running"); got[0] != "state-0" {
+		t.Errorf("Check() known combination = %q, want state-0", got[0])
+	}
+	if got := guard.Check("sonnenbatterie_state_labels", "state-99", "running"); got[0] != "state-99" {
+		t.Errorf("Check() other metric = %q, want state-99", got[0])
+	}
+}
+
+func TestCardinalityGuard_Disabled(t *testing.T) {
+	guard := NewCardinalityGuard(0)
+
+	for i := 0; i < 100; i++ {
+		state := fmt.Sprintf("state-%d", i)
+		if got := guard.Check("sonnenbatterie_info", state); got[0] != state {
+			t.Fatalf("Check() with guard disabled = %q, want %q", got[0], state)
+		}
+	}
+}
+
+func TestCollector_CardinalityLimit(t *testing.T) {
+	latestData := &LatestData{ICStatus: ICStatus{StateInverter: "running"}}
+	server := newMockBatteryServer(latestData, &Status{})
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{MaxLabelValues: 50},
+	)
+
+	// Simulate a BMS reporting a new state on every scrape
+	for i := 0; i < 100; i++ {
+		latestData.ICStatus.StateBMS = fmt.Sprintf("state-%d", i)
+		collectAll(collector)
+	}
+
+	for _, metric := range []string{"sonnenbatterie_state_labels", "sonnenbatterie_info"} {
+		if got := testutil.ToFloat64(collector.guard.exceeded.WithLabelValues(metric)); got != 50 {
+			t.Errorf("limit exceeded counter for %s = %f, want 50", metric, got)
+		}
+	}
+}
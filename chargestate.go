@@ -0,0 +1,49 @@
+package main
+
+import "time"
+
+// chargeStates lists the charge states emitted every scrape
+var chargeStates = []string{"charging", "discharging", "idle"}
+
+// chargeState resolves the charging and discharging flags into one of
+// chargeStates. Firmware occasionally reports both flags at once; the sign of
+// the battery power decides then, negative while charging and positive while
+// discharging, and mismatch is set.
+func chargeState(status *Status) (state string, mismatch bool) {
+	switch {
+	case status.BatteryCharging && status.BatteryDischarging:
+		switch {
+		case status.PacTotalW < 0:
+			return "charging", true
+		case status.PacTotalW > 0:
+			return "discharging", true
+		default:
+			return "idle", true
+		}
+	case status.BatteryCharging:
+		return "charging", false
+	case status.BatteryDischarging:
+		return "discharging", false
+	default:
+		return "idle", false
+	}
+}
+
+// collectChargeStateMismatch counts scrapes reporting charging and
+// discharging at the same time
+func (c *Collector) collectChargeStateMismatch(battery Battery, status *Status) {
+	if _, mismatch := chargeState(status); mismatch {
+		c.chargeStateMismatches.WithLabelValues(battery.Name).Inc()
+	}
+}
+
+// collectTimeInMode adds the interval since the previous successful scrape to
+// the time in the current charge state. As with the off-grid time, intervals
+// spanning a failed scrape are unknown and not counted.
+func (c *Collector) collectTimeInMode(battery Battery, status *Status, elapsed time.Duration) {
+	if elapsed <= 0 {
+		return
+	}
+	state, _ := chargeState(status)
+	c.timeInMode.WithLabelValues(battery.Name, state).Add(elapsed.Seconds())
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestChargeState(t *testing.T) {
+	tests := []struct {
+		name         string
+		charging     bool
+		discharging  bool
+		pacW         float64
+		wantState    string
+		wantMismatch bool
+	}{
+		{name: "charging", charging: true, pacW: -1500, wantState: "charging"},
+		{name: "discharging", discharging: true, pacW: 800, wantState: "discharging"},
+		{name: "idle", wantState: "idle"},
+		// Without a contradiction the flags win over the power sign
+		{name: "idle with standby power", pacW: 12, wantState: "idle"},
+		{name: "both set while charging", charging: true, discharging: true, pacW: -1500, wantState: "charging", wantMismatch: true},
+		{name: "both set while discharging", charging: true, discharging: true, pacW: 800, wantState: "discharging", wantMismatch: true},
+		{name: "both set without power", charging: true, discharging: true, wantState: "idle", wantMismatch: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, mismatch := chargeState(&Status{BatteryCharging: tt.charging, BatteryDischarging: tt.discharging, PacTotalW: tt.pacW})
+			if state != tt.wantState || mismatch != tt.wantMismatch {
+				t.Errorf("chargeState() = %q, %v, want %q, %v", state, mismatch, tt.wantState, tt.wantMismatch)
+			}
+		})
+	}
+}
+
+func TestCollector_ChargeState(t *testing.T) {
+	status := &Status{}
+	server := newMockBatteryServer(&LatestData{}, status)
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+
+	steps := []struct {
+		charging     bool
+		discharging  bool
+		pacW         float64
+		wantState    string
+		wantMismatch float64
+	}{
+		{charging: true, pacW: -1500, wantState: "charging", wantMismatch: 0},
+		{charging: true, discharging: true, pacW: 800, wantState: "discharging", wantMismatch: 1},
+		{wantState: "idle", wantMismatch: 1},
+		{charging: true, discharging: true, pacW: -200, wantState: "charging", wantMismatch: 2},
+	}
+	for i, step := range steps {
+		status.BatteryCharging, status.BatteryDischarging, status.PacTotalW = step.charging, step.discharging, step.pacW
+
+		values := map[string]float64{}
+		for _, m := range collectAll(collector) {
+			if m.Desc() == collector.chargeState {
+				pb := writeMetric(t, m)
+				values[labelValue(pb, "state")] = pb.GetGauge().GetValue()
+			}
+		}
+		if len(values) != len(chargeStates) {
+			t.Fatalf("step %d: emitted %d charge states, want %d", i, len(values), len(chargeStates))
+		}
+		for state, value := range values {
+			if want := boolToFloat(state == step.wantState); value != want {
+				t.Errorf("step %d: state %q = %v, want %v", i, state, value, want)
+			}
+		}
+		if got := testutil.ToFloat64(collector.chargeStateMismatches.WithLabelValues("test-battery")); got != step.wantMismatch {
+			t.Errorf("step %d: mismatches = %v, want %v", i, got, step.wantMismatch)
+		}
+	}
+}
+
+func TestCollector_TimeInMode(t *testing.T) {
+	status := &Status{}
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v2/latestdata":
+			_ = json.NewEncoder(w).Encode(LatestData{})
+		case "/api/v2/status":
+			_ = json.NewEncoder(w).Encode(status)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+	now := time.Date(2025, 11, 29, 12, 0, 0, 0, time.UTC)
+	collector.now = func() time.Time { return now }
+
+	steps := []struct {
+		advance time.Duration
+		mode    string
+		fail    bool
+	}{
+		// The first scrape has no interval yet
+		{0, "charging", false},
+		{time.Minute, "charging", false},
+		{time.Minute, "discharging", false},
+		{2 * time.Minute, "charging", false},
+		{time.Minute, "discharging", false},
+		{30 * time.Second, "idle", false},
+		// The interval spanning a failure is not counted
+		{time.Minute, "idle", true},
+		{time.Minute, "discharging", false},
+		{time.Minute, "discharging", false},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		status.BatteryCharging = step.mode == "charging"
+		status.BatteryDischarging = step.mode == "discharging"
+		failing = step.fail
+		collectAll(collector)
+	}
+
+	for mode, want := range map[string]float64{"charging": 180, "discharging": 180, "idle": 30} {
+		if got := testutil.ToFloat64(collector.timeInMode.WithLabelValues("test-battery", mode)); got != want {
+			t.Errorf("time in %s = %v, want %v", mode, got, want)
+		}
+	}
+}
@@ -1,54 +1,285 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/http/httptrace"
 	"time"
 )
 
+// Scheme and version of the battery API
+const (
+	apiScheme  = "http"
+	apiVersion = "v2"
+)
+
 // fetchLatestData retrieves the latest data from a SonnenBatterie
-func fetchLatestData(battery Battery) (*LatestData, error) {
+func fetchLatestData(ctx context.Context, battery Battery) (*LatestData, error) {
 	var data LatestData
-	url := fmt.Sprintf("http://%s/api/v2/latestdata", battery.IP)
-	if err := fetchJSON(url, battery.AuthToken, &data); err != nil {
+	if err := fetchJSON(ctx, battery, "latestdata", &data); err != nil {
 		return nil, err
 	}
 	return &data, nil
 }
 
 // fetchStatus retrieves the current status from a SonnenBatterie
-func fetchStatus(battery Battery) (*Status, error) {
+func fetchStatus(ctx context.Context, battery Battery) (*Status, error) {
 	var status Status
-	url := fmt.Sprintf("http://%s/api/v2/status", battery.IP)
-	if err := fetchJSON(url, battery.AuthToken, &status); err != nil {
+	if err := fetchJSON(ctx, battery, "status", &status); err != nil {
 		return nil, err
 	}
 	return &status, nil
 }
 
-// fetchJSON performs an HTTP GET request with authentication and decodes the JSON response
-func fetchJSON(url string, token string, target interface{}) error {
-	client := &http.Client{Timeout: 10 * time.Second}
-	req, err := http.NewRequest("GET", url, nil)
+// fetchBatteryData retrieves battery module details from a SonnenBatterie
+func fetchBatteryData(ctx context.Context, battery Battery) (*BatteryData, error) {
+	var data BatteryData
+	if err := fetchJSON(ctx, battery, "battery", &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// fetchInverterData retrieves inverter details from a SonnenBatterie
+func fetchInverterData(ctx context.Context, battery Battery) (*InverterData, error) {
+	var data InverterData
+	if err := fetchJSON(ctx, battery, "inverter", &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// fetchPowermeter retrieves the energy meter readings from a SonnenBatterie
+func fetchPowermeter(ctx context.Context, battery Battery) ([]PowermeterReading, error) {
+	var data []PowermeterReading
+	if err := fetchJSON(ctx, battery, "powermeter", &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// fetchConfigurations retrieves the system configuration from a SonnenBatterie
+func fetchConfigurations(ctx context.Context, battery Battery) (*Configurations, error) {
+	var data Configurations
+	if err := fetchJSON(ctx, battery, "configurations", &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// recordAuthentication counts a response rejecting the Auth-Token and tracks
+// whether the token is currently invalid. Other error responses leave the
+// state unchanged, as they say nothing about the token.
+func (m *clientMetrics) recordAuthentication(batteryName, url string, statusCode int) {
+	if statusCode == http.StatusUnauthorized {
+		log.Printf("Battery %s rejected the Auth-Token for %s", batteryName, url)
+	}
+	if m == nil {
+		return
+	}
+	switch statusCode {
+	case http.StatusUnauthorized:
+		m.authFailures.WithLabelValues(batteryName).Inc()
+		m.tokenInvalid.WithLabelValues(batteryName).Set(1)
+	case http.StatusOK:
+		m.tokenInvalid.WithLabelValues(batteryName).Set(0)
+	}
+}
+
+// recordTokenRefresh counts a successful or failed token refresh
+func (m *clientMetrics) recordTokenRefresh(batteryName string, err error) {
+	switch {
+	case m == nil:
+	case err != nil:
+		m.tokenRefreshErrors.WithLabelValues(batteryName).Inc()
+	default:
+		m.tokenRefreshes.WithLabelValues(batteryName).Inc()
+	}
+}
+
+// batteryTransport carries all battery API requests and tracks unclosed
+// response bodies, registered in main
+var batteryTransport = newTrackingTransport(http.DefaultTransport)
+
+// errScrapeDeadline marks requests cut short by the deadline of the scrape,
+// as opposed to the per-request client timeout
+var errScrapeDeadline = errors.New("scrape deadline exceeded")
+
+// scrapeError marks err with errScrapeDeadline if the scrape context expired
+func scrapeError(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", errScrapeDeadline, err)
+	}
+	return err
+}
+
+// fetchJSON performs an HTTP GET request against an /api/v2 endpoint with
+// authentication and decodes the JSON response. The request is cancelled
+// once ctx, the context of the scrape, is done.
+// If the battery rejects the token and a TokenRefreshFunc is set, the token is
+// refreshed and the request retried once.
+func fetchJSON(ctx context.Context, battery Battery, endpoint string, target interface{}) error {
+	body, err := fetchBody(ctx, battery, endpoint)
 	if err != nil {
-		return fmt.Errorf("failed to create request for %s: %w", url, err)
+		return err
 	}
-	req.Header.Set("Auth-Token", token)
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(target); err != nil {
+		battery.metrics.recordDecodeError(battery.Name, endpoint, err)
+		return fmt.Errorf("failed to decode JSON from %s: %w", battery.apiURL(endpoint), err)
+	}
+	return nil
+}
 
-	resp, err := client.Do(req)
+// fetchBody performs the request of fetchJSON and returns the response body
+// as is. A body is also returned along with the error for a status other
+// than 200 OK.
+func fetchBody(ctx context.Context, battery Battery, endpoint string) ([]byte, error) {
+	transport, err := battery.roundTripper()
 	if err != nil {
-		return fmt.Errorf("failed to fetch %s: %w", url, err)
+		return nil, err
+	}
+	client := &http.Client{Timeout: 10 * time.Second, Transport: transport}
+	url := battery.apiURL(endpoint)
+
+	resp, err := battery.metrics.instrumentedDo(ctx, client, battery.Name, endpoint, url, battery.token())
+	if err != nil {
+		return nil, err
+	}
+	battery.metrics.recordAuthentication(battery.Name, url, resp.StatusCode)
+
+	if resp.StatusCode == http.StatusUnauthorized && battery.TokenRefreshFunc != nil {
+		_ = resp.Body.Close()
+
+		refreshCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		token, err := battery.TokenRefreshFunc(refreshCtx)
+		battery.metrics.recordTokenRefresh(battery.Name, err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to refresh token after unauthorized response from %s: %w", url, err)
+		}
+		battery.setToken(token)
+
+		if resp, err = battery.metrics.instrumentedDo(ctx, client, battery.Name, endpoint, url, token); err != nil {
+			return nil, err
+		}
+		battery.metrics.recordAuthentication(battery.Name, url, resp.StatusCode)
 	}
 	defer func() { _ = resp.Body.Close() }()
+	battery.metrics.recordProtocol(battery.Name, resp)
 
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, scrapeError(ctx, fmt.Errorf("failed to read %s: %w", url, err))
+	}
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, url)
+		return body, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, url)
 	}
+	return body, nil
+}
+
+// apiURL returns the URL of an endpoint of the battery API
+func (b Battery) apiURL(endpoint string) string {
+	return fmt.Sprintf("%s://%s/api/%s/%s", b.scheme(), b.Address, apiVersion, endpoint)
+}
 
-	if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
-		return fmt.Errorf("failed to decode JSON from %s: %w", url, err)
+// instrumentedDo sends an authenticated GET request and records its duration,
+// including failed requests; the caller must close the body
+func (m *clientMetrics) instrumentedDo(ctx context.Context, client *http.Client, batteryName, endpoint, url, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %s: %w", url, err)
+	}
+	req.Header.Set("Auth-Token", token)
+	if m != nil {
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), m.dnsTrace(batteryName)))
 	}
 
-	return nil
+	start := time.Now()
+	resp, err := client.Do(req)
+	if m != nil {
+		elapsed := time.Since(start).Seconds()
+		m.requestDurationHistogram.WithLabelValues(batteryName, endpoint).Observe(elapsed)
+		m.requestDurationSummary.WithLabelValues(batteryName, endpoint).Observe(elapsed)
+	}
+	if err != nil {
+		return nil, scrapeError(ctx, fmt.Errorf("failed to fetch %s: %w", url, err))
+	}
+	return resp, nil
+}
+
+// dnsTrace observes the DNS lookups of a request. Reused connections need
+// no lookup, so not every request is observed.
+func (m *clientMetrics) dnsTrace(batteryName string) *httptrace.ClientTrace {
+	var start time.Time
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			start = time.Now()
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			m.dnsLookupDuration.WithLabelValues(batteryName).Observe(time.Since(start).Seconds())
+			if info.Err != nil {
+				m.dnsResolutionErrors.WithLabelValues(batteryName).Inc()
+			}
+		},
+	}
+}
+
+// checkReachability reports whether the battery answers HTTP requests at all.
+// Any response counts, including error statuses; only connection failures and
+// timeouts do not.
+func checkReachability(ctx context.Context, battery Battery) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, fmt.Sprintf("%s://%s/", battery.scheme(), battery.Address), nil)
+	if err != nil {
+		return false
+	}
+	transport, err := battery.roundTripper()
+	if err != nil {
+		return false
+	}
+
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return false
+	}
+	_ = resp.Body.Close()
+	return true
+}
+
+// token returns the refreshed Auth-Token if there is one, or the configured one
+func (b Battery) token() string {
+	if b.auth == nil {
+		return b.AuthToken
+	}
+	b.auth.mu.RLock()
+	defer b.auth.mu.RUnlock()
+	if b.auth.token != "" {
+		return b.auth.token
+	}
+	return b.AuthToken
+}
+
+// setToken stores a refreshed Auth-Token for all copies of the battery
+func (b Battery) setToken(token string) {
+	if b.auth == nil {
+		return
+	}
+	b.auth.mu.Lock()
+	defer b.auth.mu.Unlock()
+	b.auth.token = token
+}
+
+// withAuthState gives every battery shared storage for refreshed tokens
+func withAuthState(batteries []Battery) []Battery {
+	for i := range batteries {
+		if batteries[i].auth == nil {
+			batteries[i].auth = &authState{}
+		}
+	}
+	return batteries
 }
@@ -1,10 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestFetchLatestData(t *testing.T) {
@@ -47,11 +55,11 @@ func TestFetchLatestData(t *testing.T) {
 
 	battery := Battery{
 		Name:      "test",
-		IP:        server.URL[7:], // Remove "http://" prefix
+		Address:   server.URL[7:], // Remove "http://" prefix
 		AuthToken: "test-token",
 	}
 
-	data, err := fetchLatestData(battery)
+	data, err := fetchLatestData(context.Background(), battery)
 	if err != nil {
 		t.Fatalf("fetchLatestData() error = %v", err)
 	}
@@ -97,11 +105,11 @@ func TestFetchStatus(t *testing.T) {
 
 	battery := Battery{
 		Name:      "test",
-		IP:        server.URL[7:],
+		Address:   server.URL[7:],
 		AuthToken: "test-token",
 	}
 
-	status, err := fetchStatus(battery)
+	status, err := fetchStatus(context.Background(), battery)
 	if err != nil {
 		t.Fatalf("fetchStatus() error = %v", err)
 	}
@@ -115,6 +123,70 @@ func TestFetchStatus(t *testing.T) {
 	}
 }
 
+func TestFetchBatteryData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/battery" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"minimumcellvoltage": 3.251, "maximumcellvoltage": 3.262}`))
+	}))
+	defer server.Close()
+
+	battery := Battery{
+		Name:      "test",
+		Address:   server.URL[7:],
+		AuthToken: "test-token",
+	}
+
+	data, err := fetchBatteryData(context.Background(), battery)
+	if err != nil {
+		t.Fatalf("fetchBatteryData() error = %v", err)
+	}
+
+	if data.MinimumCellVoltage == nil || *data.MinimumCellVoltage != 3.251 {
+		t.Errorf("MinimumCellVoltage = %v, want 3.251", data.MinimumCellVoltage)
+	}
+
+	if data.MaximumCellVoltage == nil || *data.MaximumCellVoltage != 3.262 {
+		t.Errorf("MaximumCellVoltage = %v, want 3.262", data.MaximumCellVoltage)
+	}
+}
+
+func TestFetchInverterData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/inverter" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"sac_total": 2000.5}`))
+	}))
+	defer server.Close()
+
+	battery := Battery{
+		Name:      "test",
+		Address:   server.URL[7:],
+		AuthToken: "test-token",
+	}
+
+	data, err := fetchInverterData(context.Background(), battery)
+	if err != nil {
+		t.Fatalf("fetchInverterData() error = %v", err)
+	}
+
+	if data.SacTotal == nil || *data.SacTotal != 2000.5 {
+		t.Errorf("SacTotal = %v, want 2000.5", data.SacTotal)
+	}
+
+	if data.CosPhi != nil {
+		t.Errorf("CosPhi = %v, want nil", *data.CosPhi)
+	}
+}
+
 func TestFetchJSON_Unauthorized(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusUnauthorized)
@@ -123,16 +195,55 @@ func TestFetchJSON_Unauthorized(t *testing.T) {
 
 	battery := Battery{
 		Name:      "test",
-		IP:        server.URL[7:],
+		Address:   server.URL[7:],
 		AuthToken: "wrong-token",
 	}
 
-	_, err := fetchLatestData(battery)
+	_, err := fetchLatestData(context.Background(), battery)
 	if err == nil {
 		t.Error("fetchLatestData() expected error for unauthorized request")
 	}
 }
 
+func TestFetchJSON_AuthenticationFailures(t *testing.T) {
+	var rejecting atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rejecting.Load() {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	metrics := newClientMetrics(metricNames{})
+	battery := Battery{Name: "auth-test", Address: server.URL[7:], AuthToken: "test-token", metrics: metrics}
+
+	steps := []struct {
+		reject       bool
+		wantFailures float64
+		wantInvalid  float64
+	}{
+		{reject: true, wantFailures: 1, wantInvalid: 1},
+		{reject: true, wantFailures: 2, wantInvalid: 1},
+		{reject: false, wantFailures: 2, wantInvalid: 0},
+	}
+	for i, step := range steps {
+		rejecting.Store(step.reject)
+		_, err := fetchStatus(context.Background(), battery)
+		if (err != nil) != step.reject {
+			t.Errorf("step %d: fetchStatus() error = %v, want error %v", i, err, step.reject)
+		}
+		if got := testutil.ToFloat64(metrics.authFailures.WithLabelValues("auth-test")); got != step.wantFailures {
+			t.Errorf("step %d: authentication failures = %v, want %v", i, got, step.wantFailures)
+		}
+		if got := testutil.ToFloat64(metrics.tokenInvalid.WithLabelValues("auth-test")); got != step.wantInvalid {
+			t.Errorf("step %d: token invalid = %v, want %v", i, got, step.wantInvalid)
+		}
+	}
+}
+
 func TestFetchJSON_InvalidJSON(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -142,12 +253,265 @@ func TestFetchJSON_InvalidJSON(t *testing.T) {
 
 	battery := Battery{
 		Name:      "test",
-		IP:        server.URL[7:],
+		Address:   server.URL[7:],
 		AuthToken: "test-token",
 	}
 
-	_, err := fetchLatestData(battery)
+	_, err := fetchLatestData(context.Background(), battery)
 	if err == nil {
 		t.Error("fetchLatestData() expected error for invalid JSON")
 	}
 }
+
+func TestFetchJSON_TokenRefresh(t *testing.T) {
+	var tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get("Auth-Token"))
+		if r.Header.Get("Auth-Token") != "rotated-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(Status{SystemStatus: "OnGrid"})
+	}))
+	defer server.Close()
+
+	refreshCalls := 0
+	metrics := newClientMetrics(metricNames{})
+	battery := withAuthState([]Battery{{
+		Name:      "refresh-test",
+		Address:   server.URL[7:],
+		AuthToken: "old-token",
+		TokenRefreshFunc: func(ctx context.Context) (string, error) {
+			refreshCalls++
+			return "rotated-token", nil
+		},
+		metrics: metrics,
+	}})[0]
+
+	status, err := fetchStatus(context.Background(), battery)
+	if err != nil {
+		t.Fatalf("fetchStatus() error = %v", err)
+	}
+	if status.SystemStatus != "OnGrid" {
+		t.Errorf("SystemStatus = %s, want OnGrid", status.SystemStatus)
+	}
+
+	// The rejected request is retried once with the new token
+	if len(tokens) != 2 || tokens[0] != "old-token" || tokens[1] != "rotated-token" {
+		t.Errorf("request tokens = %v, want [old-token rotated-token]", tokens)
+	}
+	if refreshCalls != 1 {
+		t.Errorf("TokenRefreshFunc called %d times, want 1", refreshCalls)
+	}
+	if got := testutil.ToFloat64(metrics.tokenRefreshes.WithLabelValues("refresh-test")); got != 1 {
+		t.Errorf("token refresh counter = %f, want 1", got)
+	}
+
+	// Later requests and copies of the battery use the refreshed token directly
+	if _, err := fetchStatus(context.Background(), battery); err != nil {
+		t.Fatalf("fetchStatus() error = %v", err)
+	}
+	if len(tokens) != 3 || tokens[2] != "rotated-token" {
+		t.Errorf("request tokens = %v, want third request with rotated-token", tokens)
+	}
+	if refreshCalls != 1 {
+		t.Errorf("TokenRefreshFunc called %d times, want 1", refreshCalls)
+	}
+}
+
+func TestFetchJSON_TokenRefreshError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	metrics := newClientMetrics(metricNames{})
+	battery := Battery{
+		Name:      "refresh-error-test",
+		Address:   server.URL[7:],
+		AuthToken: "old-token",
+		TokenRefreshFunc: func(ctx context.Context) (string, error) {
+			return "", errors.New("secret store unavailable")
+		},
+		metrics: metrics,
+	}
+
+	if _, err := fetchStatus(context.Background(), battery); err == nil {
+		t.Error("fetchStatus() expected error when token refresh fails")
+	}
+	if got := testutil.ToFloat64(metrics.tokenRefreshErrors.WithLabelValues("refresh-error-test")); got != 1 {
+		t.Errorf("token refresh error counter = %f, want 1", got)
+	}
+}
+
+func TestInstrumentedDo_RequestDuration(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		time.Sleep(time.Duration(requests%3) * time.Millisecond)
+		_ = json.NewEncoder(w).Encode(Status{})
+	}))
+	defer server.Close()
+
+	metrics := newClientMetrics(metricNames{})
+	battery := Battery{Name: "duration-test", Address: server.URL[7:], AuthToken: "test-token", metrics: metrics}
+	const count = 20
+	for i := 0; i < count; i++ {
+		if _, err := fetchStatus(context.Background(), battery); err != nil {
+			t.Fatalf("fetchStatus() error = %v", err)
+		}
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(metrics.requestDurationHistogram, metrics.requestDurationSummary)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+
+	// The smallest bucket holding every observation bounds all quantiles
+	var bound float64
+	var quantiles map[float64]float64
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			if labelValue(m, "battery_name") != "duration-test" || labelValue(m, "endpoint") != "status" {
+				continue
+			}
+			if h := m.GetHistogram(); h != nil {
+				if h.GetSampleCount() != count {
+					t.Errorf("histogram sample count = %d, want %d", h.GetSampleCount(), count)
+				}
+				for _, b := range h.GetBucket() {
+					if b.GetCumulativeCount() == count {
+						bound = b.GetUpperBound()
+						break
+					}
+				}
+			}
+			if s := m.GetSummary(); s != nil {
+				if s.GetSampleCount() != count {
+					t.Errorf("summary sample count = %d, want %d", s.GetSampleCount(), count)
+				}
+				quantiles = map[float64]float64{}
+				for _, q := range s.GetQuantile() {
+					quantiles[q.GetQuantile()] = q.GetValue()
+				}
+			}
+		}
+	}
+
+	if bound == 0 {
+		t.Fatal("no histogram bucket holds all observations")
+	}
+	for _, q := range []float64{0.5, 0.95, 0.99} {
+		value, ok := quantiles[q]
+		if !ok {
+			t.Errorf("quantile %v missing", q)
+			continue
+		}
+		if value <= 0 || value > bound {
+			t.Errorf("quantile %v = %f, want between 0 and %f", q, value, bound)
+		}
+	}
+}
+
+func TestFetchJSON_DNSLookup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(Status{})
+	}))
+	defer server.Close()
+
+	// A hostname is resolved by the HTTP client and the lookup observed
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	metrics := newClientMetrics(metricNames{})
+	battery := Battery{Name: "dns-test", Address: net.JoinHostPort("localhost", port), AuthToken: "test-token", metrics: metrics}
+	if _, err := fetchStatus(context.Background(), battery); err != nil {
+		t.Fatalf("fetchStatus() error = %v", err)
+	}
+	if got := testutil.CollectAndCount(metrics.dnsLookupDuration); got != 1 {
+		t.Errorf("DNS lookup series = %d, want 1", got)
+	}
+
+	// An IP address needs no lookup
+	if _, err := fetchStatus(context.Background(), Battery{Name: "ip-test", Address: server.URL[7:], AuthToken: "test-token", metrics: metrics}); err != nil {
+		t.Fatalf("fetchStatus() error = %v", err)
+	}
+	if got := testutil.CollectAndCount(metrics.dnsLookupDuration); got != 1 {
+		t.Errorf("DNS lookup series after IP request = %d, want 1", got)
+	}
+
+	// The .invalid top-level domain never resolves
+	if _, err := fetchStatus(context.Background(), Battery{Name: "unresolvable", Address: "battery.invalid", AuthToken: "test-token", metrics: metrics}); err == nil {
+		t.Error("fetchStatus() expected error for unresolvable hostname")
+	}
+	if got := testutil.ToFloat64(metrics.dnsResolutionErrors.WithLabelValues("unresolvable")); got != 1 {
+		t.Errorf("DNS resolution errors = %f, want 1", got)
+	}
+}
+
+func TestScrapeError(t *testing.T) {
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{name: "scrape deadline", ctx: expired, err: context.DeadlineExceeded, want: true},
+		// A client timeout also reports DeadlineExceeded, but the scrape is not over
+		{name: "client timeout", ctx: context.Background(), err: context.DeadlineExceeded},
+		{name: "scrape cancelled", ctx: cancelled, err: context.Canceled},
+		{name: "other error", ctx: context.Background(), err: errors.New("connection refused")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := scrapeError(tt.ctx, tt.err)
+			if got := errors.Is(err, errScrapeDeadline); got != tt.want {
+				t.Errorf("scrapeError() = %v, marked as scrape deadline %v, want %v", err, got, tt.want)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("scrapeError() = %v, does not wrap %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCollector_ScrapeTimeout(t *testing.T) {
+	// status answers only after the scrape deadline
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v2/status" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	collector := NewCollector(
+		[]Battery{{Name: "slow", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{ScrapeTimeout: 100 * time.Millisecond},
+	)
+	collectAll(collector)
+
+	timeouts := map[string]float64{}
+	for _, endpoint := range []string{"latestdata", "status"} {
+		timeouts[endpoint] = testutil.ToFloat64(collector.scrapeTimeouts.WithLabelValues("slow", endpoint))
+	}
+	if timeouts["latestdata"] != 0 || timeouts["status"] != 1 {
+		t.Errorf("scrape_timeouts_total = %v, want 0 for latestdata and 1 for status", timeouts)
+	}
+	if got := testutil.ToFloat64(collector.scrapeErrors.WithLabelValues("slow", "status")); got != 1 {
+		t.Errorf("scrape_errors_total for status = %v, want 1", got)
+	}
+}
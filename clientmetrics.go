@@ -0,0 +1,179 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// clientMetrics instruments the battery API requests of one Collector. Every
+// collector owns its series, so the batteries of a /probe never end up in the
+// metrics of the configured batteries.
+type clientMetrics struct {
+	// Token refreshes and rejected Auth-Tokens
+	tokenRefreshes     *prometheus.CounterVec
+	tokenRefreshErrors *prometheus.CounterVec
+	authFailures       *prometheus.CounterVec
+	tokenInvalid       *prometheus.GaugeVec
+
+	// Request latency. The histogram suits aggregation across batteries, the
+	// summary shows typical latencies per battery.
+	requestDurationHistogram *prometheus.HistogramVec
+	requestDurationSummary   *prometheus.SummaryVec
+
+	// Hostname resolution of battery addresses. Addresses given as IPs are
+	// not resolved and not observed.
+	dnsLookupDuration   *prometheus.HistogramVec
+	dnsResolutionErrors *prometheus.CounterVec
+
+	// Negotiated HTTP protocol; protocolMu keeps a battery from briefly
+	// having two protocol series
+	httpProtocolInfo *prometheus.GaugeVec
+	http2InUse       *prometheus.GaugeVec
+	protocolMu       sync.Mutex
+
+	// Responses whose body could not be decoded, with the last error per
+	// endpoint served on /debug
+	decodeFailures *prometheus.CounterVec
+	decodeErrors   *decodeErrorLog
+}
+
+// newClientMetrics creates the request metrics of a Collector, recording
+// their names in names
+func newClientMetrics(names metricNames) *clientMetrics {
+	return &clientMetrics{
+		tokenRefreshes: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_token_refresh_total",
+				Help: "Number of successful Auth-Token refreshes after the battery rejected a token",
+			},
+			[]string{"battery_name"},
+		),
+		tokenRefreshErrors: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_token_refresh_errors_total",
+				Help: "Number of failed Auth-Token refreshes",
+			},
+			[]string{"battery_name"},
+		),
+		authFailures: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_api_authentication_failures_total",
+				Help: "Number of requests the battery rejected with 401 Unauthorized",
+			},
+			[]string{"battery_name"},
+		),
+		tokenInvalid: names.gaugeVec(
+			prometheus.GaugeOpts{
+				Name: "sonnenbatterie_token_invalid",
+				Help: "Whether the battery rejected the Auth-Token, until the next successful request",
+			},
+			[]string{"battery_name"},
+		),
+		requestDurationHistogram: names.histogramVec(
+			prometheus.HistogramOpts{
+				Name:    "sonnenbatterie_request_latency_seconds",
+				Help:    "Latency of requests to the battery API in seconds",
+				Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+			},
+			[]string{"battery_name", "endpoint"},
+		),
+		requestDurationSummary: names.summaryVec(
+			prometheus.SummaryOpts{
+				Name:       "sonnenbatterie_request_duration_seconds",
+				Help:       "Duration of requests to the battery API in seconds over a 5 minute window",
+				Objectives: map[float64]float64{0.5: 0.05, 0.95: 0.01, 0.99: 0.001},
+				MaxAge:     5 * time.Minute,
+				AgeBuckets: 5,
+			},
+			[]string{"battery_name", "endpoint"},
+		),
+		dnsLookupDuration: names.histogramVec(
+			prometheus.HistogramOpts{
+				Name:    "sonnenbatterie_dns_lookup_duration_seconds",
+				Help:    "Duration of DNS lookups of battery hostnames in seconds",
+				Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5},
+			},
+			[]string{"battery_name"},
+		),
+		dnsResolutionErrors: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_dns_resolution_errors_total",
+				Help: "Number of failed DNS lookups of battery hostnames",
+			},
+			[]string{"battery_name"},
+		),
+		httpProtocolInfo: names.gaugeVec(
+			prometheus.GaugeOpts{
+				Name: "sonnenbatterie_http_protocol_info",
+				Help: "HTTP protocol of the last response from the battery API (always 1)",
+			},
+			[]string{"battery_name", "protocol"},
+		),
+		http2InUse: names.gaugeVec(
+			prometheus.GaugeOpts{
+				Name: "sonnenbatterie_http2_in_use",
+				Help: "Whether the last response from the battery API used HTTP/2",
+			},
+			[]string{"battery_name"},
+		),
+		decodeFailures: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_decode_failures_total",
+				Help: "Number of successful responses from the battery API whose JSON body could not be decoded",
+			},
+			[]string{"battery_name", "endpoint"},
+		),
+		decodeErrors: &decodeErrorLog{errors: make(map[[2]string]decodeError)},
+	}
+}
+
+// withClientMetrics makes the requests of every battery record into metrics
+func withClientMetrics(batteries []Battery, metrics *clientMetrics) []Battery {
+	for i := range batteries {
+		batteries[i].metrics = metrics
+	}
+	return batteries
+}
+
+// forget drops the series of a battery that is no longer configured
+func (m *clientMetrics) forget(batteryName string) {
+	labels := prometheus.Labels{"battery_name": batteryName}
+	m.tokenRefreshes.DeletePartialMatch(labels)
+	m.tokenRefreshErrors.DeletePartialMatch(labels)
+	m.authFailures.DeletePartialMatch(labels)
+	m.tokenInvalid.DeletePartialMatch(labels)
+	m.requestDurationHistogram.DeletePartialMatch(labels)
+	m.requestDurationSummary.DeletePartialMatch(labels)
+	m.dnsLookupDuration.DeletePartialMatch(labels)
+	m.dnsResolutionErrors.DeletePartialMatch(labels)
+	m.httpProtocolInfo.DeletePartialMatch(labels)
+	m.http2InUse.DeletePartialMatch(labels)
+	m.decodeFailures.DeletePartialMatch(labels)
+	m.decodeErrors.forget(batteryName)
+}
+
+// Describe implements prometheus.Collector
+func (m *clientMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (m *clientMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// collectors returns the metric vectors in a fixed order
+func (m *clientMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.tokenRefreshes, m.tokenRefreshErrors, m.authFailures, m.tokenInvalid,
+		m.requestDurationHistogram, m.requestDurationSummary,
+		m.dnsLookupDuration, m.dnsResolutionErrors,
+		m.httpProtocolInfo, m.http2InUse, m.decodeFailures,
+	}
+}
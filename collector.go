@@ -1,130 +1,1110 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"math"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// CollectorOptions holds tunables for the collector that are not per battery
+type CollectorOptions struct {
+	CO2IntensityGPerKWh  float64          // Grid carbon intensity used for CO2 estimates
+	MaxLabelValues       int              // Label value combinations allowed per metric, 0 for unlimited
+	FirmwareGracePeriod  time.Duration    // How long cached firmware flags survive failed scrapes
+	ConfigurationsMaxAge time.Duration    // How long the system configuration is cached, 0 until reload
+	LegacyMilliwatts     bool             // Also emit the deprecated _mw power metrics
+	Compat               bool             // Also emit the deprecated unsuffixed voltage and frequency metrics
+	DropStateLabels      bool             // Keep bms_state and inverter_state off the value metrics
+	WarrantyYears        int              // Warranty period from commissioning, 0 to omit the warranty metric
+	Currency             string           // Lowercase currency code in the price metric names, "eur" if empty
+	OffPeakPriceImport   *float64         // Import price outside all time-of-use windows, nil if unknown
+	OffPeakPriceExport   *float64         // Export price outside all time-of-use windows, nil if unknown
+	SanityChecks         bool             // Drop readings outside their plausible range
+	MaxPowerW            float64          // Highest plausible consumption and production with SanityChecks
+	StaleTTL             time.Duration    // How long the last values are served after a failed scrape, 0 disables
+	SOCJumpThreshold     float64          // Charge level change in percentage points between scrapes counted as a jump, 0 disables
+	FeedInSign           string           // Sign convention of the grid feed-in metrics, export_positive if empty
+	KeepInfo             bool             // Serve the last info metric during failed scrapes regardless of StaleTTL
+	ScrapeTimeout        time.Duration    // Deadline of all requests of one scrape, 0 for none
+	UseDeviceTimestamps  bool             // Stamp the latestdata and status metrics with the battery's measurement time
+	Forecast             ForecastProvider // Solar production forecast to compare against, nil disables
+	PowerEMAAlpha        float64          // Weight of the newest reading in the smoothed battery power, defaultPowerEMAAlpha if 0
+}
+
+// MetricProvider adds custom metrics to every successful battery scrape
+type MetricProvider interface {
+	// Descs returns the descriptors of all metrics the provider may emit
+	Descs() []*prometheus.Desc
+	// Collect emits the provider's metrics for a successfully scraped battery
+	Collect(battery Battery, latestData *LatestData, status *Status, ch chan<- prometheus.Metric)
+}
+
+// batteryState holds per-battery data carried between scrapes
+type batteryState struct {
+	lastScrape   time.Time // Time of the last successful scrape, zero after a failure
+	lastSuccess  time.Time // Time of the last successful scrape, kept across failures
+	systemStatus string    // Last known SystemStatus, kept across failures
+	firmware     firmwareState
+
+	configurations        *Configurations // Cached system configuration
+	configurationsFetched time.Time       // Zero if the cache must be refreshed
+	configurationsUpdated time.Time       // Last successful fetch, kept across reloads
+
+	powermeter map[[2]string]float64 // Last kWh reading by channel and direction
+
+	heaterActive *bool // Last reported heater state, nil until seen
+
+	coreControlState string // Last reported core control module state, kept across failures
+	combinedState    string // Last reported BMS/core control/inverter state, kept across failures
+
+	socSamples []socSample // Charge readings while idle, within selfDischargeWindow
+
+	capacityUnit string // Unit FullChargeCapacity was last read in, empty until seen
+
+	// Payloads of the last successful scrape and their dropped readings, kept
+	// with StaleTTL only
+	lastLatestData *LatestData
+	lastStatus     *Status
+	lastDropped    droppedReadings
+
+	infoLabels []string // Labels of the last emitted info metric, nil until seen
+
+	energy intervalEnergy // Battery energy per 15-minute period
+	power  powerWindow    // Latest battery power readings
+
+	chargeMode  string // Last charging or discharging mode, "" until seen
+	cyclesDay   string // Day of cyclesToday in the battery's time zone
+	cyclesToday int    // Switches between charging and discharging on cyclesDay
+
+	outcomes scrapeOutcomes // Latest scrape outcomes for the error rate
+
+	lastRSOC *int // Charge level of the last successful scrape, nil after a failure or implausible reading
+
+	lastPowerW *float64 // Battery power of the last successful scrape, nil after a failure or implausible reading
+
+	// Charge level and power shown on the landing page, nil after a failure
+	// or implausible reading
+	shownRSOC   *int
+	shownPowerW *float64
+
+	powerEMA powerEMA // Smoothed battery power for the time to empty and full
+
+	configDrift bool // Whether the last read configuration differed from the expectation
+
+	offGridSince time.Time // Start of the current grid outage, zero while on grid
+
+	// Held while the battery is scraped, so overlapping scrapes are skipped
+	scraping      chan struct{}
+	scrapeStarted time.Time
+
+	// Last scrape that was not throttled, served again by throttled and
+	// missed scrapes
+	lastActualScrape time.Time
+	cachedMetrics    []prometheus.Metric
+	cachedReading    *batteryReading
+}
+
 // Collector implements prometheus.Collector for SonnenBatterie metrics
 type Collector struct {
-	batteries []Battery
+	options   CollectorOptions
+	guard     *CardinalityGuard
+	client    *clientMetrics
+	names     metricNames // Metric names of all descriptors, for the metric filters
+	providers []MetricProvider
+	now       func() time.Time
+
+	// Parent context of all scrapes, cancelled by CancelScrapes on shutdown
+	scrapes       context.Context
+	cancelScrapes context.CancelFunc
+
+	// Battery configuration and state carried between scrapes, guarded by mu.
+	// batteries is replaced by SetBatteries but never modified in place, so
+	// Collect can work on a snapshot without holding mu.
+	mu         sync.Mutex
+	batteries  []Battery
+	groups     map[string][]Battery // Parallel groups with at least two batteries
+	state      map[string]*batteryState
+	warnings   int            // Active configuration warnings
+	duplicates map[string]int // Skipped entries per battery name configured more than once
 
 	// Metrics
-	chargeLevel        *prometheus.Desc
-	userChargeLevel    *prometheus.Desc
-	consumption        *prometheus.Desc
-	production         *prometheus.Desc
-	gridFeedIn         *prometheus.Desc
-	batteryPower       *prometheus.Desc
-	charging           *prometheus.Desc
-	discharging        *prometheus.Desc
-	powerFlowState     *prometheus.Desc
-	fullChargeCapacity *prometheus.Desc
-	acVoltage          *prometheus.Desc
-	batteryVoltage     *prometheus.Desc
-	acFrequency        *prometheus.Desc
-	info               *prometheus.Desc
-	scrapeSuccess      *prometheus.Desc
+	chargeLevel              *prometheus.Desc
+	userChargeLevel          *prometheus.Desc
+	consumption              *prometheus.Desc
+	consumptionAvg           *prometheus.Desc
+	production               *prometheus.Desc
+	gridFeedIn               *prometheus.Desc
+	batteryPower             *prometheus.Desc
+	charging                 *prometheus.Desc
+	discharging              *prometheus.Desc
+	chargeState              *prometheus.Desc
+	powerFlowState           *prometheus.Desc
+	fullChargeCapacity       *prometheus.Desc
+	acVoltage                *prometheus.Desc
+	batteryVoltage           *prometheus.Desc
+	acFrequency              *prometheus.Desc
+	coreControlState         *prometheus.Desc
+	coreControlModuleInfo    *prometheus.Desc
+	coreControlModuleState   *prometheus.Desc
+	stateMachineInfo         *prometheus.Desc
+	icFlag                   *prometheus.Desc
+	cellImbalance            *prometheus.Desc
+	batteryCurrent           *prometheus.Desc
+	heaterActive             *prometheus.Desc
+	coolingActive            *prometheus.Desc
+	moduleInfo               *prometheus.Desc
+	moduleVoltage            *prometheus.Desc
+	moduleTemperature        *prometheus.Desc
+	moduleVoltageSpread      *prometheus.Desc
+	moduleVoltageMin         *prometheus.Desc
+	moduleVoltageMax         *prometheus.Desc
+	cellCount                *prometheus.Desc
+	designCapacity           *prometheus.Desc
+	commissioningDate        *prometheus.Desc
+	batteryAge               *prometheus.Desc
+	warrantyRemaining        *prometheus.Desc
+	stringCount              *prometheus.Desc
+	firmwareUpdateAvailable  *prometheus.Desc
+	firmwareUpdateInProgress *prometheus.Desc
+	timezoneInfo             *prometheus.Desc
+	priceImport              *prometheus.Desc
+	priceExport              *prometheus.Desc
+	configInfo               *prometheus.Desc
+	configLastUpdate         *prometheus.Desc
+	clockOffset              *prometheus.Desc
+	inverterCosPhi           *prometheus.Desc
+	reactivePower            *prometheus.Desc
+	apparentPower            *prometheus.Desc
+	powerAngle               *prometheus.Desc
+	inverterInfo             *prometheus.Desc
+	pvPanelsInfo             *prometheus.Desc
+	inverterEfficiency       *prometheus.Desc
+	inverterLosses           *prometheus.Desc
+	dcInputPower             *prometheus.Desc
+	dcInputVoltage           *prometheus.Desc
+	dcInputCurrent           *prometheus.Desc
+	couplingType             *prometheus.Desc
+	acCouplingPower          *prometheus.Desc
+	acCouplingDetected       *prometheus.Desc
+	co2Intensity             *prometheus.Desc
+	configWarnings           *prometheus.Desc
+	configDriftDetected      *prometheus.Desc
+	configuredBatteries      *prometheus.Desc
+	reachableBatteries       *prometheus.Desc
+	duplicateBattery         *prometheus.Desc
+	locationInfo             *prometheus.Desc
+	clientCertExpiry         *prometheus.Desc
+	groupCapacity            *prometheus.Desc
+	groupPower               *prometheus.Desc
+	groupChargeLevel         *prometheus.Desc
+	info                     *prometheus.Desc
+	scrapeSuccess            *prometheus.Desc
+	up                       *prometheus.Desc
+	scrapePartial            *prometheus.Desc
+	batteryOnline            *prometheus.Desc
+	inBackup                 *prometheus.Desc
+	offGridStart             *prometheus.Desc
+	selfDischarge            *prometheus.Desc
+	intervalEnergy           *prometheus.Desc
+	intervalEnergyPeak       *prometheus.Desc
+	intervalEnergyCoverage   *prometheus.Desc
+	lastActualScrape         *prometheus.Desc
+	dataStale                *prometheus.Desc
+	powerVariance            *prometheus.Desc
+	powerStdDev              *prometheus.Desc
+	powerRampRate            *prometheus.Desc
+	timeToEmpty              *prometheus.Desc
+	chargePowerLimit         *prometheus.Desc
+	dischargePowerLimit      *prometheus.Desc
+	chargeUtilization        *prometheus.Desc
+	dischargeUtilization     *prometheus.Desc
+	timeToFull               *prometheus.Desc
+	powerRampDirection       *prometheus.Desc
+	chargeCyclesToday        *prometheus.Desc
+	apiErrorRate             *prometheus.Desc
+	apiDegraded              *prometheus.Desc
+	consecutiveFailures      *prometheus.Desc
+	healthScore              *prometheus.Desc
+	healthComponents         *prometheus.Desc
+	forecastError            *prometheus.Desc
+	lastScrapeSuccess        *prometheus.Desc
+
+	// Deprecated milliwatt power metrics, only with LegacyMilliwatts
+	consumptionMW    *prometheus.Desc
+	productionMW     *prometheus.Desc
+	gridFeedInMW     *prometheus.Desc
+	batteryPowerMW   *prometheus.Desc
+	inverterLossesMW *prometheus.Desc
+	groupPowerMW     *prometheus.Desc
+	dcInputPowerMW   *prometheus.Desc
+
+	// Deprecated names without unit suffix, only with Compat
+	acVoltageCompat      *prometheus.Desc
+	batteryVoltageCompat *prometheus.Desc
+	acFrequencyCompat    *prometheus.Desc
+
+	// Counters accumulated across scrapes
+	co2Avoided            *prometheus.CounterVec
+	powermeterEnergy      *prometheus.CounterVec
+	offGridSeconds        *prometheus.CounterVec
+	offGridTransitions    *prometheus.CounterVec
+	heaterActivations     *prometheus.CounterVec
+	chargeCycles          *prometheus.CounterVec
+	scrapeErrors          *prometheus.CounterVec
+	scrapeTimeouts        *prometheus.CounterVec
+	collectionErrors      prometheus.Counter
+	anomalousReadings     *prometheus.CounterVec
+	scrapeThrottled       *prometheus.CounterVec
+	scrapeMissed          *prometheus.CounterVec
+	socJumps              *prometheus.CounterVec
+	coreControlChanges    *prometheus.CounterVec
+	stateTransitions      *prometheus.CounterVec
+	forecastRequests      *prometheus.CounterVec
+	forecastErrors        *prometheus.CounterVec
+	chargeStateMismatches *prometheus.CounterVec
+	timeInMode            *prometheus.CounterVec
+	configDriftEvents     *prometheus.CounterVec
 }
 
 // NewCollector creates a new SonnenBatterie collector
-func NewCollector(batteries []Battery) *Collector {
-	return &Collector{
-		batteries: batteries,
-		chargeLevel: prometheus.NewDesc(
+func NewCollector(batteries []Battery, options CollectorOptions) *Collector {
+	// Value metrics carry the BMS and inverter states unless DropStateLabels is set
+	valueLabels := []string{"battery_name", "bms_state", "inverter_state"}
+	if options.DropStateLabels {
+		valueLabels = []string{"battery_name"}
+	}
+	currency := options.Currency
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	feedInHelp := feedInSignHelp(options.FeedInSign)
+
+	batteries, duplicates := uniqueBatteries(batteries)
+	scrapes, cancelScrapes := context.WithCancel(context.Background())
+	names := metricNames{}
+	client := newClientMetrics(names)
+	c := &Collector{
+		names:         names,
+		scrapes:       scrapes,
+		cancelScrapes: cancelScrapes,
+		client:        client,
+		batteries:     withClientMetrics(withAuthState(batteries), client),
+		groups:        parallelGroups(batteries),
+		duplicates:    duplicates,
+		options:       options,
+		guard:         NewCardinalityGuard(options.MaxLabelValues),
+		state:         make(map[string]*batteryState),
+		now:           time.Now,
+		chargeLevel: names.desc(
 			"sonnenbatterie_charge_level_percent",
 			"Battery relative state of charge (RSOC) in percent",
-			[]string{"battery_name", "bms_state", "inverter_state"},
+			valueLabels,
+			nil,
+		),
+		userChargeLevel: names.desc(
+			"sonnenbatterie_user_charge_level_percent",
+			"Battery user state of charge (USOC) in percent",
+			valueLabels,
+			nil,
+		),
+		consumption: names.desc(
+			"sonnenbatterie_consumption_watts",
+			"Current house consumption in watts",
+			valueLabels,
+			nil,
+		),
+		consumptionAvg: names.desc(
+			"sonnenbatterie_consumption_avg_watts",
+			"Smoothed house consumption used by the energy manager in watts",
+			[]string{"battery_name"},
+			nil,
+		),
+		production: names.desc(
+			"sonnenbatterie_production_watts",
+			"Current solar production in watts",
+			valueLabels,
+			nil,
+		),
+		gridFeedIn: names.desc(
+			"sonnenbatterie_grid_feed_in_watts",
+			"Current grid feed-in in watts ("+feedInHelp+")",
+			valueLabels,
+			nil,
+		),
+		batteryPower: names.desc(
+			"sonnenbatterie_battery_power_watts",
+			"Current battery power in watts (negative=charging, positive=discharging)",
+			valueLabels,
+			nil,
+		),
+		charging: names.desc(
+			"sonnenbatterie_charging",
+			"Battery is currently charging (1=yes, 0=no)",
+			valueLabels,
+			nil,
+		),
+		discharging: names.desc(
+			"sonnenbatterie_discharging",
+			"Battery is currently discharging (1=yes, 0=no)",
+			valueLabels,
+			nil,
+		),
+		chargeState: names.desc(
+			"sonnenbatterie_charge_state",
+			"Charge state, 1 for the current state and 0 for all others",
+			[]string{"battery_name", "state"},
+			nil,
+		),
+		powerFlowState: names.desc(
+			"sonnenbatterie_power_flow_state",
+			"Grid power flow state: 0=idle (no grid exchange), 1=importing from grid, 2=exporting to grid",
+			valueLabels,
+			nil,
+		),
+		fullChargeCapacity: names.desc(
+			"sonnenbatterie_full_charge_capacity_wh",
+			"Battery full charge capacity in watt-hours",
+			valueLabels,
+			nil,
+		),
+		acVoltage: names.desc(
+			"sonnenbatterie_ac_voltage_volts",
+			"AC voltage in volts",
+			valueLabels,
+			nil,
+		),
+		batteryVoltage: names.desc(
+			"sonnenbatterie_battery_voltage_volts",
+			"Battery voltage in volts",
+			valueLabels,
+			nil,
+		),
+		acFrequency: names.desc(
+			"sonnenbatterie_ac_frequency_hertz",
+			"AC frequency in hertz",
+			valueLabels,
+			nil,
+		),
+		coreControlState: names.desc(
+			"sonnenbatterie_core_control_state",
+			"Core control module state, 1 for the current state and 0 for all others",
+			[]string{"battery_name", "state"},
+			nil,
+		),
+		coreControlModuleInfo: names.desc(
+			"sonnenbatterie_core_control_module_state_info",
+			"Core control module state as reported by the battery, always 1",
+			[]string{"battery_name", "state"},
+			nil,
+		),
+		coreControlModuleState: names.desc(
+			"sonnenbatterie_core_control_module_state",
+			"Core control module state (0=unknown, 1=ongrid, 2=offgrid, 3=standby)",
+			[]string{"battery_name"},
+			nil,
+		),
+		stateMachineInfo: names.desc(
+			"sonnenbatterie_battery_state_machine_info",
+			"BMS, core control module and inverter state as reported by the battery, always 1",
+			[]string{"battery_name", "bms_state", "core_control_state", "inverter_state", "combined_state"},
+			nil,
+		),
+		icFlag: names.desc(
+			"sonnenbatterie_ic_flag",
+			"Boolean warning and status flags from the nested ic_status objects (1=set, 0=clear)",
+			[]string{"battery_name", "group", "flag"},
+			nil,
+		),
+		cellImbalance: names.desc(
+			"sonnenbatterie_cell_imbalance_volts",
+			"Difference between maximum and minimum cell voltage in volts",
+			[]string{"battery_name"},
+			nil,
+		),
+		heaterActive: names.desc(
+			"sonnenbatterie_battery_heater_active",
+			"Whether the battery module heater is running (1) or not (0)",
+			[]string{"battery_name"},
+			nil,
+		),
+		coolingActive: names.desc(
+			"sonnenbatterie_battery_cooling_active",
+			"Whether battery cooling is running (1) or not (0)",
+			[]string{"battery_name"},
+			nil,
+		),
+		moduleInfo: names.desc(
+			"sonnenbatterie_battery_module_info",
+			"Status of a single battery module",
+			[]string{"battery_name", "module", "module_status"},
+			nil,
+		),
+		moduleVoltage: names.desc(
+			"sonnenbatterie_battery_module_voltage_volts",
+			"Voltage of a single battery module in volts",
+			[]string{"battery_name", "module"},
+			nil,
+		),
+		moduleTemperature: names.desc(
+			"sonnenbatterie_battery_module_temperature_celsius",
+			"Temperature of a single battery module in degrees Celsius",
+			[]string{"battery_name", "module"},
+			nil,
+		),
+		moduleVoltageSpread: names.desc(
+			"sonnenbatterie_battery_voltage_spread_volts",
+			"Difference between the highest and lowest module voltage in volts, -1 if no module voltages are reported",
+			[]string{"battery_name"},
+			nil,
+		),
+		moduleVoltageMin: names.desc(
+			"sonnenbatterie_battery_voltage_min_volts",
+			"Lowest module voltage in volts",
+			[]string{"battery_name"},
+			nil,
+		),
+		moduleVoltageMax: names.desc(
+			"sonnenbatterie_battery_voltage_max_volts",
+			"Highest module voltage in volts",
+			[]string{"battery_name"},
+			nil,
+		),
+		cellCount: names.desc(
+			"sonnenbatterie_battery_cell_count",
+			"Total number of cells across all battery modules",
+			[]string{"battery_name"},
+			nil,
+		),
+		commissioningDate: names.desc(
+			"sonnenbatterie_battery_commissioning_date_timestamp_seconds",
+			"Unix time the battery was commissioned",
+			[]string{"battery_name"},
+			nil,
+		),
+		batteryAge: names.desc(
+			"sonnenbatterie_battery_age_days",
+			"Days since the battery was commissioned",
+			[]string{"battery_name"},
+			nil,
+		),
+		warrantyRemaining: names.desc(
+			"sonnenbatterie_warranty_remaining_days",
+			"Days until the warranty counted from commissioning ends, 0 once it has expired",
+			[]string{"battery_name"},
+			nil,
+		),
+		designCapacity: names.desc(
+			"sonnenbatterie_design_capacity_wh",
+			"Installed (design) capacity in Wh",
+			[]string{"battery_name"},
+			nil,
+		),
+		stringCount: names.desc(
+			"sonnenbatterie_battery_string_count",
+			"Number of series cell strings, one per battery module",
+			[]string{"battery_name"},
+			nil,
+		),
+		batteryCurrent: names.desc(
+			"sonnenbatterie_battery_current_amperes",
+			"Battery pack DC current in amperes (positive=charging, negative=discharging)",
+			[]string{"battery_name"},
+			nil,
+		),
+		firmwareUpdateAvailable: names.desc(
+			"sonnenbatterie_firmware_update_available",
+			"A firmware update is available (1=yes, 0=no)",
+			[]string{"battery_name"},
+			nil,
+		),
+		firmwareUpdateInProgress: names.desc(
+			"sonnenbatterie_firmware_update_in_progress",
+			"A firmware update is being installed (1=yes, 0=no)",
+			[]string{"battery_name"},
+			nil,
+		),
+		priceImport: names.desc(
+			"sonnenbatterie_electricity_price_import_"+currency+"_kwh",
+			"Grid import price per kWh in the active time-of-use window",
+			[]string{"battery_name"},
+			nil,
+		),
+		priceExport: names.desc(
+			"sonnenbatterie_electricity_price_export_"+currency+"_kwh",
+			"Grid export price per kWh in the active time-of-use window",
+			[]string{"battery_name"},
+			nil,
+		),
+		timezoneInfo: names.desc(
+			"sonnenbatterie_timezone_info",
+			"Time zone configured on the battery",
+			[]string{"battery_name", "timezone"},
+			nil,
+		),
+		configInfo: names.desc(
+			"sonnenbatterie_config_info",
+			"Configuration parameters of the battery from the last successful configurations read",
+			[]string{"battery_name", "operating_mode", "backup_reserve_pct", "min_soc_pct", "api_version", "scheme"},
+			nil,
+		),
+		configLastUpdate: names.desc(
+			"sonnenbatterie_config_last_update_timestamp_seconds",
+			"Unix time of the last successful configurations read",
+			[]string{"battery_name"},
+			nil,
+		),
+		clockOffset: names.desc(
+			"sonnenbatterie_clock_offset_seconds",
+			"Battery clock minus exporter clock in seconds, based on the latestdata timestamp in the battery's time zone",
+			[]string{"battery_name"},
+			nil,
+		),
+		inverterInfo: names.desc(
+			"sonnenbatterie_inverter_info",
+			"Inverter information, labels are empty when the battery does not report them",
+			[]string{"battery_name", "type", "fw_version", "max_power"},
+			nil,
+		),
+		pvPanelsInfo: names.desc(
+			"sonnenbatterie_photovoltaic_panels_info",
+			"PV installation from the system configuration, labels are empty when the battery does not report them",
+			[]string{"battery_name", "peak_power_w", "string_count", "orientation"},
+			nil,
+		),
+		inverterCosPhi: names.desc(
+			"sonnenbatterie_inverter_cosphi",
+			"Inverter power factor (cos phi) between -1 and 1",
+			[]string{"battery_name"},
+			nil,
+		),
+		reactivePower: names.desc(
+			"sonnenbatterie_battery_reactive_power_var",
+			"Inverter reactive power in var, negative when capacitive and positive when inductive",
+			[]string{"battery_name"},
+			nil,
+		),
+		apparentPower: names.desc(
+			"sonnenbatterie_battery_apparent_power_va",
+			"Inverter apparent power in volt-amperes",
+			[]string{"battery_name"},
+			nil,
+		),
+		powerAngle: names.desc(
+			"sonnenbatterie_battery_power_angle_radians",
+			"Angle between active and reactive power in radians",
+			[]string{"battery_name"},
+			nil,
+		),
+		inverterEfficiency: names.desc(
+			"sonnenbatterie_battery_inverter_efficiency_ratio",
+			"Inverter efficiency as output divided by input power, with the DC side from battery voltage and pack current, clamped to 1.05 to absorb measurement noise",
+			[]string{"battery_name"},
+			nil,
+		),
+		inverterLosses: names.desc(
+			"sonnenbatterie_inverter_losses_watts",
+			"Inverter conversion losses as input minus output power in watts",
+			[]string{"battery_name"},
+			nil,
+		),
+		dcInputPower: names.desc(
+			"sonnenbatterie_dc_input_power_watts",
+			"DC-coupled solar input power in watts",
+			[]string{"battery_name"},
+			nil,
+		),
+		dcInputVoltage: names.desc(
+			"sonnenbatterie_dc_input_voltage_volts",
+			"DC-coupled solar input voltage in volts",
+			[]string{"battery_name"},
+			nil,
+		),
+		dcInputCurrent: names.desc(
+			"sonnenbatterie_dc_input_current_amperes",
+			"DC-coupled solar input current in amperes",
+			[]string{"battery_name"},
+			nil,
+		),
+		couplingType: names.desc(
+			"sonnenbatterie_coupling_type_info",
+			"How the solar panels are coupled to the battery: dc, ac or unknown",
+			[]string{"battery_name", "coupling_type"},
+			nil,
+		),
+		acCouplingPower: names.desc(
+			"sonnenbatterie_battery_ac_coupling_power_watts",
+			"Solar production from a PV inverter on the AC bus, i.e. production not entering through the DC input, in watts",
+			[]string{"battery_name"},
+			nil,
+		),
+		acCouplingDetected: names.desc(
+			"sonnenbatterie_battery_ac_coupling_detected",
+			"Whether AC-coupled production above 10 W is seen",
+			[]string{"battery_name"},
+			nil,
+		),
+		co2Intensity: names.desc(
+			"sonnenbatterie_grid_co2_intensity_g_kwh",
+			"Configured grid carbon intensity in grams of CO2 per kilowatt-hour used for CO2 estimates",
+			nil,
+			nil,
+		),
+		duplicateBattery: names.desc(
+			"sonnenbatterie_duplicate_battery",
+			"Number of additional batteries configured with this name, which are not scraped",
+			[]string{"battery_name"},
+			nil,
+		),
+		locationInfo: names.desc(
+			"sonnenbatterie_installation_location_info",
+			"Site the battery is installed at, from SONNENBATTERIE_LOCATIONS",
+			[]string{"battery_name", "location"},
+			nil,
+		),
+		clientCertExpiry: names.desc(
+			"sonnenbatterie_tls_client_cert_expiry_seconds",
+			"Seconds until the client certificate presented to the battery expires, negative once expired",
+			[]string{"battery_name"},
+			nil,
+		),
+		configuredBatteries: names.desc(
+			"sonnenbatterie_configured_batteries",
+			"Number of configured batteries, without duplicated names",
+			nil,
+			nil,
+		),
+		reachableBatteries: names.desc(
+			"sonnenbatterie_reachable_batteries",
+			"Number of batteries whose scrape succeeded in this scrape",
+			nil,
+			nil,
+		),
+		configDriftDetected: names.desc(
+			"sonnenbatterie_config_drift_detected",
+			"Whether the configuration differs from the expected operating mode or backup reserve",
+			[]string{"battery_name"},
+			nil,
+		),
+		configWarnings: names.desc(
+			"sonnenbatterie_config_warnings",
+			"Number of active non-fatal configuration warnings",
+			nil,
+			nil,
+		),
+		groupCapacity: names.desc(
+			"sonnenbatterie_parallel_system_capacity_wh",
+			"Combined full charge capacity of a parallel battery group in watt-hours",
+			[]string{"group"},
+			nil,
+		),
+		groupPower: names.desc(
+			"sonnenbatterie_parallel_system_battery_power_watts",
+			"Combined battery power of a parallel battery group in watts",
+			[]string{"group"},
+			nil,
+		),
+		groupChargeLevel: names.desc(
+			"sonnenbatterie_parallel_system_charge_level_percent",
+			"Capacity-weighted relative state of charge of a parallel battery group in percent",
+			[]string{"group"},
+			nil,
+		),
+		info: names.desc(
+			"sonnenbatterie_info",
+			"SonnenBatterie system information",
+			[]string{"battery_name", "bms_state", "core_control_state", "inverter_state", "battery_modules", "ip",
+				"serial", "model", "firmware", "hardware_version"},
+			nil,
+		),
+		lastScrapeSuccess: names.desc(
+			"sonnenbatterie_last_scrape_success_timestamp_seconds",
+			"Unix time of the last successful scrape of the battery",
+			[]string{"battery_name"},
+			nil,
+		),
+		batteryOnline: names.desc(
+			"sonnenbatterie_battery_online",
+			"Whether the battery answered HTTP requests, regardless of the response status",
+			[]string{"battery_name"},
+			nil,
+		),
+		inBackup: names.desc(
+			"sonnenbatterie_battery_in_backup",
+			"Whether the battery is supplying the house off-grid during a grid outage",
+			[]string{"battery_name"},
+			nil,
+		),
+		offGridStart: names.desc(
+			"sonnenbatterie_offgrid_start_timestamp_seconds",
+			"Unix time the current grid outage was first seen, only while the battery is off-grid",
+			[]string{"battery_name"},
+			nil,
+		),
+		selfDischarge: names.desc(
+			"sonnenbatterie_battery_self_discharge_watts",
+			"Estimated self-discharge of the idle battery over the last 5 minutes, -1 if there is not enough data",
+			[]string{"battery_name"},
+			nil,
+		),
+		intervalEnergy: names.desc(
+			"sonnenbatterie_battery_15min_interval_energy_wh",
+			"Battery energy of the last completed 15-minute period in watt-hours, positive when discharged",
+			[]string{"battery_name"},
+			nil,
+		),
+		intervalEnergyPeak: names.desc(
+			"sonnenbatterie_battery_15min_peak_wh",
+			"Highest battery energy of the last 4 completed 15-minute periods in watt-hours",
+			[]string{"battery_name"},
+			nil,
+		),
+		intervalEnergyCoverage: names.desc(
+			"sonnenbatterie_battery_15min_interval_coverage_ratio",
+			"Share of the last completed 15-minute period covered by battery power readings, below 1 if its energy misses gaps",
+			[]string{"battery_name"},
+			nil,
+		),
+		lastActualScrape: names.desc(
+			"sonnenbatterie_last_actual_scrape_timestamp_seconds",
+			"Unix time the battery API was last queried, not counting throttled scrapes",
+			[]string{"battery_name"},
+			nil,
+		),
+		dataStale: names.desc(
+			"sonnenbatterie_data_stale",
+			"Whether the value metrics repeat the last successful scrape because the battery is unreachable",
+			[]string{"battery_name"},
+			nil,
+		),
+		powerVariance: names.desc(
+			"sonnenbatterie_battery_power_variance_watts_squared",
+			"Variance of the battery power over the last 60 scrapes in square watts",
+			[]string{"battery_name"},
+			nil,
+		),
+		powerStdDev: names.desc(
+			"sonnenbatterie_battery_power_std_dev_watts",
+			"Standard deviation of the battery power over the last 60 scrapes in watts",
+			[]string{"battery_name"},
+			nil,
+		),
+		powerRampRate: names.desc(
+			"sonnenbatterie_battery_power_ramp_rate_watts_per_second",
+			"Magnitude of the battery power change since the previous scrape in watts per second",
+			[]string{"battery_name"},
+			nil,
+		),
+		powerRampDirection: names.desc(
+			"sonnenbatterie_battery_power_ramp_direction",
+			"Direction of the battery power change since the previous scrape: 1 increasing, -1 decreasing, 0 stable",
+			[]string{"battery_name"},
+			nil,
+		),
+		chargePowerLimit: names.desc(
+			"sonnenbatterie_battery_charge_power_limit_watts",
+			"Highest allowed charging power from the configuration (EM_ChargingLimitW) in watts",
+			[]string{"battery_name"},
+			nil,
+		),
+		dischargePowerLimit: names.desc(
+			"sonnenbatterie_battery_discharge_power_limit_watts",
+			"Highest allowed discharging power from the configuration (EM_DischargingLimitW) in watts",
+			[]string{"battery_name"},
+			nil,
+		),
+		chargeUtilization: names.desc(
+			"sonnenbatterie_battery_charge_power_utilization",
+			"Charging power as a fraction of the charging power limit between 0 and 1, 0 unless charging",
+			[]string{"battery_name"},
+			nil,
+		),
+		dischargeUtilization: names.desc(
+			"sonnenbatterie_battery_discharge_power_utilization",
+			"Discharging power as a fraction of the discharging power limit between 0 and 1, 0 unless discharging",
+			[]string{"battery_name"},
+			nil,
+		),
+		timeToEmpty: names.desc(
+			"sonnenbatterie_battery_time_to_empty_seconds",
+			"Estimated seconds until the battery is empty at the smoothed discharging power, -1 unless discharging",
+			[]string{"battery_name"},
+			nil,
+		),
+		timeToFull: names.desc(
+			"sonnenbatterie_battery_time_to_full_seconds",
+			"Estimated seconds until the battery is full at the smoothed charging power, -1 unless charging",
+			[]string{"battery_name"},
+			nil,
+		),
+		chargeCyclesToday: names.desc(
+			"sonnenbatterie_battery_charge_discharge_cycles_today",
+			"Number of switches between charging and discharging since midnight in the battery's time zone",
+			[]string{"battery_name"},
+			nil,
+		),
+		apiErrorRate: names.desc(
+			"sonnenbatterie_api_error_rate",
+			"Share of failed scrapes among the last 60 scrapes",
+			[]string{"battery_name"},
+			nil,
+		),
+		apiDegraded: names.desc(
+			"sonnenbatterie_api_degraded",
+			"Whether more than half of the last 60 scrapes failed",
+			[]string{"battery_name"},
 			nil,
 		),
-		userChargeLevel: prometheus.NewDesc(
-			"sonnenbatterie_user_charge_level_percent",
-			"Battery user state of charge (USOC) in percent",
-			[]string{"battery_name", "bms_state", "inverter_state"},
+		consecutiveFailures: names.desc(
+			"sonnenbatterie_consecutive_scrape_failures",
+			"Number of failed scrapes since the last successful one",
+			[]string{"battery_name"},
+			nil,
+		),
+		healthScore: names.desc(
+			"sonnenbatterie_battery_health_score",
+			"Battery health from 0 to 100, weighting charge level (30), state of health (40), module temperature (20) and fault state (10)",
+			[]string{"battery_name"},
+			nil,
+		),
+		healthComponents: names.desc(
+			"sonnenbatterie_health_score_components_available",
+			"Number of the 4 health score components computed from data rather than a neutral default",
+			[]string{"battery_name"},
+			nil,
+		),
+		forecastError: names.desc(
+			"sonnenbatterie_production_forecast_error_watts",
+			"Forecast minus actual solar production in watts (positive=forecast too high)",
+			[]string{"battery_name"},
 			nil,
 		),
-		consumption: prometheus.NewDesc(
+		consumptionMW: names.desc(
 			"sonnenbatterie_consumption_mw",
-			"Current house consumption in milliwatts",
-			[]string{"battery_name", "bms_state", "inverter_state"},
+			"Current house consumption in milliwatts (deprecated, use sonnenbatterie_consumption_watts)",
+			valueLabels,
 			nil,
 		),
-		production: prometheus.NewDesc(
+		productionMW: names.desc(
 			"sonnenbatterie_production_mw",
-			"Current solar production in milliwatts",
-			[]string{"battery_name", "bms_state", "inverter_state"},
+			"Current solar production in milliwatts (deprecated, use sonnenbatterie_production_watts)",
+			valueLabels,
 			nil,
 		),
-		gridFeedIn: prometheus.NewDesc(
+		gridFeedInMW: names.desc(
 			"sonnenbatterie_grid_feed_in_mw",
-			"Current grid feed-in in milliwatts (negative=consuming)",
-			[]string{"battery_name", "bms_state", "inverter_state"},
+			"Current grid feed-in in milliwatts ("+feedInHelp+") (deprecated, use sonnenbatterie_grid_feed_in_watts)",
+			valueLabels,
 			nil,
 		),
-		batteryPower: prometheus.NewDesc(
+		batteryPowerMW: names.desc(
 			"sonnenbatterie_battery_power_mw",
-			"Current battery power in milliwatts (positive=charging, negative=discharging)",
-			[]string{"battery_name", "bms_state", "inverter_state"},
-			nil,
-		),
-		charging: prometheus.NewDesc(
-			"sonnenbatterie_charging",
-			"Battery is currently charging (1=yes, 0=no)",
-			[]string{"battery_name", "bms_state", "inverter_state"},
+			"Current battery power in milliwatts (negative=charging, positive=discharging) (deprecated, use sonnenbatterie_battery_power_watts)",
+			valueLabels,
 			nil,
 		),
-		discharging: prometheus.NewDesc(
-			"sonnenbatterie_discharging",
-			"Battery is currently discharging (1=yes, 0=no)",
-			[]string{"battery_name", "bms_state", "inverter_state"},
+		inverterLossesMW: names.desc(
+			"sonnenbatterie_inverter_losses_mw",
+			"Inverter conversion losses as input minus output power in milliwatts (deprecated, use sonnenbatterie_inverter_losses_watts)",
+			[]string{"battery_name"},
 			nil,
 		),
-		powerFlowState: prometheus.NewDesc(
-			"sonnenbatterie_power_flow_state",
-			"Grid power flow state: 0=idle (no grid exchange), 1=importing from grid, 2=exporting to grid",
-			[]string{"battery_name", "bms_state", "inverter_state"},
+		groupPowerMW: names.desc(
+			"sonnenbatterie_parallel_system_battery_power_mw",
+			"Combined battery power of a parallel battery group in milliwatts (deprecated, use sonnenbatterie_parallel_system_battery_power_watts)",
+			[]string{"group"},
 			nil,
 		),
-		fullChargeCapacity: prometheus.NewDesc(
-			"sonnenbatterie_full_charge_capacity_wh",
-			"Battery full charge capacity in watt-hours",
-			[]string{"battery_name", "bms_state", "inverter_state"},
+		dcInputPowerMW: names.desc(
+			"sonnenbatterie_dc_input_power_mw",
+			"DC-coupled solar input power in milliwatts (deprecated, use sonnenbatterie_dc_input_power_watts)",
+			[]string{"battery_name"},
 			nil,
 		),
-		acVoltage: prometheus.NewDesc(
+		acVoltageCompat: names.desc(
 			"sonnenbatterie_ac_voltage",
-			"AC voltage in volts",
-			[]string{"battery_name", "bms_state", "inverter_state"},
+			"AC voltage in volts (deprecated, use sonnenbatterie_ac_voltage_volts)",
+			valueLabels,
 			nil,
 		),
-		batteryVoltage: prometheus.NewDesc(
+		batteryVoltageCompat: names.desc(
 			"sonnenbatterie_battery_voltage",
-			"Battery voltage in volts",
-			[]string{"battery_name", "bms_state", "inverter_state"},
+			"Battery voltage in volts (deprecated, use sonnenbatterie_battery_voltage_volts)",
+			valueLabels,
 			nil,
 		),
-		acFrequency: prometheus.NewDesc(
+		acFrequencyCompat: names.desc(
 			"sonnenbatterie_ac_frequency",
-			"AC frequency in hertz",
-			[]string{"battery_name", "bms_state", "inverter_state"},
-			nil,
-		),
-		info: prometheus.NewDesc(
-			"sonnenbatterie_info",
-			"SonnenBatterie system information",
-			[]string{"battery_name", "bms_state", "core_control_state", "inverter_state", "battery_modules", "ip"},
+			"AC frequency in hertz (deprecated, use sonnenbatterie_ac_frequency_hertz)",
+			valueLabels,
 			nil,
 		),
-		scrapeSuccess: prometheus.NewDesc(
+		scrapeSuccess: names.desc(
 			"sonnenbatterie_scrape_success",
 			"Whether scraping the battery API was successful",
 			[]string{"battery_name"},
 			nil,
 		),
+		up: names.desc(
+			"sonnenbatterie_up",
+			"Whether scraping the battery API was successful, same as sonnenbatterie_scrape_success",
+			[]string{"battery_name"},
+			nil,
+		),
+		scrapePartial: names.desc(
+			"sonnenbatterie_scrape_partial",
+			"Whether only latestdata could be read, so the metrics derived from it are emitted without the status metrics",
+			[]string{"battery_name"},
+			nil,
+		),
+		co2Avoided: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_grid_co2_avoided_grams_total",
+				Help: "Estimated grams of CO2 avoided by solar production, based on the configured average grid carbon intensity",
+			},
+			[]string{"battery_name"},
+		),
+		powermeterEnergy: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_powermeter_energy_kwh_total",
+				Help: "Cumulative energy reported by the energy meter in kWh",
+			},
+			[]string{"battery_name", "channel", "direction"},
+		),
+		offGridSeconds: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_offgrid_seconds_total",
+				Help: "Cumulative time the battery reported being off-grid, based on the interval between successful scrapes",
+			},
+			[]string{"battery_name"},
+		),
+		offGridTransitions: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_offgrid_transitions_total",
+				Help: "Number of times the battery was seen switching from on-grid to off-grid",
+			},
+			[]string{"battery_name"},
+		),
+		scrapeErrors: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_scrape_errors_total",
+				Help: "Number of failed requests to the battery API by endpoint",
+			},
+			[]string{"battery_name", "endpoint"},
+		),
+		scrapeTimeouts: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_scrape_timeouts_total",
+				Help: "Number of requests to the battery API cancelled by the scrape deadline",
+			},
+			[]string{"battery_name", "endpoint"},
+		),
+		heaterActivations: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_battery_heater_activations_total",
+				Help: "Number of times the battery heater was seen switching on",
+			},
+			[]string{"battery_name"},
+		),
+		anomalousReadings: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_anomalous_readings_total",
+				Help: "Number of readings dropped for being outside their plausible range",
+			},
+			[]string{"battery_name", "metric"},
+		),
+		chargeCycles: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_battery_charge_discharge_cycles_total",
+				Help: "Number of switches between charging and discharging",
+			},
+			[]string{"battery_name"},
+		),
+		scrapeThrottled: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_scrape_throttled_total",
+				Help: "Number of scrapes served from the previous scrape because of the minimum scrape interval",
+			},
+			[]string{"battery_name"},
+		),
+		scrapeMissed: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_scrape_missed_total",
+				Help: "Number of scrapes skipped because the previous scrape of the battery was still running",
+			},
+			[]string{"battery_name"},
+		),
+		socJumps: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_soc_jump_total",
+				Help: "Number of charge level changes between consecutive scrapes above the jump threshold",
+			},
+			[]string{"battery_name"},
+		),
+		coreControlChanges: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_core_control_module_transitions_total",
+				Help: "Number of core control module state changes",
+			},
+			[]string{"battery_name"},
+		),
+		stateTransitions: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_battery_state_transitions_total",
+				Help: "Number of changes of the combined BMS/core control/inverter state",
+			},
+			[]string{"battery_name", "from_state", "to_state"},
+		),
+		forecastRequests: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_forecast_requests_total",
+				Help: "Number of solar production forecast requests",
+			},
+			[]string{"battery_name"},
+		),
+		forecastErrors: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_forecast_errors_total",
+				Help: "Number of failed solar production forecast requests",
+			},
+			[]string{"battery_name"},
+		),
+		chargeStateMismatches: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_charge_state_mismatch_total",
+				Help: "Number of scrapes reporting charging and discharging at the same time",
+			},
+			[]string{"battery_name"},
+		),
+		timeInMode: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_battery_time_in_mode_seconds_total",
+				Help: "Cumulative time spent in each charge state, based on the interval between successful scrapes",
+			},
+			[]string{"battery_name", "mode"},
+		),
+		configDriftEvents: names.counterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_config_drift_events_total",
+				Help: "Number of times the configuration started to differ from the expected values",
+			},
+			[]string{"battery_name"},
+		),
+		collectionErrors: names.counter(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_collection_errors_total",
+				Help: "Number of metrics that could not be built during collection",
+			},
+		),
 	}
+	c.warnUnknownFilterMetrics(c.batteries)
+	return c
 }
 
 // Describe implements prometheus.Collector
@@ -132,67 +1112,566 @@ func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.chargeLevel
 	ch <- c.userChargeLevel
 	ch <- c.consumption
+	ch <- c.consumptionAvg
 	ch <- c.production
 	ch <- c.gridFeedIn
 	ch <- c.batteryPower
 	ch <- c.charging
 	ch <- c.discharging
+	ch <- c.chargeState
 	ch <- c.powerFlowState
 	ch <- c.fullChargeCapacity
 	ch <- c.acVoltage
 	ch <- c.batteryVoltage
 	ch <- c.acFrequency
+	ch <- c.coreControlState
+	ch <- c.coreControlModuleInfo
+	ch <- c.coreControlModuleState
+	ch <- c.stateMachineInfo
+	ch <- c.icFlag
+	ch <- c.cellImbalance
+	ch <- c.batteryCurrent
+	ch <- c.heaterActive
+	ch <- c.coolingActive
+	ch <- c.moduleInfo
+	ch <- c.moduleVoltage
+	ch <- c.moduleTemperature
+	ch <- c.moduleVoltageSpread
+	ch <- c.moduleVoltageMin
+	ch <- c.moduleVoltageMax
+	ch <- c.cellCount
+	ch <- c.stringCount
+	ch <- c.designCapacity
+	ch <- c.commissioningDate
+	ch <- c.batteryAge
+	ch <- c.warrantyRemaining
+	ch <- c.firmwareUpdateAvailable
+	ch <- c.firmwareUpdateInProgress
+	ch <- c.timezoneInfo
+	ch <- c.priceImport
+	ch <- c.priceExport
+	ch <- c.configInfo
+	ch <- c.configLastUpdate
+	ch <- c.clockOffset
+	ch <- c.inverterCosPhi
+	ch <- c.reactivePower
+	ch <- c.apparentPower
+	ch <- c.powerAngle
+	ch <- c.inverterInfo
+	ch <- c.pvPanelsInfo
+	ch <- c.inverterEfficiency
+	ch <- c.inverterLosses
+	ch <- c.dcInputPower
+	ch <- c.dcInputVoltage
+	ch <- c.dcInputCurrent
+	ch <- c.couplingType
+	ch <- c.acCouplingPower
+	ch <- c.acCouplingDetected
+	ch <- c.co2Intensity
+	ch <- c.configWarnings
+	ch <- c.configDriftDetected
+	ch <- c.configuredBatteries
+	ch <- c.reachableBatteries
+	ch <- c.duplicateBattery
+	ch <- c.locationInfo
+	ch <- c.clientCertExpiry
+	ch <- c.groupCapacity
+	ch <- c.groupPower
+	ch <- c.groupChargeLevel
 	ch <- c.info
 	ch <- c.scrapeSuccess
+	ch <- c.up
+	ch <- c.scrapePartial
+	ch <- c.batteryOnline
+	ch <- c.inBackup
+	ch <- c.offGridStart
+	ch <- c.selfDischarge
+	ch <- c.intervalEnergy
+	ch <- c.intervalEnergyPeak
+	ch <- c.intervalEnergyCoverage
+	ch <- c.lastActualScrape
+	ch <- c.dataStale
+	ch <- c.powerVariance
+	ch <- c.powerStdDev
+	ch <- c.powerRampRate
+	ch <- c.powerRampDirection
+	ch <- c.timeToEmpty
+	ch <- c.chargePowerLimit
+	ch <- c.dischargePowerLimit
+	ch <- c.chargeUtilization
+	ch <- c.dischargeUtilization
+	ch <- c.timeToFull
+	ch <- c.chargeCyclesToday
+	ch <- c.apiErrorRate
+	ch <- c.apiDegraded
+	ch <- c.consecutiveFailures
+	ch <- c.healthScore
+	ch <- c.healthComponents
+	ch <- c.forecastError
+	ch <- c.lastScrapeSuccess
+	if c.options.LegacyMilliwatts {
+		ch <- c.consumptionMW
+		ch <- c.productionMW
+		ch <- c.gridFeedInMW
+		ch <- c.batteryPowerMW
+		ch <- c.inverterLossesMW
+		ch <- c.groupPowerMW
+		ch <- c.dcInputPowerMW
+	}
+	if c.options.Compat {
+		ch <- c.acVoltageCompat
+		ch <- c.batteryVoltageCompat
+		ch <- c.acFrequencyCompat
+	}
+	c.co2Avoided.Describe(ch)
+	c.powermeterEnergy.Describe(ch)
+	c.offGridSeconds.Describe(ch)
+	c.offGridTransitions.Describe(ch)
+	c.heaterActivations.Describe(ch)
+	c.chargeCycles.Describe(ch)
+	c.scrapeErrors.Describe(ch)
+	c.scrapeTimeouts.Describe(ch)
+	c.collectionErrors.Describe(ch)
+	c.anomalousReadings.Describe(ch)
+	c.scrapeThrottled.Describe(ch)
+	c.scrapeMissed.Describe(ch)
+	c.socJumps.Describe(ch)
+	c.coreControlChanges.Describe(ch)
+	c.stateTransitions.Describe(ch)
+	c.forecastRequests.Describe(ch)
+	c.forecastErrors.Describe(ch)
+	c.chargeStateMismatches.Describe(ch)
+	c.timeInMode.Describe(ch)
+	c.configDriftEvents.Describe(ch)
+	c.guard.Describe(ch)
+	c.client.Describe(ch)
+	for _, p := range c.providers {
+		for _, desc := range p.Descs() {
+			ch <- desc
+		}
+	}
+}
+
+// Batteries returns a copy of the currently configured batteries
+func (c *Collector) Batteries() []Battery {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Battery(nil), c.batteries...)
+}
+
+// SetBatteries replaces the configured batteries and is safe to call while
+// scrapes are running. State and counters of batteries that are no longer
+// configured are dropped.
+func (c *Collector) SetBatteries(batteries []Battery) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	configured := make(map[string]bool, len(batteries))
+	for _, b := range batteries {
+		configured[b.Name] = true
+	}
+	for _, b := range c.batteries {
+		if configured[b.Name] {
+			// Cached configuration is refetched on the next scrape
+			if state, ok := c.state[b.Name]; ok {
+				state.configurationsFetched = time.Time{}
+			}
+			continue
+		}
+		delete(c.state, b.Name)
+		c.co2Avoided.DeleteLabelValues(b.Name)
+		c.powermeterEnergy.DeletePartialMatch(prometheus.Labels{"battery_name": b.Name})
+		c.offGridSeconds.DeleteLabelValues(b.Name)
+		c.offGridTransitions.DeleteLabelValues(b.Name)
+		c.heaterActivations.DeleteLabelValues(b.Name)
+		c.chargeCycles.DeleteLabelValues(b.Name)
+		c.scrapeErrors.DeletePartialMatch(prometheus.Labels{"battery_name": b.Name})
+		c.scrapeTimeouts.DeletePartialMatch(prometheus.Labels{"battery_name": b.Name})
+		c.anomalousReadings.DeletePartialMatch(prometheus.Labels{"battery_name": b.Name})
+		c.scrapeThrottled.DeleteLabelValues(b.Name)
+		c.scrapeMissed.DeleteLabelValues(b.Name)
+		c.socJumps.DeleteLabelValues(b.Name)
+		c.coreControlChanges.DeleteLabelValues(b.Name)
+		c.stateTransitions.DeletePartialMatch(prometheus.Labels{"battery_name": b.Name})
+		c.forecastRequests.DeleteLabelValues(b.Name)
+		c.forecastErrors.DeleteLabelValues(b.Name)
+		c.chargeStateMismatches.DeleteLabelValues(b.Name)
+		c.timeInMode.DeletePartialMatch(prometheus.Labels{"battery_name": b.Name})
+		c.configDriftEvents.DeleteLabelValues(b.Name)
+		c.client.forget(b.Name)
+	}
+
+	// uniqueBatteries copies, so later changes to the caller's slice cannot
+	// reach running scrapes
+	batteries, c.duplicates = uniqueBatteries(batteries)
+	c.batteries = withClientMetrics(withAuthState(batteries), c.client)
+	c.warnUnknownFilterMetrics(c.batteries)
+	c.groups = parallelGroups(batteries)
+}
+
+// uniqueBatteries returns a copy of batteries keeping only the first entry
+// per name, since duplicate names would make the whole scrape fail, along
+// with the number of skipped entries per duplicated name
+func uniqueBatteries(batteries []Battery) ([]Battery, map[string]int) {
+	unique := make([]Battery, 0, len(batteries))
+	duplicates := make(map[string]int)
+	seen := make(map[string]bool, len(batteries))
+	for _, b := range batteries {
+		if seen[b.Name] {
+			duplicates[b.Name]++
+			continue
+		}
+		seen[b.Name] = true
+		unique = append(unique, b)
+	}
+	for name, count := range duplicates {
+		log.Printf("Error: battery name %s is configured %d times, only the first is scraped", name, count+1)
+	}
+	return unique, duplicates
+}
+
+// SetConfigWarnings sets the number of active configuration warnings
+func (c *Collector) SetConfigWarnings(count int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.warnings = count
+}
+
+// CancelScrapes cancels the battery requests of all running and future
+// scrapes, which then fail. It is called when shutting down.
+func (c *Collector) CancelScrapes() {
+	c.cancelScrapes()
+}
+
+// RegisterProvider adds a custom metric provider. It must be called before the
+// collector is registered with Prometheus.
+func (c *Collector) RegisterProvider(p MetricProvider) {
+	c.providers = append(c.providers, p)
 }
 
 // Collect implements prometheus.Collector
 func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 	var wg sync.WaitGroup
 
-	for _, battery := range c.batteries {
+	ctx := c.scrapes
+	if c.options.ScrapeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.options.ScrapeTimeout)
+		defer cancel()
+	}
+
+	// Work on a snapshot so SetBatteries can run concurrently
+	c.mu.Lock()
+	batteries, groups, warnings, duplicates := c.batteries, c.groups, c.warnings, c.duplicates
+	c.mu.Unlock()
+
+	// Metrics sent while a battery is scraped are its own; the exporter-wide
+	// rest is matched to batteries by label
+	unfiltered := ch
+	ch, flush := c.withMetricFilters(batteries, ch)
+	defer flush()
+
+	// Each goroutine writes only its own slot, so no locking is needed
+	readings := make([]*batteryReading, len(batteries))
+	for i, battery := range batteries {
 		wg.Add(1)
-		go func(b Battery) {
+		go func(i int, b Battery) {
 			defer wg.Done()
-			c.collectBattery(b, ch)
-		}(battery)
+			batteryCh, flushBattery := c.withBatteryFilter(b, unfiltered)
+			defer flushBattery()
+			readings[i] = c.collectExclusive(ctx, b, batteryCh)
+		}(i, battery)
 	}
 
 	wg.Wait()
+
+	c.collectGroups(batteries, groups, readings, ch)
+
+	// Only successful scrapes return a reading
+	reachable := 0
+	for _, reading := range readings {
+		if reading != nil {
+			reachable++
+		}
+	}
+	c.gauge(ch, c.configuredBatteries, float64(len(batteries)))
+	c.gauge(ch, c.reachableBatteries, float64(reachable))
+
+	c.gauge(ch, c.co2Intensity, c.options.CO2IntensityGPerKWh)
+	c.gauge(ch, c.configWarnings, float64(warnings))
+	for name, count := range duplicates {
+		c.gauge(ch, c.duplicateBattery, float64(count), name)
+	}
+	for _, battery := range batteries {
+		location := battery.Location
+		if location == "" {
+			location = "unknown"
+		}
+		c.gauge(ch, c.locationInfo, 1, battery.Name, location)
+		c.collectClientCertExpiry(battery, ch)
+	}
+	c.co2Avoided.Collect(ch)
+	c.powermeterEnergy.Collect(ch)
+	c.offGridSeconds.Collect(ch)
+	c.offGridTransitions.Collect(ch)
+	c.heaterActivations.Collect(ch)
+	c.chargeCycles.Collect(ch)
+	c.scrapeErrors.Collect(ch)
+	c.scrapeTimeouts.Collect(ch)
+	c.collectionErrors.Collect(ch)
+	c.anomalousReadings.Collect(ch)
+	c.scrapeThrottled.Collect(ch)
+	c.scrapeMissed.Collect(ch)
+	c.socJumps.Collect(ch)
+	c.coreControlChanges.Collect(ch)
+	c.stateTransitions.Collect(ch)
+	c.forecastRequests.Collect(ch)
+	c.forecastErrors.Collect(ch)
+	c.chargeStateMismatches.Collect(ch)
+	c.timeInMode.Collect(ch)
+	c.configDriftEvents.Collect(ch)
+	c.guard.Collect(ch)
+	c.client.Collect(ch)
+}
+
+// recordScrape stores the outcome of a scrape, with a nil status marking a
+// failure, and returns the time elapsed since the previous successful scrape
+// along with the last known system status. Intervals that span a failed scrape
+// are not reported, so accumulated values never include time with unknown readings.
+func (c *Collector) recordScrape(name string, status *Status) (time.Duration, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := c.batteryState(name)
+
+	// The last known system status survives failures so that an outage
+	// interrupted by a failed scrape is not counted as a new transition
+	previousStatus := state.systemStatus
+	if status == nil {
+		state.lastScrape = time.Time{}
+		state.lastRSOC, state.lastPowerW = nil, nil
+		state.shownRSOC, state.shownPowerW = nil, nil
+		return 0, previousStatus
+	}
+
+	now := c.now()
+	var elapsed time.Duration
+	if !state.lastScrape.IsZero() {
+		elapsed = now.Sub(state.lastScrape)
+	}
+	state.lastScrape = now
+	state.lastSuccess = now
+	state.systemStatus = status.SystemStatus
+	return elapsed, previousStatus
+}
+
+// batteryState returns the state for a battery, creating it on first use.
+// The caller must hold c.mu.
+func (c *Collector) batteryState(name string) *batteryState {
+	state, ok := c.state[name]
+	if !ok {
+		state = &batteryState{}
+		c.state[name] = state
+	}
+	return state
+}
+
+// emitLastSuccess sends the time of the last successful scrape, if there was one
+func (c *Collector) emitLastSuccess(name string, ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	lastSuccess := c.batteryState(name).lastSuccess
+	c.mu.Unlock()
+
+	if !lastSuccess.IsZero() {
+		c.gauge(ch, c.lastScrapeSuccess, float64(lastSuccess.UnixNano())/1e9, name)
+	}
+}
+
+// fetchFailed logs a failed request and counts it against the endpoint,
+// separately as a timeout if the scrape deadline cut it short
+func (c *Collector) fetchFailed(battery Battery, endpoint string, err error) {
+	log.Printf("Error fetching %s for %s: %v", endpoint, battery.Name, err)
+	c.scrapeErrors.WithLabelValues(battery.Name, endpoint).Inc()
+	if errors.Is(err, errScrapeDeadline) {
+		c.scrapeTimeouts.WithLabelValues(battery.Name, endpoint).Inc()
+	}
+}
+
+// emitScrapeSuccess emits sonnenbatterie_scrape_success and its alias
+// sonnenbatterie_up, so the two never disagree
+func (c *Collector) emitScrapeSuccess(ch chan<- prometheus.Metric, value float64, name string) {
+	c.gauge(ch, c.scrapeSuccess, value, name)
+	c.gauge(ch, c.up, value, name)
 }
 
-func (c *Collector) collectBattery(battery Battery, ch chan<- prometheus.Metric) {
+// scrapeFailed records a failed scrape and emits the metrics that remain
+// meaningful without fresh data. A partial scrape got latestdata, so the
+// battery is known to be online.
+func (c *Collector) scrapeFailed(ctx context.Context, battery Battery, partial bool, ch chan<- prometheus.Metric) {
+	c.recordScrape(battery.Name, nil)
+	c.emitScrapeSuccess(ch, 0, battery.Name)
+	c.collectErrorRate(battery, true, ch)
+	c.gauge(ch, c.scrapePartial, boolToFloat(partial), battery.Name)
+	c.emitLastSuccess(battery.Name, ch)
+
+	online := partial
+	if !partial {
+		// Tell an offline battery apart from one returning errors or bad data
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		online = checkReachability(ctx, battery)
+	}
+	c.gauge(ch, c.batteryOnline, boolToFloat(online), battery.Name)
+
+	c.emitFirmwareState(battery.Name, c.cachedFirmwareState(battery.Name), ch)
+}
+
+// collectBattery emits all metrics for a single battery and returns its
+// readings, or nil if the battery could not be scraped. If only status fails
+// the metrics derived from latestdata are still emitted.
+func (c *Collector) collectBattery(ctx context.Context, battery Battery, ch chan<- prometheus.Metric) *batteryReading {
 	// Fetch latest data from the battery (combines status + system info)
-	latestData, err := fetchLatestData(battery)
+	latestData, err := fetchLatestData(ctx, battery)
 	if err != nil {
-		log.Printf("Error fetching latest data for %s: %v", battery.Name, err)
-		ch <- prometheus.MustNewConstMetric(c.scrapeSuccess, prometheus.GaugeValue, 0, battery.Name)
-		return
+		c.fetchFailed(battery, "latestdata", err)
+		c.scrapeFailed(ctx, battery, false, ch)
+		if !c.emitStale(battery, ch) {
+			c.emitCachedInfo(battery, ch)
+		}
+		return nil
 	}
+	c.normalizeCapacity(battery, latestData)
 
 	// Fetch additional status info (for charging/discharging booleans)
-	status, err := fetchStatus(battery)
+	status, err := fetchStatus(ctx, battery)
 	if err != nil {
-		log.Printf("Error fetching status for %s: %v", battery.Name, err)
-		ch <- prometheus.MustNewConstMetric(c.scrapeSuccess, prometheus.GaugeValue, 0, battery.Name)
-		return
+		c.fetchFailed(battery, "status", err)
+		configurations := c.configurations(ctx, battery)
+		c.scrapeFailed(ctx, battery, true, ch)
+
+		// latestdata also reports the power flows, just less up to date
+		dropped := c.sanitize(battery, latestData, nil)
+		labels := c.valueLabelValues(battery, latestData)
+		measured, flush := c.withDeviceTimestamps(latestData, configurations, ch)
+		c.emitLatestData(battery, latestData, configurations, labels, dropped, measured)
+		if dropped.usable("consumption") {
+			c.emitPower(measured, c.consumption, c.consumptionMW, latestData.ConsumptionW, labels...)
+		}
+		if dropped.usable("production") {
+			c.emitPower(measured, c.production, c.productionMW, latestData.ProductionW, labels...)
+		}
+		c.emitPower(measured, c.gridFeedIn, c.gridFeedInMW, c.feedIn(latestData.GridFeedInW), labels...)
+		if dropped.usable("battery_power") {
+			c.emitPower(measured, c.batteryPower, c.batteryPowerMW, latestData.PacTotalW, labels...)
+		}
+		flush()
+		if c.options.StaleTTL > 0 {
+			c.gauge(ch, c.dataStale, 0, battery.Name)
+		}
+		return nil
 	}
 
+	// Implausible readings are dropped from everything derived below
+	dropped := c.sanitize(battery, latestData, status)
+
 	// Mark as successful
-	ch <- prometheus.MustNewConstMetric(c.scrapeSuccess, prometheus.GaugeValue, 1, battery.Name)
+	elapsed, previousStatus := c.recordScrape(battery.Name, status)
+	c.emitScrapeSuccess(ch, 1, battery.Name)
+	c.collectErrorRate(battery, false, ch)
+	c.gauge(ch, c.scrapePartial, 0, battery.Name)
+	c.emitLastSuccess(battery.Name, ch)
+	c.gauge(ch, c.batteryOnline, 1, battery.Name)
+
+	// Track grid outages; the first scrape of an outage only counts as a
+	// transition if the battery was previously seen on grid
+	c.gauge(ch, c.inBackup, boolToFloat(isOffGrid(status.SystemStatus)), battery.Name)
+	if isOffGrid(status.SystemStatus) {
+		c.offGridSeconds.WithLabelValues(battery.Name).Add(elapsed.Seconds())
+		if previousStatus != "" && !isOffGrid(previousStatus) {
+			c.offGridTransitions.WithLabelValues(battery.Name).Inc()
+		}
+	}
+	c.collectOffGridStart(battery.Name, isOffGrid(status.SystemStatus), ch)
+
+	// Static system configuration, cached between scrapes
+	configurations := c.configurations(ctx, battery)
+
+	// Accumulate estimated CO2 displacement over the interval since the last scrape
+	if avoided := co2AvoidedGrams(status.ProductionW, elapsed, c.options.CO2IntensityGPerKWh); avoided > 0 && dropped.usable("production") {
+		c.co2Avoided.WithLabelValues(battery.Name).Add(avoided)
+	}
+
+	labels := c.valueLabelValues(battery, latestData)
+	measured, flush := c.withDeviceTimestamps(latestData, configurations, ch)
+	c.emitLatestData(battery, latestData, configurations, labels, dropped, measured)
+	c.emitStatus(battery, status, labels, dropped, measured)
+	flush()
+	c.cachePayloads(battery, latestData, status, dropped, ch)
+
+	c.collectChargeStateMismatch(battery, status)
+	c.recordShownReading(battery.Name, latestData, status, dropped)
+	c.collectSOCJump(battery, latestData, dropped)
+	c.collectPowerRamp(battery, status, elapsed, dropped, ch)
+	if dropped.usable("battery_power") {
+		// The power decides the charge state if both flags are set
+		c.collectIntervalEnergy(battery, status, ch)
+		c.collectPowerVariance(battery, status, ch)
+		c.collectPowerLimits(battery, status, configurations, ch)
+		c.collectChargeCycles(battery, latestData, status, configurations, ch)
+		c.collectTimeInMode(battery, status, elapsed)
+	}
+	if dropped.usable("battery_power", "charge_level") {
+		c.collectSelfDischarge(battery, latestData, status, ch)
+		c.collectTimeRemaining(battery, latestData, status, ch)
+	}
+	if dropped.usable("production") {
+		c.collectForecast(ctx, battery, status, ch)
+	}
+
+	// Custom metrics from registered providers
+	for _, p := range c.providers {
+		p.Collect(battery, latestData, status, ch)
+	}
+
+	// Battery module and inverter details are optional and do not affect scrape success
+	batteryData := c.collectBatteryData(ctx, battery, status, dropped, ch)
+	c.collectInverterData(ctx, battery, status, dropped, ch)
+	c.collectPowermeter(ctx, battery)
+	c.collectConfigurations(battery, latestData, configurations, ch)
+	c.collectConfigDrift(battery, configurations, ch)
+	if dropped.usable("charge_level") {
+		c.collectHealthScore(battery, latestData, configurations, batteryData, ch)
+	}
+
+	return &batteryReading{latestData: latestData, status: status, dropped: dropped}
+}
 
-	// Common labels with state information
-	labels := []string{battery.Name, latestData.ICStatus.StateBMS, latestData.ICStatus.StateInverter}
+// valueLabelValues returns the label values of the value metrics. State
+// strings come straight from the API, so they are guarded against runaway
+// cardinality.
+func (c *Collector) valueLabelValues(battery Battery, latestData *LatestData) []string {
+	labels := []string{battery.Name}
+	if !c.options.DropStateLabels {
+		states := c.guard.Check("sonnenbatterie_state_labels", latestData.ICStatus.StateBMS, latestData.ICStatus.StateInverter)
+		labels = append(labels, states[0], states[1])
+	}
+	return labels
+}
 
-	// Emit metrics from both endpoints (all in watts, convert to milliwatts)
+// emitStatus emits the metrics read from the status endpoint, except for the
+// dropped readings
+func (c *Collector) emitStatus(battery Battery, status *Status, labels []string, dropped droppedReadings, ch chan<- prometheus.Metric) {
 	// Use status endpoint for power values as they're more accurate/real-time
-	ch <- prometheus.MustNewConstMetric(c.chargeLevel, prometheus.GaugeValue, float64(latestData.RSOC), labels...)
-	ch <- prometheus.MustNewConstMetric(c.userChargeLevel, prometheus.GaugeValue, float64(latestData.USOC), labels...)
-	ch <- prometheus.MustNewConstMetric(c.consumption, prometheus.GaugeValue, status.ConsumptionW*1000, labels...)
-	ch <- prometheus.MustNewConstMetric(c.production, prometheus.GaugeValue, status.ProductionW*1000, labels...)
-	ch <- prometheus.MustNewConstMetric(c.gridFeedIn, prometheus.GaugeValue, status.GridFeedInW*1000, labels...)
-	ch <- prometheus.MustNewConstMetric(c.batteryPower, prometheus.GaugeValue, status.PacTotalW*1000, labels...)
-	ch <- prometheus.MustNewConstMetric(c.fullChargeCapacity, prometheus.GaugeValue, float64(latestData.FullChargeCapacity), labels...)
+	if dropped.usable("consumption") {
+		c.emitPower(ch, c.consumption, c.consumptionMW, status.ConsumptionW, labels...)
+	}
+	if dropped.usable("production") {
+		c.emitPower(ch, c.production, c.productionMW, status.ProductionW, labels...)
+	}
+	c.emitPower(ch, c.gridFeedIn, c.gridFeedInMW, c.feedIn(status.GridFeedInW), labels...)
+	if dropped.usable("battery_power") {
+		c.emitPower(ch, c.batteryPower, c.batteryPowerMW, status.PacTotalW, labels...)
+	}
 
 	// Charge mode as binary metrics from status endpoint
 	charging := 0.0
@@ -203,9 +1682,17 @@ func (c *Collector) collectBattery(battery Battery, ch chan<- prometheus.Metric)
 	if status.BatteryDischarging {
 		discharging = 1.0
 	}
-	ch <- prometheus.MustNewConstMetric(c.charging, prometheus.GaugeValue, charging, labels...)
-	ch <- prometheus.MustNewConstMetric(c.discharging, prometheus.GaugeValue, discharging, labels...)
+	c.gauge(ch, c.charging, charging, labels...)
+	c.gauge(ch, c.discharging, discharging, labels...)
+
+	// The same as one series per state, with contradicting flags resolved
+	current, _ := chargeState(status)
+	for _, state := range chargeStates {
+		c.gauge(ch, c.chargeState, boolToFloat(state == current), battery.Name, state)
+	}
 
+	// The flow state follows the battery's own convention, so it means the
+	// same with either FeedInSign
 	powerFlowState := 0.0
 	switch {
 	case status.GridFeedInW > 0:
@@ -213,21 +1700,383 @@ func (c *Collector) collectBattery(battery Battery, ch chan<- prometheus.Metric)
 	case status.GridFeedInW < 0:
 		powerFlowState = 1.0
 	}
-	ch <- prometheus.MustNewConstMetric(c.powerFlowState, prometheus.GaugeValue, powerFlowState, labels...)
+	c.gauge(ch, c.powerFlowState, powerFlowState, labels...)
 
 	// Voltage and frequency metrics from status endpoint
-	ch <- prometheus.MustNewConstMetric(c.acVoltage, prometheus.GaugeValue, status.Uac, labels...)
-	ch <- prometheus.MustNewConstMetric(c.batteryVoltage, prometheus.GaugeValue, status.Ubat, labels...)
-	ch <- prometheus.MustNewConstMetric(c.acFrequency, prometheus.GaugeValue, status.Fac, labels...)
+	if dropped.usable("ac_voltage") {
+		c.emitCompat(ch, c.acVoltage, c.acVoltageCompat, status.Uac, labels...)
+	}
+	if dropped.usable("battery_voltage") {
+		c.emitCompat(ch, c.batteryVoltage, c.batteryVoltageCompat, status.Ubat, labels...)
+	}
+	c.emitCompat(ch, c.acFrequency, c.acFrequencyCompat, status.Fac, labels...)
+
+	// DC- and AC-coupled solar production and coupling type
+	c.collectCoupling(battery, status, dropped, ch)
+}
+
+// emitLatestData emits the metrics that only depend on latestdata and the
+// cached configuration
+func (c *Collector) emitLatestData(battery Battery, latestData *LatestData, configurations *Configurations, labels []string, dropped droppedReadings, ch chan<- prometheus.Metric) {
+	if dropped.usable("charge_level") {
+		c.gauge(ch, c.chargeLevel, float64(latestData.RSOC), labels...)
+	}
+	if dropped.usable("user_charge_level") {
+		c.gauge(ch, c.userChargeLevel, float64(latestData.USOC), labels...)
+	}
+	if latestData.ConsumptionAvg != nil {
+		c.gauge(ch, c.consumptionAvg, *latestData.ConsumptionAvg, battery.Name)
+	}
+	c.gauge(ch, c.fullChargeCapacity, float64(latestData.FullChargeCapacity), labels...)
+
+	// Pack topology, only known on firmware reporting cells per module
+	if cells, cellStrings, ok := batteryTopology(latestData.ICStatus); ok {
+		c.gauge(ch, c.cellCount, float64(cells), battery.Name)
+		c.gauge(ch, c.stringCount, float64(cellStrings), battery.Name)
+	}
+
+	// Core control module state as one-hot series so time spent in each state can be graphed
+	current := coreControlStateBucket(latestData.ICStatus.StateCoreControlModule)
+	for _, state := range coreControlStates {
+		value := 0.0
+		if state == current {
+			value = 1.0
+		}
+		c.gauge(ch, c.coreControlState, value, battery.Name, state)
+	}
+	c.emitCoreControlModule(battery, latestData.ICStatus.StateCoreControlModule, ch)
+	c.collectStateMachine(battery, latestData.ICStatus, ch)
+
+	// Fault causes only show up as booleans in the nested ic_status objects
+	for _, flag := range icFlags(latestData.ICStatus.Raw) {
+		value := 0.0
+		if flag.Value {
+			value = 1.0
+		}
+		c.gauge(ch, c.icFlag, value, battery.Name, flag.Group, flag.Flag)
+	}
 
 	// System info
-	infoLabels := []string{
-		battery.Name,
+	infoStates := c.guard.Check("sonnenbatterie_info",
 		latestData.ICStatus.StateBMS,
 		latestData.ICStatus.StateCoreControlModule,
 		latestData.ICStatus.StateInverter,
+	)
+	infoLabels := append([]string{
+		battery.Name,
+		infoStates[0],
+		infoStates[1],
+		infoStates[2],
 		strconv.Itoa(latestData.ICStatus.NrBatteryModules),
-		battery.IP,
+		battery.Address,
+	}, systemInfo(configurations)...)
+	c.gauge(ch, c.info, 1, infoLabels...)
+
+	c.mu.Lock()
+	c.batteryState(battery.Name).infoLabels = infoLabels
+	c.mu.Unlock()
+}
+
+// collectGroups emits aggregated metrics for each parallel battery group
+// whose members were all scraped successfully
+func (c *Collector) collectGroups(batteries []Battery, groups map[string][]Battery, readings []*batteryReading, ch chan<- prometheus.Metric) {
+	if len(groups) == 0 {
+		return
+	}
+
+	byName := make(map[string]*batteryReading, len(readings))
+	for i, reading := range readings {
+		byName[batteries[i].Name] = reading
+	}
+
+	for group, members := range groups {
+		groupReadings := make([]*batteryReading, 0, len(members))
+		for _, member := range members {
+			if reading := byName[member.Name]; reading != nil && reading.dropped.usable("charge_level", "battery_power") {
+				groupReadings = append(groupReadings, reading)
+			}
+		}
+		if len(groupReadings) != len(members) {
+			log.Printf("Skipping group metrics for %s: %d of %d batteries scraped with plausible readings", group, len(groupReadings), len(members))
+			continue
+		}
+
+		totals := aggregateGroup(groupReadings)
+		c.gauge(ch, c.groupCapacity, totals.capacityWh, group)
+		c.emitPower(ch, c.groupPower, c.groupPowerMW, totals.powerW, group)
+		c.gauge(ch, c.groupChargeLevel, totals.chargeLevel, group)
+	}
+}
+
+// gauge sends a gauge sample for desc. A metric that cannot be built, for
+// example because of a label count mismatch, is sent as an invalid metric so
+// the error surfaces in the scrape instead of panicking the exporter.
+func (c *Collector) gauge(ch chan<- prometheus.Metric, desc *prometheus.Desc, value float64, labels ...string) {
+	m, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+	if err != nil {
+		c.collectionErrors.Inc()
+		log.Printf("Error building metric %s: %v", desc, err)
+		m = prometheus.NewInvalidMetric(desc, err)
+	}
+	ch <- m
+}
+
+// emitCompat sends a gauge, plus the same value under its deprecated name
+// when Compat is set
+func (c *Collector) emitCompat(ch chan<- prometheus.Metric, desc, compatDesc *prometheus.Desc, value float64, labels ...string) {
+	c.gauge(ch, desc, value, labels...)
+	if c.options.Compat {
+		c.gauge(ch, compatDesc, value, labels...)
+	}
+}
+
+// emitPower sends a power reading in watts, plus the deprecated milliwatt
+// series when LegacyMilliwatts is set
+func (c *Collector) emitPower(ch chan<- prometheus.Metric, desc, legacyDesc *prometheus.Desc, watts float64, labels ...string) {
+	c.gauge(ch, desc, watts, labels...)
+	if c.options.LegacyMilliwatts {
+		c.gauge(ch, legacyDesc, watts*1000, labels...)
+	}
+}
+
+// collectBatteryData emits metrics derived from the optional /api/v2/battery
+// endpoint and returns its data, or nil if it could not be fetched
+func (c *Collector) collectBatteryData(ctx context.Context, battery Battery, status *Status, dropped droppedReadings, ch chan<- prometheus.Metric) *BatteryData {
+	batteryData, err := fetchBatteryData(ctx, battery)
+	if err != nil {
+		c.fetchFailed(battery, "battery", err)
+		return nil
+	}
+	if imbalance, ok := cellImbalance(battery.Name, batteryData); ok {
+		c.gauge(ch, c.cellImbalance, imbalance, battery.Name)
+	}
+	if batteryData.SystemCurrent != nil {
+		current := signedBatteryCurrent(*batteryData.SystemCurrent, status)
+		c.gauge(ch, c.batteryCurrent, current, battery.Name)
+
+		// The status endpoint has no DC power, so the battery side of the
+		// inverter is the pack voltage times the pack current
+		if dropped.usable("battery_power", "battery_voltage") {
+			dcPowerW := status.Ubat * math.Abs(current)
+			if efficiency, ok := inverterEfficiency(status.PacTotalW, dcPowerW); ok {
+				c.gauge(ch, c.inverterEfficiency, efficiency, battery.Name)
+			}
+			if lossesW, ok := inverterLosses(status.PacTotalW, dcPowerW); ok {
+				c.emitPower(ch, c.inverterLosses, c.inverterLossesMW, lossesW, battery.Name)
+			}
+		}
+	}
+	if batteryData.BatteryHeaterActive != nil {
+		active := *batteryData.BatteryHeaterActive
+		c.gauge(ch, c.heaterActive, boolToFloat(active), battery.Name)
+		if c.recordHeaterState(battery.Name, active) {
+			c.heaterActivations.WithLabelValues(battery.Name).Inc()
+		}
+	}
+	if batteryData.CoolingActive != nil {
+		c.gauge(ch, c.coolingActive, boolToFloat(*batteryData.CoolingActive), battery.Name)
+	}
+
+	// Modules can be in different states, e.g. one balancing while the others are ready
+	for _, module := range batteryData.Modules {
+		id := strconv.Itoa(module.ModuleID)
+		moduleStatus := c.guard.Check("sonnenbatterie_battery_module_info", module.Status)[0]
+		c.gauge(ch, c.moduleInfo, 1, battery.Name, id, moduleStatus)
+		c.gauge(ch, c.moduleVoltage, module.Voltage, battery.Name, id)
+		c.gauge(ch, c.moduleTemperature, module.Temperature, battery.Name, id)
+	}
+
+	// A module drifting away from the others points at degradation or a loose connection
+	spread := -1.0
+	if low, high, ok := moduleVoltageRange(batteryData.Modules); ok {
+		spread = high - low
+		c.gauge(ch, c.moduleVoltageMin, low, battery.Name)
+		c.gauge(ch, c.moduleVoltageMax, high, battery.Name)
+	}
+	c.gauge(ch, c.moduleVoltageSpread, spread, battery.Name)
+	return batteryData
+}
+
+// recordHeaterState stores the reported heater state and returns whether it
+// switched on since the previous report
+func (c *Collector) recordHeaterState(name string, active bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := c.batteryState(name)
+	switchedOn := active && state.heaterActive != nil && !*state.heaterActive
+	state.heaterActive = &active
+	return switchedOn
+}
+
+// collectInverterData emits metrics derived from the optional /api/v2/inverter endpoint
+func (c *Collector) collectInverterData(ctx context.Context, battery Battery, status *Status, dropped droppedReadings, ch chan<- prometheus.Metric) {
+	inverterData, err := fetchInverterData(ctx, battery)
+	if err != nil {
+		c.fetchFailed(battery, "inverter", err)
+		return
+	}
+	// The values below are derived from the battery power
+	if !dropped.usable("battery_power") {
+		return
+	}
+	if cosPhi, ok := inverterCosPhi(status, inverterData); ok {
+		c.gauge(ch, c.inverterCosPhi, cosPhi, battery.Name)
+	}
+	c.collectReactivePower(battery, status, inverterData, ch)
+}
+
+// coreControlStates lists the core control module states emitted every scrape
+var coreControlStates = []string{"ongrid", "offgrid", "critical error", "config", "unknown"}
+
+// coreControlStateBucket maps a reported core control module state onto one
+// of coreControlStates, using "unknown" for anything unrecognised
+func coreControlStateBucket(state string) string {
+	state = strings.ToLower(strings.TrimSpace(state))
+	for _, known := range coreControlStates {
+		if state == known {
+			return known
+		}
+	}
+	return "unknown"
+}
+
+// collectOffGridStart emits when the current grid outage began. The start is
+// kept across failed scrapes, so a flaky connection during an outage does not
+// move it.
+func (c *Collector) collectOffGridStart(name string, offGrid bool, ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	state := c.batteryState(name)
+	switch {
+	case !offGrid:
+		state.offGridSince = time.Time{}
+	case state.offGridSince.IsZero():
+		state.offGridSince = c.now()
+	}
+	since := state.offGridSince
+	c.mu.Unlock()
+
+	if !since.IsZero() {
+		c.gauge(ch, c.offGridStart, float64(since.Unix()), name)
+	}
+}
+
+// isOffGrid reports whether a SystemStatus value indicates a grid outage
+func isOffGrid(systemStatus string) bool {
+	return strings.EqualFold(systemStatus, "OffGrid")
+}
+
+// co2AvoidedGrams estimates the CO2 displaced by producing productionW for elapsed
+// at the given grid carbon intensity in g/kWh
+func co2AvoidedGrams(productionW float64, elapsed time.Duration, intensity float64) float64 {
+	if productionW <= 0 || elapsed <= 0 {
+		return 0
+	}
+	return productionW / 1000 * elapsed.Hours() * intensity
+}
+
+// signedBatteryCurrent normalises the pack current so charging is positive and
+// discharging negative. Firmware versions disagree on the sign, so the
+// charging flags from the status endpoint decide it whenever one is set.
+func signedBatteryCurrent(current float64, status *Status) float64 {
+	switch {
+	case status.BatteryCharging:
+		return math.Abs(current)
+	case status.BatteryDischarging:
+		return -math.Abs(current)
+	default:
+		return current
+	}
+}
+
+// batteryTopology returns the total cell count and the number of series
+// strings. Each module is a single series string of cells, so the string count
+// equals the module count.
+func batteryTopology(ic ICStatus) (cells, cellStrings int, ok bool) {
+	if ic.NrBatteryModules <= 0 || ic.NrCellsPerModule <= 0 {
+		return 0, 0, false
+	}
+	return ic.NrBatteryModules * ic.NrCellsPerModule, ic.NrBatteryModules, true
+}
+
+// cellImbalance returns the spread between maximum and minimum cell voltage.
+// It reports false unless both bounds are present and clamps inverted bounds to 0.
+func cellImbalance(name string, data *BatteryData) (float64, bool) {
+	if data.MinimumCellVoltage == nil || data.MaximumCellVoltage == nil {
+		return 0, false
+	}
+	imbalance := *data.MaximumCellVoltage - *data.MinimumCellVoltage
+	if imbalance < 0 {
+		log.Printf("Warning: %s reported maximum cell voltage %.3f below minimum %.3f",
+			name, *data.MaximumCellVoltage, *data.MinimumCellVoltage)
+		return 0, true
+	}
+	return imbalance, true
+}
+
+// moduleVoltageRange returns the lowest and highest module voltage. It reports
+// false if no modules are reported; a single module has no spread.
+func moduleVoltageRange(modules []BatteryModule) (float64, float64, bool) {
+	if len(modules) == 0 {
+		return 0, 0, false
+	}
+	low, high := modules[0].Voltage, modules[0].Voltage
+	for _, module := range modules[1:] {
+		low = math.Min(low, module.Voltage)
+		high = math.Max(high, module.Voltage)
+	}
+	return low, high, true
+}
+
+// maxInverterEfficiency absorbs measurement noise of the two power readings
+const maxInverterEfficiency = 1.05
+
+// inverterPowers returns the input and output power magnitudes of the
+// inverter: DC to AC while discharging, and AC to DC while charging, when the
+// AC power is negative. It reports false if either side is zero.
+func inverterPowers(acPowerW, dcPowerW float64) (input, output float64, ok bool) {
+	ac, dc := math.Abs(acPowerW), math.Abs(dcPowerW)
+	if ac == 0 || dc == 0 {
+		return 0, 0, false
+	}
+	if acPowerW < 0 {
+		return ac, dc, true
+	}
+	return dc, ac, true
+}
+
+// inverterEfficiency returns the ratio of output to input power clamped to
+// (0, maxInverterEfficiency]
+func inverterEfficiency(acPowerW, dcPowerW float64) (float64, bool) {
+	input, output, ok := inverterPowers(acPowerW, dcPowerW)
+	if !ok {
+		return 0, false
+	}
+	return math.Min(output/input, maxInverterEfficiency), true
+}
+
+// inverterLosses returns input minus output power in watts. It reports false
+// where the efficiency is clamped, as the readings are then implausible.
+func inverterLosses(acPowerW, dcPowerW float64) (float64, bool) {
+	input, output, ok := inverterPowers(acPowerW, dcPowerW)
+	if !ok || output/input > maxInverterEfficiency {
+		return 0, false
+	}
+	return input - output, true
+}
+
+// inverterCosPhi returns the inverter power factor clamped to [-1, 1]. A value
+// reported by the inverter takes precedence; otherwise it is derived from the
+// active power and the apparent power, which must be present and non-zero.
+func inverterCosPhi(status *Status, data *InverterData) (float64, bool) {
+	var cosPhi float64
+	switch {
+	case data.CosPhi != nil:
+		cosPhi = *data.CosPhi
+	case data.SacTotal != nil && *data.SacTotal != 0:
+		cosPhi = status.PacTotalW / *data.SacTotal
+	default:
+		return 0, false
 	}
-	ch <- prometheus.MustNewConstMetric(c.info, prometheus.GaugeValue, 1, infoLabels...)
+	return math.Max(-1, math.Min(1, cosPhi)), true
 }
@@ -2,20 +2,38 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 )
 
+// exporterMetrics is the number of exporter-wide metrics sent on every Collect:
+// co2Intensity, configWarnings, configuredBatteries, reachableBatteries and
+// collectionErrors
+const exporterMetrics = 5
+
+// requestMetrics is the number of request metrics per battery endpoint queried:
+// the latency histogram and summary
+const requestMetrics = 2
+
 func TestNewCollector(t *testing.T) {
 	batteries := []Battery{
-		{Name: "test1", IP: "192.168.1.100", AuthToken: "token1"},
-		{Name: "test2", IP: "192.168.1.101", AuthToken: "token2"},
+		{Name: "test1", Address: "192.168.1.100", AuthToken: "token1"},
+		{Name: "test2", Address: "192.168.1.101", AuthToken: "token2"},
 	}
 
-	collector := NewCollector(batteries)
+	collector := NewCollector(batteries, CollectorOptions{})
 
 	if len(collector.batteries) != 2 {
 		t.Errorf("NewCollector() batteries count = %d, want 2", len(collector.batteries))
@@ -38,12 +56,131 @@ func TestNewCollector(t *testing.T) {
 	}
 }
 
+func TestCollector_SetBatteries(t *testing.T) {
+	collector := NewCollector([]Battery{
+		{Name: "old", Address: "192.168.1.100", AuthToken: "token1"},
+		{Name: "kept", Address: "192.168.1.101", AuthToken: "token2"},
+	}, CollectorOptions{})
+	collector.co2Avoided.WithLabelValues("old").Add(1)
+	collector.co2Avoided.WithLabelValues("kept").Add(1)
+
+	collector.SetBatteries([]Battery{
+		{Name: "kept", Address: "192.168.1.101", AuthToken: "token2", Group: "plant"},
+		{Name: "new", Address: "192.168.1.102", AuthToken: "token3", Group: "plant"},
+	})
+
+	batteries := collector.Batteries()
+	if len(batteries) != 2 || batteries[1].Name != "new" {
+		t.Errorf("Batteries() = %+v, want kept and new", batteries)
+	}
+	if len(collector.groups["plant"]) != 2 {
+		t.Errorf("group plant has %d batteries, want 2", len(collector.groups["plant"]))
+	}
+
+	// Counters of removed batteries are dropped, others are kept
+	if got := testutil.CollectAndCount(collector.co2Avoided); got != 1 {
+		t.Errorf("co2Avoided has %d series, want 1", got)
+	}
+}
+
+func TestCollector_DuplicateBattery(t *testing.T) {
+	server := newMockBatteryServer(
+		&LatestData{ICStatus: ICStatus{StateBMS: "ready", StateInverter: "running"}},
+		&Status{},
+	)
+	defer server.Close()
+
+	battery := Battery{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token", Group: "plant"}
+	collector := NewCollector([]Battery{battery, battery}, CollectorOptions{})
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(collector)
+
+	if _, err := registry.Gather(); err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	if got := testutil.CollectAndCount(collector, "sonnenbatterie_scrape_success"); got != 1 {
+		t.Errorf("sonnenbatterie_scrape_success has %d series, want 1", got)
+	}
+	// A group of one battery is not a parallel group
+	if got := testutil.CollectAndCount(collector, "sonnenbatterie_parallel_system_capacity_wh"); got != 0 {
+		t.Errorf("sonnenbatterie_parallel_system_capacity_wh has %d series, want 0", got)
+	}
+
+	want := `
+# HELP sonnenbatterie_duplicate_battery Number of additional batteries configured with this name, which are not scraped
+# TYPE sonnenbatterie_duplicate_battery gauge
+sonnenbatterie_duplicate_battery{battery_name="test-battery"} 1
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want), "sonnenbatterie_duplicate_battery"); err != nil {
+		t.Error(err)
+	}
+
+	// Fixing the configuration clears the metric
+	collector.SetBatteries([]Battery{battery})
+	if got := testutil.CollectAndCount(collector, "sonnenbatterie_duplicate_battery"); got != 0 {
+		t.Errorf("sonnenbatterie_duplicate_battery has %d series after fix, want 0", got)
+	}
+}
+
+// TestCollector_SetBatteriesConcurrent is meant to run under go test -race
+func TestCollector_SetBatteriesConcurrent(t *testing.T) {
+	server := newMockBatteryServer(
+		&LatestData{ICStatus: ICStatus{StateBMS: "ready", StateInverter: "running"}},
+		&Status{SystemStatus: "OnGrid"},
+	)
+	defer server.Close()
+
+	configs := [][]Battery{
+		{{Name: "a", Address: server.URL[7:], AuthToken: "token"}},
+		{
+			{Name: "a", Address: server.URL[7:], AuthToken: "token", Group: "plant"},
+			{Name: "b", Address: server.URL[7:], AuthToken: "token", Group: "plant"},
+		},
+		{{Name: "b", Address: server.URL[7:], AuthToken: "token"}},
+	}
+
+	collector := NewCollector(configs[0], CollectorOptions{})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if _, err := registry.Gather(); err != nil {
+					t.Errorf("Gather() error = %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 50; j++ {
+			batteries := append([]Battery(nil), configs[j%len(configs)]...)
+			collector.SetBatteries(batteries)
+			// Changing the passed slice afterwards must not affect the collector
+			batteries[0].Name = "changed"
+		}
+	}()
+	wg.Wait()
+
+	for _, b := range collector.Batteries() {
+		if b.Name == "changed" {
+			t.Errorf("Batteries() = %+v, want the slice passed to SetBatteries copied", collector.Batteries())
+		}
+	}
+}
+
 func TestCollector_Describe(t *testing.T) {
 	batteries := []Battery{
-		{Name: "test", IP: "192.168.1.100", AuthToken: "token"},
+		{Name: "test", Address: "192.168.1.100", AuthToken: "token"},
 	}
 
-	collector := NewCollector(batteries)
+	collector := NewCollector(batteries, CollectorOptions{})
 	descCh := make(chan *prometheus.Desc, 20)
 
 	go func() {
@@ -56,17 +193,27 @@ func TestCollector_Describe(t *testing.T) {
 		count++
 	}
 
-	// We have 15 metrics: chargeLevel, userChargeLevel, consumption, production, gridFeedIn,
-	// batteryPower, fullChargeCapacity, charging, discharging, powerFlowState, acVoltage,
-	// batteryVoltage, acFrequency, info, scrapeSuccess
-	expectedCount := 15
+	// We have 122 metrics: chargeLevel, userChargeLevel, consumption, consumptionAvg, production, gridFeedIn,
+	// batteryPower, fullChargeCapacity, charging, discharging, chargeState, powerFlowState, acVoltage,
+	// batteryVoltage, acFrequency, coreControlState, coreControlModuleInfo, coreControlModuleState, stateMachineInfo, icFlag, cellImbalance, batteryCurrent,
+	// heaterActive, coolingActive, moduleInfo, moduleVoltage, moduleTemperature,
+	// moduleVoltageSpread, moduleVoltageMin, moduleVoltageMax, cellCount, stringCount, designCapacity,
+	// commissioningDate, batteryAge, warrantyRemaining,
+	// firmwareUpdateAvailable, firmwareUpdateInProgress, timezoneInfo, priceImport, priceExport, configInfo, configLastUpdate, clockOffset,
+	// inverterCosPhi, reactivePower, apparentPower, powerAngle, inverterInfo, pvPanelsInfo, inverterEfficiency,
+	// inverterLosses, dcInputPower, dcInputVoltage, dcInputCurrent, couplingType, acCouplingPower, acCouplingDetected,
+	// co2Intensity, configWarnings, configDriftDetected, configuredBatteries, reachableBatteries, duplicateBattery, locationInfo, clientCertExpiry, groupCapacity, groupPower, groupChargeLevel, info,
+	// scrapeSuccess, up, scrapePartial, batteryOnline, inBackup, offGridStart, selfDischarge, intervalEnergy, intervalEnergyPeak, intervalEnergyCoverage, lastActualScrape, dataStale, powerVariance, powerStdDev, powerRampRate, powerRampDirection, timeToEmpty, timeToFull, chargePowerLimit, dischargePowerLimit, chargeUtilization, dischargeUtilization, chargeCyclesToday, apiErrorRate, apiDegraded, consecutiveFailures, healthScore, healthComponents, forecastError, lastScrapeSuccess, co2Avoided, powermeterEnergy, offGridSeconds, offGridTransitions, heaterActivations, chargeCycles, scrapeErrors, scrapeTimeouts, collectionErrors, anomalousReadings, scrapeThrottled, scrapeMissed, socJumps, coreControlChanges, stateTransitions, forecastRequests, forecastErrors, chargeStateMismatches, timeInMode, configDriftEvents, cardinalityLimitExceeded,
+	// tokenRefreshes, tokenRefreshErrors, authFailures, tokenInvalid, requestDurationHistogram, requestDurationSummary,
+	// dnsLookupDuration, dnsResolutionErrors, httpProtocolInfo, http2InUse, decodeFailures
+	expectedCount := 132
 	if count != expectedCount {
 		t.Errorf("Describe() sent %d descriptors, want %d", count, expectedCount)
 	}
 }
 
 func TestCollector_Collect_EmptyBatteries(t *testing.T) {
-	collector := NewCollector([]Battery{})
+	collector := NewCollector([]Battery{}, CollectorOptions{})
 	metricCh := make(chan prometheus.Metric, 100)
 
 	go func() {
@@ -79,15 +226,18 @@ func TestCollector_Collect_EmptyBatteries(t *testing.T) {
 		count++
 	}
 
-	if count != 0 {
-		t.Errorf("Collect() with no batteries sent %d metrics, want 0", count)
+	// Only the exporter-wide metrics are sent
+	if count != exporterMetrics {
+		t.Errorf("Collect() with no batteries sent %d metrics, want %d", count, exporterMetrics)
 	}
 }
 
 func TestCollector_Collect_Success(t *testing.T) {
 	// Create mock servers for latestdata and status endpoints
+	consumptionAvg := 740.0
 	mockLatestData := LatestData{
 		ConsumptionW:       750.5,
+		ConsumptionAvg:     &consumptionAvg,
 		FullChargeCapacity: 5000,
 		GridFeedInW:        -250.0,
 		PacTotalW:          100.0,
@@ -136,11 +286,11 @@ func TestCollector_Collect_Success(t *testing.T) {
 
 	battery := Battery{
 		Name:      "test-battery",
-		IP:        server.URL[7:], // Remove "http://" prefix
+		Address:   server.URL[7:], // Remove "http://" prefix
 		AuthToken: "test-token",
 	}
 
-	collector := NewCollector([]Battery{battery})
+	collector := NewCollector([]Battery{battery}, CollectorOptions{})
 	metricCh := make(chan prometheus.Metric, 100)
 
 	go func() {
@@ -154,10 +304,16 @@ func TestCollector_Collect_Success(t *testing.T) {
 		count++
 	}
 
-	// We expect: scrapeSuccess + chargeLevel + userChargeLevel + consumption + production +
-	// gridFeedIn + batteryPower + fullChargeCapacity + charging + discharging + powerFlowState +
-	// acVoltage + batteryVoltage + acFrequency + info = 15 metrics
-	expectedCount := 15
+	// We expect: scrapeSuccess + up + scrapePartial + chargeLevel + userChargeLevel + consumption + consumptionAvg + production +
+	// gridFeedIn + batteryPower + fullChargeCapacity + charging + discharging + 3 chargeState + powerFlowState +
+	// acVoltage + batteryVoltage + acFrequency + 5 coreControlState + coreControlModuleInfo +
+	// coreControlModuleState + stateMachineInfo + info + inverterInfo + batteryOnline + inBackup + couplingType +
+	// selfDischarge + chargeCyclesToday + apiErrorRate + apiDegraded + consecutiveFailures + healthScore +
+	// healthComponents + lastScrapeSuccess + locationInfo + timeToEmpty + timeToFull + acCouplingPower +
+	// acCouplingDetected = 46 metrics, plus the exporter-wide metrics, scrape errors for the 4 optional
+	// endpoints the mock does not serve, the request metrics of the 6 endpoints queried and
+	// httpProtocolInfo, http2InUse and tokenInvalid
+	expectedCount := 46 + exporterMetrics + 4 + 6*requestMetrics + 3
 	if count != expectedCount {
 		t.Errorf("Collect() sent %d metrics, want %d", count, expectedCount)
 	}
@@ -174,11 +330,11 @@ func TestCollector_Collect_LatestDataError(t *testing.T) {
 
 	battery := Battery{
 		Name:      "test-battery",
-		IP:        server.URL[7:],
+		Address:   server.URL[7:],
 		AuthToken: "test-token",
 	}
 
-	collector := NewCollector([]Battery{battery})
+	collector := NewCollector([]Battery{battery}, CollectorOptions{})
 	metricCh := make(chan prometheus.Metric, 100)
 
 	go func() {
@@ -186,19 +342,20 @@ func TestCollector_Collect_LatestDataError(t *testing.T) {
 		close(metricCh)
 	}()
 
-	// Should only get scrapeSuccess metric with value 0
+	// Should only get scrapeSuccess and up with value 0, scrapePartial, batteryOnline,
+	// apiErrorRate, apiDegraded, consecutiveFailures, locationInfo, the scrape error, the exporter-wide metrics
+	// and the request and protocol metrics of latestdata
 	count := 0
 	for range metricCh {
 		count++
 	}
 
-	if count != 1 {
-		t.Errorf("Collect() with latestdata error sent %d metrics, want 1 (scrapeSuccess)", count)
+	if want := 9 + exporterMetrics + requestMetrics + 2; count != want {
+		t.Errorf("Collect() with latestdata error sent %d metrics, want %d", count, want)
 	}
 }
 
-func TestCollector_Collect_StatusError(t *testing.T) {
-	// Create a server that returns success for latestdata but error for status
+func TestCollector_Collect_PartialFailure(t *testing.T) {
 	mockLatestData := LatestData{
 		ConsumptionW:       750.5,
 		FullChargeCapacity: 5000,
@@ -216,39 +373,626 @@ func TestCollector_Collect_StatusError(t *testing.T) {
 		},
 	}
 
+	// Metrics emitted whenever the battery is scraped at all
+	base := []string{
+		"sonnenbatterie_api_degraded",
+		"sonnenbatterie_api_error_rate",
+		"sonnenbatterie_battery_online",
+		"sonnenbatterie_collection_errors_total",
+		"sonnenbatterie_config_warnings",
+		"sonnenbatterie_configured_batteries",
+		"sonnenbatterie_consecutive_scrape_failures",
+		"sonnenbatterie_grid_co2_intensity_g_kwh",
+		"sonnenbatterie_http2_in_use",
+		"sonnenbatterie_http_protocol_info",
+		"sonnenbatterie_installation_location_info",
+		"sonnenbatterie_reachable_batteries",
+		"sonnenbatterie_request_duration_seconds",
+		"sonnenbatterie_request_latency_seconds",
+		"sonnenbatterie_scrape_errors_total",
+		"sonnenbatterie_scrape_partial",
+		"sonnenbatterie_scrape_success",
+		"sonnenbatterie_up",
+	}
+	latestDataMetrics := []string{
+		"sonnenbatterie_battery_power_watts",
+		"sonnenbatterie_battery_state_machine_info",
+		"sonnenbatterie_charge_level_percent",
+		"sonnenbatterie_consumption_watts",
+		"sonnenbatterie_core_control_module_state",
+		"sonnenbatterie_core_control_module_state_info",
+		"sonnenbatterie_core_control_state",
+		"sonnenbatterie_full_charge_capacity_wh",
+		"sonnenbatterie_grid_feed_in_watts",
+		"sonnenbatterie_info",
+		"sonnenbatterie_production_watts",
+		"sonnenbatterie_token_invalid",
+		"sonnenbatterie_user_charge_level_percent",
+	}
+
+	tests := []struct {
+		name           string
+		latestDataDown bool
+		statusDown     bool
+		wantMetrics    []string
+		wantPartial    float64
+		wantConsumed   float64
+	}{
+		{
+			name:         "status down",
+			statusDown:   true,
+			wantMetrics:  append(append([]string{}, base...), latestDataMetrics...),
+			wantPartial:  1,
+			wantConsumed: 750.5,
+		},
+		{
+			name:           "latestdata down",
+			latestDataDown: true,
+			wantMetrics:    base,
+		},
+		{
+			name:           "both down",
+			latestDataDown: true,
+			statusDown:     true,
+			wantMetrics:    base,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch {
+				case r.URL.Path == "/api/v2/latestdata" && !tt.latestDataDown:
+					_ = json.NewEncoder(w).Encode(mockLatestData)
+				case r.URL.Path == "/api/v2/status" && !tt.statusDown:
+					_ = json.NewEncoder(w).Encode(Status{ConsumptionW: 800})
+				default:
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			}))
+			defer server.Close()
+
+			collector := NewCollector(
+				[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+				CollectorOptions{},
+			)
+			registry := prometheus.NewPedanticRegistry()
+			registry.MustRegister(collector)
+			families, err := registry.Gather()
+			if err != nil {
+				t.Fatalf("Gather() error = %v", err)
+			}
+
+			var got []string
+			values := map[string]float64{}
+			for _, family := range families {
+				got = append(got, family.GetName())
+				if m := family.GetMetric(); len(m) == 1 && m[0].GetGauge() != nil {
+					values[family.GetName()] = m[0].GetGauge().GetValue()
+				}
+			}
+
+			want := append([]string{}, tt.wantMetrics...)
+			sort.Strings(want)
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("metrics = %v, want %v", got, want)
+			}
+			if values["sonnenbatterie_scrape_success"] != 0 {
+				t.Errorf("scrape_success = %f, want 0", values["sonnenbatterie_scrape_success"])
+			}
+			if values["sonnenbatterie_scrape_partial"] != tt.wantPartial {
+				t.Errorf("scrape_partial = %f, want %f", values["sonnenbatterie_scrape_partial"], tt.wantPartial)
+			}
+			// Power flows fall back to latestdata when status is down
+			if values["sonnenbatterie_consumption_watts"] != tt.wantConsumed {
+				t.Errorf("consumption = %f, want %f", values["sonnenbatterie_consumption_watts"], tt.wantConsumed)
+			}
+		})
+	}
+}
+
+func TestCollector_PowerUnits(t *testing.T) {
+	server := newMockBatteryServer(&LatestData{}, &Status{
+		ConsumptionW: 750.5,
+		ProductionW:  500,
+		GridFeedInW:  -250.25,
+		PacTotalW:    100,
+	})
+	defer server.Close()
+
+	wantWatts := map[string]float64{
+		"sonnenbatterie_consumption_watts":   750.5,
+		"sonnenbatterie_production_watts":    500,
+		"sonnenbatterie_grid_feed_in_watts":  -250.25,
+		"sonnenbatterie_battery_power_watts": 100,
+	}
+	wantMilliwatts := map[string]float64{
+		"sonnenbatterie_consumption_mw":   750500,
+		"sonnenbatterie_production_mw":    500000,
+		"sonnenbatterie_grid_feed_in_mw":  -250250,
+		"sonnenbatterie_battery_power_mw": 100000,
+	}
+
+	tests := []struct {
+		name   string
+		legacy bool
+	}{
+		{name: "watts only", legacy: false},
+		{name: "legacy milliwatts", legacy: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := NewCollector(
+				[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+				CollectorOptions{LegacyMilliwatts: tt.legacy},
+			)
+			registry := prometheus.NewPedanticRegistry()
+			registry.MustRegister(collector)
+			families, err := registry.Gather()
+			if err != nil {
+				t.Fatalf("Gather() error = %v", err)
+			}
+
+			values := map[string]float64{}
+			for _, family := range families {
+				values[family.GetName()] = family.GetMetric()[0].GetGauge().GetValue()
+			}
+
+			for name, want := range wantWatts {
+				if got, ok := values[name]; !ok || math.Abs(got-want) > 1e-9 {
+					t.Errorf("%s = %v (present %v), want %v", name, got, ok, want)
+				}
+			}
+			for name, want := range wantMilliwatts {
+				got, ok := values[name]
+				if !tt.legacy {
+					if ok {
+						t.Errorf("%s emitted without legacy milliwatts", name)
+					}
+					continue
+				}
+				if !ok || math.Abs(got-want) > 1e-6 {
+					t.Errorf("%s = %v (present %v), want %v", name, got, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCollector_InvalidMetric(t *testing.T) {
+	server := newMockBatteryServer(
+		&LatestData{RSOC: 85, ICStatus: ICStatus{StateBMS: "ready", StateInverter: "running"}},
+		&Status{},
+	)
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+	// One label more than the collector sends, so building the metric fails
+	collector.chargeLevel = prometheus.NewDesc(
+		"sonnenbatterie_charge_level_percent",
+		"Relative state of charge",
+		[]string{"battery_name", "bms_state", "inverter_state", "extra"},
+		nil,
+	)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	families, err := registry.Gather()
+	if err == nil || !strings.Contains(err.Error(), "sonnenbatterie_charge_level_percent") {
+		t.Fatalf("Gather() error = %v, want error for sonnenbatterie_charge_level_percent", err)
+	}
+
+	// The remaining metrics are still gathered
+	found := false
+	for _, family := range families {
+		if family.GetName() == "sonnenbatterie_user_charge_level_percent" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("sonnenbatterie_user_charge_level_percent missing after collection error")
+	}
+
+	if got := testutil.ToFloat64(collector.collectionErrors); got != 1 {
+		t.Errorf("collectionErrors = %v, want 1", got)
+	}
+}
+
+func TestCollector_CompatNames(t *testing.T) {
+	server := newMockBatteryServer(
+		&LatestData{ICStatus: ICStatus{StateBMS: "ready", StateInverter: "running"}},
+		&Status{Uac: 229.5, Ubat: 51.2, Fac: 50.01},
+	)
+	defer server.Close()
+
+	names := map[string]string{
+		"sonnenbatterie_ac_voltage_volts":      "sonnenbatterie_ac_voltage",
+		"sonnenbatterie_battery_voltage_volts": "sonnenbatterie_battery_voltage",
+		"sonnenbatterie_ac_frequency_hertz":    "sonnenbatterie_ac_frequency",
+	}
+
+	for _, compat := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compat=%v", compat), func(t *testing.T) {
+			collector := NewCollector(
+				[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+				CollectorOptions{Compat: compat},
+			)
+			registry := prometheus.NewPedanticRegistry()
+			registry.MustRegister(collector)
+			families, err := registry.Gather()
+			if err != nil {
+				t.Fatalf("Gather() error = %v", err)
+			}
+
+			// Series by metric name, as their label pairs and value
+			series := map[string]string{}
+			for _, family := range families {
+				m := family.GetMetric()[0]
+				series[family.GetName()] = fmt.Sprint(m.GetLabel(), m.GetGauge().GetValue())
+			}
+
+			for name, oldName := range names {
+				got, ok := series[name]
+				if !ok {
+					t.Errorf("%s missing", name)
+					continue
+				}
+				old, oldOK := series[oldName]
+				if oldOK != compat {
+					t.Errorf("%s present = %v, want %v", oldName, oldOK, compat)
+				}
+				if compat && old != got {
+					t.Errorf("%s = %s, want the same labels and value as %s = %s", oldName, old, name, got)
+				}
+			}
+
+			if !compat {
+				newNames := make([]string, 0, len(names))
+				for name := range names {
+					newNames = append(newNames, name)
+				}
+				problems, err := testutil.CollectAndLint(collector, newNames...)
+				if err != nil {
+					t.Fatalf("CollectAndLint() error = %v", err)
+				}
+				for _, p := range problems {
+					t.Errorf("lint: %s: %s", p.Metric, p.Text)
+				}
+			}
+		})
+	}
+}
+
+func TestCollector_DropStateLabels(t *testing.T) {
+	latestData := &LatestData{RSOC: 50, ICStatus: ICStatus{StateBMS: "ready", StateInverter: "running"}}
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
 		switch r.URL.Path {
 		case "/api/v2/latestdata":
-			_ = json.NewEncoder(w).Encode(mockLatestData)
+			_ = json.NewEncoder(w).Encode(latestData)
 		case "/api/v2/status":
-			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(Status{})
+		default:
+			w.WriteHeader(http.StatusNotFound)
 		}
 	}))
 	defer server.Close()
 
-	battery := Battery{
-		Name:      "test-battery",
-		IP:        server.URL[7:],
-		AuthToken: "test-token",
+	// seriesIdentity returns the label pairs of the charge level series
+	seriesIdentity := func(collector *Collector) string {
+		for _, m := range collectAll(collector) {
+			if m.Desc() == collector.chargeLevel {
+				return fmt.Sprint(writeMetric(t, m).GetLabel())
+			}
+		}
+		t.Fatal("charge level not emitted")
+		return ""
 	}
 
-	collector := NewCollector([]Battery{battery})
-	metricCh := make(chan prometheus.Metric, 100)
+	tests := []struct {
+		name         string
+		drop         bool
+		wantPreserve bool
+	}{
+		{name: "state labels", drop: false, wantPreserve: false},
+		{name: "drop state labels", drop: true, wantPreserve: true},
+	}
 
-	go func() {
-		collector.Collect(metricCh)
-		close(metricCh)
-	}()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			latestData.ICStatus.StateBMS = "ready"
+			collector := NewCollector(
+				[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+				CollectorOptions{DropStateLabels: tt.drop},
+			)
 
-	// Should only get scrapeSuccess metric with value 0
-	count := 0
-	for range metricCh {
-		count++
+			before := seriesIdentity(collector)
+			latestData.ICStatus.StateBMS = "charging"
+			after := seriesIdentity(collector)
+
+			if preserved := before == after; preserved != tt.wantPreserve {
+				t.Errorf("series identity preserved = %v, want %v (before %s, after %s)", preserved, tt.wantPreserve, before, after)
+			}
+
+			// The states remain available for joins on the info metric
+			for _, m := range collectAll(collector) {
+				if m.Desc() == collector.info {
+					if got := labelValue(writeMetric(t, m), "bms_state"); got != "charging" {
+						t.Errorf("info bms_state = %q, want charging", got)
+					}
+				}
+			}
+		})
 	}
+}
 
-	if count != 1 {
-		t.Errorf("Collect() with status error sent %d metrics, want 1 (scrapeSuccess)", count)
+func TestCollector_ScrapeErrors(t *testing.T) {
+	var failStatus atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/latestdata", "/api/v2/battery", "/api/v2/inverter", "/api/v2/configurations":
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/v2/status":
+			if failStatus.Load() {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+	errors := func(endpoint string) float64 {
+		return testutil.ToFloat64(collector.scrapeErrors.WithLabelValues("test-battery", endpoint))
+	}
+
+	// Only the powermeter is missing
+	collectAll(collector)
+	collectAll(collector)
+	if got := errors("powermeter"); got != 2 {
+		t.Errorf("powermeter errors = %f, want 2", got)
+	}
+	if got := errors("status"); got != 0 {
+		t.Errorf("status errors = %f, want 0", got)
+	}
+
+	// A failing status stops the scrape before the optional endpoints
+	failStatus.Store(true)
+	collectAll(collector)
+	if got := errors("status"); got != 1 {
+		t.Errorf("status errors = %f, want 1", got)
+	}
+	if got := errors("powermeter"); got != 2 {
+		t.Errorf("powermeter errors after failed scrape = %f, want 2", got)
+	}
+	for _, endpoint := range []string{"latestdata", "battery", "inverter", "configurations"} {
+		if got := errors(endpoint); got != 0 {
+			t.Errorf("%s errors = %f, want 0", endpoint, got)
+		}
+	}
+}
+
+func TestCollector_LastScrapeSuccess(t *testing.T) {
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v2/latestdata", "/api/v2/status":
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+	now := time.Date(2025, 11, 29, 12, 0, 0, 0, time.UTC)
+	collector.now = func() time.Time { return now }
+
+	lastSuccess := func() (float64, bool) {
+		for _, m := range collectAll(collector) {
+			if m.Desc() == collector.lastScrapeSuccess {
+				return writeMetric(t, m).GetGauge().GetValue(), true
+			}
+		}
+		return 0, false
+	}
+
+	// A battery that never succeeded emits nothing
+	failing.Store(true)
+	if value, ok := lastSuccess(); ok {
+		t.Errorf("last scrape success before any success = %f, want none", value)
+	}
+
+	// Success updates the timestamp
+	failing.Store(false)
+	succeeded := now
+	if value, ok := lastSuccess(); !ok || value != float64(succeeded.Unix()) {
+		t.Errorf("last scrape success = %f (present %v), want %d", value, ok, succeeded.Unix())
+	}
+
+	// A later failure leaves it unchanged
+	failing.Store(true)
+	now = now.Add(time.Minute)
+	if value, ok := lastSuccess(); !ok || value != float64(succeeded.Unix()) {
+		t.Errorf("last scrape success after failure = %f (present %v), want %d", value, ok, succeeded.Unix())
+	}
+}
+
+func TestCollector_BatteryOnline(t *testing.T) {
+	// Reachable but failing battery
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	// Closed server, so connections are refused
+	offline := httptest.NewServer(http.NotFoundHandler())
+	offlineIP := offline.URL[7:]
+	offline.Close()
+
+	collector := NewCollector([]Battery{
+		{Name: "failing", Address: failing.URL[7:], AuthToken: "test-token"},
+		{Name: "offline", Address: offlineIP, AuthToken: "test-token"},
+	}, CollectorOptions{})
+
+	online := map[string]float64{}
+	success := map[string]float64{}
+	for _, m := range collectAll(collector) {
+		switch m.Desc() {
+		case collector.batteryOnline:
+			pb := writeMetric(t, m)
+			online[labelValue(pb, "battery_name")] = pb.GetGauge().GetValue()
+		case collector.scrapeSuccess:
+			pb := writeMetric(t, m)
+			success[labelValue(pb, "battery_name")] = pb.GetGauge().GetValue()
+		}
+	}
+
+	if success["failing"] != 0 || success["offline"] != 0 {
+		t.Errorf("scrape_success = %v, want 0 for both batteries", success)
+	}
+	if online["failing"] != 1 {
+		t.Errorf("battery_online for 500-responding battery = %f, want 1", online["failing"])
+	}
+	if v, ok := online["offline"]; !ok || v != 0 {
+		t.Errorf("battery_online for unreachable battery = %v (present %v), want 0", v, ok)
+	}
+}
+
+func TestCollector_Up(t *testing.T) {
+	tests := []struct {
+		name           string
+		latestDataDown bool
+		statusDown     bool
+		want           float64
+	}{
+		{name: "success", want: 1},
+		{name: "partial", statusDown: true, want: 0},
+		{name: "failure", latestDataDown: true, statusDown: true, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch {
+				case r.URL.Path == "/api/v2/latestdata" && !tt.latestDataDown:
+					_ = json.NewEncoder(w).Encode(LatestData{RSOC: 50})
+				case r.URL.Path == "/api/v2/status" && !tt.statusDown:
+					_ = json.NewEncoder(w).Encode(Status{ConsumptionW: 800})
+				default:
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			}))
+			defer server.Close()
+
+			collector := NewCollector(
+				[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+				CollectorOptions{},
+			)
+
+			success, up := -1.0, -1.0
+			for _, m := range collectAll(collector) {
+				switch m.Desc() {
+				case collector.scrapeSuccess:
+					success = writeMetric(t, m).GetGauge().GetValue()
+				case collector.up:
+					up = writeMetric(t, m).GetGauge().GetValue()
+				}
+			}
+			if success != tt.want || up != success {
+				t.Errorf("scrape_success = %v, up = %v, want both %v", success, up, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollector_LocationInfo(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	collector := NewCollector([]Battery{
+		{Name: "house", Address: server.URL[7:], AuthToken: "test-token", Location: "berlin"},
+		{Name: "garage", Address: server.URL[7:], AuthToken: "test-token"},
+	}, CollectorOptions{})
+
+	locations := map[string]string{}
+	for _, m := range collectAll(collector) {
+		if m.Desc() == collector.locationInfo {
+			pb := writeMetric(t, m)
+			if pb.GetGauge().GetValue() != 1 {
+				t.Errorf("installation_location_info value = %f, want 1", pb.GetGauge().GetValue())
+			}
+			locations[labelValue(pb, "battery_name")] = labelValue(pb, "location")
+		}
+	}
+
+	if len(locations) != 2 || locations["house"] != "berlin" || locations["garage"] != "unknown" {
+		t.Errorf("locations = %v, want house=berlin and garage=unknown", locations)
+	}
+}
+
+func TestCollector_ReachableBatteries(t *testing.T) {
+	healthy := newMockBatteryServer(&LatestData{RSOC: 50}, &Status{ConsumptionW: 800})
+	defer healthy.Close()
+
+	// Serves latestdata only, so the scrape is partial
+	partial := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/latestdata" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(LatestData{RSOC: 50})
+	}))
+	defer partial.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	collector := NewCollector([]Battery{
+		{Name: "healthy1", Address: healthy.URL[7:], AuthToken: "test-token"},
+		{Name: "healthy2", Address: healthy.URL[7:], AuthToken: "test-token"},
+		{Name: "partial", Address: partial.URL[7:], AuthToken: "test-token"},
+		{Name: "failing", Address: failing.URL[7:], AuthToken: "test-token"},
+	}, CollectorOptions{})
+
+	configured, reachable, succeeded := -1.0, -1.0, 0.0
+	for _, m := range collectAll(collector) {
+		switch m.Desc() {
+		case collector.configuredBatteries:
+			configured = writeMetric(t, m).GetGauge().GetValue()
+		case collector.reachableBatteries:
+			reachable = writeMetric(t, m).GetGauge().GetValue()
+		case collector.scrapeSuccess:
+			succeeded += writeMetric(t, m).GetGauge().GetValue()
+		}
+	}
+
+	if configured != 4 {
+		t.Errorf("configured_batteries = %v, want 4", configured)
+	}
+	if reachable != 2 || reachable != succeeded {
+		t.Errorf("reachable_batteries = %v, want 2 matching the sum of scrape_success %v", reachable, succeeded)
 	}
 }
 
@@ -296,11 +1040,11 @@ func TestCollector_Collect_MultipleBatteries(t *testing.T) {
 	defer server.Close()
 
 	batteries := []Battery{
-		{Name: "battery1", IP: server.URL[7:], AuthToken: "token1"},
-		{Name: "battery2", IP: server.URL[7:], AuthToken: "token2"},
+		{Name: "battery1", Address: server.URL[7:], AuthToken: "token1"},
+		{Name: "battery2", Address: server.URL[7:], AuthToken: "token2"},
 	}
 
-	collector := NewCollector(batteries)
+	collector := NewCollector(batteries, CollectorOptions{})
 	metricCh := make(chan prometheus.Metric, 100)
 
 	go func() {
@@ -313,9 +1057,957 @@ func TestCollector_Collect_MultipleBatteries(t *testing.T) {
 		count++
 	}
 
-	// 15 metrics per battery * 2 batteries = 30 metrics
-	expectedCount := 30
+	// 45 metrics and 4 optional endpoint scrape errors per battery * 2 batteries,
+	// plus the exporter-wide metrics. Each battery also has the request metrics
+	// of 6 endpoints, httpProtocolInfo, http2InUse, tokenInvalid and decode
+	// failures of the 4 optional endpoints the mock answers with an empty body
+	expectedCount := 98 + exporterMetrics + 2*(6*requestMetrics+3+4)
 	if count != expectedCount {
 		t.Errorf("Collect() with 2 batteries sent %d metrics, want %d", count, expectedCount)
 	}
 }
+
+func TestCoreControlStateBucket(t *testing.T) {
+	tests := []struct {
+		state string
+		want  string
+	}{
+		{state: "ongrid", want: "ongrid"},
+		{state: "offgrid", want: "offgrid"},
+		{state: "critical error", want: "critical error"},
+		{state: "config", want: "config"},
+		{state: " OnGrid ", want: "ongrid"},
+		{state: "updating", want: "unknown"},
+		{state: "", want: "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.state, func(t *testing.T) {
+			if got := coreControlStateBucket(tt.state); got != tt.want {
+				t.Errorf("coreControlStateBucket(%q) = %q, want %q", tt.state, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollector_CoreControlState(t *testing.T) {
+	server := newMockBatteryServer(
+		&LatestData{ICStatus: ICStatus{StateCoreControlModule: "config"}},
+		&Status{},
+	)
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+
+	values := map[string]float64{}
+	for _, m := range collectAll(collector) {
+		if m.Desc() != collector.coreControlState {
+			continue
+		}
+		pb := writeMetric(t, m)
+		values[labelValue(pb, "state")] = pb.GetGauge().GetValue()
+	}
+
+	if len(values) != len(coreControlStates) {
+		t.Fatalf("emitted %d core control states, want %d", len(values), len(coreControlStates))
+	}
+	for state, value := range values {
+		want := 0.0
+		if state == "config" {
+			want = 1.0
+		}
+		if value != want {
+			t.Errorf("state %q = %f, want %f", state, value, want)
+		}
+	}
+}
+
+func TestCollector_BatteryTopology(t *testing.T) {
+	tests := []struct {
+		name        string
+		icStatus    ICStatus
+		wantCells   float64
+		wantStrings float64
+		wantOK      bool
+	}{
+		{
+			name:        "two modules with 14 cells",
+			icStatus:    ICStatus{NrBatteryModules: 2, NrCellsPerModule: 14},
+			wantCells:   28,
+			wantStrings: 2,
+			wantOK:      true,
+		},
+		{
+			name:     "cell count not reported",
+			icStatus: ICStatus{NrBatteryModules: 2},
+			wantOK:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMockBatteryServer(&LatestData{ICStatus: tt.icStatus}, &Status{})
+			defer server.Close()
+
+			collector := NewCollector(
+				[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+				CollectorOptions{},
+			)
+
+			values := map[string]float64{}
+			for _, m := range collectAll(collector) {
+				switch m.Desc() {
+				case collector.cellCount:
+					values["cells"] = writeMetric(t, m).GetGauge().GetValue()
+				case collector.stringCount:
+					values["strings"] = writeMetric(t, m).GetGauge().GetValue()
+				}
+			}
+
+			if !tt.wantOK {
+				if len(values) != 0 {
+					t.Errorf("topology metrics = %v, want none", values)
+				}
+				return
+			}
+			if values["cells"] != tt.wantCells || values["strings"] != tt.wantStrings {
+				t.Errorf("topology metrics = %v, want cells=%f strings=%f", values, tt.wantCells, tt.wantStrings)
+			}
+		})
+	}
+}
+
+func TestCollector_ThermalManagement(t *testing.T) {
+	var batteryData atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/latestdata", "/api/v2/status":
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/v2/battery":
+			_, _ = w.Write([]byte(batteryData.Load().(string)))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+
+	steps := []struct {
+		name            string
+		batteryData     string
+		wantHeater      float64
+		wantCooling     float64
+		wantActivations float64
+	}{
+		{
+			name:        "heater off",
+			batteryData: `{"batteryheateractive": false, "coolingactive": false}`,
+		},
+		{
+			name:            "heater switches on",
+			batteryData:     `{"batteryheateractive": true, "coolingactive": false}`,
+			wantHeater:      1,
+			wantActivations: 1,
+		},
+		{
+			name:            "heater stays on",
+			batteryData:     `{"batteryheateractive": true, "coolingactive": true}`,
+			wantHeater:      1,
+			wantCooling:     1,
+			wantActivations: 1,
+		},
+		{
+			name:            "heater off again",
+			batteryData:     `{"batteryheateractive": false, "coolingactive": false}`,
+			wantActivations: 1,
+		},
+		{
+			name:            "heater switches on again",
+			batteryData:     `{"batteryheateractive": true, "coolingactive": false}`,
+			wantHeater:      1,
+			wantActivations: 2,
+		},
+	}
+
+	// Steps build on each other, so they run in order against the same collector
+	for _, step := range steps {
+		batteryData.Store(step.batteryData)
+		values := map[string]float64{}
+		for _, m := range collectAll(collector) {
+			switch m.Desc() {
+			case collector.heaterActive:
+				values["heater"] = writeMetric(t, m).GetGauge().GetValue()
+			case collector.coolingActive:
+				values["cooling"] = writeMetric(t, m).GetGauge().GetValue()
+			}
+		}
+
+		if values["heater"] != step.wantHeater || values["cooling"] != step.wantCooling {
+			t.Errorf("%s: thermal gauges = %v, want heater=%f cooling=%f", step.name, values, step.wantHeater, step.wantCooling)
+		}
+		if got := testutil.ToFloat64(collector.heaterActivations.WithLabelValues("test-battery")); got != step.wantActivations {
+			t.Errorf("%s: heater activations = %f, want %f", step.name, got, step.wantActivations)
+		}
+	}
+}
+
+func TestCollector_InverterEfficiency(t *testing.T) {
+	var systemCurrent atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/latestdata":
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/v2/status":
+			_, _ = w.Write([]byte(`{"Pac_total_W": 100, "Ubat": 50, "BatteryDischarging": true}`))
+		case "/api/v2/battery":
+			_, _ = fmt.Fprintf(w, `{"systemcurrent": %s}`, systemCurrent.Load().(string))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{LegacyMilliwatts: true},
+	)
+
+	tests := []struct {
+		name           string
+		systemCurrent  string
+		wantEfficiency float64
+		wantLossesW    float64
+		wantLosses     bool
+	}{
+		// 50 V * 2.2 A = 110 W DC for 100 W AC
+		{name: "plausible readings", systemCurrent: "2.2", wantEfficiency: 100.0 / 110, wantLossesW: 10, wantLosses: true},
+		// 50 V * 1 A = 50 W DC for 100 W AC is implausible
+		{name: "clamped readings", systemCurrent: "1", wantEfficiency: 1.05},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			systemCurrent.Store(tt.systemCurrent)
+			values := map[*prometheus.Desc]float64{}
+			for _, m := range collectAll(collector) {
+				switch m.Desc() {
+				case collector.inverterEfficiency, collector.inverterLosses, collector.inverterLossesMW:
+					values[m.Desc()] = writeMetric(t, m).GetGauge().GetValue()
+				}
+			}
+
+			if got, ok := values[collector.inverterEfficiency]; !ok || math.Abs(got-tt.wantEfficiency) > 1e-9 {
+				t.Errorf("efficiency = %v (present %v), want %v", got, ok, tt.wantEfficiency)
+			}
+			got, ok := values[collector.inverterLosses]
+			if ok != tt.wantLosses || math.Abs(got-tt.wantLossesW) > 1e-9 {
+				t.Errorf("losses = %v (present %v), want %v (present %v)", got, ok, tt.wantLossesW, tt.wantLosses)
+			}
+			if _, ok := values[collector.inverterLossesMW]; ok != tt.wantLosses {
+				t.Errorf("legacy losses present = %v, want %v", ok, tt.wantLosses)
+			}
+		})
+	}
+}
+
+func TestCollector_BatteryModules(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/latestdata", "/api/v2/status":
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/v2/battery":
+			_, _ = w.Write([]byte(`{"modules": [
+				{"moduleid": 0, "status": "ready", "voltage": 51.2, "temperature": 21.5},
+				{"moduleid": 1, "status": "balancing", "voltage": 51.4, "temperature": 22},
+				{"moduleid": 2, "status": "error", "voltage": 49.8, "temperature": 35.5}
+			]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "home", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+
+	statuses := map[string]string{}
+	voltages := map[string]float64{}
+	temperatures := map[string]float64{}
+	spread := 0.0
+	for _, m := range collectAll(collector) {
+		switch m.Desc() {
+		case collector.moduleVoltageSpread:
+			spread = writeMetric(t, m).GetGauge().GetValue()
+		case collector.moduleInfo:
+			pb := writeMetric(t, m)
+			statuses[labelValue(pb, "module")] = labelValue(pb, "module_status")
+		case collector.moduleVoltage:
+			pb := writeMetric(t, m)
+			voltages[labelValue(pb, "module")] = pb.GetGauge().GetValue()
+		case collector.moduleTemperature:
+			pb := writeMetric(t, m)
+			temperatures[labelValue(pb, "module")] = pb.GetGauge().GetValue()
+		}
+	}
+
+	wantStatuses := map[string]string{"0": "ready", "1": "balancing", "2": "error"}
+	if len(statuses) != len(wantStatuses) {
+		t.Fatalf("module info series = %v, want %v", statuses, wantStatuses)
+	}
+	for module, want := range wantStatuses {
+		if statuses[module] != want {
+			t.Errorf("module %s status = %q, want %q", module, statuses[module], want)
+		}
+	}
+	if voltages["1"] != 51.4 || temperatures["2"] != 35.5 {
+		t.Errorf("module gauges = voltages %v, temperatures %v", voltages, temperatures)
+	}
+	if math.Abs(spread-1.6) > 1e-9 {
+		t.Errorf("voltage spread = %f, want 1.6", spread)
+	}
+}
+
+func TestModuleVoltageRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		voltages []float64
+		wantLow  float64
+		wantHigh float64
+		wantOK   bool
+	}{
+		{
+			name:     "four modules",
+			voltages: []float64{51.2, 51.5, 50.9, 51.3},
+			wantLow:  50.9,
+			wantHigh: 51.5,
+			wantOK:   true,
+		},
+		{
+			name:     "four modules with extremes at the ends",
+			voltages: []float64{52.1, 51.0, 51.1, 50.4},
+			wantLow:  50.4,
+			wantHigh: 52.1,
+			wantOK:   true,
+		},
+		{
+			name:     "single module",
+			voltages: []float64{51.2},
+			wantLow:  51.2,
+			wantHigh: 51.2,
+			wantOK:   true,
+		},
+		{
+			name:   "no modules",
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var modules []BatteryModule
+			for i, v := range tt.voltages {
+				modules = append(modules, BatteryModule{ModuleID: i, Voltage: v})
+			}
+			low, high, ok := moduleVoltageRange(modules)
+			if ok != tt.wantOK {
+				t.Fatalf("moduleVoltageRange() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if low != tt.wantLow || high != tt.wantHigh {
+				t.Errorf("moduleVoltageRange() = %f, %f, want %f, %f", low, high, tt.wantLow, tt.wantHigh)
+			}
+		})
+	}
+}
+
+func TestCellImbalance(t *testing.T) {
+	volts := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name   string
+		data   BatteryData
+		want   float64
+		wantOK bool
+	}{
+		{
+			name:   "normal spread",
+			data:   BatteryData{MinimumCellVoltage: volts(3.25), MaximumCellVoltage: volts(3.3)},
+			want:   0.05,
+			wantOK: true,
+		},
+		{
+			name:   "inverted bounds clamped",
+			data:   BatteryData{MinimumCellVoltage: volts(3.3), MaximumCellVoltage: volts(3.25)},
+			want:   0,
+			wantOK: true,
+		},
+		{
+			name:   "maximum missing",
+			data:   BatteryData{MinimumCellVoltage: volts(3.25)},
+			wantOK: false,
+		},
+		{
+			name:   "minimum missing",
+			data:   BatteryData{MaximumCellVoltage: volts(3.3)},
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := cellImbalance("test", &tt.data)
+			if ok != tt.wantOK {
+				t.Fatalf("cellImbalance() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("cellImbalance() = %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignedBatteryCurrent(t *testing.T) {
+	tests := []struct {
+		name    string
+		current float64
+		status  Status
+		want    float64
+	}{
+		{
+			name:    "charging reported positive",
+			current: 12.5,
+			status:  Status{BatteryCharging: true},
+			want:    12.5,
+		},
+		{
+			name:    "charging reported negative",
+			current: -12.5,
+			status:  Status{BatteryCharging: true},
+			want:    12.5,
+		},
+		{
+			name:    "discharging reported positive",
+			current: 8,
+			status:  Status{BatteryDischarging: true},
+			want:    -8,
+		},
+		{
+			name:    "discharging reported negative",
+			current: -8,
+			status:  Status{BatteryDischarging: true},
+			want:    -8,
+		},
+		{
+			name:    "idle keeps raw value",
+			current: -0.2,
+			status:  Status{},
+			want:    -0.2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := signedBatteryCurrent(tt.current, &tt.status); got != tt.want {
+				t.Errorf("signedBatteryCurrent() = %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInverterCosPhi(t *testing.T) {
+	value := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name   string
+		status Status
+		data   InverterData
+		want   float64
+		wantOK bool
+	}{
+		{
+			name:   "derived from active and apparent power",
+			status: Status{PacTotalW: 1900},
+			data:   InverterData{SacTotal: value(2000)},
+			want:   0.95,
+			wantOK: true,
+		},
+		{
+			name:   "reported directly",
+			status: Status{PacTotalW: 1900},
+			data:   InverterData{CosPhi: value(0.98), SacTotal: value(2000)},
+			want:   0.98,
+			wantOK: true,
+		},
+		{
+			name:   "derived value clamped",
+			status: Status{PacTotalW: -2100},
+			data:   InverterData{SacTotal: value(2000)},
+			want:   -1,
+			wantOK: true,
+		},
+		{
+			name:   "apparent power zero",
+			status: Status{PacTotalW: 1900},
+			data:   InverterData{SacTotal: value(0)},
+			wantOK: false,
+		},
+		{
+			name:   "apparent power missing",
+			status: Status{PacTotalW: 1900},
+			data:   InverterData{},
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := inverterCosPhi(&tt.status, &tt.data)
+			if ok != tt.wantOK {
+				t.Fatalf("inverterCosPhi() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("inverterCosPhi() = %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInverterEfficiency(t *testing.T) {
+	tests := []struct {
+		name           string
+		acPowerW       float64
+		dcPowerW       float64
+		wantEfficiency float64
+		wantLossesW    float64
+		wantOK         bool
+		wantLossesOK   bool
+	}{
+		{
+			name:           "discharging",
+			acPowerW:       950,
+			dcPowerW:       1000,
+			wantEfficiency: 0.95,
+			wantLossesW:    50,
+			wantOK:         true,
+			wantLossesOK:   true,
+		},
+		{
+			name:           "charging converts AC to DC",
+			acPowerW:       -1000,
+			dcPowerW:       -950,
+			wantEfficiency: 0.95,
+			wantLossesW:    50,
+			wantOK:         true,
+			wantLossesOK:   true,
+		},
+		{
+			name:           "noise within the clamp",
+			acPowerW:       1020,
+			dcPowerW:       1000,
+			wantEfficiency: 1.02,
+			wantLossesW:    -20,
+			wantOK:         true,
+			wantLossesOK:   true,
+		},
+		{
+			name:           "clamped above 1.05 without losses",
+			acPowerW:       1200,
+			dcPowerW:       1000,
+			wantEfficiency: 1.05,
+			wantOK:         true,
+		},
+		{
+			name:     "no DC power",
+			acPowerW: 950,
+			dcPowerW: 0,
+		},
+		{
+			name:     "no AC power",
+			acPowerW: 0,
+			dcPowerW: 1000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			efficiency, ok := inverterEfficiency(tt.acPowerW, tt.dcPowerW)
+			if ok != tt.wantOK {
+				t.Fatalf("inverterEfficiency() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if math.Abs(efficiency-tt.wantEfficiency) > 1e-9 {
+				t.Errorf("inverterEfficiency() = %f, want %f", efficiency, tt.wantEfficiency)
+			}
+
+			lossesW, ok := inverterLosses(tt.acPowerW, tt.dcPowerW)
+			if ok != tt.wantLossesOK {
+				t.Fatalf("inverterLosses() ok = %v, want %v", ok, tt.wantLossesOK)
+			}
+			if math.Abs(lossesW-tt.wantLossesW) > 1e-9 {
+				t.Errorf("inverterLosses() = %f, want %f", lossesW, tt.wantLossesW)
+			}
+		})
+	}
+}
+
+// mockProvider records the data it was called with
+type mockProvider struct {
+	desc  *prometheus.Desc
+	mu    sync.Mutex
+	calls map[string]*LatestData
+	stats map[string]*Status
+}
+
+func (p *mockProvider) Descs() []*prometheus.Desc {
+	return []*prometheus.Desc{p.desc}
+}
+
+func (p *mockProvider) Collect(battery Battery, latestData *LatestData, status *Status, ch chan<- prometheus.Metric) {
+	p.mu.Lock()
+	p.calls[battery.Name] = latestData
+	p.stats[battery.Name] = status
+	p.mu.Unlock()
+	ch <- prometheus.MustNewConstMetric(p.desc, prometheus.GaugeValue, float64(latestData.RSOC), battery.Name)
+}
+
+func TestCollector_RegisterProvider(t *testing.T) {
+	server := newMockBatteryServer(&LatestData{RSOC: 42}, &Status{ProductionW: 1234})
+	defer server.Close()
+
+	provider := &mockProvider{
+		desc:  prometheus.NewDesc("custom_metric", "Custom metric", []string{"battery_name"}, nil),
+		calls: map[string]*LatestData{},
+		stats: map[string]*Status{},
+	}
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+	collector.RegisterProvider(provider)
+
+	descCh := make(chan *prometheus.Desc, 200)
+	collector.Describe(descCh)
+	close(descCh)
+	described := false
+	for desc := range descCh {
+		if desc == provider.desc {
+			described = true
+		}
+	}
+	if !described {
+		t.Error("Describe() did not include provider descriptor")
+	}
+
+	emitted := 0
+	for _, m := range collectAll(collector) {
+		if m.Desc() == provider.desc {
+			emitted++
+		}
+	}
+	if emitted != 1 {
+		t.Errorf("provider emitted %d metrics, want 1", emitted)
+	}
+
+	latestData, ok := provider.calls["test-battery"]
+	if !ok {
+		t.Fatal("provider Collect() not called for test-battery")
+	}
+	if latestData.RSOC != 42 {
+		t.Errorf("provider got RSOC = %d, want 42", latestData.RSOC)
+	}
+	if status := provider.stats["test-battery"]; status.ProductionW != 1234 {
+		t.Errorf("provider got ProductionW = %f, want 1234", status.ProductionW)
+	}
+}
+
+// newMockBatteryServer serves the given latestdata and status responses
+func newMockBatteryServer(latestData *LatestData, status *Status) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v2/latestdata":
+			_ = json.NewEncoder(w).Encode(latestData)
+		case "/api/v2/status":
+			_ = json.NewEncoder(w).Encode(status)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+// collectAll runs a single Collect and drains all metrics
+func collectAll(collector *Collector) []prometheus.Metric {
+	metricCh := make(chan prometheus.Metric, 100)
+	go func() {
+		collector.Collect(metricCh)
+		close(metricCh)
+	}()
+
+	var metrics []prometheus.Metric
+	for m := range metricCh {
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// writeMetric converts a metric into its protobuf representation
+func writeMetric(t *testing.T, m prometheus.Metric) *dto.Metric {
+	t.Helper()
+	pb := &dto.Metric{}
+	if err := m.Write(pb); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	return pb
+}
+
+// labelValue returns the value of the named label, or "" if it is absent
+func labelValue(pb *dto.Metric, name string) string {
+	for _, lp := range pb.GetLabel() {
+		if lp.GetName() == name {
+			return lp.GetValue()
+		}
+	}
+	return ""
+}
+
+func TestCO2AvoidedGrams(t *testing.T) {
+	tests := []struct {
+		name        string
+		productionW float64
+		elapsed     time.Duration
+		intensity   float64
+		want        float64
+	}{
+		{
+			name:        "one kilowatt for one hour",
+			productionW: 1000,
+			elapsed:     time.Hour,
+			intensity:   400,
+			want:        400,
+		},
+		{
+			name:        "half hour at 2.5 kW",
+			productionW: 2500,
+			elapsed:     30 * time.Minute,
+			intensity:   300,
+			want:        375,
+		},
+		{
+			name:        "no production",
+			productionW: 0,
+			elapsed:     time.Hour,
+			intensity:   400,
+			want:        0,
+		},
+		{
+			name:        "no elapsed time",
+			productionW: 1000,
+			elapsed:     0,
+			intensity:   400,
+			want:        0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := co2AvoidedGrams(tt.productionW, tt.elapsed, tt.intensity)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("co2AvoidedGrams() = %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollector_CO2Accumulation(t *testing.T) {
+	server := newMockBatteryServer(&LatestData{}, &Status{ProductionW: 2000})
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{CO2IntensityGPerKWh: 400},
+	)
+	now := time.Date(2025, 11, 29, 12, 0, 0, 0, time.UTC)
+	collector.now = func() time.Time { return now }
+
+	// First scrape has no previous interval
+	collectAll(collector)
+	if got := testutil.ToFloat64(collector.co2Avoided.WithLabelValues("test-battery")); got != 0 {
+		t.Fatalf("co2 avoided after first scrape = %f, want 0", got)
+	}
+
+	// 2 kW for 15 minutes at 400 g/kWh = 200 g
+	now = now.Add(15 * time.Minute)
+	collectAll(collector)
+	// Another 15 minutes accumulates on top
+	now = now.Add(15 * time.Minute)
+	collectAll(collector)
+
+	if got := testutil.ToFloat64(collector.co2Avoided.WithLabelValues("test-battery")); math.Abs(got-400) > 1e-9 {
+		t.Errorf("co2 avoided = %f, want 400", got)
+	}
+}
+
+func TestCollector_OffGridTracking(t *testing.T) {
+	status := &Status{SystemStatus: "OnGrid"}
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v2/latestdata":
+			_ = json.NewEncoder(w).Encode(LatestData{})
+		case "/api/v2/status":
+			_ = json.NewEncoder(w).Encode(status)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+	now := time.Date(2025, 11, 29, 12, 0, 0, 0, time.UTC)
+	collector.now = func() time.Time { return now }
+
+	steps := []struct {
+		advance      time.Duration
+		systemStatus string
+		fail         bool
+	}{
+		{0, "OnGrid", false},
+		{30 * time.Second, "OffGrid", false},
+		{30 * time.Second, "OffGrid", false},
+		// A failed scrape mid-outage neither adds time nor a new transition
+		{30 * time.Second, "OffGrid", true},
+		{30 * time.Second, "OffGrid", false},
+		{30 * time.Second, "OffGrid", false},
+		{30 * time.Second, "OnGrid", false},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		status.SystemStatus = step.systemStatus
+		failing = step.fail
+		collectAll(collector)
+	}
+
+	// Counted intervals: first off-grid scrape, second off-grid scrape and the
+	// last off-grid scrape; the one following the failure has no known interval
+	if got := testutil.ToFloat64(collector.offGridSeconds.WithLabelValues("test-battery")); got != 90 {
+		t.Errorf("offgrid seconds = %f, want 90", got)
+	}
+	if got := testutil.ToFloat64(collector.offGridTransitions.WithLabelValues("test-battery")); got != 1 {
+		t.Errorf("offgrid transitions = %f, want 1", got)
+	}
+}
+
+func TestCollector_OffGridStart(t *testing.T) {
+	status := &Status{SystemStatus: "OnGrid"}
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v2/latestdata":
+			_ = json.NewEncoder(w).Encode(LatestData{})
+		case "/api/v2/status":
+			_ = json.NewEncoder(w).Encode(status)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+	start := time.Date(2025, 11, 29, 12, 0, 0, 0, time.UTC)
+	now := start
+	collector.now = func() time.Time { return now }
+
+	steps := []struct {
+		systemStatus string
+		fail         bool
+		want         float64 // Expected start timestamp, 0 for none
+	}{
+		{systemStatus: "OnGrid"},
+		{systemStatus: "OffGrid", want: float64(start.Add(2 * time.Minute).Unix())},
+		{systemStatus: "OffGrid", want: float64(start.Add(2 * time.Minute).Unix())},
+		{systemStatus: "OffGrid", fail: true},
+		{systemStatus: "OffGrid", want: float64(start.Add(2 * time.Minute).Unix())},
+		{systemStatus: "OnGrid"},
+		{systemStatus: "OffGrid", want: float64(start.Add(7 * time.Minute).Unix())},
+	}
+	for i, step := range steps {
+		now = start.Add(time.Duration(i+1) * time.Minute)
+		status.SystemStatus = step.systemStatus
+		failing = step.fail
+
+		var got float64
+		for _, m := range collectAll(collector) {
+			if m.Desc() == collector.offGridStart {
+				got = writeMetric(t, m).GetGauge().GetValue()
+			}
+		}
+		if got != step.want {
+			t.Errorf("step %d: offgrid start = %v, want %v", i, got, step.want)
+		}
+	}
+}
+
+func TestCollector_BackupCycle(t *testing.T) {
+	status := &Status{}
+	server := newMockBatteryServer(&LatestData{}, status)
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+	now := time.Date(2025, 11, 29, 12, 0, 0, 0, time.UTC)
+	collector.now = func() time.Time { return now }
+
+	steps := []struct {
+		systemStatus    string
+		wantInBackup    float64
+		wantEvents      float64
+		wantBackupTotal float64
+	}{
+		{"OnGrid", 0, 0, 0},
+		{"OffGrid", 1, 1, 60},
+		{"OnGrid", 0, 1, 60},
+	}
+	for i, step := range steps {
+		now = now.Add(time.Minute)
+		status.SystemStatus = step.systemStatus
+
+		inBackup := -1.0
+		for _, m := range collectAll(collector) {
+			if m.Desc() == collector.inBackup {
+				inBackup = writeMetric(t, m).GetGauge().GetValue()
+			}
+		}
+		if inBackup != step.wantInBackup {
+			t.Errorf("step %d: in_backup = %v, want %v", i, inBackup, step.wantInBackup)
+		}
+		if got := testutil.ToFloat64(collector.offGridTransitions.WithLabelValues("test-battery")); got != step.wantEvents {
+			t.Errorf("step %d: offgrid transitions = %v, want %v", i, got, step.wantEvents)
+		}
+		if got := testutil.ToFloat64(collector.offGridSeconds.WithLabelValues("test-battery")); got != step.wantBackupTotal {
+			t.Errorf("step %d: offgrid seconds = %v, want %v", i, got, step.wantBackupTotal)
+		}
+	}
+}
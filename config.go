@@ -1,41 +1,177 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 const (
-	defaultPort = "9090"
+	defaultPort         = "9090"
+	defaultCO2Intensity = 400.0 // Typical EU grid average in g/kWh
+	defaultMaxLabels    = 50
+	defaultGracePeriod  = 30 * time.Minute
+	defaultConfigMaxAge = 0 // Keep until reload
+	defaultCurrency     = "eur"
+	defaultWarranty     = 10 // Years
+	defaultTLSInterval  = time.Hour
+	defaultMaxPowerW    = 30000.0 // Well above any home installation
+	defaultSOCJump      = 10.0    // Percentage points between two scrapes
 )
 
+// Warning describes a non-fatal configuration issue
+type Warning struct {
+	Code    string
+	Message string
+}
+
+// ParseResult holds the parsed batteries and any non-fatal configuration issues
+type ParseResult struct {
+	Batteries []Battery
+	Warnings  []Warning
+}
+
+// validCurrency matches lowercase ISO 4217 currency codes
+var validCurrency = regexp.MustCompile(`^[a-z]{3}$`)
+
+// validName matches battery names that are safe to use in labels and URLs
+var validName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
 // parseBatteries parses battery configuration from environment variables
 func parseBatteries() ([]Battery, error) {
-	ips := os.Getenv("SONNENBATTERIE_IPS")
-	if ips == "" {
-		return nil, fmt.Errorf("SONNENBATTERIE_IPS must be set")
+	result, err := parseBatteriesDetailed()
+	if err != nil {
+		return nil, err
 	}
+	return result.Batteries, nil
+}
+
+// parseBatteriesDetailed parses battery configuration from environment
+// variables and reports non-fatal issues as warnings
+func parseBatteriesDetailed() (ParseResult, error) {
+	var result ParseResult
 
-	tokens := os.Getenv("SONNENBATTERIE_TOKENS")
-	if tokens == "" {
-		return nil, fmt.Errorf("SONNENBATTERIE_TOKENS must be set")
+	addressList, err := configList("SONNENBATTERIE_ADDRESSES")
+	if err != nil {
+		return result, err
+	}
+	// SONNENBATTERIE_IPS is the former name, still accepted
+	ipList, err := configList("SONNENBATTERIE_IPS")
+	if err != nil {
+		return result, err
+	}
+	if len(addressList) > 0 && len(ipList) > 0 {
+		result.Warnings = append(result.Warnings, Warning{
+			Code:    "addresses_and_ips",
+			Message: "both SONNENBATTERIE_ADDRESSES and SONNENBATTERIE_IPS are set, ignoring SONNENBATTERIE_IPS",
+		})
+	}
+	if len(addressList) == 0 {
+		addressList = ipList
+	}
+	if len(addressList) == 0 {
+		return result, fmt.Errorf("SONNENBATTERIE_ADDRESSES or SONNENBATTERIE_ADDRESSES_FILE must be set")
+	}
+
+	tokenList, err := configList("SONNENBATTERIE_TOKENS")
+	if err != nil {
+		return result, err
+	}
+	if len(tokenList) == 0 {
+		return result, fmt.Errorf("SONNENBATTERIE_TOKENS or SONNENBATTERIE_TOKENS_FILE must be set")
 	}
 
-	ipList := strings.Split(ips, ",")
-	tokenList := strings.Split(tokens, ",")
+	// Tokens read from a file can be re-read when the battery rejects them
+	envTokens := 0
+	if value := os.Getenv("SONNENBATTERIE_TOKENS"); value != "" {
+		envTokens = len(strings.Split(value, ","))
+	}
+	tokensFile := os.Getenv("SONNENBATTERIE_TOKENS_FILE")
+
 	names := strings.Split(os.Getenv("SONNENBATTERIE_NAMES"), ",")
+	groups := strings.Split(os.Getenv("SONNENBATTERIE_GROUPS"), ",")
+	locations := strings.Split(os.Getenv("SONNENBATTERIE_LOCATIONS"), ",")
+	capacities := strings.Split(os.Getenv("SONNENBATTERIE_DESIGN_CAPACITIES_WH"), ",")
+	capacityUnits := strings.Split(os.Getenv("SONNENBATTERIE_CAPACITY_UNITS"), ",")
+	metricFilters := strings.Split(os.Getenv("SONNENBATTERIE_METRIC_FILTERS"), ",")
+
+	// Expected configuration, also read from files for per-site automation
+	expectedModes, err := configList("SONNENBATTERIE_EXPECTED_OPERATING_MODES")
+	if err != nil {
+		return result, err
+	}
+	expectedReserves, err := configList("SONNENBATTERIE_EXPECTED_BACKUP_RESERVES")
+	if err != nil {
+		return result, err
+	}
+
+	minScrapeInterval, err := getMinScrapeInterval()
+	if err != nil {
+		return result, err
+	}
+
+	clientCertFile, clientKeyFile, caCertFile, err := getClientTLSFiles()
+	if err != nil {
+		return result, err
+	}
 
-	if len(ipList) != len(tokenList) {
-		return nil, fmt.Errorf("number of IPs (%d) must match number of tokens (%d)", len(ipList), len(tokenList))
+	if len(addressList) != len(tokenList) {
+		return result, fmt.Errorf("number of addresses (%d) must match number of tokens (%d)", len(addressList), len(tokenList))
 	}
 
-	batteries := make([]Battery, 0, len(ipList))
-	for i := range ipList {
-		ip := strings.TrimSpace(ipList[i])
+	if os.Getenv("SONNENBATTERIE_NAMES") != "" && len(names) != len(addressList) {
+		result.Warnings = append(result.Warnings, Warning{
+			Code:    "names_count_mismatch",
+			Message: fmt.Sprintf("number of names (%d) does not match number of addresses (%d)", len(names), len(addressList)),
+		})
+	}
+	if os.Getenv("SONNENBATTERIE_GROUPS") != "" && len(groups) != len(addressList) {
+		result.Warnings = append(result.Warnings, Warning{
+			Code:    "groups_count_mismatch",
+			Message: fmt.Sprintf("number of groups (%d) does not match number of addresses (%d)", len(groups), len(addressList)),
+		})
+	}
+	if os.Getenv("SONNENBATTERIE_LOCATIONS") != "" && len(locations) != len(addressList) {
+		result.Warnings = append(result.Warnings, Warning{
+			Code:    "locations_count_mismatch",
+			Message: fmt.Sprintf("number of locations (%d) does not match number of addresses (%d)", len(locations), len(addressList)),
+		})
+	}
+	if os.Getenv("SONNENBATTERIE_DESIGN_CAPACITIES_WH") != "" && len(capacities) != len(addressList) {
+		result.Warnings = append(result.Warnings, Warning{
+			Code:    "design_capacities_count_mismatch",
+			Message: fmt.Sprintf("number of design capacities (%d) does not match number of addresses (%d)", len(capacities), len(addressList)),
+		})
+	}
+
+	if os.Getenv("SONNENBATTERIE_CAPACITY_UNITS") != "" && len(capacityUnits) != len(addressList) {
+		result.Warnings = append(result.Warnings, Warning{
+			Code:    "capacity_units_count_mismatch",
+			Message: fmt.Sprintf("number of capacity units (%d) does not match number of addresses (%d)", len(capacityUnits), len(addressList)),
+		})
+	}
+
+	if os.Getenv("SONNENBATTERIE_METRIC_FILTERS") != "" && len(metricFilters) != len(addressList) {
+		result.Warnings = append(result.Warnings, Warning{
+			Code:    "metric_filters_count_mismatch",
+			Message: fmt.Sprintf("number of metric filters (%d) does not match number of addresses (%d)", len(metricFilters), len(addressList)),
+		})
+	}
+
+	batteries := make([]Battery, 0, len(addressList))
+	seen := make(map[string]bool, len(addressList))
+	for i := range addressList {
+		address, https := splitAddressScheme(strings.TrimSpace(addressList[i]))
 		token := strings.TrimSpace(tokenList[i])
-		if ip == "" || token == "" {
+		if address == "" || token == "" {
 			continue
 		}
 
@@ -44,18 +180,184 @@ func parseBatteries() ([]Battery, error) {
 			name = strings.TrimSpace(names[i])
 		}
 
-		batteries = append(batteries, Battery{
-			Name:      name,
-			IP:        ip,
-			AuthToken: token,
-		})
+		if !validName.MatchString(name) {
+			result.Warnings = append(result.Warnings, Warning{
+				Code:    "unusual_name",
+				Message: fmt.Sprintf("battery name %q contains characters other than letters, digits, '.', '_' and '-'", name),
+			})
+		}
+		if seen[name] {
+			result.Warnings = append(result.Warnings, Warning{
+				Code:    "duplicate_name",
+				Message: fmt.Sprintf("battery name %q is used more than once", name),
+			})
+		}
+		seen[name] = true
+
+		group := ""
+		if i < len(groups) {
+			group = strings.TrimSpace(groups[i])
+		}
+
+		location := ""
+		if i < len(locations) {
+			location = strings.TrimSpace(locations[i])
+		}
+		if location != "" && !validName.MatchString(location) {
+			result.Warnings = append(result.Warnings, Warning{
+				Code:    "unusual_location",
+				Message: fmt.Sprintf("location %q of battery %q contains characters other than letters, digits, '.', '_' and '-'", location, name),
+			})
+		}
+
+		designCapacity := 0.0
+		if i < len(capacities) && strings.TrimSpace(capacities[i]) != "" {
+			value, err := strconv.ParseFloat(strings.TrimSpace(capacities[i]), 64)
+			if err != nil || value <= 0 {
+				result.Warnings = append(result.Warnings, Warning{
+					Code:    "invalid_design_capacity",
+					Message: fmt.Sprintf("design capacity %q for battery %q is not a positive number", capacities[i], name),
+				})
+			} else {
+				designCapacity = value
+			}
+		}
+
+		capacityUnit := ""
+		if i < len(capacityUnits) {
+			capacityUnit = strings.ToLower(strings.TrimSpace(capacityUnits[i]))
+			if capacityUnit != "" && capacityUnit != capacityUnitWh && capacityUnit != capacityUnitMWh {
+				result.Warnings = append(result.Warnings, Warning{
+					Code:    "invalid_capacity_unit",
+					Message: fmt.Sprintf("capacity unit %q for battery %q is not wh or mwh, detecting it instead", capacityUnits[i], name),
+				})
+				capacityUnit = ""
+			}
+		}
+
+		var expectation ConfigExpectation
+		if i < len(expectedModes) {
+			expectation.ExpectedOperatingMode = expectedValue(&result, "operating mode", expectedModes[i], name, 0, 100)
+		}
+		if i < len(expectedReserves) {
+			expectation.ExpectedBackupReserve = expectedValue(&result, "backup reserve", expectedReserves[i], name, 0, 100)
+		}
+
+		var metricFilter []string
+		if i < len(metricFilters) {
+			metricFilter = parseMetricFilter(metricFilters[i])
+		}
+
+		battery := Battery{
+			Name:              name,
+			Address:           address,
+			HTTPS:             https,
+			AuthToken:         token,
+			Group:             group,
+			Location:          location,
+			Expectation:       expectation,
+			DesignCapacityWh:  designCapacity,
+			CapacityUnit:      capacityUnit,
+			MinScrapeInterval: minScrapeInterval,
+			MetricFilter:      metricFilter,
+			ClientCertFile:    clientCertFile,
+			ClientKeyFile:     clientKeyFile,
+			CACertFile:        caCertFile,
+		}
+		if tokensFile != "" && i >= envTokens {
+			battery.TokenRefreshFunc = fileTokenRefresher(tokensFile, i-envTokens)
+		}
+		batteries = append(batteries, battery)
 	}
 
 	if len(batteries) == 0 {
-		return nil, fmt.Errorf("no valid batteries configured")
+		return result, fmt.Errorf("no valid batteries configured")
+	}
+
+	result.Batteries = batteries
+	return result, nil
+}
+
+// splitAddressScheme strips an http:// or https:// prefix and a trailing slash
+// from a battery address and reports whether HTTPS was asked for
+func splitAddressScheme(address string) (string, bool) {
+	https := false
+	switch lower := strings.ToLower(address); {
+	case strings.HasPrefix(lower, "https://"):
+		address, https = address[len("https://"):], true
+	case strings.HasPrefix(lower, "http://"):
+		address = address[len("http://"):]
 	}
+	return strings.TrimSuffix(address, "/"), https
+}
 
-	return batteries, nil
+// expectedValue parses an expected configuration value of a battery, warning
+// about and ignoring values that are not integers between lo and hi. It
+// returns nil for an empty entry.
+func expectedValue(result *ParseResult, setting, raw, name string, lo, hi int) *int {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < lo || value > hi {
+		result.Warnings = append(result.Warnings, Warning{
+			Code:    "invalid_expected_" + strings.ReplaceAll(setting, " ", "_"),
+			Message: fmt.Sprintf("expected %s %q for battery %q is not an integer between %d and %d, ignoring it", setting, raw, name, lo, hi),
+		})
+		return nil
+	}
+	return &value
+}
+
+// configList returns the comma-separated entries of the env variable followed
+// by the lines of the file named in the matching _FILE variable
+func configList(env string) ([]string, error) {
+	var list []string
+	if value := os.Getenv(env); value != "" {
+		list = strings.Split(value, ",")
+	}
+
+	if path := os.Getenv(env + "_FILE"); path != "" {
+		lines, err := readLinesFromFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s_FILE: %w", env, err)
+		}
+		list = append(list, lines...)
+	}
+
+	return list, nil
+}
+
+// readLinesFromFile returns the trimmed, non-blank lines of a file
+func readLinesFromFile(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// fileTokenRefresher returns a TokenRefreshFunc that re-reads the token on the
+// given non-blank line of path, so rotated tokens are picked up without a reload
+func fileTokenRefresher(path string, index int) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		lines, err := readLinesFromFile(path)
+		if err != nil {
+			return "", err
+		}
+		if index >= len(lines) {
+			return "", fmt.Errorf("%s has no token on line %d", path, index+1)
+		}
+		return lines[index], nil
+	}
 }
 
 // getPort returns the configured port or the default
@@ -66,3 +368,529 @@ func getPort() string {
 	}
 	return port
 }
+
+// getCO2Intensity returns the configured grid carbon intensity in g/kWh or the default
+func getCO2Intensity() (float64, error) {
+	value := os.Getenv("SONNENBATTERIE_CO2_INTENSITY_G_KWH")
+	if value == "" {
+		return defaultCO2Intensity, nil
+	}
+
+	intensity, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid SONNENBATTERIE_CO2_INTENSITY_G_KWH %q: %w", value, err)
+	}
+	if intensity < 0 {
+		return 0, fmt.Errorf("SONNENBATTERIE_CO2_INTENSITY_G_KWH must not be negative, got %v", intensity)
+	}
+	return intensity, nil
+}
+
+// getMaxLabelValues returns the configured label value limit per metric or the default
+func getMaxLabelValues() (int, error) {
+	value := os.Getenv("SONNENBATTERIE_MAX_LABEL_VALUES")
+	if value == "" {
+		return defaultMaxLabels, nil
+	}
+
+	limit, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid SONNENBATTERIE_MAX_LABEL_VALUES %q: %w", value, err)
+	}
+	if limit < 0 {
+		return 0, fmt.Errorf("SONNENBATTERIE_MAX_LABEL_VALUES must not be negative, got %d", limit)
+	}
+	return limit, nil
+}
+
+// getFirmwareGracePeriod returns how long cached firmware flags are kept while
+// a battery is unreachable, or the default
+func getFirmwareGracePeriod() (time.Duration, error) {
+	value := os.Getenv("SONNENBATTERIE_FIRMWARE_GRACE_PERIOD")
+	if value == "" {
+		return defaultGracePeriod, nil
+	}
+
+	period, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid SONNENBATTERIE_FIRMWARE_GRACE_PERIOD %q: %w", value, err)
+	}
+	if period < 0 {
+		return 0, fmt.Errorf("SONNENBATTERIE_FIRMWARE_GRACE_PERIOD must not be negative, got %s", period)
+	}
+	return period, nil
+}
+
+// getConfigurationsMaxAge returns how long the system configuration is cached,
+// or the default. 0 keeps it until the next reload
+func getConfigurationsMaxAge() (time.Duration, error) {
+	value := os.Getenv("SONNENBATTERIE_CONFIGURATIONS_MAX_AGE")
+	if value == "" {
+		return defaultConfigMaxAge, nil
+	}
+
+	maxAge, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid SONNENBATTERIE_CONFIGURATIONS_MAX_AGE %q: %w", value, err)
+	}
+	if maxAge < 0 {
+		return 0, fmt.Errorf("SONNENBATTERIE_CONFIGURATIONS_MAX_AGE must not be negative, got %s", maxAge)
+	}
+	return maxAge, nil
+}
+
+// getCurrency returns the configured currency code used in the price metric
+// names, or the default
+func getCurrency() (string, error) {
+	value := os.Getenv("SONNENBATTERIE_CURRENCY")
+	if value == "" {
+		return defaultCurrency, nil
+	}
+
+	currency := strings.ToLower(strings.TrimSpace(value))
+	if !validCurrency.MatchString(currency) {
+		return "", fmt.Errorf("invalid SONNENBATTERIE_CURRENCY %q: must be a three-letter currency code", value)
+	}
+	return currency, nil
+}
+
+// getFeedInSign returns the sign convention of the grid feed-in metrics
+func getFeedInSign() (string, error) {
+	value := os.Getenv("SONNENBATTERIE_FEEDIN_SIGN")
+	if value == "" {
+		return feedInExportPositive, nil
+	}
+
+	sign := strings.ToLower(strings.TrimSpace(value))
+	if sign != feedInExportPositive && sign != feedInImportPositive {
+		return "", fmt.Errorf("invalid SONNENBATTERIE_FEEDIN_SIGN %q: must be %s or %s", value, feedInExportPositive, feedInImportPositive)
+	}
+	return sign, nil
+}
+
+// getBasicAuth returns the username and bcrypt password hash required on the
+// exporter's endpoints, or empty values if basic auth is disabled
+func getBasicAuth() (string, []byte, error) {
+	username := os.Getenv("EXPORTER_BASIC_AUTH_USERNAME")
+	passwordHash := strings.TrimSpace(os.Getenv("EXPORTER_BASIC_AUTH_PASSWORD_HASH"))
+	if username == "" && passwordHash == "" {
+		return "", nil, nil
+	}
+	if username == "" || passwordHash == "" {
+		return "", nil, fmt.Errorf("EXPORTER_BASIC_AUTH_USERNAME and EXPORTER_BASIC_AUTH_PASSWORD_HASH must be set together")
+	}
+
+	if _, err := bcrypt.Cost([]byte(passwordHash)); err != nil {
+		return "", nil, fmt.Errorf("invalid EXPORTER_BASIC_AUTH_PASSWORD_HASH: %w", err)
+	}
+	return username, []byte(passwordHash), nil
+}
+
+// getListenerTLS returns the certificate and key files the exporter serves
+// HTTPS with, or empty names to serve HTTP
+func getListenerTLS() (certFile, keyFile string, err error) {
+	certFile = strings.TrimSpace(os.Getenv("EXPORTER_TLS_CERT_FILE"))
+	keyFile = strings.TrimSpace(os.Getenv("EXPORTER_TLS_KEY_FILE"))
+	if (certFile == "") != (keyFile == "") {
+		return "", "", fmt.Errorf("EXPORTER_TLS_CERT_FILE and EXPORTER_TLS_KEY_FILE must be set together")
+	}
+	return certFile, keyFile, nil
+}
+
+// getReadyMode returns whether /ready requires any or all batteries to be
+// scraped successfully
+func getReadyMode() (string, error) {
+	value := os.Getenv("EXPORTER_READY_MODE")
+	if value == "" {
+		return readyAny, nil
+	}
+
+	mode := strings.ToLower(strings.TrimSpace(value))
+	if mode != readyAny && mode != readyAll {
+		return "", fmt.Errorf("invalid EXPORTER_READY_MODE %q: must be %s or %s", value, readyAny, readyAll)
+	}
+	return mode, nil
+}
+
+// getReadyWindow returns how recent a successful scrape must be for /ready,
+// or the default
+func getReadyWindow() (time.Duration, error) {
+	value := os.Getenv("EXPORTER_READY_WINDOW")
+	if value == "" {
+		return defaultReadyWindow, nil
+	}
+
+	window, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid EXPORTER_READY_WINDOW %q: %w", value, err)
+	}
+	if window <= 0 {
+		return 0, fmt.Errorf("EXPORTER_READY_WINDOW must be positive, got %s", window)
+	}
+	return window, nil
+}
+
+// getOffPeakPrice returns the price per kWh in the given env variable, or nil
+// if it is not set
+func getOffPeakPrice(env string) (*float64, error) {
+	value := os.Getenv(env)
+	if value == "" {
+		return nil, nil
+	}
+
+	price, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", env, value, err)
+	}
+	return &price, nil
+}
+
+// getWarrantyYears returns the configured warranty period in years or the
+// default. 0 disables the warranty metric
+func getWarrantyYears() (int, error) {
+	value := os.Getenv("SONNENBATTERIE_WARRANTY_YEARS")
+	if value == "" {
+		return defaultWarranty, nil
+	}
+
+	years, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid SONNENBATTERIE_WARRANTY_YEARS %q: %w", value, err)
+	}
+	if years < 0 {
+		return 0, fmt.Errorf("SONNENBATTERIE_WARRANTY_YEARS must not be negative, got %d", years)
+	}
+	return years, nil
+}
+
+// getRuntimeMetrics returns whether the Go runtime and process metrics are
+// exported, true unless disabled
+func getRuntimeMetrics() (bool, error) {
+	value := os.Getenv("EXPORTER_ENABLE_RUNTIME_METRICS")
+	if value == "" {
+		return true, nil
+	}
+
+	enabled, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return false, fmt.Errorf("invalid EXPORTER_ENABLE_RUNTIME_METRICS %q: %w", value, err)
+	}
+	return enabled, nil
+}
+
+// getBatteryDebug returns whether /debug/battery/{name} is served, false
+// unless enabled
+func getBatteryDebug() (bool, error) {
+	value := os.Getenv("EXPORTER_ENABLE_BATTERY_DEBUG")
+	if value == "" {
+		return false, nil
+	}
+
+	enabled, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return false, fmt.Errorf("invalid EXPORTER_ENABLE_BATTERY_DEBUG %q: %w", value, err)
+	}
+	return enabled, nil
+}
+
+// getTelemetryAddress returns the address of the separate listener for the
+// exporter-internal endpoints, empty to serve them on the main listener
+func getTelemetryAddress() (string, error) {
+	address := strings.TrimSpace(os.Getenv("EXPORTER_TELEMETRY_ADDRESS"))
+	if address == "" {
+		return "", nil
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return "", fmt.Errorf("invalid EXPORTER_TELEMETRY_ADDRESS %q: %w", address, err)
+	}
+	return address, nil
+}
+
+// getHideAddresses returns whether the landing page omits the battery
+// addresses, false unless enabled
+func getHideAddresses() (bool, error) {
+	value := os.Getenv("EXPORTER_HIDE_BATTERY_ADDRESSES")
+	if value == "" {
+		return false, nil
+	}
+
+	hide, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return false, fmt.Errorf("invalid EXPORTER_HIDE_BATTERY_ADDRESSES %q: %w", value, err)
+	}
+	return hide, nil
+}
+
+// getSanityChecks returns whether implausible readings are dropped, false
+// unless enabled
+func getSanityChecks() (bool, error) {
+	value := os.Getenv("SONNENBATTERIE_SANITY_CHECKS")
+	if value == "" {
+		return false, nil
+	}
+
+	enabled, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return false, fmt.Errorf("invalid SONNENBATTERIE_SANITY_CHECKS %q: %w", value, err)
+	}
+	return enabled, nil
+}
+
+// getMaxPowerW returns the highest plausible consumption and production in
+// watts, or the default
+func getMaxPowerW() (float64, error) {
+	value := os.Getenv("SONNENBATTERIE_SANITY_MAX_POWER_W")
+	if value == "" {
+		return defaultMaxPowerW, nil
+	}
+
+	maxPower, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid SONNENBATTERIE_SANITY_MAX_POWER_W %q: %w", value, err)
+	}
+	if maxPower <= 0 {
+		return 0, fmt.Errorf("SONNENBATTERIE_SANITY_MAX_POWER_W must be positive, got %v", maxPower)
+	}
+	return maxPower, nil
+}
+
+// getMinScrapeInterval returns the shortest time between two queries of each
+// battery, or 0 if scrapes are not throttled
+func getMinScrapeInterval() (time.Duration, error) {
+	value := os.Getenv("SONNENBATTERIE_MIN_SCRAPE_INTERVAL")
+	if value == "" {
+		return 0, nil
+	}
+
+	interval, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid SONNENBATTERIE_MIN_SCRAPE_INTERVAL %q: %w", value, err)
+	}
+	if interval < 0 {
+		return 0, fmt.Errorf("SONNENBATTERIE_MIN_SCRAPE_INTERVAL must not be negative, got %s", interval)
+	}
+	return interval, nil
+}
+
+// getClientTLSFiles returns the client certificate, key and CA files used for
+// all batteries, after checking that they can be loaded
+func getClientTLSFiles() (certFile, keyFile, caFile string, err error) {
+	certFile = strings.TrimSpace(os.Getenv("SONNENBATTERIE_CLIENT_CERT_FILE"))
+	keyFile = strings.TrimSpace(os.Getenv("SONNENBATTERIE_CLIENT_KEY_FILE"))
+	caFile = strings.TrimSpace(os.Getenv("SONNENBATTERIE_CA_CERT_FILE"))
+	if (certFile == "") != (keyFile == "") {
+		return "", "", "", fmt.Errorf("SONNENBATTERIE_CLIENT_CERT_FILE and SONNENBATTERIE_CLIENT_KEY_FILE must be set together")
+	}
+	if certFile == "" && caFile == "" {
+		return "", "", "", nil
+	}
+
+	if _, err := loadClientTLS(certFile, keyFile, caFile); err != nil {
+		return "", "", "", fmt.Errorf("invalid battery TLS files: %w", err)
+	}
+	return certFile, keyFile, caFile, nil
+}
+
+// getSOCJumpThreshold returns the charge level change in percentage points
+// between two scrapes counted as a jump, 0 disables the detection
+func getSOCJumpThreshold() (float64, error) {
+	value := os.Getenv("SONNENBATTERIE_SOC_JUMP_THRESHOLD")
+	if value == "" {
+		return defaultSOCJump, nil
+	}
+
+	threshold, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid SONNENBATTERIE_SOC_JUMP_THRESHOLD %q: %w", value, err)
+	}
+	if threshold < 0 || threshold > 100 {
+		return 0, fmt.Errorf("SONNENBATTERIE_SOC_JUMP_THRESHOLD must be between 0 and 100, got %v", threshold)
+	}
+	return threshold, nil
+}
+
+// getPowerEMAAlpha returns the weight of the newest reading in the smoothed
+// battery power, or the default
+func getPowerEMAAlpha() (float64, error) {
+	value := os.Getenv("SONNENBATTERIE_POWER_EMA_ALPHA")
+	if value == "" {
+		return defaultPowerEMAAlpha, nil
+	}
+
+	alpha, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid SONNENBATTERIE_POWER_EMA_ALPHA %q: %w", value, err)
+	}
+	if alpha <= 0 || alpha > 1 {
+		return 0, fmt.Errorf("SONNENBATTERIE_POWER_EMA_ALPHA must be above 0 and at most 1, got %v", alpha)
+	}
+	return alpha, nil
+}
+
+// getStaleTTL returns how long the last values of an unreachable battery are
+// served, or 0 if they are not
+func getStaleTTL() (time.Duration, error) {
+	value := os.Getenv("SONNENBATTERIE_STALE_TTL")
+	if value == "" {
+		return 0, nil
+	}
+
+	ttl, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid SONNENBATTERIE_STALE_TTL %q: %w", value, err)
+	}
+	if ttl < 0 {
+		return 0, fmt.Errorf("SONNENBATTERIE_STALE_TTL must not be negative, got %s", ttl)
+	}
+	return ttl, nil
+}
+
+// getScrapeTimeout returns the deadline of all battery requests of one
+// scrape, 0 if there is none
+func getScrapeTimeout() (time.Duration, error) {
+	value := os.Getenv("SONNENBATTERIE_SCRAPE_TIMEOUT")
+	if value == "" {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid SONNENBATTERIE_SCRAPE_TIMEOUT %q: %w", value, err)
+	}
+	if timeout < 0 {
+		return 0, fmt.Errorf("SONNENBATTERIE_SCRAPE_TIMEOUT must not be negative, got %s", timeout)
+	}
+	return timeout, nil
+}
+
+// getShutdownGrace returns how long in-flight requests may run after SIGTERM
+// or SIGINT, or the default
+func getShutdownGrace() (time.Duration, error) {
+	value := os.Getenv("EXPORTER_SHUTDOWN_GRACE_PERIOD")
+	if value == "" {
+		return defaultShutdownGrace, nil
+	}
+
+	grace, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid EXPORTER_SHUTDOWN_GRACE_PERIOD %q: %w", value, err)
+	}
+	if grace < 0 {
+		return 0, fmt.Errorf("EXPORTER_SHUTDOWN_GRACE_PERIOD must not be negative, got %s", grace)
+	}
+	return grace, nil
+}
+
+// getTLSCheckInterval returns how often battery TLS certificates are checked,
+// or the default. 0 disables the check
+func getTLSCheckInterval() (time.Duration, error) {
+	value := os.Getenv("SONNENBATTERIE_TLS_CHECK_INTERVAL")
+	if value == "" {
+		return defaultTLSInterval, nil
+	}
+
+	interval, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid SONNENBATTERIE_TLS_CHECK_INTERVAL %q: %w", value, err)
+	}
+	if interval < 0 {
+		return 0, fmt.Errorf("SONNENBATTERIE_TLS_CHECK_INTERVAL must not be negative, got %s", interval)
+	}
+	return interval, nil
+}
+
+// getForecastURL returns the solar production forecast URL, or "" if none is
+// configured
+func getForecastURL() (string, error) {
+	value := strings.TrimSpace(os.Getenv("SONNENBATTERIE_FORECAST_URL"))
+	if value == "" {
+		return "", nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid SONNENBATTERIE_FORECAST_URL %q: %w", value, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid SONNENBATTERIE_FORECAST_URL %q: must be an http or https URL", value)
+	}
+	return value, nil
+}
+
+// getRemoteWriteURL returns the Prometheus remote write endpoint metrics are
+// pushed to, or "" to only serve them for scraping
+func getRemoteWriteURL() (string, error) {
+	value := strings.TrimSpace(os.Getenv("SONNENBATTERIE_REMOTE_WRITE_URL"))
+	if value == "" {
+		return "", nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid SONNENBATTERIE_REMOTE_WRITE_URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid SONNENBATTERIE_REMOTE_WRITE_URL %q: must be an http or https URL", u.Redacted())
+	}
+	return value, nil
+}
+
+// getPushInterval returns how often metrics are pushed with remote write
+func getPushInterval() (time.Duration, error) {
+	value := os.Getenv("SONNENBATTERIE_PUSH_INTERVAL")
+	if value == "" {
+		return defaultPushInterval, nil
+	}
+
+	interval, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid SONNENBATTERIE_PUSH_INTERVAL %q: %w", value, err)
+	}
+	if interval <= 0 {
+		return 0, fmt.Errorf("SONNENBATTERIE_PUSH_INTERVAL must be positive, got %s", interval)
+	}
+	return interval, nil
+}
+
+// getAuthModules returns the Auth-Tokens usable with /probe by module name,
+// configured as name=token@target1|target2 entries. Every module must list
+// the targets its token may be sent to.
+func getAuthModules() (map[string]authModule, error) {
+	entries, err := configList("SONNENBATTERIE_AUTH_MODULES")
+	if err != nil {
+		return nil, err
+	}
+
+	modules := make(map[string]authModule, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		// Hosts never contain "@", so the last one separates the targets
+		at := strings.LastIndex(value, "@")
+		if !ok || name == "" || at < 0 {
+			// The entry holds a token, so it is not included in the error
+			return nil, fmt.Errorf("invalid SONNENBATTERIE_AUTH_MODULES entry for module %q: must be name=token@target1|target2", name)
+		}
+		module := authModule{token: strings.TrimSpace(value[:at])}
+		for _, target := range strings.Split(value[at+1:], "|") {
+			if target = strings.TrimSpace(target); target != "" {
+				if !validTarget(target) {
+					return nil, fmt.Errorf("SONNENBATTERIE_AUTH_MODULES: target %q of module %q must be host or host:port", target, name)
+				}
+				module.targets = append(module.targets, target)
+			}
+		}
+		if module.token == "" || len(module.targets) == 0 {
+			return nil, fmt.Errorf("invalid SONNENBATTERIE_AUTH_MODULES entry for module %q: must be name=token@target1|target2", name)
+		}
+		if _, seen := modules[name]; seen {
+			return nil, fmt.Errorf("SONNENBATTERIE_AUTH_MODULES: module %q is defined more than once", name)
+		}
+		modules[name] = module
+	}
+	return modules, nil
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestParseBatteries(t *testing.T) {
@@ -118,14 +124,487 @@ func TestParseBatteries(t *testing.T) {
 				if tt.wantFirstName != "" && batteries[0].Name != tt.wantFirstName {
 					t.Errorf("first battery name = %s, want %s", batteries[0].Name, tt.wantFirstName)
 				}
-				if tt.wantFirstIP != "" && batteries[0].IP != tt.wantFirstIP {
-					t.Errorf("first battery IP = %s, want %s", batteries[0].IP, tt.wantFirstIP)
+				if tt.wantFirstIP != "" && batteries[0].Address != tt.wantFirstIP {
+					t.Errorf("first battery IP = %s, want %s", batteries[0].Address, tt.wantFirstIP)
 				}
 			}
 		})
 	}
 }
 
+func TestParseBatteries_Groups(t *testing.T) {
+	_ = os.Setenv("SONNENBATTERIE_IPS", "192.168.1.100,192.168.1.101,192.168.1.102")
+	_ = os.Setenv("SONNENBATTERIE_TOKENS", "token1,token2,token3")
+	_ = os.Setenv("SONNENBATTERIE_GROUPS", " plant , plant")
+	defer func() {
+		_ = os.Unsetenv("SONNENBATTERIE_IPS")
+		_ = os.Unsetenv("SONNENBATTERIE_TOKENS")
+		_ = os.Unsetenv("SONNENBATTERIE_GROUPS")
+	}()
+
+	batteries, err := parseBatteries()
+	if err != nil {
+		t.Fatalf("parseBatteries() unexpected error: %v", err)
+	}
+
+	wantGroups := []string{"plant", "plant", ""}
+	for i, want := range wantGroups {
+		if batteries[i].Group != want {
+			t.Errorf("battery %d group = %q, want %q", i, batteries[i].Group, want)
+		}
+	}
+}
+
+func TestParseBatteries_Locations(t *testing.T) {
+	_ = os.Setenv("SONNENBATTERIE_IPS", "192.168.1.100,192.168.1.101,192.168.1.102")
+	_ = os.Setenv("SONNENBATTERIE_TOKENS", "token1,token2,token3")
+	_ = os.Setenv("SONNENBATTERIE_LOCATIONS", " berlin ,,munich")
+	defer func() {
+		_ = os.Unsetenv("SONNENBATTERIE_IPS")
+		_ = os.Unsetenv("SONNENBATTERIE_TOKENS")
+		_ = os.Unsetenv("SONNENBATTERIE_LOCATIONS")
+	}()
+
+	batteries, err := parseBatteries()
+	if err != nil {
+		t.Fatalf("parseBatteries() unexpected error: %v", err)
+	}
+
+	wantLocations := []string{"berlin", "", "munich"}
+	for i, want := range wantLocations {
+		if batteries[i].Location != want {
+			t.Errorf("battery %d location = %q, want %q", i, batteries[i].Location, want)
+		}
+	}
+}
+
+func TestParseBatteries_Expectations(t *testing.T) {
+	_ = os.Setenv("SONNENBATTERIE_IPS", "192.168.1.100,192.168.1.101,192.168.1.102")
+	_ = os.Setenv("SONNENBATTERIE_TOKENS", "token1,token2,token3")
+	_ = os.Setenv("SONNENBATTERIE_EXPECTED_OPERATING_MODES", "2,,auto")
+	_ = os.Setenv("SONNENBATTERIE_EXPECTED_BACKUP_RESERVES_FILE", writeTempFile(t, "20\n0\n"))
+	defer func() {
+		_ = os.Unsetenv("SONNENBATTERIE_IPS")
+		_ = os.Unsetenv("SONNENBATTERIE_TOKENS")
+		_ = os.Unsetenv("SONNENBATTERIE_EXPECTED_OPERATING_MODES")
+		_ = os.Unsetenv("SONNENBATTERIE_EXPECTED_BACKUP_RESERVES_FILE")
+	}()
+
+	result, err := parseBatteriesDetailed()
+	if err != nil {
+		t.Fatalf("parseBatteriesDetailed() unexpected error: %v", err)
+	}
+
+	format := func(v *int) string {
+		if v == nil {
+			return "none"
+		}
+		return strconv.Itoa(*v)
+	}
+	want := [][2]string{{"2", "20"}, {"none", "0"}, {"none", "none"}}
+	for i, w := range want {
+		expectation := result.Batteries[i].Expectation
+		mode, reserve := format(expectation.ExpectedOperatingMode), format(expectation.ExpectedBackupReserve)
+		if mode != w[0] || reserve != w[1] {
+			t.Errorf("battery %d expectation = %s/%s, want %s/%s", i, mode, reserve, w[0], w[1])
+		}
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Code != "invalid_expected_operating_mode" {
+		t.Errorf("warnings = %+v, want one invalid_expected_operating_mode", result.Warnings)
+	}
+}
+
+func TestParseBatteries_Addresses(t *testing.T) {
+	tests := []struct {
+		name          string
+		envAddresses  string
+		envIPs        string
+		wantAddresses []string
+		wantHTTPS     []bool
+		wantWarning   bool
+	}{
+		{
+			name:          "hostnames and IPs",
+			envAddresses:  "battery.home.arpa, 192.168.1.101:8080",
+			wantAddresses: []string{"battery.home.arpa", "192.168.1.101:8080"},
+		},
+		{
+			name:          "former variable name",
+			envIPs:        "192.168.1.100,192.168.1.101",
+			wantAddresses: []string{"192.168.1.100", "192.168.1.101"},
+		},
+		{
+			name:          "addresses take precedence",
+			envAddresses:  "battery0.local,battery1.local",
+			envIPs:        "192.168.1.100,192.168.1.101",
+			wantAddresses: []string{"battery0.local", "battery1.local"},
+			wantWarning:   true,
+		},
+		{
+			name:          "scheme prefixes",
+			envAddresses:  "https://battery.example.com/,HTTP://192.168.1.101:8080",
+			wantAddresses: []string{"battery.example.com", "192.168.1.101:8080"},
+			wantHTTPS:     []bool{true, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SONNENBATTERIE_ADDRESSES", tt.envAddresses)
+			t.Setenv("SONNENBATTERIE_IPS", tt.envIPs)
+			t.Setenv("SONNENBATTERIE_TOKENS", "token0,token1")
+
+			result, err := parseBatteriesDetailed()
+			if err != nil {
+				t.Fatalf("parseBatteriesDetailed() unexpected error: %v", err)
+			}
+
+			var addresses []string
+			var https []bool
+			for _, b := range result.Batteries {
+				addresses = append(addresses, b.Address)
+				https = append(https, b.HTTPS)
+			}
+			if fmt.Sprint(addresses) != fmt.Sprint(tt.wantAddresses) {
+				t.Errorf("addresses = %v, want %v", addresses, tt.wantAddresses)
+			}
+			if tt.wantHTTPS != nil && fmt.Sprint(https) != fmt.Sprint(tt.wantHTTPS) {
+				t.Errorf("HTTPS = %v, want %v", https, tt.wantHTTPS)
+			}
+
+			warned := false
+			for _, w := range result.Warnings {
+				warned = warned || w.Code == "addresses_and_ips"
+			}
+			if warned != tt.wantWarning {
+				t.Errorf("addresses_and_ips warning = %v, want %v", warned, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestParseBatteries_DesignCapacities(t *testing.T) {
+	_ = os.Setenv("SONNENBATTERIE_IPS", "192.168.1.100,192.168.1.101,192.168.1.102")
+	_ = os.Setenv("SONNENBATTERIE_TOKENS", "token1,token2,token3")
+	_ = os.Setenv("SONNENBATTERIE_DESIGN_CAPACITIES_WH", "10000,,lots")
+	defer func() {
+		_ = os.Unsetenv("SONNENBATTERIE_IPS")
+		_ = os.Unsetenv("SONNENBATTERIE_TOKENS")
+		_ = os.Unsetenv("SONNENBATTERIE_DESIGN_CAPACITIES_WH")
+	}()
+
+	result, err := parseBatteriesDetailed()
+	if err != nil {
+		t.Fatalf("parseBatteriesDetailed() unexpected error: %v", err)
+	}
+
+	wantCapacities := []float64{10000, 0, 0}
+	for i, want := range wantCapacities {
+		if result.Batteries[i].DesignCapacityWh != want {
+			t.Errorf("battery %d design capacity = %f, want %f", i, result.Batteries[i].DesignCapacityWh, want)
+		}
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Code != "invalid_design_capacity" {
+		t.Errorf("parseBatteriesDetailed() warnings = %+v, want invalid_design_capacity", result.Warnings)
+	}
+}
+
+func TestParseBatteries_CapacityUnits(t *testing.T) {
+	_ = os.Setenv("SONNENBATTERIE_ADDRESSES", "192.168.1.100,192.168.1.101,192.168.1.102,192.168.1.103")
+	_ = os.Setenv("SONNENBATTERIE_TOKENS", "token1,token2,token3,token4")
+	_ = os.Setenv("SONNENBATTERIE_CAPACITY_UNITS", "wh, MWh,,kwh")
+	defer func() {
+		_ = os.Unsetenv("SONNENBATTERIE_ADDRESSES")
+		_ = os.Unsetenv("SONNENBATTERIE_TOKENS")
+		_ = os.Unsetenv("SONNENBATTERIE_CAPACITY_UNITS")
+	}()
+
+	result, err := parseBatteriesDetailed()
+	if err != nil {
+		t.Fatalf("parseBatteriesDetailed() unexpected error: %v", err)
+	}
+
+	wantUnits := []string{"wh", "mwh", "", ""}
+	for i, want := range wantUnits {
+		if result.Batteries[i].CapacityUnit != want {
+			t.Errorf("battery %d capacity unit = %q, want %q", i, result.Batteries[i].CapacityUnit, want)
+		}
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Code != "invalid_capacity_unit" {
+		t.Errorf("parseBatteriesDetailed() warnings = %+v, want invalid_capacity_unit", result.Warnings)
+	}
+}
+
+func TestParseBatteries_MetricFilters(t *testing.T) {
+	t.Setenv("SONNENBATTERIE_ADDRESSES", "192.168.1.100,192.168.1.101,192.168.1.102")
+	t.Setenv("SONNENBATTERIE_TOKENS", "token1,token2,token3")
+	t.Setenv("SONNENBATTERIE_METRIC_FILTERS", "sonnenbatterie_charge_level_percent,, sonnenbatterie_charge_level_percent | sonnenbatterie_battery_power_watts ")
+
+	result, err := parseBatteriesDetailed()
+	if err != nil {
+		t.Fatalf("parseBatteriesDetailed() unexpected error: %v", err)
+	}
+
+	wantFilters := [][]string{
+		{"sonnenbatterie_charge_level_percent"},
+		nil,
+		{"sonnenbatterie_charge_level_percent", "sonnenbatterie_battery_power_watts"},
+	}
+	for i, want := range wantFilters {
+		if got := result.Batteries[i].MetricFilter; !slices.Equal(got, want) {
+			t.Errorf("battery %d metric filter = %q, want %q", i, got, want)
+		}
+	}
+	if len(result.Warnings) != 0 {
+		t.Errorf("parseBatteriesDetailed() warnings = %+v, want none", result.Warnings)
+	}
+}
+
+func TestParseBatteries_MinScrapeInterval(t *testing.T) {
+	_ = os.Setenv("SONNENBATTERIE_ADDRESSES", "192.168.1.100,192.168.1.101")
+	_ = os.Setenv("SONNENBATTERIE_TOKENS", "token1,token2")
+	defer func() {
+		_ = os.Unsetenv("SONNENBATTERIE_ADDRESSES")
+		_ = os.Unsetenv("SONNENBATTERIE_TOKENS")
+		_ = os.Unsetenv("SONNENBATTERIE_MIN_SCRAPE_INTERVAL")
+	}()
+
+	_ = os.Setenv("SONNENBATTERIE_MIN_SCRAPE_INTERVAL", "30s")
+	result, err := parseBatteriesDetailed()
+	if err != nil {
+		t.Fatalf("parseBatteriesDetailed() unexpected error: %v", err)
+	}
+	for _, b := range result.Batteries {
+		if b.MinScrapeInterval != 30*time.Second {
+			t.Errorf("battery %s min scrape interval = %s, want 30s", b.Name, b.MinScrapeInterval)
+		}
+	}
+
+	_ = os.Setenv("SONNENBATTERIE_MIN_SCRAPE_INTERVAL", "-30s")
+	if _, err := parseBatteriesDetailed(); err == nil {
+		t.Error("parseBatteriesDetailed() with negative interval expected error but got none")
+	}
+}
+
+func TestParseBatteriesDetailed_Warnings(t *testing.T) {
+	tests := []struct {
+		name         string
+		envIPs       string
+		envTokens    string
+		envNames     string
+		envGroups    string
+		envLocations string
+		wantCodes    []string
+	}{
+		{
+			name:      "no warnings",
+			envIPs:    "192.168.1.100,192.168.1.101",
+			envTokens: "token1,token2",
+			envNames:  "house,garage",
+		},
+		{
+			name:      "more names than IPs",
+			envIPs:    "192.168.1.100",
+			envTokens: "token1",
+			envNames:  "house,garage",
+			wantCodes: []string{"names_count_mismatch"},
+		},
+		{
+			name:      "fewer names than IPs",
+			envIPs:    "192.168.1.100,192.168.1.101",
+			envTokens: "token1,token2",
+			envNames:  "house",
+			wantCodes: []string{"names_count_mismatch"},
+		},
+		{
+			name:      "more groups than IPs",
+			envIPs:    "192.168.1.100",
+			envTokens: "token1",
+			envGroups: "plant,plant",
+			wantCodes: []string{"groups_count_mismatch"},
+		},
+		{
+			name:         "more locations than IPs",
+			envIPs:       "192.168.1.100",
+			envTokens:    "token1",
+			envLocations: "berlin,munich",
+			wantCodes:    []string{"locations_count_mismatch"},
+		},
+		{
+			name:         "unusual location",
+			envIPs:       "192.168.1.100",
+			envTokens:    "token1",
+			envLocations: "main street",
+			wantCodes:    []string{"unusual_location"},
+		},
+		{
+			name:      "unusual and duplicate names",
+			envIPs:    "192.168.1.100,192.168.1.101",
+			envTokens: "token1,token2",
+			envNames:  "my house,my house",
+			wantCodes: []string{"unusual_name", "unusual_name", "duplicate_name"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_ = os.Setenv("SONNENBATTERIE_IPS", tt.envIPs)
+			_ = os.Setenv("SONNENBATTERIE_TOKENS", tt.envTokens)
+			_ = os.Setenv("SONNENBATTERIE_NAMES", tt.envNames)
+			_ = os.Setenv("SONNENBATTERIE_GROUPS", tt.envGroups)
+			_ = os.Setenv("SONNENBATTERIE_LOCATIONS", tt.envLocations)
+			defer func() {
+				_ = os.Unsetenv("SONNENBATTERIE_IPS")
+				_ = os.Unsetenv("SONNENBATTERIE_TOKENS")
+				_ = os.Unsetenv("SONNENBATTERIE_NAMES")
+				_ = os.Unsetenv("SONNENBATTERIE_GROUPS")
+				_ = os.Unsetenv("SONNENBATTERIE_LOCATIONS")
+			}()
+
+			result, err := parseBatteriesDetailed()
+			if err != nil {
+				t.Fatalf("parseBatteriesDetailed() unexpected error: %v", err)
+			}
+
+			if len(result.Warnings) != len(tt.wantCodes) {
+				t.Fatalf("parseBatteriesDetailed() got %d warnings, want %d: %+v", len(result.Warnings), len(tt.wantCodes), result.Warnings)
+			}
+			for i, code := range tt.wantCodes {
+				if result.Warnings[i].Code != code {
+					t.Errorf("warning %d code = %s, want %s", i, result.Warnings[i].Code, code)
+				}
+			}
+		})
+	}
+}
+
+// writeTempFile creates a temporary file with the given content and returns its path
+func writeTempFile(t *testing.T, content string) string {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "config")
+	if err != nil {
+		t.Fatalf("CreateTemp() error = %v", err)
+	}
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("WriteString() error = %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	return f.Name()
+}
+
+func TestReadLinesFromFile(t *testing.T) {
+	path := writeTempFile(t, "192.168.1.100\n\n  192.168.1.101  \r\n\t\n192.168.1.102")
+
+	lines, err := readLinesFromFile(path)
+	if err != nil {
+		t.Fatalf("readLinesFromFile() error = %v", err)
+	}
+
+	want := []string{"192.168.1.100", "192.168.1.101", "192.168.1.102"}
+	if len(lines) != len(want) {
+		t.Fatalf("readLinesFromFile() returned %d lines, want %d: %q", len(lines), len(want), lines)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want[i])
+		}
+	}
+
+	if _, err := readLinesFromFile(path + ".missing"); err == nil {
+		t.Error("readLinesFromFile() expected error for missing file")
+	}
+}
+
+func TestParseBatteries_Files(t *testing.T) {
+	_ = os.Setenv("SONNENBATTERIE_IPS", "192.168.1.100")
+	_ = os.Setenv("SONNENBATTERIE_TOKENS", "token0")
+	_ = os.Setenv("SONNENBATTERIE_IPS_FILE", writeTempFile(t, "192.168.1.101\n\n192.168.1.102\n"))
+	_ = os.Setenv("SONNENBATTERIE_TOKENS_FILE", writeTempFile(t, "token1\ntoken2\n"))
+	defer func() {
+		_ = os.Unsetenv("SONNENBATTERIE_IPS")
+		_ = os.Unsetenv("SONNENBATTERIE_TOKENS")
+		_ = os.Unsetenv("SONNENBATTERIE_IPS_FILE")
+		_ = os.Unsetenv("SONNENBATTERIE_TOKENS_FILE")
+	}()
+
+	batteries, err := parseBatteries()
+	if err != nil {
+		t.Fatalf("parseBatteries() unexpected error: %v", err)
+	}
+
+	// Env var entries come first, followed by the file entries
+	wantIPs := []string{"192.168.1.100", "192.168.1.101", "192.168.1.102"}
+	wantTokens := []string{"token0", "token1", "token2"}
+	if len(batteries) != len(wantIPs) {
+		t.Fatalf("parseBatteries() got %d batteries, want %d", len(batteries), len(wantIPs))
+	}
+	for i := range wantIPs {
+		if batteries[i].Address != wantIPs[i] || batteries[i].AuthToken != wantTokens[i] {
+			t.Errorf("battery %d = %s/%s, want %s/%s", i, batteries[i].Address, batteries[i].AuthToken, wantIPs[i], wantTokens[i])
+		}
+	}
+}
+
+func TestParseBatteries_TokenFileRefresh(t *testing.T) {
+	tokensFile := writeTempFile(t, "token1\n")
+	_ = os.Setenv("SONNENBATTERIE_IPS", "192.168.1.100,192.168.1.101")
+	_ = os.Setenv("SONNENBATTERIE_TOKENS", "token0")
+	_ = os.Setenv("SONNENBATTERIE_TOKENS_FILE", tokensFile)
+	defer func() {
+		_ = os.Unsetenv("SONNENBATTERIE_IPS")
+		_ = os.Unsetenv("SONNENBATTERIE_TOKENS")
+		_ = os.Unsetenv("SONNENBATTERIE_TOKENS_FILE")
+	}()
+
+	batteries, err := parseBatteries()
+	if err != nil {
+		t.Fatalf("parseBatteries() unexpected error: %v", err)
+	}
+
+	if batteries[0].TokenRefreshFunc != nil {
+		t.Error("battery with env token has a TokenRefreshFunc")
+	}
+	if batteries[1].TokenRefreshFunc == nil {
+		t.Fatal("battery with file token has no TokenRefreshFunc")
+	}
+
+	// A rotated token written to the file is picked up by the refresh
+	if err := os.WriteFile(tokensFile, []byte("rotated\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	token, err := batteries[1].TokenRefreshFunc(context.Background())
+	if err != nil {
+		t.Fatalf("TokenRefreshFunc() error = %v", err)
+	}
+	if token != "rotated" {
+		t.Errorf("TokenRefreshFunc() = %q, want rotated", token)
+	}
+}
+
+func TestParseBatteries_FilesOnly(t *testing.T) {
+	_ = os.Setenv("SONNENBATTERIE_IPS_FILE", writeTempFile(t, "192.168.1.100\n"))
+	_ = os.Setenv("SONNENBATTERIE_TOKENS_FILE", writeTempFile(t, "token0\n"))
+	defer func() {
+		_ = os.Unsetenv("SONNENBATTERIE_IPS_FILE")
+		_ = os.Unsetenv("SONNENBATTERIE_TOKENS_FILE")
+	}()
+
+	batteries, err := parseBatteries()
+	if err != nil {
+		t.Fatalf("parseBatteries() unexpected error: %v", err)
+	}
+	if len(batteries) != 1 || batteries[0].Name != "battery0" {
+		t.Errorf("parseBatteries() = %+v, want single battery0", batteries)
+	}
+
+	_ = os.Setenv("SONNENBATTERIE_TOKENS_FILE", "/nonexistent/tokens")
+	if _, err := parseBatteries(); err == nil {
+		t.Error("parseBatteries() expected error for missing tokens file")
+	}
+}
+
 func TestGetPort(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -158,3 +637,1280 @@ func TestGetPort(t *testing.T) {
 		})
 	}
 }
+
+func TestGetCO2Intensity(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    float64
+		wantErr bool
+	}{
+		{
+			name: "default intensity",
+			env:  "",
+			want: 400,
+		},
+		{
+			name: "custom intensity",
+			env:  "250.5",
+			want: 250.5,
+		},
+		{
+			name:    "invalid intensity",
+			env:     "lots",
+			wantErr: true,
+		},
+		{
+			name:    "negative intensity",
+			env:     "-1",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				_ = os.Setenv("SONNENBATTERIE_CO2_INTENSITY_G_KWH", tt.env)
+				defer func() { _ = os.Unsetenv("SONNENBATTERIE_CO2_INTENSITY_G_KWH") }()
+			}
+
+			got, err := getCO2Intensity()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getCO2Intensity() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getCO2Intensity() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getCO2Intensity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetMaxLabelValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "default limit",
+			env:  "",
+			want: 50,
+		},
+		{
+			name: "custom limit",
+			env:  "10",
+			want: 10,
+		},
+		{
+			name: "guard disabled",
+			env:  "0",
+			want: 0,
+		},
+		{
+			name:    "invalid limit",
+			env:     "many",
+			wantErr: true,
+		},
+		{
+			name:    "negative limit",
+			env:     "-5",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				_ = os.Setenv("SONNENBATTERIE_MAX_LABEL_VALUES", tt.env)
+				defer func() { _ = os.Unsetenv("SONNENBATTERIE_MAX_LABEL_VALUES") }()
+			}
+
+			got, err := getMaxLabelValues()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getMaxLabelValues() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getMaxLabelValues() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getMaxLabelValues() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetFirmwareGracePeriod(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{
+			name: "default grace period",
+			env:  "",
+			want: 30 * time.Minute,
+		},
+		{
+			name: "custom grace period",
+			env:  "1h30m",
+			want: 90 * time.Minute,
+		},
+		{
+			name:    "invalid grace period",
+			env:     "forever",
+			wantErr: true,
+		},
+		{
+			name:    "negative grace period",
+			env:     "-5m",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				_ = os.Setenv("SONNENBATTERIE_FIRMWARE_GRACE_PERIOD", tt.env)
+				defer func() { _ = os.Unsetenv("SONNENBATTERIE_FIRMWARE_GRACE_PERIOD") }()
+			}
+
+			got, err := getFirmwareGracePeriod()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getFirmwareGracePeriod() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getFirmwareGracePeriod() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getFirmwareGracePeriod() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetConfigurationsMaxAge(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{
+			name: "default keeps until reload",
+			env:  "",
+			want: 0,
+		},
+		{
+			name: "explicit cache until reload",
+			env:  "0",
+			want: 0,
+		},
+		{
+			name: "custom max age",
+			env:  "1h",
+			want: time.Hour,
+		},
+		{
+			name:    "invalid max age",
+			env:     "often",
+			wantErr: true,
+		},
+		{
+			name:    "negative max age",
+			env:     "-1m",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				_ = os.Setenv("SONNENBATTERIE_CONFIGURATIONS_MAX_AGE", tt.env)
+				defer func() { _ = os.Unsetenv("SONNENBATTERIE_CONFIGURATIONS_MAX_AGE") }()
+			}
+
+			got, err := getConfigurationsMaxAge()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getConfigurationsMaxAge() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getConfigurationsMaxAge() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getConfigurationsMaxAge() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetCurrency(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "default currency",
+			env:  "",
+			want: "eur",
+		},
+		{
+			name: "custom currency is lowercased",
+			env:  " CHF ",
+			want: "chf",
+		},
+		{
+			name:    "invalid currency",
+			env:     "euro",
+			wantErr: true,
+		},
+		{
+			name:    "symbol",
+			env:     "€",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				_ = os.Setenv("SONNENBATTERIE_CURRENCY", tt.env)
+				defer func() { _ = os.Unsetenv("SONNENBATTERIE_CURRENCY") }()
+			}
+
+			got, err := getCurrency()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getCurrency() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getCurrency() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getCurrency() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetOffPeakPrice(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    *float64
+		wantErr bool
+	}{
+		{
+			name: "not configured",
+			env:  "",
+		},
+		{
+			name: "price",
+			env:  "0.28",
+			want: func() *float64 { v := 0.28; return &v }(),
+		},
+		{
+			name: "negative price",
+			env:  "-0.05",
+			want: func() *float64 { v := -0.05; return &v }(),
+		},
+		{
+			name:    "invalid price",
+			env:     "cheap",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				_ = os.Setenv("SONNENBATTERIE_OFFPEAK_PRICE_IMPORT", tt.env)
+				defer func() { _ = os.Unsetenv("SONNENBATTERIE_OFFPEAK_PRICE_IMPORT") }()
+			}
+
+			got, err := getOffPeakPrice("SONNENBATTERIE_OFFPEAK_PRICE_IMPORT")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getOffPeakPrice() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getOffPeakPrice() unexpected error: %v", err)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("getOffPeakPrice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetWarrantyYears(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "default warranty",
+			env:  "",
+			want: 10,
+		},
+		{
+			name: "custom warranty",
+			env:  "12",
+			want: 12,
+		},
+		{
+			name: "disabled",
+			env:  "0",
+			want: 0,
+		},
+		{
+			name:    "invalid warranty",
+			env:     "ten",
+			wantErr: true,
+		},
+		{
+			name:    "negative warranty",
+			env:     "-1",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				_ = os.Setenv("SONNENBATTERIE_WARRANTY_YEARS", tt.env)
+				defer func() { _ = os.Unsetenv("SONNENBATTERIE_WARRANTY_YEARS") }()
+			}
+
+			got, err := getWarrantyYears()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getWarrantyYears() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getWarrantyYears() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getWarrantyYears() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetTelemetryAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{name: "main listener by default", env: ""},
+		{name: "port only", env: ":9091", want: ":9091"},
+		{name: "host and port", env: " 127.0.0.1:9091 ", want: "127.0.0.1:9091"},
+		{name: "missing port", env: "127.0.0.1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("EXPORTER_TELEMETRY_ADDRESS", tt.env)
+
+			got, err := getTelemetryAddress()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getTelemetryAddress() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getTelemetryAddress() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getTelemetryAddress() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetRemoteWrite(t *testing.T) {
+	tests := []struct {
+		name         string
+		url          string
+		interval     string
+		wantURL      string
+		wantInterval time.Duration
+		wantErr      bool
+	}{
+		{name: "disabled by default", wantInterval: defaultPushInterval},
+		{name: "configured", url: "https://prometheus.example.com/api/v1/write", interval: "15s", wantURL: "https://prometheus.example.com/api/v1/write", wantInterval: 15 * time.Second},
+		{name: "not http", url: "ftp://prometheus.example.com/write", wantErr: true},
+		{name: "missing host", url: "http:///api/v1/write", wantErr: true},
+		{name: "invalid interval", interval: "often", wantErr: true},
+		{name: "zero interval", interval: "0s", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SONNENBATTERIE_REMOTE_WRITE_URL", tt.url)
+			t.Setenv("SONNENBATTERIE_PUSH_INTERVAL", tt.interval)
+
+			gotURL, urlErr := getRemoteWriteURL()
+			gotInterval, intervalErr := getPushInterval()
+			if tt.wantErr {
+				if urlErr == nil && intervalErr == nil {
+					t.Errorf("getRemoteWriteURL(), getPushInterval() expected error but got none")
+				}
+				return
+			}
+			if urlErr != nil || intervalErr != nil {
+				t.Fatalf("unexpected errors: %v, %v", urlErr, intervalErr)
+			}
+			if gotURL != tt.wantURL || gotInterval != tt.wantInterval {
+				t.Errorf("got %q, %s, want %q, %s", gotURL, gotInterval, tt.wantURL, tt.wantInterval)
+			}
+		})
+	}
+}
+
+func TestGetHideAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    bool
+		wantErr bool
+	}{
+		{name: "shown by default", env: "", want: false},
+		{name: "hidden", env: "true", want: true},
+		{name: "invalid value", env: "maybe", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("EXPORTER_HIDE_BATTERY_ADDRESSES", tt.env)
+
+			got, err := getHideAddresses()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getHideAddresses() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getHideAddresses() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getHideAddresses() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetBatteryDebug(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    bool
+		wantErr bool
+	}{
+		{name: "disabled by default", env: "", want: false},
+		{name: "enabled", env: "true", want: true},
+		{name: "invalid value", env: "sometimes", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				_ = os.Setenv("EXPORTER_ENABLE_BATTERY_DEBUG", tt.env)
+				defer func() { _ = os.Unsetenv("EXPORTER_ENABLE_BATTERY_DEBUG") }()
+			}
+
+			got, err := getBatteryDebug()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getBatteryDebug() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getBatteryDebug() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getBatteryDebug() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetRuntimeMetrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    bool
+		wantErr bool
+	}{
+		{
+			name: "default enabled",
+			env:  "",
+			want: true,
+		},
+		{
+			name: "disabled",
+			env:  "false",
+			want: false,
+		},
+		{
+			name: "enabled",
+			env:  "true",
+			want: true,
+		},
+		{
+			name:    "invalid value",
+			env:     "sometimes",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				_ = os.Setenv("EXPORTER_ENABLE_RUNTIME_METRICS", tt.env)
+				defer func() { _ = os.Unsetenv("EXPORTER_ENABLE_RUNTIME_METRICS") }()
+			}
+
+			got, err := getRuntimeMetrics()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getRuntimeMetrics() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getRuntimeMetrics() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getRuntimeMetrics() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetTLSCheckInterval(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{
+			name: "default interval",
+			env:  "",
+			want: time.Hour,
+		},
+		{
+			name: "disabled",
+			env:  "0",
+			want: 0,
+		},
+		{
+			name: "custom interval",
+			env:  "6h",
+			want: 6 * time.Hour,
+		},
+		{
+			name:    "invalid interval",
+			env:     "hourly",
+			wantErr: true,
+		},
+		{
+			name:    "negative interval",
+			env:     "-1h",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				_ = os.Setenv("SONNENBATTERIE_TLS_CHECK_INTERVAL", tt.env)
+				defer func() { _ = os.Unsetenv("SONNENBATTERIE_TLS_CHECK_INTERVAL") }()
+			}
+
+			got, err := getTLSCheckInterval()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getTLSCheckInterval() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getTLSCheckInterval() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getTLSCheckInterval() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetMaxPowerW(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    float64
+		wantErr bool
+	}{
+		{
+			name: "default",
+			env:  "",
+			want: 30000,
+		},
+		{
+			name: "custom limit",
+			env:  "15000",
+			want: 15000,
+		},
+		{
+			name:    "zero",
+			env:     "0",
+			wantErr: true,
+		},
+		{
+			name:    "invalid value",
+			env:     "lots",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				_ = os.Setenv("SONNENBATTERIE_SANITY_MAX_POWER_W", tt.env)
+				defer func() { _ = os.Unsetenv("SONNENBATTERIE_SANITY_MAX_POWER_W") }()
+			}
+
+			got, err := getMaxPowerW()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getMaxPowerW() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getMaxPowerW() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getMaxPowerW() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetStaleTTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{
+			name: "disabled by default",
+			env:  "",
+			want: 0,
+		},
+		{
+			name: "five minutes",
+			env:  "5m",
+			want: 5 * time.Minute,
+		},
+		{
+			name:    "negative ttl",
+			env:     "-5m",
+			wantErr: true,
+		},
+		{
+			name:    "invalid value",
+			env:     "soon",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				_ = os.Setenv("SONNENBATTERIE_STALE_TTL", tt.env)
+				defer func() { _ = os.Unsetenv("SONNENBATTERIE_STALE_TTL") }()
+			}
+
+			got, err := getStaleTTL()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getStaleTTL() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getStaleTTL() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getStaleTTL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetScrapeTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "disabled by default", env: "", want: 0},
+		{name: "nine seconds", env: "9s", want: 9 * time.Second},
+		{name: "negative timeout", env: "-1s", wantErr: true},
+		{name: "invalid value", env: "fast", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				_ = os.Setenv("SONNENBATTERIE_SCRAPE_TIMEOUT", tt.env)
+				defer func() { _ = os.Unsetenv("SONNENBATTERIE_SCRAPE_TIMEOUT") }()
+			}
+
+			got, err := getScrapeTimeout()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getScrapeTimeout() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getScrapeTimeout() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getScrapeTimeout() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetShutdownGrace(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "default", env: "", want: 10 * time.Second},
+		{name: "thirty seconds", env: "30s", want: 30 * time.Second},
+		{name: "no grace period", env: "0s", want: 0},
+		{name: "negative grace period", env: "-1s", wantErr: true},
+		{name: "invalid value", env: "soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				_ = os.Setenv("EXPORTER_SHUTDOWN_GRACE_PERIOD", tt.env)
+				defer func() { _ = os.Unsetenv("EXPORTER_SHUTDOWN_GRACE_PERIOD") }()
+			}
+
+			got, err := getShutdownGrace()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getShutdownGrace() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getShutdownGrace() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getShutdownGrace() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetPowerEMAAlpha(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    float64
+		wantErr bool
+	}{
+		{name: "default", env: "", want: 0.1},
+		{name: "custom alpha", env: "0.3", want: 0.3},
+		{name: "no smoothing", env: "1", want: 1},
+		{name: "zero alpha", env: "0", wantErr: true},
+		{name: "above one", env: "1.5", wantErr: true},
+		{name: "invalid value", env: "smooth", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				_ = os.Setenv("SONNENBATTERIE_POWER_EMA_ALPHA", tt.env)
+				defer func() { _ = os.Unsetenv("SONNENBATTERIE_POWER_EMA_ALPHA") }()
+			}
+
+			got, err := getPowerEMAAlpha()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getPowerEMAAlpha() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getPowerEMAAlpha() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getPowerEMAAlpha() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetClientTLSFiles(t *testing.T) {
+	dir := t.TempDir()
+	_, certFile, keyFile := newClientCertificate(t, dir, time.Now().Add(time.Hour))
+	garbage := filepath.Join(dir, "garbage.pem")
+	if err := os.WriteFile(garbage, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		caFile   string
+		wantErr  bool
+	}{
+		{name: "disabled by default"},
+		{name: "client certificate", certFile: certFile, keyFile: keyFile},
+		{name: "CA only", caFile: certFile},
+		{name: "certificate without key", certFile: certFile, wantErr: true},
+		{name: "missing file", certFile: certFile, keyFile: filepath.Join(dir, "missing.key"), wantErr: true},
+		{name: "CA file without certificates", caFile: garbage, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SONNENBATTERIE_CLIENT_CERT_FILE", tt.certFile)
+			t.Setenv("SONNENBATTERIE_CLIENT_KEY_FILE", tt.keyFile)
+			t.Setenv("SONNENBATTERIE_CA_CERT_FILE", tt.caFile)
+
+			certFile, keyFile, caFile, err := getClientTLSFiles()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getClientTLSFiles() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getClientTLSFiles() unexpected error: %v", err)
+			}
+			if certFile != tt.certFile || keyFile != tt.keyFile || caFile != tt.caFile {
+				t.Errorf("getClientTLSFiles() = %q, %q, %q, want %q, %q, %q", certFile, keyFile, caFile, tt.certFile, tt.keyFile, tt.caFile)
+			}
+		})
+	}
+}
+
+func TestGetSOCJumpThreshold(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    float64
+		wantErr bool
+	}{
+		{
+			name: "default threshold",
+			env:  "",
+			want: 10,
+		},
+		{
+			name: "custom threshold",
+			env:  "5.5",
+			want: 5.5,
+		},
+		{
+			name: "disabled",
+			env:  "0",
+			want: 0,
+		},
+		{
+			name:    "negative threshold",
+			env:     "-1",
+			wantErr: true,
+		},
+		{
+			name:    "above 100",
+			env:     "150",
+			wantErr: true,
+		},
+		{
+			name:    "invalid value",
+			env:     "ten",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				_ = os.Setenv("SONNENBATTERIE_SOC_JUMP_THRESHOLD", tt.env)
+				defer func() { _ = os.Unsetenv("SONNENBATTERIE_SOC_JUMP_THRESHOLD") }()
+			}
+
+			got, err := getSOCJumpThreshold()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getSOCJumpThreshold() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getSOCJumpThreshold() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getSOCJumpThreshold() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetBasicAuth(t *testing.T) {
+	// bcrypt hash of "s3cret"
+	hash := "$2a$04$nf4THYkvK9csYGZek0CAjO/Ds8rsDhckH58Sd.Hp98y.GLr5RmRQG"
+
+	tests := []struct {
+		name         string
+		username     string
+		passwordHash string
+		wantUsername string
+		wantErr      bool
+	}{
+		{name: "disabled by default"},
+		{name: "enabled", username: "prometheus", passwordHash: hash, wantUsername: "prometheus"},
+		{name: "username without hash", username: "prometheus", wantErr: true},
+		{name: "hash without username", passwordHash: hash, wantErr: true},
+		{name: "plain text password", username: "prometheus", passwordHash: "s3cret", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("EXPORTER_BASIC_AUTH_USERNAME", tt.username)
+			t.Setenv("EXPORTER_BASIC_AUTH_PASSWORD_HASH", tt.passwordHash)
+
+			username, passwordHash, err := getBasicAuth()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getBasicAuth() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getBasicAuth() unexpected error: %v", err)
+			}
+			if username != tt.wantUsername {
+				t.Errorf("getBasicAuth() username = %q, want %q", username, tt.wantUsername)
+			}
+			if tt.wantUsername != "" && string(passwordHash) != hash {
+				t.Errorf("getBasicAuth() password hash = %q, want %q", passwordHash, hash)
+			}
+		})
+	}
+}
+
+func TestGetListenerTLS(t *testing.T) {
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		wantErr  bool
+	}{
+		{name: "HTTP by default"},
+		{name: "certificate and key", certFile: "/etc/tls/tls.crt", keyFile: "/etc/tls/tls.key"},
+		{name: "certificate without key", certFile: "/etc/tls/tls.crt", wantErr: true},
+		{name: "key without certificate", keyFile: "/etc/tls/tls.key", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("EXPORTER_TLS_CERT_FILE", tt.certFile)
+			t.Setenv("EXPORTER_TLS_KEY_FILE", tt.keyFile)
+
+			certFile, keyFile, err := getListenerTLS()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getListenerTLS() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getListenerTLS() unexpected error: %v", err)
+			}
+			if certFile != tt.certFile || keyFile != tt.keyFile {
+				t.Errorf("getListenerTLS() = %q, %q, want %q, %q", certFile, keyFile, tt.certFile, tt.keyFile)
+			}
+		})
+	}
+}
+
+func TestGetReadyMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{name: "any by default", env: "", want: readyAny},
+		{name: "all", env: " ALL ", want: readyAll},
+		{name: "invalid value", env: "most", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				_ = os.Setenv("EXPORTER_READY_MODE", tt.env)
+				defer func() { _ = os.Unsetenv("EXPORTER_READY_MODE") }()
+			}
+
+			got, err := getReadyMode()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getReadyMode() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getReadyMode() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getReadyMode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetReadyWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "default", env: "", want: 5 * time.Minute},
+		{name: "fifteen minutes", env: "15m", want: 15 * time.Minute},
+		{name: "zero window", env: "0s", wantErr: true},
+		{name: "invalid value", env: "recent", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				_ = os.Setenv("EXPORTER_READY_WINDOW", tt.env)
+				defer func() { _ = os.Unsetenv("EXPORTER_READY_WINDOW") }()
+			}
+
+			got, err := getReadyWindow()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getReadyWindow() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getReadyWindow() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getReadyWindow() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetFeedInSign(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "export positive by default",
+			env:  "",
+			want: feedInExportPositive,
+		},
+		{
+			name: "import positive",
+			env:  "import_positive",
+			want: feedInImportPositive,
+		},
+		{
+			name: "case and whitespace",
+			env:  " Export_Positive ",
+			want: feedInExportPositive,
+		},
+		{
+			name:    "invalid value",
+			env:     "positive",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				_ = os.Setenv("SONNENBATTERIE_FEEDIN_SIGN", tt.env)
+				defer func() { _ = os.Unsetenv("SONNENBATTERIE_FEEDIN_SIGN") }()
+			}
+
+			got, err := getFeedInSign()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getFeedInSign() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getFeedInSign() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getFeedInSign() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetForecastURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "disabled by default",
+			env:  "",
+			want: "",
+		},
+		{
+			name: "https url",
+			env:  " https://forecast.example.com/api?site=1 ",
+			want: "https://forecast.example.com/api?site=1",
+		},
+		{
+			name:    "missing scheme",
+			env:     "forecast.example.com/api",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported scheme",
+			env:     "ftp://forecast.example.com",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				_ = os.Setenv("SONNENBATTERIE_FORECAST_URL", tt.env)
+				defer func() { _ = os.Unsetenv("SONNENBATTERIE_FORECAST_URL") }()
+			}
+
+			got, err := getForecastURL()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getForecastURL() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getForecastURL() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getForecastURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetAuthModules(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    map[string]authModule
+		wantErr bool
+	}{
+		{name: "none", env: "", want: map[string]authModule{}},
+		{
+			name: "two modules",
+			env:  "house=token1@192.168.1.50, garage = token2 @ garage.local:8080 | 192.168.2.50",
+			want: map[string]authModule{
+				"house":  {token: "token1", targets: []string{"192.168.1.50"}},
+				"garage": {token: "token2", targets: []string{"garage.local:8080", "192.168.2.50"}},
+			},
+		},
+		{name: "token containing equals and at signs", env: "house=a@b=@192.168.1.50", want: map[string]authModule{"house": {token: "a@b=", targets: []string{"192.168.1.50"}}}},
+		{name: "missing targets", env: "house=token1", wantErr: true},
+		{name: "empty targets", env: "house=token1@", wantErr: true},
+		{name: "invalid target", env: "house=token1@http://192.168.1.50", wantErr: true},
+		{name: "missing token", env: "house@192.168.1.50", wantErr: true},
+		{name: "empty token", env: "house=@192.168.1.50", wantErr: true},
+		{name: "empty name", env: "=token1@192.168.1.50", wantErr: true},
+		{name: "duplicate module", env: "house=token1@192.168.1.50,house=token2@192.168.1.51", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SONNENBATTERIE_AUTH_MODULES", tt.env)
+
+			got, err := getAuthModules()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getAuthModules() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getAuthModules() unexpected error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("getAuthModules() = %v, want %v", got, tt.want)
+			}
+			for name, module := range tt.want {
+				if got[name].token != module.token || !slices.Equal(got[name].targets, module.targets) {
+					t.Errorf("getAuthModules()[%q] = %+v, want %+v", name, got[name], module)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// batteryTimestampLayout is the layout of the local time in latestdata
+const batteryTimestampLayout = "2006-01-02 15:04:05"
+
+// configurations returns the cached system configuration of a battery,
+// fetching it on first use and after a reload, and once it is older than
+// ConfigurationsMaxAge if that is set. If it cannot be fetched the previous value is kept; an
+// empty configuration is returned if none is known.
+func (c *Collector) configurations(ctx context.Context, battery Battery) *Configurations {
+	c.mu.Lock()
+	state := c.batteryState(battery.Name)
+	cached, fetched := state.configurations, state.configurationsFetched
+	c.mu.Unlock()
+
+	now := c.now()
+	maxAge := c.options.ConfigurationsMaxAge
+	if cached != nil && !fetched.IsZero() && (maxAge == 0 || now.Sub(fetched) < maxAge) {
+		return cached
+	}
+
+	configurations, err := fetchConfigurations(ctx, battery)
+	if err != nil {
+		c.fetchFailed(battery, "configurations", err)
+		if cached != nil {
+			return cached
+		}
+		return &Configurations{}
+	}
+
+	c.mu.Lock()
+	state.configurations = configurations
+	state.configurationsFetched = now
+	state.configurationsUpdated = now
+	state.firmware = firmwareStateFrom(configurations, now)
+	c.mu.Unlock()
+
+	return configurations
+}
+
+// collectConfigurations emits metrics derived from the system configuration,
+// falling back to latestdata values where the configuration lacks them
+func (c *Collector) collectConfigurations(battery Battery, latestData *LatestData, configurations *Configurations, ch chan<- prometheus.Metric) {
+	c.emitFirmwareState(battery.Name, c.cachedFirmwareState(battery.Name), ch)
+
+	// Audit trail of the last configuration read, kept while the endpoint fails
+	c.mu.Lock()
+	updated := c.batteryState(battery.Name).configurationsUpdated
+	c.mu.Unlock()
+	if !updated.IsZero() {
+		c.gauge(ch, c.configInfo, 1,
+			battery.Name,
+			formatFlexFloat(configurations.OperatingMode),
+			formatFlexFloat(configurations.BackupReservePct),
+			formatFlexFloat(configurations.MinSOCPct),
+			apiVersion,
+			battery.scheme(),
+		)
+		c.gauge(ch, c.configLastUpdate, float64(updated.Unix()), battery.Name)
+	}
+
+	if configurations.TimeZone != nil && *configurations.TimeZone != "" {
+		c.gauge(ch, c.timezoneInfo, 1, battery.Name, *configurations.TimeZone)
+	}
+
+	inverterType, fwVersion, maxPower := inverterInfo(configurations)
+	c.gauge(ch, c.inverterInfo, 1, battery.Name, inverterType, fwVersion, maxPower)
+
+	if pv, ok := pvInfo(configurations.PVConfiguration); ok {
+		c.gauge(ch, c.pvPanelsInfo, 1, append([]string{battery.Name}, pv...)...)
+	}
+
+	if capacity, ok := designCapacity(configurations, battery.DesignCapacityWh); ok {
+		c.gauge(ch, c.designCapacity, capacity, battery.Name)
+	}
+
+	c.collectElectricityPrices(battery, latestData, configurations, ch)
+
+	loc, ok := batteryLocation(configurations.TimeZone, latestData.UTCOffset)
+	c.collectBatteryAge(battery, configurations, loc, ch)
+	if !ok {
+		return
+	}
+	offset, err := clockOffset(latestData.Timestamp, loc, c.now())
+	if err != nil {
+		log.Printf("Error parsing timestamp for %s: %v", battery.Name, err)
+		return
+	}
+	c.gauge(ch, c.clockOffset, offset, battery.Name)
+}
+
+// Enrich fetches the system configuration of every battery, so the static
+// system information is known from the first scrape on. Batteries that cannot
+// be reached are retried on their next successful scrape.
+func (c *Collector) Enrich() {
+	var wg sync.WaitGroup
+	for _, battery := range c.Batteries() {
+		wg.Add(1)
+		go func(b Battery) {
+			defer wg.Done()
+			c.configurations(context.Background(), b)
+		}(battery)
+	}
+	wg.Wait()
+}
+
+// systemInfo returns the serial, model, firmware and hardware version labels,
+// empty where the configuration lacks them
+func systemInfo(configurations *Configurations) []string {
+	return []string{
+		optionalString(configurations.SerialNumber),
+		optionalString(configurations.Model),
+		optionalString(configurations.SoftwareVersion),
+		optionalString(configurations.HardwareVersion),
+	}
+}
+
+// optionalString returns the value of s, or "" if it is nil
+func optionalString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// inverterInfo returns the inverter info labels, empty where the configuration lacks them
+func inverterInfo(configurations *Configurations) (inverterType, fwVersion, maxPower string) {
+	return optionalString(configurations.InverterType), optionalString(configurations.SoftwareVersion),
+		formatFlexFloat(configurations.InverterMaxPower)
+}
+
+// pvInfo returns the peak power, string count and orientation labels of the
+// PV installation, empty where the configuration lacks them. It reports false
+// if the configuration has no PV keys at all.
+func pvInfo(pv PVConfiguration) ([]string, bool) {
+	if pv.PeakPowerW == nil && pv.StringCount == nil && pv.Orientation == nil {
+		return nil, false
+	}
+	return []string{formatFlexFloat(pv.PeakPowerW), formatFlexFloat(pv.StringCount), optionalString(pv.Orientation)}, true
+}
+
+// designCapacity returns the installed capacity in Wh from the module count and
+// module capacity reported by the battery, falling back to the configured value
+func designCapacity(configurations *Configurations, configuredWh float64) (float64, bool) {
+	if configurations.BatteryModules != nil && configurations.ModuleCapacityWh != nil {
+		capacity := float64(*configurations.BatteryModules) * float64(*configurations.ModuleCapacityWh)
+		if capacity > 0 {
+			return capacity, true
+		}
+	}
+	if configuredWh > 0 {
+		return configuredWh, true
+	}
+	return 0, false
+}
+
+// collectBatteryAge emits the commissioning date, the age and the remaining
+// warranty. loc may be nil, in which case the date is read as UTC.
+func (c *Collector) collectBatteryAge(battery Battery, configurations *Configurations, loc *time.Location, ch chan<- prometheus.Metric) {
+	if configurations.CommissionedAt == nil || *configurations.CommissionedAt == "" {
+		return
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	commissioned, err := commissioningDate(*configurations.CommissionedAt, loc)
+	if err != nil {
+		log.Printf("Warning: %s: %v", battery.Name, err)
+		return
+	}
+
+	now := c.now()
+	c.gauge(ch, c.commissioningDate, float64(commissioned.Unix()), battery.Name)
+	c.gauge(ch, c.batteryAge, days(now.Sub(commissioned)), battery.Name)
+	if c.options.WarrantyYears > 0 {
+		remaining := days(commissioned.AddDate(c.options.WarrantyYears, 0, 0).Sub(now))
+		c.gauge(ch, c.warrantyRemaining, max(remaining, 0), battery.Name)
+	}
+}
+
+// commissioningLayouts are the date formats seen in the commissioning date
+var commissioningLayouts = []string{"2006-01-02", batteryTimestampLayout, time.RFC3339}
+
+// commissioningDate parses the commissioning date in the battery's time zone
+func commissioningDate(value string, loc *time.Location) (time.Time, error) {
+	for _, layout := range commissioningLayouts {
+		if date, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid commissioning date %q", value)
+}
+
+// days converts a duration into fractional days
+func days(d time.Duration) float64 {
+	return d.Hours() / 24
+}
+
+// batteryLocation returns the battery's time zone, preferring the configured
+// zone name and falling back to the UTC offset reported in latestdata
+func batteryLocation(timezone *string, utcOffsetHours *float64) (*time.Location, bool) {
+	if timezone != nil && *timezone != "" {
+		loc, err := time.LoadLocation(*timezone)
+		if err == nil {
+			return loc, true
+		}
+		log.Printf("Warning: unknown battery time zone %q: %v", *timezone, err)
+	}
+	if utcOffsetHours != nil {
+		seconds := int(*utcOffsetHours * 3600)
+		return time.FixedZone(fmt.Sprintf("UTC%+.4g", *utcOffsetHours), seconds), true
+	}
+	return nil, false
+}
+
+// clockOffset returns how many seconds the battery's local timestamp is ahead
+// of now, negative if the battery clock is behind
+func clockOffset(timestamp string, loc *time.Location, now time.Time) (float64, error) {
+	batteryTime, err := time.ParseInLocation(batteryTimestampLayout, timestamp, loc)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp %q: %w", timestamp, err)
+	}
+	return batteryTime.Sub(now).Seconds(), nil
+}
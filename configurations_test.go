@@ -0,0 +1,600 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestBatteryLocation(t *testing.T) {
+	zone := func(s string) *string { return &s }
+	hours := func(h float64) *float64 { return &h }
+	reference := time.Date(2020, 6, 3, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		timezone   *string
+		utcOffset  *float64
+		wantOffset int
+		wantOK     bool
+	}{
+		{
+			name:       "configured zone",
+			timezone:   zone("Europe/Berlin"),
+			utcOffset:  hours(5),
+			wantOffset: 2 * 3600,
+			wantOK:     true,
+		},
+		{
+			name:       "unknown zone falls back to UTC offset",
+			timezone:   zone("Mars/Olympus_Mons"),
+			utcOffset:  hours(5.5),
+			wantOffset: 5*3600 + 1800,
+			wantOK:     true,
+		},
+		{
+			name:       "UTC offset only",
+			utcOffset:  hours(-3),
+			wantOffset: -3 * 3600,
+			wantOK:     true,
+		},
+		{
+			name:   "nothing known",
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, ok := batteryLocation(tt.timezone, tt.utcOffset)
+			if ok != tt.wantOK {
+				t.Fatalf("batteryLocation() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if _, offset := reference.In(loc).Zone(); offset != tt.wantOffset {
+				t.Errorf("batteryLocation() offset = %d, want %d", offset, tt.wantOffset)
+			}
+		})
+	}
+}
+
+func TestClockOffset(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("LoadLocation() error = %v", err)
+	}
+
+	// 10:10:30 CEST is 08:10:30 UTC
+	tests := []struct {
+		name string
+		now  time.Time
+		want float64
+	}{
+		{name: "battery behind", now: time.Date(2020, 6, 3, 8, 12, 30, 0, time.UTC), want: -120},
+		{name: "battery ahead", now: time.Date(2020, 6, 3, 8, 5, 30, 0, time.UTC), want: 300},
+		{name: "in sync", now: time.Date(2020, 6, 3, 8, 10, 30, 400_000_000, time.UTC), want: -0.4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := clockOffset("2020-06-03 10:10:30", berlin, tt.now)
+			if err != nil {
+				t.Fatalf("clockOffset() error = %v", err)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("clockOffset() = %f, want %f", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := clockOffset("Wed Jun  3 10:10:31 2020", berlin, time.Now()); err == nil {
+		t.Error("clockOffset() expected error for unexpected layout")
+	}
+}
+
+func TestCollector_ClockOffset_Fixture(t *testing.T) {
+	latestData, err := os.ReadFile("testdata/latestdata.json")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	configurations, err := os.ReadFile("testdata/configurations.json")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v2/latestdata":
+			_, _ = w.Write(latestData)
+		case "/api/v2/status":
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/v2/configurations":
+			_, _ = w.Write(configurations)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+	// The fixture timestamp 2020-06-03 10:10:30 is in Europe/Berlin; skew the exporter clock by 45 seconds
+	collector.now = func() time.Time { return time.Date(2020, 6, 3, 8, 9, 45, 0, time.UTC) }
+
+	var offset, capacity float64
+	var timezone string
+	for _, m := range collectAll(collector) {
+		switch m.Desc() {
+		case collector.clockOffset:
+			offset = writeMetric(t, m).GetGauge().GetValue()
+		case collector.designCapacity:
+			capacity = writeMetric(t, m).GetGauge().GetValue()
+		case collector.timezoneInfo:
+			timezone = labelValue(writeMetric(t, m), "timezone")
+		}
+	}
+
+	if offset != 45 {
+		t.Errorf("clock offset = %f, want 45", offset)
+	}
+	if timezone != "Europe/Berlin" {
+		t.Errorf("timezone label = %q, want Europe/Berlin", timezone)
+	}
+	// 4 modules of 2500 Wh
+	if capacity != 10000 {
+		t.Errorf("design capacity = %f, want 10000", capacity)
+	}
+}
+
+func TestDesignCapacity(t *testing.T) {
+	number := func(f float64) *flexFloat { v := flexFloat(f); return &v }
+
+	tests := []struct {
+		name           string
+		configurations Configurations
+		configuredWh   float64
+		want           float64
+		wantOK         bool
+	}{
+		{
+			name:           "firmware provided",
+			configurations: Configurations{BatteryModules: number(4), ModuleCapacityWh: number(2500)},
+			configuredWh:   5000,
+			want:           10000,
+			wantOK:         true,
+		},
+		{
+			name:           "config provided",
+			configurations: Configurations{BatteryModules: number(4)},
+			configuredWh:   5000,
+			want:           5000,
+			wantOK:         true,
+		},
+		{
+			name:   "absent",
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := designCapacity(&tt.configurations, tt.configuredWh)
+			if ok != tt.wantOK {
+				t.Fatalf("designCapacity() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if got != tt.want {
+				t.Errorf("designCapacity() = %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollector_SerialNumber(t *testing.T) {
+	var requests atomic.Int32
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/latestdata", "/api/v2/status":
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/v2/configurations":
+			requests.Add(1)
+			if failing.Load() {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write([]byte(`{"DE_Ticket_Number": "123456"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	batteries := []Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}}
+	scrape := func(collector *Collector) (serial string, success float64) {
+		t.Helper()
+		for _, m := range collectAll(collector) {
+			switch m.Desc() {
+			case collector.info:
+				serial = labelValue(writeMetric(t, m), "serial")
+			case collector.scrapeSuccess:
+				success = writeMetric(t, m).GetGauge().GetValue()
+			}
+		}
+		return serial, success
+	}
+
+	collector := NewCollector(batteries, CollectorOptions{})
+	for i := 0; i < 3; i++ {
+		if serial, _ := scrape(collector); serial != "123456" {
+			t.Errorf("scrape %d: serial = %q, want 123456", i, serial)
+		}
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("configurations requests = %d, want 1", got)
+	}
+
+	// A reload refetches, keeping the cached serial if that fails
+	failing.Store(true)
+	collector.SetBatteries(batteries)
+	if serial, _ := scrape(collector); serial != "123456" {
+		t.Errorf("serial after failed refetch = %q, want 123456", serial)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("configurations requests after reload = %d, want 2", got)
+	}
+
+	// Without any cached configuration the label is empty but the scrape succeeds
+	collector = NewCollector(batteries, CollectorOptions{})
+	serial, success := scrape(collector)
+	if serial != "" {
+		t.Errorf("serial without configuration = %q, want empty", serial)
+	}
+	if success != 1 {
+		t.Errorf("scrape_success = %f, want 1", success)
+	}
+}
+
+func TestCollector_InverterInfo(t *testing.T) {
+	var requests atomic.Int32
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/latestdata", "/api/v2/status":
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/v2/configurations":
+			requests.Add(1)
+			if failing.Load() {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write([]byte(`{"IC_InverterType": "hybrid", "DE_Software": "1.14.5", "IC_InverterMaxPower_w": "4600"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	batteries := []Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}}
+	inverterLabels := func(collector *Collector) []string {
+		t.Helper()
+		for _, m := range collectAll(collector) {
+			if m.Desc() == collector.inverterInfo {
+				pb := writeMetric(t, m)
+				return []string{labelValue(pb, "type"), labelValue(pb, "fw_version"), labelValue(pb, "max_power")}
+			}
+		}
+		t.Fatal("sonnenbatterie_inverter_info not emitted")
+		return nil
+	}
+
+	collector := NewCollector(batteries, CollectorOptions{})
+	for i := 0; i < 3; i++ {
+		got := inverterLabels(collector)
+		if got[0] != "hybrid" || got[1] != "1.14.5" || got[2] != "4600" {
+			t.Errorf("collect %d: inverter info labels = %v, want [hybrid 1.14.5 4600]", i, got)
+		}
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("configurations requests = %d, want 1", got)
+	}
+
+	// Without a configuration the metric is still emitted with empty labels
+	failing.Store(true)
+	collector = NewCollector(batteries, CollectorOptions{})
+	if got := inverterLabels(collector); got[0] != "" || got[1] != "" || got[2] != "" {
+		t.Errorf("inverter info labels on failure = %v, want empty", got)
+	}
+}
+
+func TestCollector_PVPanelsInfo(t *testing.T) {
+	tests := []struct {
+		name           string
+		configurations string
+		want           []string // peak_power_w, string_count, orientation; nil if not emitted
+	}{
+		{
+			name:           "full PV configuration",
+			configurations: `{"PV_PeakPower_w": "10000", "PV_StringCount": 2, "PV_Orientation": "south"}`,
+			want:           []string{"10000", "2", "south"},
+		},
+		{
+			name:           "peak power only",
+			configurations: `{"PV_PeakPower_w": 7200}`,
+			want:           []string{"7200", "", ""},
+		},
+		{
+			name:           "no PV configuration",
+			configurations: `{"IC_InverterType": "hybrid"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api/v2/latestdata", "/api/v2/status":
+					_, _ = w.Write([]byte(`{}`))
+				case "/api/v2/configurations":
+					_, _ = w.Write([]byte(tt.configurations))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			collector := NewCollector(
+				[]Battery{{Name: "home", Address: server.URL[7:], AuthToken: "test-token"}},
+				CollectorOptions{},
+			)
+			registry := prometheus.NewRegistry()
+			registry.MustRegister(collector)
+			families, err := registry.Gather()
+			if err != nil {
+				t.Fatalf("Gather() error = %v", err)
+			}
+
+			var got []string
+			for _, family := range families {
+				if family.GetName() != "sonnenbatterie_photovoltaic_panels_info" {
+					continue
+				}
+				pb := family.GetMetric()[0]
+				if labelValue(pb, "battery_name") != "home" || pb.GetGauge().GetValue() != 1 {
+					t.Errorf("photovoltaic_panels_info = %v, want value 1 for battery home", pb)
+				}
+				got = []string{labelValue(pb, "peak_power_w"), labelValue(pb, "string_count"), labelValue(pb, "orientation")}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("photovoltaic_panels_info labels = %v, want %v", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("photovoltaic_panels_info labels = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCollector_ConfigInfo(t *testing.T) {
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/latestdata", "/api/v2/status":
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/v2/configurations":
+			if failing.Load() {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write([]byte(`{"EM_OperatingMode": "2", "EM_USOC": "20", "EM_MinSOC": 5}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{ConfigurationsMaxAge: time.Minute},
+	)
+	fetched := time.Date(2025, 11, 29, 12, 0, 0, 0, time.UTC)
+	now := fetched
+	collector.now = func() time.Time { return now }
+
+	wantLabels := map[string]string{
+		"battery_name":       "test-battery",
+		"operating_mode":     "2",
+		"backup_reserve_pct": "20",
+		"min_soc_pct":        "5",
+		"api_version":        "v2",
+		"scheme":             "http",
+	}
+	check := func(step string) {
+		t.Helper()
+		var labels map[string]string
+		var updated float64
+		for _, m := range collectAll(collector) {
+			switch m.Desc() {
+			case collector.configInfo:
+				labels = map[string]string{}
+				for _, lp := range writeMetric(t, m).GetLabel() {
+					labels[lp.GetName()] = lp.GetValue()
+				}
+			case collector.configLastUpdate:
+				updated = writeMetric(t, m).GetGauge().GetValue()
+			}
+		}
+		for name, want := range wantLabels {
+			if labels[name] != want {
+				t.Errorf("%s: label %s = %q, want %q", step, name, labels[name], want)
+			}
+		}
+		if updated != float64(fetched.Unix()) {
+			t.Errorf("%s: last update = %f, want %d", step, updated, fetched.Unix())
+		}
+	}
+
+	check("fetched")
+
+	// The configurations endpoint fails on the next poll; the last-known labels stay
+	failing.Store(true)
+	now = now.Add(2 * time.Minute)
+	check("after failure")
+}
+
+func TestCommissioningDate(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("LoadLocation() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "date", value: "2021-03-15", want: time.Date(2021, 3, 14, 23, 0, 0, 0, time.UTC)},
+		{name: "battery timestamp", value: "2021-03-15 10:30:00", want: time.Date(2021, 3, 15, 9, 30, 0, 0, time.UTC)},
+		{name: "RFC 3339", value: "2021-03-15T10:30:00Z", want: time.Date(2021, 3, 15, 10, 30, 0, 0, time.UTC)},
+		{name: "invalid", value: "15.03.2021", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := commissioningDate(tt.value, berlin)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("commissioningDate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) && !tt.wantErr {
+				t.Errorf("commissioningDate() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollector_BatteryAge(t *testing.T) {
+	now := time.Date(2025, 11, 29, 12, 0, 0, 0, time.UTC)
+	commissioned := now.AddDate(0, 0, -365)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/latestdata", "/api/v2/status":
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/v2/configurations":
+			_, _ = w.Write([]byte(`{"DE_Commissioning_Date": "` + commissioned.Format(batteryTimestampLayout) + `"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{WarrantyYears: 10},
+	)
+	collector.now = func() time.Time { return now }
+
+	var date, age, warranty float64
+	for _, m := range collectAll(collector) {
+		switch m.Desc() {
+		case collector.commissioningDate:
+			date = writeMetric(t, m).GetGauge().GetValue()
+		case collector.batteryAge:
+			age = writeMetric(t, m).GetGauge().GetValue()
+		case collector.warrantyRemaining:
+			warranty = writeMetric(t, m).GetGauge().GetValue()
+		}
+	}
+
+	if date != float64(commissioned.Unix()) {
+		t.Errorf("commissioning date = %f, want %d", date, commissioned.Unix())
+	}
+	if math.Abs(age-365) > 0.01 {
+		t.Errorf("age = %f days, want about 365", age)
+	}
+	// 10 years from commissioning, minus the year already passed
+	wantWarranty := commissioned.AddDate(10, 0, 0).Sub(now).Hours() / 24
+	if math.Abs(warranty-wantWarranty) > 0.01 {
+		t.Errorf("warranty remaining = %f days, want %f", warranty, wantWarranty)
+	}
+}
+
+func TestCollector_Enrich(t *testing.T) {
+	var requests atomic.Int32
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/latestdata", "/api/v2/status":
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/v2/configurations":
+			requests.Add(1)
+			if failing.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte(`{"DE_Ticket_Number": "123456", "ERP_ArticleName": "sonnenBatterie 10", "DE_Software": "1.14.5", "IC_HardwareVersion": "B2"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	batteries := []Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}}
+	infoLabels := func(collector *Collector) []string {
+		t.Helper()
+		for _, m := range collectAll(collector) {
+			if m.Desc() == collector.info {
+				pb := writeMetric(t, m)
+				return []string{labelValue(pb, "serial"), labelValue(pb, "model"), labelValue(pb, "firmware"), labelValue(pb, "hardware_version")}
+			}
+		}
+		t.Fatal("sonnenbatterie_info not emitted")
+		return nil
+	}
+	want := "[123456 sonnenBatterie 10 1.14.5 B2]"
+
+	collector := NewCollector(batteries, CollectorOptions{})
+	collector.Enrich()
+	if got := requests.Load(); got != 1 {
+		t.Fatalf("configurations requests after Enrich() = %d, want 1", got)
+	}
+	for i := 0; i < 5; i++ {
+		if got := fmt.Sprint(infoLabels(collector)); got != want {
+			t.Errorf("gather %d: info labels = %s, want %s", i, got, want)
+		}
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("configurations requests after 5 gathers = %d, want 1", got)
+	}
+
+	// A battery whose configuration is unavailable at startup is enriched on a later scrape
+	requests.Store(0)
+	failing.Store(true)
+	collector = NewCollector(batteries, CollectorOptions{})
+	collector.Enrich()
+	if got := fmt.Sprint(infoLabels(collector)); got != "[   ]" {
+		t.Errorf("info labels before enrichment = %s, want empty", got)
+	}
+	failing.Store(false)
+	if got := fmt.Sprint(infoLabels(collector)); got != want {
+		t.Errorf("info labels after late enrichment = %s, want %s", got, want)
+	}
+	infoLabels(collector)
+	if got := requests.Load(); got != 3 {
+		t.Errorf("configurations requests = %d, want 3 (startup, failed scrape, late enrichment)", got)
+	}
+}
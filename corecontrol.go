@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// coreControlModuleStateValue maps a core control module state onto the value
+// of sonnenbatterie_core_control_module_state, 0 for anything unrecognised
+func coreControlModuleStateValue(state string) float64 {
+	switch state {
+	case "ongrid":
+		return 1
+	case "offgrid":
+		return 2
+	case "standby":
+		return 3
+	default:
+		return 0
+	}
+}
+
+// emitCoreControlModule emits the reported core control module state and
+// counts changes from the last known one. Serving the same data again, as
+// with StaleTTL, never counts as a change.
+func (c *Collector) emitCoreControlModule(battery Battery, reported string, ch chan<- prometheus.Metric) {
+	state := strings.ToLower(strings.TrimSpace(reported))
+	if state == "" {
+		state = "unknown"
+	}
+
+	c.mu.Lock()
+	batteryState := c.batteryState(battery.Name)
+	previous := batteryState.coreControlState
+	batteryState.coreControlState = state
+	c.mu.Unlock()
+
+	if previous != "" && state != previous {
+		c.coreControlChanges.WithLabelValues(battery.Name).Inc()
+	}
+
+	infoState := c.guard.Check("sonnenbatterie_core_control_module_state_info", state)[0]
+	c.gauge(ch, c.coreControlModuleInfo, 1, battery.Name, infoState)
+	c.gauge(ch, c.coreControlModuleState, coreControlModuleStateValue(state), battery.Name)
+}
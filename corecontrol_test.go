@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCoreControlModuleStateValue(t *testing.T) {
+	tests := []struct {
+		state string
+		want  float64
+	}{
+		{state: "ongrid", want: 1},
+		{state: "offgrid", want: 2},
+		{state: "standby", want: 3},
+		{state: "config", want: 0},
+		{state: "unknown", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.state, func(t *testing.T) {
+			if got := coreControlModuleStateValue(tt.state); got != tt.want {
+				t.Errorf("coreControlModuleStateValue(%q) = %v, want %v", tt.state, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollector_CoreControlModuleTransitions(t *testing.T) {
+	latestData := &LatestData{}
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v2/latestdata":
+			_ = json.NewEncoder(w).Encode(latestData)
+		case "/api/v2/status":
+			_ = json.NewEncoder(w).Encode(Status{})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+
+	steps := []struct {
+		state           string
+		fail            bool
+		wantInfo        string
+		wantValue       float64
+		wantTransitions float64
+	}{
+		{state: "ongrid", wantInfo: "ongrid", wantValue: 1, wantTransitions: 0},
+		{state: "OnGrid", wantInfo: "ongrid", wantValue: 1, wantTransitions: 0},
+		{state: "offgrid", wantInfo: "offgrid", wantValue: 2, wantTransitions: 1},
+		// A failed scrape keeps the last known state
+		{fail: true, wantValue: -1, wantTransitions: 1},
+		{state: "offgrid", wantInfo: "offgrid", wantValue: 2, wantTransitions: 1},
+		{state: "standby", wantInfo: "standby", wantValue: 3, wantTransitions: 2},
+		{state: "", wantInfo: "unknown", wantValue: 0, wantTransitions: 3},
+		{state: "ongrid", wantInfo: "ongrid", wantValue: 1, wantTransitions: 4},
+	}
+	for i, step := range steps {
+		latestData.ICStatus.StateCoreControlModule = step.state
+		failing = step.fail
+
+		info, value := "", -1.0
+		for _, m := range collectAll(collector) {
+			switch m.Desc() {
+			case collector.coreControlModuleInfo:
+				info = labelValue(writeMetric(t, m), "state")
+			case collector.coreControlModuleState:
+				value = writeMetric(t, m).GetGauge().GetValue()
+			}
+		}
+		if info != step.wantInfo {
+			t.Errorf("step %d: state info = %q, want %q", i, info, step.wantInfo)
+		}
+		if value != step.wantValue {
+			t.Errorf("step %d: state = %v, want %v", i, value, step.wantValue)
+		}
+		if got := testutil.ToFloat64(collector.coreControlChanges.WithLabelValues("test-battery")); got != step.wantTransitions {
+			t.Errorf("step %d: transitions = %v, want %v", i, got, step.wantTransitions)
+		}
+	}
+}
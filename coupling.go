@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// acCouplingThresholdW is the AC-coupled production above which AC coupling
+// counts as detected, so meter noise at night does not
+const acCouplingThresholdW = 10
+
+// collectCoupling emits the DC-coupled solar input, the AC-coupled
+// production and the coupling type derived from them. The latter two need
+// the production and are skipped if it was dropped.
+func (c *Collector) collectCoupling(battery Battery, status *Status, dropped droppedReadings, ch chan<- prometheus.Metric) {
+	if status.DCInputPowerW != nil {
+		c.emitPower(ch, c.dcInputPower, c.dcInputPowerMW, *status.DCInputPowerW, battery.Name)
+	}
+	if status.DCInputVoltage != nil {
+		c.gauge(ch, c.dcInputVoltage, *status.DCInputVoltage, battery.Name)
+	}
+	if status.DCInputCurrentA != nil {
+		c.gauge(ch, c.dcInputCurrent, *status.DCInputCurrentA, battery.Name)
+	}
+
+	if !dropped.usable("production") {
+		return
+	}
+	acPowerW := acCoupledPower(status)
+	c.gauge(ch, c.acCouplingPower, acPowerW, battery.Name)
+	c.gauge(ch, c.acCouplingDetected, boolToFloat(acPowerW > acCouplingThresholdW), battery.Name)
+
+	c.gauge(ch, c.couplingType, 1, battery.Name, couplingType(status))
+}
+
+// acCoupledPower returns the production that does not enter through the DC
+// input, i.e. comes from a separate PV inverter on the AC bus
+func acCoupledPower(status *Status) float64 {
+	powerW := status.ProductionW
+	if status.DCInputPowerW != nil {
+		powerW -= *status.DCInputPowerW
+	}
+	return math.Max(powerW, 0)
+}
+
+// couplingType returns "dc" if the battery reports any DC input, "ac" if it
+// reports production without DC input, and "unknown" if neither tells
+func couplingType(status *Status) string {
+	switch {
+	case status.DCInputPowerW != nil || status.DCInputVoltage != nil || status.DCInputCurrentA != nil:
+		return "dc"
+	case status.ProductionW != 0:
+		return "ac"
+	default:
+		return "unknown"
+	}
+}
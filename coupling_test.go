@@ -0,0 +1,104 @@
+package main
+
+import "testing"
+
+func TestCollector_Coupling(t *testing.T) {
+	value := func(f float64) *float64 { return &f }
+
+	tests := []struct {
+		name         string
+		status       Status
+		wantCoupling string
+		wantDC       map[string]float64
+		wantACPower  float64
+		wantDetected float64
+	}{
+		{
+			name: "dc coupled",
+			status: Status{
+				ProductionW:     3200,
+				DCInputPowerW:   value(3300),
+				DCInputVoltage:  value(410.5),
+				DCInputCurrentA: value(8.04),
+			},
+			wantCoupling: "dc",
+			wantDC:       map[string]float64{"power": 3300, "voltage": 410.5, "current": 8.04},
+		},
+		{
+			name:         "dc coupled with an additional ac inverter",
+			status:       Status{ProductionW: 5000, DCInputPowerW: value(3000)},
+			wantCoupling: "dc",
+			wantDC:       map[string]float64{"power": 3000},
+			wantACPower:  2000,
+			wantDetected: 1,
+		},
+		{
+			name:         "ac coupled",
+			status:       Status{ProductionW: 3200},
+			wantCoupling: "ac",
+			wantDC:       map[string]float64{},
+			wantACPower:  3200,
+			wantDetected: 1,
+		},
+		{
+			name:         "ac coupled below threshold",
+			status:       Status{ProductionW: 8},
+			wantCoupling: "ac",
+			wantDC:       map[string]float64{},
+			wantACPower:  8,
+		},
+		{
+			name:         "no production reported",
+			status:       Status{},
+			wantCoupling: "unknown",
+			wantDC:       map[string]float64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMockBatteryServer(&LatestData{}, &tt.status)
+			defer server.Close()
+
+			collector := NewCollector(
+				[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+				CollectorOptions{},
+			)
+
+			coupling := ""
+			dc := map[string]float64{}
+			acPower, detected := -1.0, -1.0
+			for _, m := range collectAll(collector) {
+				switch m.Desc() {
+				case collector.couplingType:
+					coupling = labelValue(writeMetric(t, m), "coupling_type")
+				case collector.dcInputPower:
+					dc["power"] = writeMetric(t, m).GetGauge().GetValue()
+				case collector.dcInputVoltage:
+					dc["voltage"] = writeMetric(t, m).GetGauge().GetValue()
+				case collector.dcInputCurrent:
+					dc["current"] = writeMetric(t, m).GetGauge().GetValue()
+				case collector.acCouplingPower:
+					acPower = writeMetric(t, m).GetGauge().GetValue()
+				case collector.acCouplingDetected:
+					detected = writeMetric(t, m).GetGauge().GetValue()
+				}
+			}
+
+			if coupling != tt.wantCoupling {
+				t.Errorf("coupling_type = %q, want %q", coupling, tt.wantCoupling)
+			}
+			if acPower != tt.wantACPower || detected != tt.wantDetected {
+				t.Errorf("AC coupling power, detected = %v, %v, want %v, %v", acPower, detected, tt.wantACPower, tt.wantDetected)
+			}
+			if len(dc) != len(tt.wantDC) {
+				t.Fatalf("DC input metrics = %v, want %v", dc, tt.wantDC)
+			}
+			for name, want := range tt.wantDC {
+				if dc[name] != want {
+					t.Errorf("DC input %s = %f, want %f", name, dc[name], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// chargeMode returns "charging" or "discharging" from the status flags, or
+// "" while the battery is idle
+func chargeMode(status *Status) string {
+	if state, _ := chargeState(status); state != "idle" {
+		return state
+	}
+	return ""
+}
+
+// collectChargeCycles counts switches between charging and discharging, with
+// idle periods in between ignored, and emits the count of the current day in
+// the battery's time zone. The exporter's time zone is used if the battery's
+// is unknown.
+func (c *Collector) collectChargeCycles(battery Battery, latestData *LatestData, status *Status, configurations *Configurations, ch chan<- prometheus.Metric) {
+	loc, ok := batteryLocation(configurations.TimeZone, latestData.UTCOffset)
+	if !ok {
+		loc = time.Local
+	}
+	day := c.now().In(loc).Format(time.DateOnly)
+	mode := chargeMode(status)
+
+	c.mu.Lock()
+	state := c.batteryState(battery.Name)
+	if state.cyclesDay != day {
+		state.cyclesDay, state.cyclesToday = day, 0
+	}
+	switched := mode != "" && state.chargeMode != "" && mode != state.chargeMode
+	if switched {
+		state.cyclesToday++
+	}
+	if mode != "" {
+		state.chargeMode = mode
+	}
+	today := state.cyclesToday
+	c.mu.Unlock()
+
+	if switched {
+		c.chargeCycles.WithLabelValues(battery.Name).Inc()
+	}
+	c.gauge(ch, c.chargeCyclesToday, float64(today), battery.Name)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector_ChargeCycles(t *testing.T) {
+	utcOffset := 2.0
+	status := &Status{}
+	server := newMockBatteryServer(&LatestData{UTCOffset: &utcOffset}, status)
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+	var now time.Time
+	collector.now = func() time.Time { return now }
+
+	// 21:00 UTC is 23:00 at the battery, so its day ends at 22:00 UTC
+	evening := time.Date(2025, 11, 29, 21, 0, 0, 0, time.UTC)
+	steps := []struct {
+		at        time.Time
+		mode      string
+		wantToday float64
+		wantTotal float64
+	}{
+		{evening, "charging", 0, 0},
+		{evening.Add(10 * time.Minute), "discharging", 1, 1},
+		// Idle in between does not count as a switch
+		{evening.Add(20 * time.Minute), "", 1, 1},
+		{evening.Add(30 * time.Minute), "charging", 2, 2},
+		{evening.Add(40 * time.Minute), "discharging", 3, 3},
+		{evening.Add(50 * time.Minute), "discharging", 3, 3},
+		// Midnight at the battery resets the daily count only
+		{evening.Add(70 * time.Minute), "discharging", 0, 3},
+		{evening.Add(80 * time.Minute), "charging", 1, 4},
+	}
+	for i, step := range steps {
+		now = step.at
+		status.BatteryCharging = step.mode == "charging"
+		status.BatteryDischarging = step.mode == "discharging"
+
+		today := -1.0
+		for _, m := range collectAll(collector) {
+			if m.Desc() == collector.chargeCyclesToday {
+				today = writeMetric(t, m).GetGauge().GetValue()
+			}
+		}
+		if today != step.wantToday {
+			t.Errorf("step %d: cycles today = %v, want %v", i, today, step.wantToday)
+		}
+		if got := testutil.ToFloat64(collector.chargeCycles.WithLabelValues("test-battery")); got != step.wantTotal {
+			t.Errorf("step %d: cycles total = %v, want %v", i, got, step.wantTotal)
+		}
+	}
+}
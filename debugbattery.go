@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// debugFetchTimeout bounds the live fetches of one /debug/battery request
+const debugFetchTimeout = 30 * time.Second
+
+// debugEndpoints are the battery endpoints fetched by /debug/battery/{name},
+// with the type their payload is decoded into
+var debugEndpoints = []struct {
+	name   string
+	target func() any
+}{
+	{"latestdata", func() any { return &LatestData{} }},
+	{"status", func() any { return &Status{} }},
+}
+
+// debugFetch is the outcome of one live fetch
+type debugFetch struct {
+	Endpoint        string  `json:"endpoint"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Error           string  `json:"error,omitempty"`
+	Raw             string  `json:"raw,omitempty"` // Response body as is, also if it is not JSON
+	Decoded         any     `json:"decoded,omitempty"`
+	DecodeError     string  `json:"decode_error,omitempty"`
+}
+
+// battery returns the configured battery with the given name
+func (c *Collector) battery(name string) (Battery, bool) {
+	for _, b := range c.Batteries() {
+		if b.Name == name {
+			return b, true
+		}
+	}
+	return Battery{}, false
+}
+
+// batteryDebugHandler fetches latestdata and status of the battery named in
+// the path live, through the same client code as scrapes, and serves the raw
+// response bodies, including error pages and bodies that are not JSON, with
+// how they decode. The Auth-Token is redacted from the output.
+func batteryDebugHandler(collector *Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		battery, ok := collector.battery(r.PathValue("name"))
+		if !ok {
+			http.Error(w, "unknown battery", http.StatusNotFound)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), debugFetchTimeout)
+		defer cancel()
+		fetches := make([]debugFetch, 0, len(debugEndpoints))
+		for _, endpoint := range debugEndpoints {
+			fetch := debugFetch{Endpoint: endpoint.name}
+			start := time.Now()
+			raw, err := fetchBody(ctx, battery, endpoint.name)
+			fetch.DurationSeconds = time.Since(start).Seconds()
+			fetch.Raw = string(redactToken(battery, raw))
+			if err != nil {
+				fetch.Error = err.Error()
+			} else {
+				decoded := endpoint.target()
+				if err := json.Unmarshal(raw, decoded); err != nil {
+					fetch.DecodeError = err.Error()
+				} else {
+					fetch.Decoded = decoded
+				}
+			}
+			fetches = append(fetches, fetch)
+		}
+
+		body, err := json.MarshalIndent(struct {
+			BatteryName string       `json:"battery_name"`
+			Address     string       `json:"address"`
+			Fetches     []debugFetch `json:"fetches"`
+		}{battery.Name, battery.Address, fetches}, "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		body = redactToken(battery, body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	})
+}
+
+// redactToken replaces the battery's Auth-Token in data, so firmware or
+// proxies echoing the request cannot leak it
+func redactToken(battery Battery, data []byte) []byte {
+	if token := battery.token(); token != "" {
+		return bytes.ReplaceAll(data, []byte(token), []byte("[REDACTED]"))
+	}
+	return data
+}
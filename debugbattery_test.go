@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBatteryDebugHandler(t *testing.T) {
+	battery := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/latestdata":
+			_, _ = w.Write([]byte(`{"RSOC": 72, "Consumption_W": 450}`))
+		case "/api/v2/status":
+			// Firmware echoing the request headers
+			_, _ = w.Write([]byte(`{"Pac_total_W": "fast", "Request": {"Auth-Token": "` + r.Header.Get("Auth-Token") + `"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer battery.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "house", Address: battery.URL[7:], AuthToken: "secret-token"}},
+		CollectorOptions{},
+	)
+	mux := http.NewServeMux()
+	mux.Handle("GET /debug/battery/{name}", batteryDebugHandler(collector))
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{name: "known battery", path: "/debug/battery/house", wantStatus: http.StatusOK},
+		{name: "unknown battery", path: "/debug/battery/garage", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if strings.Contains(rec.Body.String(), "secret-token") {
+				t.Errorf("response contains the Auth-Token: %s", rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got struct {
+				BatteryName string `json:"battery_name"`
+				Fetches     []struct {
+					Endpoint    string         `json:"endpoint"`
+					Error       string         `json:"error"`
+					Raw         string         `json:"raw"`
+					Decoded     map[string]any `json:"decoded"`
+					DecodeError string         `json:"decode_error"`
+				} `json:"fetches"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if got.BatteryName != "house" || len(got.Fetches) != 2 {
+				t.Fatalf("response = %+v, want 2 fetches of house", got)
+			}
+
+			latestData, status := got.Fetches[0], got.Fetches[1]
+			if latestData.Error != "" || latestData.DecodeError != "" || latestData.Decoded["RSOC"] != 72.0 {
+				t.Errorf("latestdata fetch = %+v, want decoded RSOC 72", latestData)
+			}
+			// The raw payload is served even if it does not decode
+			if status.DecodeError == "" || !strings.Contains(string(status.Raw), `"fast"`) {
+				t.Errorf("status fetch = %+v, want raw payload with decode error", status)
+			}
+			if !strings.Contains(string(status.Raw), "[REDACTED]") {
+				t.Errorf("status raw payload = %s, want the echoed token redacted", status.Raw)
+			}
+		})
+	}
+}
+
+func TestBatteryDebugHandler_NotJSON(t *testing.T) {
+	battery := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/latestdata":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte(`<html>Maintenance <b>` + r.Header.Get("Auth-Token") + `</b></html>`))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`Service Unavailable`))
+		}
+	}))
+	defer battery.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "house", Address: battery.URL[7:], AuthToken: "secret-token"}},
+		CollectorOptions{},
+	)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/debug/battery/house", nil)
+	req.SetPathValue("name", "house")
+	batteryDebugHandler(collector).ServeHTTP(rec, req)
+
+	var got struct {
+		Fetches []debugFetch `json:"fetches"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if len(got.Fetches) != 2 {
+		t.Fatalf("fetches = %+v, want 2", got.Fetches)
+	}
+
+	// Bodies that are not JSON are served as they are, also with an error status
+	html, unavailable := got.Fetches[0], got.Fetches[1]
+	if html.DecodeError == "" || html.Raw != "<html>Maintenance <b>[REDACTED]</b></html>" {
+		t.Errorf("latestdata fetch = %+v, want the HTML body redacted with a decode error", html)
+	}
+	if unavailable.Error == "" || unavailable.Raw != "Service Unavailable" {
+		t.Errorf("status fetch = %+v, want the error page with the status error", unavailable)
+	}
+}
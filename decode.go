@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// decodeError is the last decode failure of a battery endpoint
+type decodeError struct {
+	BatteryName string    `json:"battery_name"`
+	Endpoint    string    `json:"endpoint"`
+	Error       string    `json:"error"`
+	Time        time.Time `json:"time"`
+}
+
+// decodeErrorLog keeps the last decode failure per battery and endpoint
+type decodeErrorLog struct {
+	mu     sync.Mutex
+	errors map[[2]string]decodeError
+}
+
+// recordDecodeError counts a decode failure and keeps it as the endpoint's
+// last one
+func (m *clientMetrics) recordDecodeError(batteryName, endpoint string, err error) {
+	if m == nil {
+		return
+	}
+	m.decodeFailures.WithLabelValues(batteryName, endpoint).Inc()
+	m.decodeErrors.record(batteryName, endpoint, err)
+}
+
+// record keeps err as the endpoint's last decode failure
+func (l *decodeErrorLog) record(batteryName, endpoint string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors[[2]string{batteryName, endpoint}] = decodeError{
+		BatteryName: batteryName,
+		Endpoint:    endpoint,
+		Error:       err.Error(),
+		Time:        time.Now(),
+	}
+}
+
+// forget drops the failures of a battery that is no longer configured
+func (l *decodeErrorLog) forget(batteryName string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key := range l.errors {
+		if key[0] == batteryName {
+			delete(l.errors, key)
+		}
+	}
+}
+
+// list returns the last failures sorted by battery and endpoint
+func (l *decodeErrorLog) list() []decodeError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := make([]decodeError, 0, len(l.errors))
+	for _, e := range l.errors {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].BatteryName != list[j].BatteryName {
+			return list[i].BatteryName < list[j].BatteryName
+		}
+		return list[i].Endpoint < list[j].Endpoint
+	})
+	return list
+}
+
+// debugHandler serves troubleshooting details as JSON, currently the last
+// decode failure of each battery endpoint of collector
+func debugHandler(collector *Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			DecodeErrors []decodeError `json:"decode_errors"`
+		}{collector.client.decodeErrors.list()})
+	})
+}
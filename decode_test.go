@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestFetchJSON_DecodeFailures(t *testing.T) {
+	fetchers := map[string]func(Battery) error{
+		"latestdata":     func(b Battery) error { _, err := fetchLatestData(context.Background(), b); return err },
+		"status":         func(b Battery) error { _, err := fetchStatus(context.Background(), b); return err },
+		"battery":        func(b Battery) error { _, err := fetchBatteryData(context.Background(), b); return err },
+		"inverter":       func(b Battery) error { _, err := fetchInverterData(context.Background(), b); return err },
+		"powermeter":     func(b Battery) error { _, err := fetchPowermeter(context.Background(), b); return err },
+		"configurations": func(b Battery) error { _, err := fetchConfigurations(context.Background(), b); return err },
+	}
+
+	tests := []struct {
+		name        string
+		statusCode  int
+		body        string
+		wantFailure bool
+	}{
+		{name: "malformed json", statusCode: http.StatusOK, body: `{"RSOC": `, wantFailure: true},
+		{name: "html error page", statusCode: http.StatusOK, body: "<html>busy</html>", wantFailure: true},
+		{name: "wrong type", statusCode: http.StatusOK, body: `"ok"`, wantFailure: true},
+		{name: "valid json", statusCode: http.StatusOK, body: `null`, wantFailure: false},
+		{name: "server error", statusCode: http.StatusInternalServerError, body: "garbage", wantFailure: false},
+	}
+
+	for _, tt := range tests {
+		for endpoint, fetch := range fetchers {
+			t.Run(tt.name+"/"+endpoint, func(t *testing.T) {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(tt.statusCode)
+					_, _ = w.Write([]byte(tt.body))
+				}))
+				defer server.Close()
+
+				metrics := newClientMetrics(metricNames{})
+				battery := Battery{Name: "decode-test", Address: server.URL[7:], AuthToken: "test-token", metrics: metrics}
+
+				_ = fetch(battery)
+
+				want := 0.0
+				if tt.wantFailure {
+					want = 1
+				}
+				if got := testutil.ToFloat64(metrics.decodeFailures.WithLabelValues(battery.Name, endpoint)); got != want {
+					t.Errorf("decode failures = %v, want %v", got, want)
+				}
+				if got := len(metrics.decodeErrors.list()); got != int(want) {
+					t.Errorf("last decode errors = %d, want %d", got, int(want))
+				}
+			})
+		}
+	}
+}
+
+func TestDebugHandler(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("not json"))
+	}))
+	defer server.Close()
+
+	collector := NewCollector([]Battery{{Name: "debug-test", Address: server.URL[7:], AuthToken: "test-token"}}, CollectorOptions{})
+	battery := collector.Batteries()[0]
+	_, _ = fetchStatus(context.Background(), battery)
+	_, _ = fetchLatestData(context.Background(), battery)
+
+	recorder := httptest.NewRecorder()
+	debugHandler(collector).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug", nil))
+
+	var body struct {
+		DecodeErrors []decodeError `json:"decode_errors"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding /debug response: %v", err)
+	}
+	decodeErrors := body.DecodeErrors
+	if len(decodeErrors) != 2 {
+		t.Fatalf("decode errors = %+v, want 2", decodeErrors)
+	}
+	// Sorted by battery and endpoint
+	for i, endpoint := range []string{"latestdata", "status"} {
+		got := decodeErrors[i]
+		if got.BatteryName != "debug-test" || got.Endpoint != endpoint {
+			t.Errorf("decode error %d = %s/%s, want debug-test/%s", i, got.BatteryName, got.Endpoint, endpoint)
+		}
+		if !strings.Contains(got.Error, "invalid character") {
+			t.Errorf("decode error %d message = %q, want the JSON error", i, got.Error)
+		}
+	}
+}
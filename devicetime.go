@@ -0,0 +1,57 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxDeviceClockSkew is how far the battery's measurement time may be from the
+// exporter's clock to be used as sample timestamp. Prometheus rejects samples
+// that are too old or out of order, so a battery with a wrong clock falls
+// back to untimestamped samples.
+const maxDeviceClockSkew = 5 * time.Minute
+
+// deviceTime returns the time the battery took the latestdata readings, if its
+// time zone is known and the time is within maxDeviceClockSkew of now
+func deviceTime(latestData *LatestData, configurations *Configurations, now time.Time) (time.Time, bool) {
+	loc, ok := batteryLocation(configurations.TimeZone, latestData.UTCOffset)
+	if !ok {
+		return time.Time{}, false
+	}
+	measured, err := time.ParseInLocation(batteryTimestampLayout, latestData.Timestamp, loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if skew := measured.Sub(now); skew > maxDeviceClockSkew || skew < -maxDeviceClockSkew {
+		return time.Time{}, false
+	}
+	return measured, true
+}
+
+// withDeviceTimestamps returns a channel that forwards metrics to ch stamped
+// with the battery's measurement time, and a function to call once all
+// metrics are sent. Without UseDeviceTimestamps or a usable measurement time,
+// ch itself is returned.
+func (c *Collector) withDeviceTimestamps(latestData *LatestData, configurations *Configurations, ch chan<- prometheus.Metric) (chan<- prometheus.Metric, func()) {
+	if !c.options.UseDeviceTimestamps {
+		return ch, func() {}
+	}
+	measured, ok := deviceTime(latestData, configurations, c.now())
+	if !ok {
+		return ch, func() {}
+	}
+
+	stamped := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for m := range stamped {
+			ch <- prometheus.NewMetricWithTimestamp(measured, m)
+		}
+	}()
+	return stamped, func() {
+		close(stamped)
+		<-done
+	}
+}
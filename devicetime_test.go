@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeviceTime(t *testing.T) {
+	utc := 0.0
+	berlin := "Europe/Berlin"
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		latestData     LatestData
+		configurations Configurations
+		want           time.Time
+		wantOK         bool
+	}{
+		{
+			name:       "recent measurement",
+			latestData: LatestData{Timestamp: "2025-06-01 11:59:30", UTCOffset: &utc},
+			want:       now.Add(-30 * time.Second),
+			wantOK:     true,
+		},
+		{
+			name:           "battery time zone",
+			latestData:     LatestData{Timestamp: "2025-06-01 14:00:10"},
+			configurations: Configurations{TimeZone: &berlin},
+			want:           now.Add(10 * time.Second),
+			wantOK:         true,
+		},
+		{
+			name:       "stale measurement",
+			latestData: LatestData{Timestamp: "2025-06-01 11:50:00", UTCOffset: &utc},
+		},
+		{
+			name:       "battery clock ahead",
+			latestData: LatestData{Timestamp: "2025-06-01 12:06:00", UTCOffset: &utc},
+		},
+		{
+			name:       "unknown time zone",
+			latestData: LatestData{Timestamp: "2025-06-01 12:00:00"},
+		},
+		{
+			name:       "invalid timestamp",
+			latestData: LatestData{Timestamp: "noon", UTCOffset: &utc},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := deviceTime(&tt.latestData, &tt.configurations, now)
+			if ok != tt.wantOK || !got.Equal(tt.want) {
+				t.Errorf("deviceTime() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCollector_DeviceTimestamps(t *testing.T) {
+	utc := 0.0
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		enabled   bool
+		timestamp string
+		want      int64 // Expected timestamp in milliseconds, 0 for none
+	}{
+		{name: "recent measurement", enabled: true, timestamp: "2025-06-01 11:59:58", want: now.Add(-2 * time.Second).UnixMilli()},
+		{name: "stale measurement", enabled: true, timestamp: "2025-06-01 11:00:00"},
+		{name: "disabled", timestamp: "2025-06-01 11:59:58"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMockBatteryServer(&LatestData{RSOC: 50, Timestamp: tt.timestamp, UTCOffset: &utc}, &Status{})
+			defer server.Close()
+
+			collector := NewCollector(
+				[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+				CollectorOptions{UseDeviceTimestamps: tt.enabled},
+			)
+			collector.now = func() time.Time { return now }
+
+			var charge, success int64 = -1, -1
+			for _, m := range collectAll(collector) {
+				switch m.Desc() {
+				case collector.chargeLevel:
+					charge = writeMetric(t, m).GetTimestampMs()
+				case collector.scrapeSuccess:
+					success = writeMetric(t, m).GetTimestampMs()
+				}
+			}
+			if charge != tt.want {
+				t.Errorf("charge level timestamp = %d, want %d", charge, tt.want)
+			}
+			// Metrics about the scrape itself are never stamped
+			if success != 0 {
+				t.Errorf("scrape success timestamp = %d, want none", success)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// configDrift returns the configuration values that differ from the
+// expectation, as "name: got, want expected". Values the battery does not
+// report are not compared.
+func configDrift(expected ConfigExpectation, configurations *Configurations) []string {
+	var drift []string
+	compare := func(name string, want *int, got *flexFloat) {
+		if want != nil && got != nil && float64(*got) != float64(*want) {
+			drift = append(drift, fmt.Sprintf("%s: %s, want %d", name, formatFlexFloat(got), *want))
+		}
+	}
+	compare("operating mode", expected.ExpectedOperatingMode, configurations.OperatingMode)
+	compare("backup reserve", expected.ExpectedBackupReserve, configurations.BackupReservePct)
+	return drift
+}
+
+// collectConfigDrift compares the configuration with the expected values of
+// the battery and counts each change from matching to drifted. Nothing is
+// emitted until the configuration has been read once.
+func (c *Collector) collectConfigDrift(battery Battery, configurations *Configurations, ch chan<- prometheus.Metric) {
+	drift := configDrift(battery.Expectation, configurations)
+
+	c.mu.Lock()
+	state := c.batteryState(battery.Name)
+	known := !state.configurationsUpdated.IsZero()
+	started := known && len(drift) > 0 && !state.configDrift
+	if known {
+		state.configDrift = len(drift) > 0
+	}
+	c.mu.Unlock()
+
+	if !known {
+		return
+	}
+	if started {
+		log.Printf("Configuration of %s differs from the expected values: %s", battery.Name, strings.Join(drift, "; "))
+		c.configDriftEvents.WithLabelValues(battery.Name).Inc()
+	}
+	c.gauge(ch, c.configDriftDetected, boolToFloat(len(drift) > 0), battery.Name)
+}
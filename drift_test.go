@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestConfigDrift(t *testing.T) {
+	value := func(v int) *int { return &v }
+	reported := func(v float64) *flexFloat { f := flexFloat(v); return &f }
+
+	tests := []struct {
+		name           string
+		expectation    ConfigExpectation
+		configurations Configurations
+		want           int
+	}{
+		{
+			name:           "no expectations",
+			configurations: Configurations{OperatingMode: reported(1), BackupReservePct: reported(50)},
+		},
+		{
+			name:           "all match",
+			expectation:    ConfigExpectation{ExpectedOperatingMode: value(2), ExpectedBackupReserve: value(20)},
+			configurations: Configurations{OperatingMode: reported(2), BackupReservePct: reported(20)},
+		},
+		{
+			name:           "operating mode differs",
+			expectation:    ConfigExpectation{ExpectedOperatingMode: value(2), ExpectedBackupReserve: value(20)},
+			configurations: Configurations{OperatingMode: reported(1), BackupReservePct: reported(20)},
+			want:           1,
+		},
+		{
+			name:           "both differ",
+			expectation:    ConfigExpectation{ExpectedOperatingMode: value(2), ExpectedBackupReserve: value(20)},
+			configurations: Configurations{OperatingMode: reported(10), BackupReservePct: reported(0)},
+			want:           2,
+		},
+		{
+			name:           "unreported value is not compared",
+			expectation:    ConfigExpectation{ExpectedOperatingMode: value(2), ExpectedBackupReserve: value(20)},
+			configurations: Configurations{OperatingMode: reported(2)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := configDrift(tt.expectation, &tt.configurations); len(got) != tt.want {
+				t.Errorf("configDrift() = %v, want %d differences", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollector_ConfigDrift(t *testing.T) {
+	var reserve atomic.Int32
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/latestdata", "/api/v2/status":
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/v2/configurations":
+			if failing.Load() {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"EM_OperatingMode": "2", "EM_USOC": "%d"}`, reserve.Load())
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	mode, backupReserve := 2, 20
+	collector := NewCollector([]Battery{
+		{
+			Name:        "expected",
+			Address:     server.URL[7:],
+			AuthToken:   "test-token",
+			Expectation: ConfigExpectation{ExpectedOperatingMode: &mode, ExpectedBackupReserve: &backupReserve},
+		},
+		{Name: "unexpected", Address: server.URL[7:], AuthToken: "test-token"},
+	}, CollectorOptions{ConfigurationsMaxAge: time.Minute})
+	now := time.Date(2025, 11, 29, 12, 0, 0, 0, time.UTC)
+	collector.now = func() time.Time { return now }
+
+	failing.Store(true)
+	for _, m := range collectAll(collector) {
+		if m.Desc() == collector.configDriftDetected {
+			t.Errorf("config_drift_detected emitted before the configuration was read")
+		}
+	}
+	failing.Store(false)
+
+	steps := []struct {
+		reserve    int32
+		wantDrift  float64
+		wantEvents float64
+	}{
+		{reserve: 20, wantDrift: 0, wantEvents: 0},
+		{reserve: 30, wantDrift: 1, wantEvents: 1},
+		{reserve: 30, wantDrift: 1, wantEvents: 1},
+		{reserve: 20, wantDrift: 0, wantEvents: 1},
+		{reserve: 50, wantDrift: 1, wantEvents: 2},
+	}
+	for i, step := range steps {
+		reserve.Store(step.reserve)
+		now = now.Add(2 * time.Minute)
+
+		drift := map[string]float64{}
+		for _, m := range collectAll(collector) {
+			if m.Desc() == collector.configDriftDetected {
+				pb := writeMetric(t, m)
+				drift[labelValue(pb, "battery_name")] = pb.GetGauge().GetValue()
+			}
+		}
+		if drift["expected"] != step.wantDrift {
+			t.Errorf("step %d: config_drift_detected = %v, want %v", i, drift["expected"], step.wantDrift)
+		}
+		if v, ok := drift["unexpected"]; !ok || v != 0 {
+			t.Errorf("step %d: config_drift_detected without expectations = %v (present %v), want 0", i, v, ok)
+		}
+		if got := testutil.ToFloat64(collector.configDriftEvents.WithLabelValues("expected")); got != step.wantEvents {
+			t.Errorf("step %d: config_drift_events_total = %v, want %v", i, got, step.wantEvents)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// dryRunTimeout bounds the connectivity check of each battery with --dry-run
+const dryRunTimeout = 10 * time.Second
+
+// connectivityResult is the outcome of querying one battery
+type connectivityResult struct {
+	battery Battery
+	err     error // nil if latestdata was fetched
+}
+
+// validateConnectivity queries latestdata of all batteries in parallel and
+// returns the results in battery order. A battery not answering within
+// timeout counts as unreachable.
+func validateConnectivity(batteries []Battery, timeout time.Duration) []connectivityResult {
+	results := make([]connectivityResult, len(batteries))
+	var wg sync.WaitGroup
+	for i, battery := range batteries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done := make(chan error, 1)
+			go func() {
+				_, err := fetchLatestData(context.Background(), battery)
+				done <- err
+			}()
+
+			results[i].battery = battery
+			select {
+			case err := <-done:
+				results[i].err = err
+			case <-time.After(timeout):
+				results[i].err = fmt.Errorf("no response within %s", timeout)
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// runDryRun checks all batteries, writes a table of the results followed by
+// sonnenbatterie_dry_run_validation_passed in the text exposition format, and
+// returns the exit code: 0 if all batteries are reachable, 1 otherwise
+func runDryRun(w io.Writer, batteries []Battery, timeout time.Duration) int {
+	results := validateConnectivity(batteries, timeout)
+
+	passed := len(results) > 0
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(table, "BATTERY\tADDRESS\tTOKEN LENGTH\tAPI VERSION\tSTATUS")
+	for _, r := range results {
+		status := "ok"
+		if r.err != nil {
+			status = "error: " + r.err.Error()
+			passed = false
+		}
+		_, _ = fmt.Fprintf(table, "%s\t%s\t%d\t%s\t%s\n", r.battery.Name, r.battery.Address, len(r.battery.AuthToken), apiVersion, status)
+	}
+	_ = table.Flush()
+
+	// Machine-readable result for pipelines
+	validation := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sonnenbatterie_dry_run_validation_passed",
+		Help: "Whether all configured batteries were reachable during the dry run",
+	})
+	validation.Set(boolToFloat(passed))
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(validation)
+	families, err := registry.Gather()
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "gathering dry run metrics: %v\n", err)
+		return 1
+	}
+	_, _ = fmt.Fprintln(w)
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(w, family); err != nil {
+			_, _ = fmt.Fprintf(w, "writing dry run metrics: %v\n", err)
+			return 1
+		}
+	}
+
+	if !passed {
+		return 1
+	}
+	return 0
+}
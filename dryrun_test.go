@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunDryRun(t *testing.T) {
+	healthy := newMockBatteryServer(&LatestData{}, &Status{})
+	defer healthy.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer failing.Close()
+	release := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hanging.Close()
+	defer close(release)
+
+	ok := Battery{Name: "home", Address: healthy.URL[7:], AuthToken: "secret-token-1"}
+	unauthorized := Battery{Name: "garage", Address: failing.URL[7:], AuthToken: "secret-token-2"}
+	slow := Battery{Name: "barn", Address: hanging.URL[7:], AuthToken: "secret-token-3"}
+
+	tests := []struct {
+		name       string
+		batteries  []Battery
+		wantCode   int
+		wantPassed string
+	}{
+		{
+			name:       "all reachable",
+			batteries:  []Battery{ok},
+			wantCode:   0,
+			wantPassed: "sonnenbatterie_dry_run_validation_passed 1",
+		},
+		{
+			name:       "one failing",
+			batteries:  []Battery{ok, unauthorized},
+			wantCode:   1,
+			wantPassed: "sonnenbatterie_dry_run_validation_passed 0",
+		},
+		{
+			name:       "timeout",
+			batteries:  []Battery{slow},
+			wantCode:   1,
+			wantPassed: "sonnenbatterie_dry_run_validation_passed 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if got := runDryRun(&out, tt.batteries, 100*time.Millisecond); got != tt.wantCode {
+				t.Errorf("runDryRun() = %d, want %d", got, tt.wantCode)
+			}
+
+			output := out.String()
+			if !strings.Contains(output, tt.wantPassed+"\n") {
+				t.Errorf("output missing %q:\n%s", tt.wantPassed, output)
+			}
+			for _, b := range tt.batteries {
+				if strings.Contains(output, b.AuthToken) {
+					t.Errorf("output contains the token of %s:\n%s", b.Name, output)
+				}
+				if !strings.Contains(output, b.Address) {
+					t.Errorf("output missing the address of %s:\n%s", b.Name, output)
+				}
+			}
+			// Every test token is 14 characters long
+			if !strings.Contains(output, " 14 ") {
+				t.Errorf("output missing the token length:\n%s", output)
+			}
+		})
+	}
+}
+
+func TestValidateConnectivity_Order(t *testing.T) {
+	server := newMockBatteryServer(&LatestData{}, &Status{})
+	defer server.Close()
+	offline := httptest.NewServer(http.NotFoundHandler())
+	offline.Close()
+
+	results := validateConnectivity([]Battery{
+		{Name: "first", Address: offline.URL[7:]},
+		{Name: "second", Address: server.URL[7:]},
+	}, time.Second)
+
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	if results[0].battery.Name != "first" || results[0].err == nil {
+		t.Errorf("results[0] = %s, err %v, want first with an error", results[0].battery.Name, results[0].err)
+	}
+	if results[1].battery.Name != "second" || results[1].err != nil {
+		t.Errorf("results[1] = %s, err %v, want second without error", results[1].battery.Name, results[1].err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// scrapeOutcomesSize is the number of scrape outcomes kept per battery, five
+// minutes at a 5-second scrape interval
+const scrapeOutcomesSize = 60
+
+// degradedErrorRate is the error rate above which a battery counts as degraded
+const degradedErrorRate = 0.5
+
+// scrapeOutcomes is a ring buffer of the latest scrape outcomes
+type scrapeOutcomes struct {
+	failed [scrapeOutcomesSize]bool
+	next   int // Slot the next outcome is written to
+	count  int // Outcomes in failed
+
+	consecutiveFailures int // Failed scrapes since the last successful one
+}
+
+// add stores an outcome, replacing the oldest once the window is full
+func (o *scrapeOutcomes) add(failed bool) {
+	o.failed[o.next] = failed
+	o.next = (o.next + 1) % scrapeOutcomesSize
+	o.count = min(o.count+1, scrapeOutcomesSize)
+	if failed {
+		o.consecutiveFailures++
+	} else {
+		o.consecutiveFailures = 0
+	}
+}
+
+// errorRate returns the share of failed scrapes in the window
+func (o *scrapeOutcomes) errorRate() float64 {
+	if o.count == 0 {
+		return 0
+	}
+	failures := 0
+	for _, failed := range o.failed[:o.count] {
+		if failed {
+			failures++
+		}
+	}
+	return float64(failures) / float64(o.count)
+}
+
+// collectErrorRate records the outcome of a scrape and emits the error rate
+// over the window, whether it exceeds degradedErrorRate and the number of
+// failed scrapes in a row
+func (c *Collector) collectErrorRate(battery Battery, failed bool, ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	outcomes := &c.batteryState(battery.Name).outcomes
+	outcomes.add(failed)
+	rate, consecutive := outcomes.errorRate(), outcomes.consecutiveFailures
+	c.mu.Unlock()
+
+	c.gauge(ch, c.apiErrorRate, rate, battery.Name)
+	c.gauge(ch, c.apiDegraded, boolToFloat(rate > degradedErrorRate), battery.Name)
+	c.gauge(ch, c.consecutiveFailures, float64(consecutive), battery.Name)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestScrapeOutcomes_ErrorRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern []bool // true for a failed scrape
+		want    float64
+	}{
+		{name: "empty", pattern: nil, want: 0},
+		{name: "all successful", pattern: repeatOutcomes(60, false), want: 0},
+		{name: "all failed", pattern: repeatOutcomes(60, true), want: 1},
+		{name: "partially filled", pattern: []bool{true, false, false, false}, want: 0.25},
+		{name: "alternating", pattern: append(repeatOutcomes(30, true), repeatOutcomes(30, false)...), want: 0.5},
+		// The 20 failures are pushed out by the 60 successes that follow
+		{name: "oldest dropped", pattern: append(repeatOutcomes(20, true), repeatOutcomes(60, false)...), want: 0},
+		{name: "wrapped", pattern: append(repeatOutcomes(60, false), repeatOutcomes(15, true)...), want: 0.25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var outcomes scrapeOutcomes
+			for _, failed := range tt.pattern {
+				outcomes.add(failed)
+			}
+			if got := outcomes.errorRate(); got != tt.want {
+				t.Errorf("errorRate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollector_APIErrorRate(t *testing.T) {
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v2/latestdata":
+			_ = json.NewEncoder(w).Encode(LatestData{})
+		case "/api/v2/status":
+			_ = json.NewEncoder(w).Encode(Status{})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+
+	steps := []struct {
+		fail         bool
+		wantRate     float64
+		wantDegraded float64
+	}{
+		{fail: false, wantRate: 0, wantDegraded: 0},
+		{fail: true, wantRate: 0.5, wantDegraded: 0},
+		{fail: true, wantRate: 2.0 / 3, wantDegraded: 1},
+		{fail: false, wantRate: 0.5, wantDegraded: 0},
+	}
+	for i, step := range steps {
+		failing = step.fail
+
+		rate, degraded := -1.0, -1.0
+		for _, m := range collectAll(collector) {
+			switch m.Desc() {
+			case collector.apiErrorRate:
+				rate = writeMetric(t, m).GetGauge().GetValue()
+			case collector.apiDegraded:
+				degraded = writeMetric(t, m).GetGauge().GetValue()
+			}
+		}
+		if rate != step.wantRate {
+			t.Errorf("step %d: error rate = %v, want %v", i, rate, step.wantRate)
+		}
+		if degraded != step.wantDegraded {
+			t.Errorf("step %d: degraded = %v, want %v", i, degraded, step.wantDegraded)
+		}
+	}
+}
+
+func TestCollector_ConsecutiveScrapeFailures(t *testing.T) {
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v2/latestdata":
+			_ = json.NewEncoder(w).Encode(LatestData{})
+		case "/api/v2/status":
+			_ = json.NewEncoder(w).Encode(Status{})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	steps := []struct {
+		fail bool
+		want float64
+	}{
+		{fail: true, want: 1},
+		{fail: true, want: 2},
+		{fail: false, want: 0},
+		{fail: true, want: 1},
+	}
+	for i, step := range steps {
+		failing = step.fail
+
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatalf("step %d: Gather() error = %v", i, err)
+		}
+		got := -1.0
+		for _, family := range families {
+			if family.GetName() == "sonnenbatterie_consecutive_scrape_failures" {
+				got = family.GetMetric()[0].GetGauge().GetValue()
+			}
+		}
+		if got != step.want {
+			t.Errorf("step %d: consecutive_scrape_failures = %v, want %v", i, got, step.want)
+		}
+	}
+}
+
+// repeatOutcomes returns n scrape outcomes of the same kind
+func repeatOutcomes(n int, failed bool) []bool {
+	outcomes := make([]bool, n)
+	for i := range outcomes {
+		outcomes[i] = failed
+	}
+	return outcomes
+}
@@ -0,0 +1,90 @@
+//go:build ignore
+
+// This file is a reference MetricProvider implementation. Copy it next to the
+// exporter sources, remove the build constraint and register it in main.go:
+//
+//	collector.RegisterProvider(NewSolarIrradianceProvider("http://weather.local/api/irradiance"))
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// irradianceResponse is the JSON document returned by the local weather API
+type irradianceResponse struct {
+	IrradianceWM2 float64 `json:"irradiance_w_m2"`
+}
+
+// SolarIrradianceProvider correlates solar production with the irradiance
+// reported by a local weather station
+type SolarIrradianceProvider struct {
+	url    string
+	client *http.Client
+
+	irradiance         *prometheus.Desc
+	productionPerWatts *prometheus.Desc
+}
+
+// NewSolarIrradianceProvider creates a provider reading irradiance from url
+func NewSolarIrradianceProvider(url string) *SolarIrradianceProvider {
+	return &SolarIrradianceProvider{
+		url:    url,
+		client: &http.Client{Timeout: 5 * time.Second},
+		irradiance: prometheus.NewDesc(
+			"sonnenbatterie_solar_irradiance_w_m2",
+			"Solar irradiance reported by the local weather station in watts per square meter",
+			[]string{"battery_name"},
+			nil,
+		),
+		productionPerWatts: prometheus.NewDesc(
+			"sonnenbatterie_production_per_irradiance_m2",
+			"Solar production divided by irradiance, an effective panel area in square meters",
+			[]string{"battery_name"},
+			nil,
+		),
+	}
+}
+
+// Descs implements MetricProvider
+func (p *SolarIrradianceProvider) Descs() []*prometheus.Desc {
+	return []*prometheus.Desc{p.irradiance, p.productionPerWatts}
+}
+
+// Collect implements MetricProvider
+func (p *SolarIrradianceProvider) Collect(battery Battery, latestData *LatestData, status *Status, ch chan<- prometheus.Metric) {
+	irradiance, err := p.fetchIrradiance()
+	if err != nil {
+		log.Printf("Error fetching irradiance for %s: %v", battery.Name, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(p.irradiance, prometheus.GaugeValue, irradiance, battery.Name)
+	if irradiance > 0 {
+		ch <- prometheus.MustNewConstMetric(p.productionPerWatts, prometheus.GaugeValue, status.ProductionW/irradiance, battery.Name)
+	}
+}
+
+func (p *SolarIrradianceProvider) fetchIrradiance() (float64, error) {
+	resp, err := p.client.Get(p.url)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch %s: %w", p.url, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, p.url)
+	}
+
+	var data irradianceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return 0, fmt.Errorf("failed to decode JSON from %s: %w", p.url, err)
+	}
+	return data.IrradianceWM2, nil
+}
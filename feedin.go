@@ -0,0 +1,25 @@
+package main
+
+// Grid feed-in sign conventions; sonnen flipped the sign between firmware
+// lines, so dashboards expect either one
+const (
+	feedInExportPositive = "export_positive" // As reported by the battery API
+	feedInImportPositive = "import_positive"
+)
+
+// feedInSignHelp describes the sign convention in the feed-in metric help
+func feedInSignHelp(sign string) string {
+	if sign == feedInImportPositive {
+		return "positive=consuming from grid, negative=exporting"
+	}
+	return "positive=exporting, negative=consuming"
+}
+
+// feedIn converts a GridFeedIn reading, positive when exporting, to the
+// configured sign convention
+func (c *Collector) feedIn(gridFeedInW float64) float64 {
+	if c.options.FeedInSign == feedInImportPositive {
+		return -gridFeedInW
+	}
+	return gridFeedInW
+}
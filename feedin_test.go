@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCollector_FeedInSign(t *testing.T) {
+	tests := []struct {
+		name       string
+		sign       string
+		wantFeedIn float64
+		wantHelp   string
+	}{
+		{
+			name:       "default",
+			sign:       "",
+			wantFeedIn: -250,
+			wantHelp:   "positive=exporting, negative=consuming",
+		},
+		{
+			name:       "export positive",
+			sign:       feedInExportPositive,
+			wantFeedIn: -250,
+			wantHelp:   "positive=exporting, negative=consuming",
+		},
+		{
+			name:       "import positive",
+			sign:       feedInImportPositive,
+			wantFeedIn: 250,
+			wantHelp:   "positive=consuming from grid, negative=exporting",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The battery imports 250 W from the grid
+			server := newMockBatteryServer(
+				&LatestData{GridFeedInW: -250},
+				&Status{GridFeedInW: -250},
+			)
+			defer server.Close()
+
+			collector := NewCollector(
+				[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+				CollectorOptions{FeedInSign: tt.sign, LegacyMilliwatts: true},
+			)
+
+			feedIn, feedInMW, flow := 0.0, 0.0, 0.0
+			for _, m := range collectAll(collector) {
+				switch m.Desc() {
+				case collector.gridFeedIn:
+					feedIn = writeMetric(t, m).GetGauge().GetValue()
+				case collector.gridFeedInMW:
+					feedInMW = writeMetric(t, m).GetGauge().GetValue()
+				case collector.powerFlowState:
+					flow = writeMetric(t, m).GetGauge().GetValue()
+				}
+			}
+
+			if feedIn != tt.wantFeedIn {
+				t.Errorf("grid feed-in = %v, want %v", feedIn, tt.wantFeedIn)
+			}
+			if feedInMW != tt.wantFeedIn*1000 {
+				t.Errorf("grid feed-in mW = %v, want %v", feedInMW, tt.wantFeedIn*1000)
+			}
+			// Importing regardless of the sign convention
+			if flow != 1 {
+				t.Errorf("power flow state = %v, want 1", flow)
+			}
+			for _, desc := range []string{collector.gridFeedIn.String(), collector.gridFeedInMW.String()} {
+				if !strings.Contains(desc, tt.wantHelp) {
+					t.Errorf("desc %s does not state %q", desc, tt.wantHelp)
+				}
+			}
+		})
+	}
+}
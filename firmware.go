@@ -0,0 +1,55 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// firmwareState caches the last reported firmware update flags, since the API
+// often disappears entirely while an update is being installed
+type firmwareState struct {
+	updateAvailable  *bool
+	updateInProgress *bool
+	seen             time.Time // When the flags were last read from the battery
+}
+
+// firmwareStateFrom extracts the firmware flags from a configuration read at seen
+func firmwareStateFrom(configurations *Configurations, seen time.Time) firmwareState {
+	return firmwareState{
+		updateAvailable:  (*bool)(configurations.UpdateAvailable),
+		updateInProgress: (*bool)(configurations.UpdateInProgress),
+		seen:             seen,
+	}
+}
+
+// cachedFirmwareState returns the cached firmware flags if they are still
+// within the grace period, or an empty state otherwise
+func (c *Collector) cachedFirmwareState(name string) firmwareState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fw := c.batteryState(name).firmware
+	if fw.seen.IsZero() || c.now().Sub(fw.seen) > c.options.FirmwareGracePeriod {
+		return firmwareState{}
+	}
+	return fw
+}
+
+// emitFirmwareState emits the firmware flags that are known
+func (c *Collector) emitFirmwareState(name string, fw firmwareState, ch chan<- prometheus.Metric) {
+	if fw.updateAvailable != nil {
+		c.gauge(ch, c.firmwareUpdateAvailable, boolToFloat(*fw.updateAvailable), name)
+	}
+	if fw.updateInProgress != nil {
+		c.gauge(ch, c.firmwareUpdateInProgress, boolToFloat(*fw.updateInProgress), name)
+	}
+}
+
+// boolToFloat converts a boolean into a 1/0 gauge value
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1.0
+	}
+	return 0.0
+}
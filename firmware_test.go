@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// firmwareMetrics collects once and returns the firmware gauges by metric name
+func firmwareMetrics(t *testing.T, collector *Collector) map[string]float64 {
+	t.Helper()
+	values := map[string]float64{}
+	for _, m := range collectAll(collector) {
+		switch m.Desc() {
+		case collector.firmwareUpdateAvailable:
+			values["available"] = writeMetric(t, m).GetGauge().GetValue()
+		case collector.firmwareUpdateInProgress:
+			values["in_progress"] = writeMetric(t, m).GetGauge().GetValue()
+		}
+	}
+	return values
+}
+
+func TestCollector_FirmwareUpdate(t *testing.T) {
+	configurations := `{"UpdateAvailable": "1", "UpdateInProgress": false}`
+	online := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !online {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v2/latestdata":
+			_ = json.NewEncoder(w).Encode(LatestData{})
+		case "/api/v2/status":
+			_ = json.NewEncoder(w).Encode(Status{})
+		case "/api/v2/configurations":
+			_, _ = w.Write([]byte(configurations))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{FirmwareGracePeriod: 10 * time.Minute, ConfigurationsMaxAge: time.Minute},
+	)
+	now := time.Date(2025, 11, 29, 12, 0, 0, 0, time.UTC)
+	collector.now = func() time.Time { return now }
+
+	// Flags present
+	values := firmwareMetrics(t, collector)
+	if values["available"] != 1 || values["in_progress"] != 0 {
+		t.Fatalf("firmware flags = %v, want available=1 in_progress=0", values)
+	}
+
+	// Update starts and the API goes away; cached flags survive the grace period
+	configurations = `{"UpdateAvailable": true, "UpdateInProgress": true}`
+	now = now.Add(2 * time.Minute)
+	firmwareMetrics(t, collector)
+	online = false
+	now = now.Add(5 * time.Minute)
+	values = firmwareMetrics(t, collector)
+	if values["available"] != 1 || values["in_progress"] != 1 {
+		t.Errorf("firmware flags within grace period = %v, want available=1 in_progress=1", values)
+	}
+
+	// After the grace period the cached flags are dropped
+	now = now.Add(10 * time.Minute)
+	values = firmwareMetrics(t, collector)
+	if len(values) != 0 {
+		t.Errorf("firmware flags after grace period = %v, want none", values)
+	}
+
+	// Battery returns with firmware that does not report the flags
+	online = true
+	configurations = `{}`
+	values = firmwareMetrics(t, collector)
+	if len(values) != 0 {
+		t.Errorf("firmware flags when absent = %v, want none", values)
+	}
+}
+
+func TestFlexFloat(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{in: `2500`, want: 2500},
+		{in: `"2500"`, want: 2500},
+		{in: `" 4 "`, want: 4},
+		{in: `""`, want: 0},
+		{in: `"many"`, wantErr: true},
+		{in: `true`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var got flexFloat
+			err := json.Unmarshal([]byte(tt.in), &got)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Unmarshal(%s) expected error but got none", tt.in)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal(%s) unexpected error: %v", tt.in, err)
+			}
+			if float64(got) != tt.want {
+				t.Errorf("Unmarshal(%s) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlexBool(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    bool
+		wantErr bool
+	}{
+		{in: `true`, want: true},
+		{in: `false`, want: false},
+		{in: `1`, want: true},
+		{in: `0`, want: false},
+		{in: `"1"`, want: true},
+		{in: `"false"`, want: false},
+		{in: `""`, want: false},
+		{in: `"maybe"`, wantErr: true},
+		{in: `[]`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var got flexBool
+			err := json.Unmarshal([]byte(tt.in), &got)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Unmarshal(%s) expected error but got none", tt.in)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal(%s) unexpected error: %v", tt.in, err)
+			}
+			if bool(got) != tt.want {
+				t.Errorf("Unmarshal(%s) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigurations_BlankStrings(t *testing.T) {
+	data := `{"UpdateAvailable": "", "UpdateInProgress": "true", "EM_OperatingMode": "", "EM_USOC": " ",
+		"PV_PeakPower_w": "", "TimeZone": "", "EM_ToU_Schedule": [{"start": "06:00", "stop": "10:00", "price_import": ""}]}`
+
+	var got Configurations
+	if err := json.Unmarshal([]byte(data), &got); err != nil {
+		t.Fatalf("Unmarshal() unexpected error: %v", err)
+	}
+	if got.UpdateAvailable != nil || got.OperatingMode != nil || got.BackupReservePct != nil || got.PeakPowerW != nil || got.TimeZone != nil {
+		t.Errorf("blank values decoded as set: %+v", got)
+	}
+	if got.UpdateInProgress == nil || !bool(*got.UpdateInProgress) {
+		t.Errorf("UpdateInProgress = %v, want true", got.UpdateInProgress)
+	}
+	if len(got.TOUSchedule) != 1 || got.TOUSchedule[0].PriceImport != nil {
+		t.Errorf("TOUSchedule = %+v, want one slot without import price", got.TOUSchedule)
+	}
+}
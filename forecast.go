@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// forecastTimeout bounds a forecast request during a scrape
+const forecastTimeout = 5 * time.Second
+
+// ForecastProvider returns the expected solar production of a battery's
+// installation at a given time
+type ForecastProvider interface {
+	GetForecastWatts(ctx context.Context, battery Battery, t time.Time) (float64, error)
+}
+
+// httpForecastProvider reads forecasts from an HTTP endpoint. It requests
+// the configured URL with the battery name and RFC 3339 time as the battery
+// and time query parameters and expects a JSON object {"watts": <number>}.
+type httpForecastProvider struct {
+	url    string
+	client *http.Client
+}
+
+// newHTTPForecastProvider creates a provider for the given forecast URL
+func newHTTPForecastProvider(forecastURL string) *httpForecastProvider {
+	return &httpForecastProvider{url: forecastURL, client: &http.Client{}}
+}
+
+// GetForecastWatts implements ForecastProvider
+func (p *httpForecastProvider) GetForecastWatts(ctx context.Context, battery Battery, t time.Time) (float64, error) {
+	u, err := url.Parse(p.url)
+	if err != nil {
+		return 0, fmt.Errorf("invalid forecast URL: %w", err)
+	}
+	query := u.Query()
+	query.Set("battery", battery.Name)
+	query.Set("time", t.UTC().Format(time.RFC3339))
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create forecast request: %w", err)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch forecast: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status code %d from forecast", resp.StatusCode)
+	}
+	var forecast struct {
+		Watts *float64 `json:"watts"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&forecast); err != nil {
+		return 0, fmt.Errorf("failed to decode forecast: %w", err)
+	}
+	if forecast.Watts == nil {
+		return 0, fmt.Errorf("forecast without watts")
+	}
+	return *forecast.Watts, nil
+}
+
+// collectForecast emits the forecast production minus the actual one, so a
+// positive error means the forecast was too optimistic. Nothing is emitted
+// without a ForecastProvider or if the forecast cannot be read.
+func (c *Collector) collectForecast(ctx context.Context, battery Battery, status *Status, ch chan<- prometheus.Metric) {
+	if c.options.Forecast == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, forecastTimeout)
+	defer cancel()
+	c.forecastRequests.WithLabelValues(battery.Name).Inc()
+	forecast, err := c.options.Forecast.GetForecastWatts(ctx, battery, c.now())
+	if err != nil {
+		log.Printf("Error fetching production forecast for %s: %v", battery.Name, err)
+		c.forecastErrors.WithLabelValues(battery.Name).Inc()
+		return
+	}
+	c.gauge(ch, c.forecastError, forecast-status.ProductionW, battery.Name)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHTTPForecastProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    float64
+		wantErr bool
+	}{
+		{name: "forecast", status: http.StatusOK, body: `{"watts": 2500}`, want: 2500},
+		{name: "zero at night", status: http.StatusOK, body: `{"watts": 0}`, want: 0},
+		{name: "missing watts", status: http.StatusOK, body: `{}`, wantErr: true},
+		{name: "malformed", status: http.StatusOK, body: `{"watts": `, wantErr: true},
+		{name: "server error", status: http.StatusBadGateway, body: `{"watts": 2500}`, wantErr: true},
+	}
+
+	at := time.Date(2025, 6, 21, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var query map[string][]string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				query = r.URL.Query()
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			provider := newHTTPForecastProvider(server.URL + "/forecast?site=home")
+			got, err := provider.GetForecastWatts(context.Background(), Battery{Name: "test-battery"}, at)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("GetForecastWatts() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetForecastWatts() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("GetForecastWatts() = %v, want %v", got, tt.want)
+			}
+			// Query parameters of the configured URL are kept
+			for key, want := range map[string]string{"site": "home", "battery": "test-battery", "time": "2025-06-21T12:00:00Z"} {
+				if got := query[key]; len(got) != 1 || got[0] != want {
+					t.Errorf("query %s = %v, want %s", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCollector_ForecastError(t *testing.T) {
+	battery := newMockBatteryServer(&LatestData{}, &Status{ProductionW: 2000})
+	defer battery.Close()
+
+	forecastBody := `{"watts": 2500}`
+	forecast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(forecastBody))
+	}))
+	defer forecast.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: battery.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{Forecast: newHTTPForecastProvider(forecast.URL)},
+	)
+
+	steps := []struct {
+		body         string
+		wantError    float64 // -1 if the metric must be omitted
+		wantRequests float64
+		wantErrors   float64
+	}{
+		{body: `{"watts": 2500}`, wantError: 500, wantRequests: 1, wantErrors: 0},
+		{body: `{"watts": 1200}`, wantError: -800, wantRequests: 2, wantErrors: 0},
+		{body: `not json`, wantError: -1, wantRequests: 3, wantErrors: 1},
+	}
+	for i, step := range steps {
+		forecastBody = step.body
+
+		forecastError := -1.0
+		for _, m := range collectAll(collector) {
+			if m.Desc() == collector.forecastError {
+				forecastError = writeMetric(t, m).GetGauge().GetValue()
+			}
+		}
+		if forecastError != step.wantError {
+			t.Errorf("step %d: forecast error = %v, want %v", i, forecastError, step.wantError)
+		}
+		if got := testutil.ToFloat64(collector.forecastRequests.WithLabelValues("test-battery")); got != step.wantRequests {
+			t.Errorf("step %d: forecast requests = %v, want %v", i, got, step.wantRequests)
+		}
+		if got := testutil.ToFloat64(collector.forecastErrors.WithLabelValues("test-battery")); got != step.wantErrors {
+			t.Errorf("step %d: forecast errors = %v, want %v", i, got, step.wantErrors)
+		}
+	}
+}
+
+// blockingForecast waits for its context to end, signalling started first
+type blockingForecast struct{ started chan struct{} }
+
+func (f blockingForecast) GetForecastWatts(ctx context.Context, _ Battery, _ time.Time) (float64, error) {
+	close(f.started)
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func TestCollector_ForecastCancelledWithScrape(t *testing.T) {
+	battery := newMockBatteryServer(&LatestData{}, &Status{ProductionW: 2000})
+	defer battery.Close()
+
+	forecast := blockingForecast{started: make(chan struct{})}
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: battery.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{Forecast: forecast},
+	)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		collectAll(collector)
+	}()
+	<-forecast.started
+	collector.CancelScrapes()
+	select {
+	case <-done:
+	case <-time.After(forecastTimeout / 2):
+		t.Fatal("forecast request outlived the cancelled scrape")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+// batteryReading holds the API responses of a successfully scraped battery
+type batteryReading struct {
+	latestData *LatestData
+	status     *Status
+	dropped    droppedReadings // Readings that failed the sanity checks
+}
+
+// groupTotals holds aggregated values for a parallel battery group
+type groupTotals struct {
+	capacityWh  float64
+	powerW      float64
+	chargeLevel float64
+}
+
+// groupBatteries maps each configured group to its batteries, skipping
+// batteries without a group
+func groupBatteries(batteries []Battery) map[string][]Battery {
+	groups := make(map[string][]Battery)
+	for _, b := range batteries {
+		if b.Group == "" {
+			continue
+		}
+		groups[b.Group] = append(groups[b.Group], b)
+	}
+	return groups
+}
+
+// parallelGroups returns only the groups that combine at least two batteries
+func parallelGroups(batteries []Battery) map[string][]Battery {
+	groups := groupBatteries(batteries)
+	for name, members := range groups {
+		if len(members) < 2 {
+			delete(groups, name)
+		}
+	}
+	return groups
+}
+
+// aggregateGroup sums capacity and power across a group. The charge level is
+// weighted by capacity, falling back to a plain average if no capacity is known.
+func aggregateGroup(readings []*batteryReading) groupTotals {
+	var totals groupTotals
+	var weightedCharge, plainCharge float64
+	for _, r := range readings {
+		capacity := float64(r.latestData.FullChargeCapacity)
+		totals.capacityWh += capacity
+		totals.powerW += r.status.PacTotalW
+		weightedCharge += float64(r.latestData.RSOC) * capacity
+		plainCharge += float64(r.latestData.RSOC)
+	}
+
+	switch {
+	case totals.capacityWh > 0:
+		totals.chargeLevel = weightedCharge / totals.capacityWh
+	case len(readings) > 0:
+		totals.chargeLevel = plainCharge / float64(len(readings))
+	}
+	return totals
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGroupBatteries(t *testing.T) {
+	batteries := []Battery{
+		{Name: "unit1", Group: "plant"},
+		{Name: "unit2", Group: "plant"},
+		{Name: "garage", Group: "garage"},
+		{Name: "standalone"},
+	}
+
+	groups := groupBatteries(batteries)
+	if len(groups) != 2 {
+		t.Fatalf("groupBatteries() returned %d groups, want 2", len(groups))
+	}
+	if len(groups["plant"]) != 2 {
+		t.Errorf("group plant has %d batteries, want 2", len(groups["plant"]))
+	}
+	if len(groups["garage"]) != 1 {
+		t.Errorf("group garage has %d batteries, want 1", len(groups["garage"]))
+	}
+
+	parallel := parallelGroups(batteries)
+	if _, ok := parallel["garage"]; ok {
+		t.Error("parallelGroups() kept single-battery group garage")
+	}
+	if len(parallel["plant"]) != 2 {
+		t.Errorf("parallelGroups() group plant has %d batteries, want 2", len(parallel["plant"]))
+	}
+}
+
+func TestAggregateGroup(t *testing.T) {
+	readings := []*batteryReading{
+		{
+			latestData: &LatestData{FullChargeCapacity: 10000, RSOC: 80},
+			status:     &Status{PacTotalW: 1500},
+		},
+		{
+			latestData: &LatestData{FullChargeCapacity: 5000, RSOC: 50},
+			status:     &Status{PacTotalW: -500},
+		},
+	}
+
+	totals := aggregateGroup(readings)
+	if totals.capacityWh != 15000 {
+		t.Errorf("capacityWh = %f, want 15000", totals.capacityWh)
+	}
+	if totals.powerW != 1000 {
+		t.Errorf("powerW = %f, want 1000", totals.powerW)
+	}
+	if totals.chargeLevel != 70 {
+		t.Errorf("chargeLevel = %f, want 70", totals.chargeLevel)
+	}
+}
+
+func TestCollector_Collect_Groups(t *testing.T) {
+	server := newMockBatteryServer(
+		&LatestData{FullChargeCapacity: 5000, RSOC: 60},
+		&Status{PacTotalW: 200},
+	)
+	defer server.Close()
+
+	batteries := []Battery{
+		{Name: "unit1", Address: server.URL[7:], AuthToken: "token1", Group: "plant"},
+		{Name: "unit2", Address: server.URL[7:], AuthToken: "token2", Group: "plant"},
+		{Name: "garage", Address: server.URL[7:], AuthToken: "token3", Group: "garage"},
+	}
+
+	groupMetrics := map[string]int{}
+	for _, m := range collectAll(NewCollector(batteries, CollectorOptions{})) {
+		if !strings.Contains(m.Desc().String(), "sonnenbatterie_parallel_system_") {
+			continue
+		}
+		pb := writeMetric(t, m)
+		group := labelValue(pb, "group")
+		groupMetrics[group]++
+
+		if strings.Contains(m.Desc().String(), "sonnenbatterie_parallel_system_capacity_wh") {
+			if got := pb.GetGauge().GetValue(); got != 10000 {
+				t.Errorf("capacity for group %s = %f, want 10000", group, got)
+			}
+		}
+	}
+
+	if groupMetrics["plant"] != 3 {
+		t.Errorf("group plant emitted %d metrics, want 3", groupMetrics["plant"])
+	}
+	if groupMetrics["garage"] != 0 {
+		t.Errorf("single-battery group garage emitted %d metrics, want 0", groupMetrics["garage"])
+	}
+}
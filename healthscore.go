@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Weights of the health score components, summing to 100
+const (
+	healthWeightSOC         = 30.0
+	healthWeightSoH         = 40.0
+	healthWeightTemperature = 20.0
+	healthWeightFault       = 10.0
+)
+
+// Healthy module temperature range in degrees Celsius; the temperature
+// component drops to 0 at healthTemperatureMargin outside of it
+const (
+	healthTemperatureMin    = 15.0
+	healthTemperatureMax    = 35.0
+	healthTemperatureMargin = 10.0
+)
+
+// healthNeutral is the component value used for unavailable inputs
+const healthNeutral = 0.5
+
+// computeHealthScore combines charge level and state of health in percent,
+// module temperature and fault state into a score from 0 to 100. The charge
+// level scores best at 50%, away from the extremes that stress the cells.
+// NaN marks an unavailable input, which scores healthNeutral.
+func computeHealthScore(soc, soh, tempCelsius float64, faultActive bool) float64 {
+	socComponent := healthNeutral
+	if !math.IsNaN(soc) {
+		socComponent = 1 - math.Abs(soc-50)/50
+	}
+	sohComponent := healthNeutral
+	if !math.IsNaN(soh) {
+		sohComponent = soh / 100
+	}
+	temperatureComponent := healthNeutral
+	if !math.IsNaN(tempCelsius) {
+		outside := max(healthTemperatureMin-tempCelsius, tempCelsius-healthTemperatureMax, 0)
+		temperatureComponent = 1 - outside/healthTemperatureMargin
+	}
+	faultComponent := 1.0
+	if faultActive {
+		faultComponent = 0
+	}
+
+	score := healthWeightSOC*clamp01(socComponent) +
+		healthWeightSoH*clamp01(sohComponent) +
+		healthWeightTemperature*clamp01(temperatureComponent) +
+		healthWeightFault*faultComponent
+	return math.Min(math.Max(score, 0), 100)
+}
+
+// clamp01 limits a component value to [0, 1]
+func clamp01(value float64) float64 {
+	return math.Min(math.Max(value, 0), 1)
+}
+
+// stateOfHealth returns the full charge capacity in percent of the design
+// capacity, or NaN if either is unknown
+func stateOfHealth(fullChargeWh, designWh float64, designKnown bool) float64 {
+	if !designKnown || designWh <= 0 || fullChargeWh <= 0 {
+		return math.NaN()
+	}
+	return fullChargeWh / designWh * 100
+}
+
+// worstModuleTemperature returns the module temperature farthest from the
+// healthy range, or NaN if no modules are reported
+func worstModuleTemperature(batteryData *BatteryData) float64 {
+	if batteryData == nil || len(batteryData.Modules) == 0 {
+		return math.NaN()
+	}
+	center := (healthTemperatureMin + healthTemperatureMax) / 2
+	worst := batteryData.Modules[0].Temperature
+	for _, module := range batteryData.Modules[1:] {
+		if math.Abs(module.Temperature-center) > math.Abs(worst-center) {
+			worst = module.Temperature
+		}
+	}
+	return worst
+}
+
+// collectHealthScore emits the health score and how many of its components
+// were available. The charge level and fault state are always known; state
+// of health needs the design capacity and temperature the battery endpoint.
+func (c *Collector) collectHealthScore(battery Battery, latestData *LatestData, configurations *Configurations, batteryData *BatteryData, ch chan<- prometheus.Metric) {
+	designWh, designKnown := designCapacity(configurations, battery.DesignCapacityWh)
+	soh := stateOfHealth(float64(latestData.FullChargeCapacity), designWh, designKnown)
+	temperature := worstModuleTemperature(batteryData)
+	fault := coreControlStateBucket(latestData.ICStatus.StateCoreControlModule) == "critical error"
+
+	components := 2
+	for _, input := range []float64{soh, temperature} {
+		if !math.IsNaN(input) {
+			components++
+		}
+	}
+
+	c.gauge(ch, c.healthScore, computeHealthScore(float64(latestData.RSOC), soh, temperature, fault), battery.Name)
+	c.gauge(ch, c.healthComponents, float64(components), battery.Name)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestComputeHealthScore(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name  string
+		soc   float64
+		soh   float64
+		temp  float64
+		fault bool
+		want  float64
+	}{
+		{name: "ideal", soc: 50, soh: 100, temp: 25, want: 100},
+		{name: "full", soc: 100, soh: 100, temp: 25, want: 70},
+		{name: "empty", soc: 0, soh: 100, temp: 25, want: 70},
+		{name: "quarter charge", soc: 25, soh: 100, temp: 25, want: 85},
+		{name: "aged", soc: 50, soh: 80, temp: 25, want: 92},
+		{name: "edge of temperature range", soc: 50, soh: 100, temp: 15, want: 100},
+		{name: "warm", soc: 50, soh: 100, temp: 40, want: 90},
+		{name: "frozen", soc: 50, soh: 100, temp: 0, want: 80},
+		{name: "fault", soc: 50, soh: 100, temp: 25, fault: true, want: 90},
+		{name: "soh above 100 clamped", soc: 50, soh: 120, temp: 25, want: 100},
+		{name: "unavailable soh and temperature", soc: 50, soh: nan, temp: nan, want: 70},
+		{name: "all unavailable", soc: nan, soh: nan, temp: nan, want: 55},
+		{name: "worst", soc: 0, soh: 0, temp: 60, fault: true, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := computeHealthScore(tt.soc, tt.soh, tt.temp, tt.fault)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("computeHealthScore(%v, %v, %v, %v) = %v, want %v", tt.soc, tt.soh, tt.temp, tt.fault, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorstModuleTemperature(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    *BatteryData
+		want    float64
+		wantNaN bool
+	}{
+		{name: "no data", data: nil, wantNaN: true},
+		{name: "no modules", data: &BatteryData{}, wantNaN: true},
+		{name: "hottest", data: &BatteryData{Modules: []BatteryModule{{Temperature: 24}, {Temperature: 41}, {Temperature: 20}}}, want: 41},
+		{name: "coldest", data: &BatteryData{Modules: []BatteryModule{{Temperature: 30}, {Temperature: 2}}}, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := worstModuleTemperature(tt.data)
+			if tt.wantNaN {
+				if !math.IsNaN(got) {
+					t.Errorf("worstModuleTemperature() = %v, want NaN", got)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("worstModuleTemperature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollector_HealthScore(t *testing.T) {
+	tests := []struct {
+		name           string
+		designWh       float64
+		wantScore      float64
+		wantComponents float64
+	}{
+		// Full charge capacity of 9000 Wh out of 10000 Wh is a 90% state of health
+		{name: "design capacity known", designWh: 10000, wantScore: 30 + 36 + 10 + 10, wantComponents: 3},
+		{name: "design capacity unknown", designWh: 0, wantScore: 30 + 20 + 10 + 10, wantComponents: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMockBatteryServer(&LatestData{RSOC: 50, FullChargeCapacity: 9000}, &Status{})
+			defer server.Close()
+
+			collector := NewCollector(
+				[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token", DesignCapacityWh: tt.designWh}},
+				CollectorOptions{},
+			)
+
+			score, components := -1.0, -1.0
+			for _, m := range collectAll(collector) {
+				switch m.Desc() {
+				case collector.healthScore:
+					score = writeMetric(t, m).GetGauge().GetValue()
+				case collector.healthComponents:
+					components = writeMetric(t, m).GetGauge().GetValue()
+				}
+			}
+			// The mock does not serve the battery endpoint, so the temperature is neutral
+			if math.Abs(score-tt.wantScore) > 1e-9 {
+				t.Errorf("health score = %v, want %v", score, tt.wantScore)
+			}
+			if components != tt.wantComponents {
+				t.Errorf("components available = %v, want %v", components, tt.wantComponents)
+			}
+		})
+	}
+}
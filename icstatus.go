@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// icFlag is a single boolean found in the nested ic_status objects
+type icFlag struct {
+	Group string
+	Flag  string
+	Value bool
+}
+
+// UnmarshalJSON decodes the known ic_status fields and keeps the full
+// document, since the nested flag objects vary between firmware versions
+func (s *ICStatus) UnmarshalJSON(data []byte) error {
+	type plain ICStatus
+	if err := json.Unmarshal(data, (*plain)(s)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &s.Raw)
+}
+
+// icFlags walks the nested objects of a raw ic_status document and returns
+// every boolean member, sorted by group and flag. The group is the top-level
+// key and deeper keys are joined into the flag name, all in snake_case.
+func icFlags(raw map[string]any) []icFlag {
+	var flags []icFlag
+	for key, value := range raw {
+		nested, ok := value.(map[string]any)
+		if !ok {
+			continue
+		}
+		flags = appendICFlags(flags, toSnakeCase(key), "", nested)
+	}
+
+	sort.Slice(flags, func(i, j int) bool {
+		if flags[i].Group != flags[j].Group {
+			return flags[i].Group < flags[j].Group
+		}
+		return flags[i].Flag < flags[j].Flag
+	})
+	return flags
+}
+
+func appendICFlags(flags []icFlag, group, prefix string, object map[string]any) []icFlag {
+	for key, value := range object {
+		name := toSnakeCase(key)
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+		switch v := value.(type) {
+		case bool:
+			flags = append(flags, icFlag{Group: group, Flag: name, Value: v})
+		case map[string]any:
+			flags = appendICFlags(flags, group, name, v)
+		}
+	}
+	return flags
+}
+
+// toSnakeCase normalises API keys such as "Critical BMS Alarm", "HW_Shutdown"
+// or "DischargeNotAllowed" into lower-case words separated by underscores
+func toSnakeCase(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			// Split camelCase at a lower-to-upper boundary
+			if i > 0 && unicode.IsUpper(r) && unicode.IsLower(runes[i-1]) {
+				b.WriteRune('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "_"):
+			b.WriteRune('_')
+		}
+	}
+	return strings.TrimSuffix(b.String(), "_")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestToSnakeCase(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "Critical BMS Alarm", want: "critical_bms_alarm"},
+		{in: "DC Shutdown Reason", want: "dc_shutdown_reason"},
+		{in: "HW_Shutdown", want: "hw_shutdown"},
+		{in: "Country Code Set status flag 1", want: "country_code_set_status_flag_1"},
+		{in: "DischargeNotAllowed", want: "discharge_not_allowed"},
+		{in: " Voltage Not OK ", want: "voltage_not_ok"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := toSnakeCase(tt.in); got != tt.want {
+				t.Errorf("toSnakeCase(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestICFlags_Fixture(t *testing.T) {
+	raw, err := os.ReadFile("testdata/latestdata.json")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+
+	var data LatestData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		t.Fatalf("failed to decode fixture: %v", err)
+	}
+
+	// Known fields still decode alongside the raw document
+	if data.ICStatus.StateBMS != "ready" || data.ICStatus.NrBatteryModules != 4 {
+		t.Errorf("ICStatus = %+v, want statebms ready and 4 modules", data.ICStatus)
+	}
+	if data.ConsumptionAvg == nil || *data.ConsumptionAvg != 495 {
+		t.Errorf("ConsumptionAvg = %v, want 495", data.ConsumptionAvg)
+	}
+
+	flags := icFlags(data.ICStatus.Raw)
+
+	// 14 + 5 + 4 + 13 + 6 + 7 booleans across the six nested objects
+	if len(flags) != 49 {
+		t.Errorf("icFlags() returned %d flags, want 49", len(flags))
+	}
+
+	byName := map[string]bool{}
+	for _, f := range flags {
+		byName[f.Group+"/"+f.Flag] = f.Value
+	}
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{key: "dc_shutdown_reason/critical_bms_alarm", want: false},
+		{key: "dc_shutdown_reason/hw_shutdown", want: false},
+		{key: "eclipse_led/pulsing_white", want: true},
+		{key: "setpoint_priority/energy_manager", want: true},
+		{key: "microgrid_status/transition_to_ongrid_pending", want: false},
+	}
+	for _, tt := range tests {
+		got, ok := byName[tt.key]
+		if !ok {
+			t.Errorf("flag %s missing", tt.key)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("flag %s = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+
+	// Non-boolean members such as brightness are not flags
+	if _, ok := byName["eclipse_led/brightness"]; ok {
+		t.Error("non-boolean eclipse_led/brightness reported as flag")
+	}
+}
+
+func TestICFlags_Nested(t *testing.T) {
+	raw := map[string]any{
+		"statebms": "ready",
+		"Outer Group": map[string]any{
+			"Inner Object": map[string]any{"Deep Flag": true},
+			"Plain Flag":   false,
+		},
+	}
+
+	flags := icFlags(raw)
+	want := []icFlag{
+		{Group: "outer_group", Flag: "inner_object_deep_flag", Value: true},
+		{Group: "outer_group", Flag: "plain_flag", Value: false},
+	}
+	if len(flags) != len(want) {
+		t.Fatalf("icFlags() returned %d flags, want %d", len(flags), len(want))
+	}
+	for i := range want {
+		if flags[i] != want[i] {
+			t.Errorf("icFlags()[%d] = %+v, want %+v", i, flags[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,221 @@
+//go:build integration
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+// newIntegrationBatteryServer serves realistic latestdata, status and
+// configurations responses for the given Auth-Token
+func newIntegrationBatteryServer(t *testing.T, token string) *httptest.Server {
+	t.Helper()
+
+	latestData, err := os.ReadFile("testdata/latestdata.json")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	configurations, err := os.ReadFile("testdata/configurations.json")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	status := Status{
+		BatteryCharging: true,
+		ConsumptionW:    497,
+		GridFeedInW:     -120,
+		PacTotalW:       -1500,
+		ProductionW:     2100,
+		SystemStatus:    "OnGrid",
+		Uac:             231.2,
+		Ubat:            53.4,
+		Fac:             50.01,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Auth-Token") != token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v2/latestdata":
+			_, _ = w.Write(latestData)
+		case "/api/v2/status":
+			_ = json.NewEncoder(w).Encode(status)
+		case "/api/v2/configurations":
+			_, _ = w.Write(configurations)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// startExporter builds the exporter, runs it with the given environment and
+// returns the base URL once it reports healthy
+func startExporter(t *testing.T, env ...string) string {
+	t.Helper()
+
+	binary := filepath.Join(t.TempDir(), "sonnenbatterie-exporter")
+	if out, err := exec.Command("go", "build", "-o", binary, ".").CombinedOutput(); err != nil {
+		t.Fatalf("go build failed: %v\n%s", err, out)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	cmd := exec.Command(binary)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Env = append(cmd.Env, "EXPORTER_PORT="+strconv.Itoa(port))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start exporter: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	baseURL := fmt.Sprintf("http://127.0.0.1:%d", port)
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(baseURL + "/health")
+		if err == nil {
+			_ = resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return baseURL
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("exporter did not become healthy")
+	return ""
+}
+
+// scrapeExporter fetches /metrics and parses the Prometheus text format
+func scrapeExporter(t *testing.T, baseURL string) map[string]*dto.MetricFamily {
+	t.Helper()
+
+	resp, err := http.Get(baseURL + "/metrics")
+	if err != nil {
+		t.Fatalf("scrape failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to parse metrics: %v", err)
+	}
+	return families
+}
+
+// metricValue returns the value of the series with the given battery_name
+func metricValue(t *testing.T, families map[string]*dto.MetricFamily, name, battery string) float64 {
+	t.Helper()
+
+	family, ok := families[name]
+	if !ok {
+		t.Fatalf("metric %s missing", name)
+	}
+	for _, m := range family.GetMetric() {
+		if labelValue(m, "battery_name") != battery {
+			continue
+		}
+		switch {
+		case m.GetGauge() != nil:
+			return m.GetGauge().GetValue()
+		case m.GetCounter() != nil:
+			return m.GetCounter().GetValue()
+		}
+	}
+	t.Fatalf("metric %s has no series for battery %s", name, battery)
+	return 0
+}
+
+func TestIntegration_FullScrapeFlow(t *testing.T) {
+	battery := newIntegrationBatteryServer(t, "integration-token")
+	baseURL := startExporter(t,
+		"SONNENBATTERIE_ADDRESSES="+battery.URL[7:],
+		"SONNENBATTERIE_TOKENS=integration-token",
+		"SONNENBATTERIE_NAMES=house",
+	)
+
+	families := scrapeExporter(t, baseURL)
+
+	tests := []struct {
+		name string
+		want float64
+	}{
+		{name: "sonnenbatterie_scrape_success", want: 1},
+		{name: "sonnenbatterie_battery_online", want: 1},
+		{name: "sonnenbatterie_charge_level_percent", want: 60},
+		{name: "sonnenbatterie_consumption_watts", want: 497},
+		{name: "sonnenbatterie_consumption_avg_watts", want: 495},
+		{name: "sonnenbatterie_production_watts", want: 2100},
+		{name: "sonnenbatterie_design_capacity_wh", want: 10000},
+		{name: "sonnenbatterie_firmware_update_available", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := metricValue(t, families, tt.name, "house"); got != tt.want {
+				t.Errorf("%s = %f, want %f", tt.name, got, tt.want)
+			}
+		})
+	}
+
+	info := families["sonnenbatterie_info"]
+	if info == nil || len(info.GetMetric()) != 1 {
+		t.Fatalf("sonnenbatterie_info = %v, want one series", info)
+	}
+	if serial := labelValue(info.GetMetric()[0], "serial"); serial != "123456" {
+		t.Errorf("serial label = %q, want 123456", serial)
+	}
+}
+
+func TestIntegration_MultipleConsecutiveScrapes(t *testing.T) {
+	battery := newIntegrationBatteryServer(t, "integration-token")
+	baseURL := startExporter(t,
+		"SONNENBATTERIE_ADDRESSES="+battery.URL[7:],
+		"SONNENBATTERIE_TOKENS=integration-token",
+		"SONNENBATTERIE_NAMES=house",
+	)
+
+	// The first scrape only establishes the baseline for accumulated counters
+	scrapeExporter(t, baseURL)
+
+	previous := 0.0
+	for i := 0; i < 3; i++ {
+		time.Sleep(200 * time.Millisecond)
+		families := scrapeExporter(t, baseURL)
+
+		if got := metricValue(t, families, "sonnenbatterie_scrape_success", "house"); got != 1 {
+			t.Fatalf("scrape %d: scrape_success = %f, want 1", i, got)
+		}
+		avoided := metricValue(t, families, "sonnenbatterie_grid_co2_avoided_grams_total", "house")
+		if avoided <= previous {
+			t.Errorf("scrape %d: co2 avoided = %f, want more than %f", i, avoided, previous)
+		}
+		previous = avoided
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	energyInterval  = 15 * time.Minute // Metering period used by utility billing
+	energyIntervals = 4                // Completed periods kept for the peak
+	energyTick      = 10 * time.Second // How often the latest power readings are integrated
+	energyMaxAge    = 5 * time.Minute  // How long a power reading is held before it leaves a gap
+)
+
+// intervalEnergy integrates battery power over clock-aligned 15-minute
+// periods and keeps the energy of the last completed ones. Scrapes only record
+// the latest power reading; the accumulator is advanced by ticks independent
+// of the scrape cycle, holding each reading until the next one.
+type intervalEnergy struct {
+	powerW  float64   // Latest battery power reading
+	reading time.Time // Time of the latest reading, zero before the first
+
+	start   time.Time     // Start of the period being accumulated, zero before the first tick
+	cursor  time.Time     // Time up to which the period is integrated
+	wh      float64       // Energy integrated in the current period
+	covered time.Duration // Part of the current period covered by a held reading
+
+	totals   [energyIntervals]float64 // Ring buffer of completed period energy in Wh
+	coverage [energyIntervals]float64 // Share of each completed period covered by readings
+	next     int                      // Slot the next completed period is written to
+	count    int                      // Completed periods in totals
+}
+
+// record integrates the previous reading up to now and replaces it with powerW
+func (e *intervalEnergy) record(now time.Time, powerW float64) {
+	e.tick(now)
+	e.powerW, e.reading = powerW, now
+}
+
+// tick integrates the held reading up to now and completes every period that
+// ended since the previous tick. Time more than energyMaxAge after the latest
+// reading is a gap that adds no energy and lowers its period's coverage, so
+// periods without readings are completed with zero energy and coverage
+// instead of being skipped.
+func (e *intervalEnergy) tick(now time.Time) {
+	if e.start.IsZero() {
+		e.start, e.cursor = now.Truncate(energyInterval), now
+		return
+	}
+	if !now.After(e.cursor) {
+		return
+	}
+
+	// Periods older than the kept ones would be overwritten anyway
+	if oldest := now.Truncate(energyInterval).Add(-energyIntervals * energyInterval); e.start.Before(oldest) {
+		e.start, e.cursor, e.wh, e.covered = oldest, oldest, 0, 0
+	}
+	for {
+		end := e.start.Add(energyInterval)
+		if now.Before(end) {
+			e.integrate(now)
+			return
+		}
+		e.integrate(end)
+		e.totals[e.next] = e.wh
+		e.coverage[e.next] = float64(e.covered) / float64(energyInterval)
+		e.next = (e.next + 1) % energyIntervals
+		e.count = min(e.count+1, energyIntervals)
+		e.start, e.wh, e.covered = end, 0, 0
+	}
+}
+
+// integrate adds the held reading from the cursor up to until, but no longer
+// than energyMaxAge past the reading, and moves the cursor to until
+func (e *intervalEnergy) integrate(until time.Time) {
+	if !e.reading.IsZero() {
+		held := min(until.Sub(e.cursor), e.reading.Add(energyMaxAge).Sub(e.cursor))
+		if held > 0 {
+			e.wh += e.powerW * held.Hours()
+			e.covered += held
+		}
+	}
+	e.cursor = until
+}
+
+// latest returns the energy and coverage of the most recently completed period
+func (e *intervalEnergy) latest() (wh, coverage float64, ok bool) {
+	if e.count == 0 {
+		return 0, 0, false
+	}
+	last := (e.next + energyIntervals - 1) % energyIntervals
+	return e.totals[last], e.coverage[last], true
+}
+
+// peak returns the highest energy among the kept periods, -Inf if there are none
+func (e *intervalEnergy) peak() float64 {
+	peak := math.Inf(-1)
+	for _, wh := range e.totals[:e.count] {
+		peak = max(peak, wh)
+	}
+	return peak
+}
+
+// collectIntervalEnergy records the current battery power for the 15-minute
+// periods and emits the completed periods, once there is one
+func (c *Collector) collectIntervalEnergy(battery Battery, status *Status, ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	energy := &c.batteryState(battery.Name).energy
+	energy.record(c.now(), status.PacTotalW)
+	latest, coverage, ok := energy.latest()
+	peak := energy.peak()
+	c.mu.Unlock()
+
+	if ok {
+		c.gauge(ch, c.intervalEnergy, latest, battery.Name)
+		c.gauge(ch, c.intervalEnergyCoverage, coverage, battery.Name)
+		c.gauge(ch, c.intervalEnergyPeak, peak, battery.Name)
+	}
+}
+
+// tickIntervalEnergy advances the 15-minute periods of every battery to now
+func (c *Collector) tickIntervalEnergy() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for _, state := range c.state {
+		state.energy.tick(now)
+	}
+}
+
+// runIntervalEnergy advances the 15-minute periods every interval, forever
+func (c *Collector) runIntervalEnergy(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.tickIntervalEnergy()
+	}
+}
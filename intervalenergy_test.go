@@ -0,0 +1,161 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestIntervalEnergy_Rotation(t *testing.T) {
+	start := time.Date(2025, 11, 29, 12, 0, 0, 0, time.UTC)
+	var energy intervalEnergy
+
+	if _, _, ok := energy.latest(); ok {
+		t.Fatal("latest() ok before the first completed period")
+	}
+
+	// Readings every 5 minutes, the last two of a period alike: 400, 800,
+	// 1200, 2000, 1600 W on average
+	powers := [][2]float64{{600, 300}, {800, 800}, {1500, 1050}, {2000, 2000}, {1600, 1600}}
+	wantLatest := []float64{100, 200, 300, 500}
+	wantPeak := []float64{100, 200, 300, 500}
+	for i, p := range powers {
+		now := start.Add(time.Duration(i) * energyInterval)
+		energy.record(now, p[0])
+		energy.record(now.Add(5*time.Minute), p[1])
+		energy.record(now.Add(10*time.Minute), p[1])
+
+		if i == 0 {
+			continue
+		}
+		latest, coverage, ok := energy.latest()
+		if !ok || math.Abs(latest-wantLatest[i-1]) > 1e-9 || coverage != 1 {
+			t.Errorf("period %d: latest() = %v, %v, %v, want %v, 1", i, latest, coverage, ok, wantLatest[i-1])
+		}
+		if got := energy.peak(); math.Abs(got-wantPeak[i-1]) > 1e-9 {
+			t.Errorf("period %d: peak() = %v, want %v", i, got, wantPeak[i-1])
+		}
+	}
+
+	// Completing the fifth period overwrites the first slot of the ring
+	energy.record(start.Add(5*energyInterval), 0)
+	if latest, _, _ := energy.latest(); math.Abs(latest-400) > 1e-9 {
+		t.Errorf("latest() after rotation = %v, want 400", latest)
+	}
+	for i, want := range []float64{400, 200, 300, 500} {
+		if math.Abs(energy.totals[i]-want) > 1e-9 {
+			t.Errorf("totals = %v, want [400 200 300 500]", energy.totals)
+			break
+		}
+	}
+
+	// The 500 Wh period drops out after four more periods
+	for i := 6; i <= 9; i++ {
+		energy.record(start.Add(time.Duration(i)*energyInterval), 0)
+	}
+	if got := energy.peak(); got != 0 {
+		t.Errorf("peak() after four idle periods = %v, want 0", got)
+	}
+}
+
+func TestIntervalEnergy_TimeWeighted(t *testing.T) {
+	start := time.Date(2025, 11, 29, 12, 0, 0, 0, time.UTC)
+	var energy intervalEnergy
+
+	// 2000 W for 1 minute and 200 W for the remaining 14: a plain average of
+	// the readings, 650 W, would give 162.5 Wh
+	energy.record(start, 2000)
+	for _, minute := range []time.Duration{1, 6, 11} {
+		energy.record(start.Add(minute*time.Minute), 200)
+	}
+	for now := start; !now.After(start.Add(energyInterval)); now = now.Add(energyTick) {
+		energy.tick(now)
+	}
+
+	wh, coverage, ok := energy.latest()
+	if want := 2000.0/60 + 200*14.0/60; !ok || math.Abs(wh-want) > 1e-9 || coverage != 1 {
+		t.Errorf("latest() = %v, %v, %v, want %v, 1", wh, coverage, ok, want)
+	}
+}
+
+func TestIntervalEnergy_Gaps(t *testing.T) {
+	start := time.Date(2025, 11, 29, 12, 0, 0, 0, time.UTC)
+	var energy intervalEnergy
+
+	// The only reading is held for energyMaxAge, the rest of the period is a gap
+	energy.record(start, 1200)
+	energy.tick(start.Add(energyInterval))
+	wh, coverage, _ := energy.latest()
+	if wh != 100 || coverage != float64(energyMaxAge)/float64(energyInterval) {
+		t.Errorf("period with a gap: latest() = %v, %v, want 100, %v", wh, coverage, float64(energyMaxAge)/float64(energyInterval))
+	}
+
+	// Periods without readings are completed empty rather than skipped
+	energy.tick(start.Add(3 * energyInterval))
+	if wh, coverage, _ := energy.latest(); wh != 0 || coverage != 0 {
+		t.Errorf("empty period: latest() = %v, %v, want 0, 0", wh, coverage)
+	}
+	if energy.count != 3 {
+		t.Errorf("completed periods = %d, want 3", energy.count)
+	}
+
+	// After a long outage only the kept periods are completed, all empty
+	energy.record(start.Add(48*time.Hour), 500)
+	if energy.totals != [energyIntervals]float64{} || energy.peak() != 0 {
+		t.Errorf("totals after an outage = %v, want all empty", energy.totals)
+	}
+}
+
+func TestCollector_IntervalEnergy(t *testing.T) {
+	status := &Status{PacTotalW: 2000}
+	server := newMockBatteryServer(&LatestData{}, status)
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+	now := time.Date(2025, 11, 29, 12, 0, 0, 0, time.UTC)
+	collector.now = func() time.Time { return now }
+
+	collect := func() map[string]float64 {
+		values := map[string]float64{}
+		for _, m := range collectAll(collector) {
+			switch m.Desc() {
+			case collector.intervalEnergy:
+				values["energy"] = writeMetric(t, m).GetGauge().GetValue()
+			case collector.intervalEnergyPeak:
+				values["peak"] = writeMetric(t, m).GetGauge().GetValue()
+			case collector.intervalEnergyCoverage:
+				values["coverage"] = writeMetric(t, m).GetGauge().GetValue()
+			}
+		}
+		return values
+	}
+
+	if got := collect(); len(got) != 0 {
+		t.Errorf("metrics during the first period = %v, want none", got)
+	}
+
+	// Scrapes every 5 minutes, with ticks integrating the held reading between them
+	scrapes := func(powerW float64) map[string]float64 {
+		var got map[string]float64
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 30; j++ {
+				now = now.Add(energyTick)
+				collector.tickIntervalEnergy()
+			}
+			if i == 2 {
+				status.PacTotalW = powerW
+			}
+			got = collect()
+		}
+		return got
+	}
+	if got := scrapes(-1000); math.Abs(got["energy"]-500) > 1e-9 || math.Abs(got["peak"]-500) > 1e-9 || got["coverage"] != 1 {
+		t.Errorf("metrics after the first period = %v, want energy and peak 500, coverage 1", got)
+	}
+	if got := scrapes(-1000); math.Abs(got["energy"]+250) > 1e-9 || math.Abs(got["peak"]-500) > 1e-9 || got["coverage"] != 1 {
+		t.Errorf("metrics after the second period = %v, want energy -250, peak 500 and coverage 1", got)
+	}
+}
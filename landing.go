@@ -0,0 +1,106 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+)
+
+// landingRefresh is how often the browser reloads the landing page
+const landingRefresh = 30 * time.Second
+
+// batteryStatus is a battery's row in the landing page status table
+type batteryStatus struct {
+	Name        string
+	Address     string    // Empty if addresses are hidden
+	LastResult  string    // "ok", "failed" or "never" before the first scrape
+	LastSuccess time.Time // Zero before the first successful scrape
+	HasReading  bool      // Whether the last scrape succeeded and the fields below are set
+	ChargeLevel int
+	PowerW      float64
+}
+
+// batteryStatuses returns the cached state of every battery in configuration
+// order. Authentication tokens are never included.
+func (c *Collector) batteryStatuses(showAddresses bool) []batteryStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	statuses := make([]batteryStatus, 0, len(c.batteries))
+	for _, b := range c.batteries {
+		state := c.batteryState(b.Name)
+		status := batteryStatus{Name: b.Name, LastResult: "never", LastSuccess: state.lastSuccess}
+		if showAddresses {
+			status.Address = b.Address
+		}
+		switch {
+		case state.outcomes.consecutiveFailures > 0:
+			status.LastResult = "failed"
+		case state.outcomes.count > 0:
+			status.LastResult = "ok"
+		}
+		if state.shownRSOC != nil && state.shownPowerW != nil {
+			status.HasReading = true
+			status.ChargeLevel, status.PowerW = *state.shownRSOC, *state.shownPowerW
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// recordShownReading keeps the plausible charge level and power of a
+// successful scrape for the landing page
+func (c *Collector) recordShownReading(name string, latestData *LatestData, status *Status, dropped droppedReadings) {
+	var rsoc *int
+	if dropped.usable("charge_level") {
+		rsoc = &latestData.RSOC
+	}
+	var powerW *float64
+	if dropped.usable("battery_power") {
+		powerW = &status.PacTotalW
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := c.batteryState(name)
+	state.shownRSOC, state.shownPowerW = rsoc, powerW
+}
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>SonnenBatterie Exporter</title>
+<meta http-equiv="refresh" content="{{.RefreshSeconds}}">
+</head>
+<body>
+<h1>SonnenBatterie Prometheus Exporter</h1>
+<p>Monitoring {{len .Batteries}} battery/batteries</p>
+<table border="1">
+<tr><th>Name</th>{{if .ShowAddresses}}<th>Address</th>{{end}}<th>Last scrape</th><th>Last success</th><th>Charge level</th><th>Power</th></tr>
+{{- range .Batteries}}
+<tr><td>{{.Name}}</td>{{if $.ShowAddresses}}<td>{{.Address}}</td>{{end}}<td>{{.LastResult}}</td><td>{{if .LastSuccess.IsZero}}-{{else}}{{.LastSuccess.UTC.Format "2006-01-02 15:04:05 UTC"}}{{end}}</td>{{if .HasReading}}<td>{{.ChargeLevel}} %</td><td>{{printf "%.0f" .PowerW}} W</td>{{else}}<td>-</td><td>-</td>{{end}}</tr>
+{{- end}}
+</table>
+<p><a href="/metrics">Metrics</a></p>
+</body>
+</html>
+`))
+
+// landingHandler serves the root page with a status table of the batteries,
+// rendered from the state of the latest scrapes. Battery addresses are only
+// listed if showAddresses is set, as the page needs no authentication by
+// default.
+func landingHandler(collector *Collector, showAddresses bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := landingTemplate.Execute(w, struct {
+			RefreshSeconds int
+			ShowAddresses  bool
+			Batteries      []batteryStatus
+		}{int(landingRefresh.Seconds()), showAddresses, collector.batteryStatuses(showAddresses)})
+		if err != nil {
+			log.Printf("Error rendering landing page: %v", err)
+		}
+	})
+}
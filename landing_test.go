@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLandingHandler(t *testing.T) {
+	collector := NewCollector([]Battery{
+		{Name: "garage", Address: "192.168.1.10", AuthToken: "secret-token"},
+		{Name: "cellar", Address: "192.168.1.11", AuthToken: "secret-token"},
+		{Name: "attic", Address: "192.168.1.12", AuthToken: "secret-token"},
+	}, CollectorOptions{})
+
+	// Fake state instead of scrapes: garage succeeded, cellar failed after
+	// an earlier success and attic was never scraped
+	collector.mu.Lock()
+	charge, garagePower, cellarPower := 87, -1250.0, 400.0
+	garage := collector.batteryState("garage")
+	garage.outcomes.add(false)
+	garage.lastSuccess = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	garage.shownRSOC = &charge
+	garage.shownPowerW = &garagePower
+	cellar := collector.batteryState("cellar")
+	cellar.outcomes.add(true)
+	cellar.lastSuccess = time.Date(2025, 6, 1, 11, 30, 0, 0, time.UTC)
+	cellar.shownPowerW = &cellarPower
+	collector.mu.Unlock()
+
+	tests := []struct {
+		name          string
+		showAddresses bool
+		want          []string
+		notWant       []string
+	}{
+		{
+			name:          "addresses shown",
+			showAddresses: true,
+			want: []string{
+				`<meta http-equiv="refresh" content="30">`,
+				"<td>garage</td><td>192.168.1.10</td><td>ok</td><td>2025-06-01 12:00:00 UTC</td><td>87 %</td><td>-1250 W</td>",
+				"<td>cellar</td><td>192.168.1.11</td><td>failed</td><td>2025-06-01 11:30:00 UTC</td><td>-</td><td>-</td>",
+				"<td>attic</td><td>192.168.1.12</td><td>never</td><td>-</td><td>-</td><td>-</td>",
+			},
+			notWant: []string{"secret-token"},
+		},
+		{
+			name: "addresses hidden",
+			want: []string{
+				"<td>garage</td><td>ok</td>",
+			},
+			notWant: []string{"secret-token", "192.168.1.", "<th>Address</th>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			landingHandler(collector, tt.showAddresses).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			body := rec.Body.String()
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(body, s) {
+					t.Errorf("page does not contain %q:\n%s", s, body)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(body, s) {
+					t.Errorf("page contains %q:\n%s", s, body)
+				}
+			}
+		})
+	}
+}
+
+func TestLandingHandler_EscapesNames(t *testing.T) {
+	collector := NewCollector([]Battery{{Name: "<script>", Address: "192.168.1.10"}}, CollectorOptions{})
+
+	rec := httptest.NewRecorder()
+	landingHandler(collector, true).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if body := rec.Body.String(); strings.Contains(body, "<script>") {
+		t.Errorf("battery name not escaped:\n%s", body)
+	}
+}
+
+func TestLandingHandler_ScrapedReading(t *testing.T) {
+	server := newMockBatteryServer(&LatestData{RSOC: 64}, &Status{PacTotalW: 800})
+	defer server.Close()
+
+	// SOC jump detection is off, which must not hide the reading
+	collector := NewCollector([]Battery{{Name: "garage", Address: server.URL[7:], AuthToken: "test-token"}}, CollectorOptions{SOCJumpThreshold: 0})
+	collectAll(collector)
+
+	rec := httptest.NewRecorder()
+	landingHandler(collector, false).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "<td>64 %</td><td>800 W</td>") {
+		t.Errorf("page does not show the scraped reading:\n%s", body)
+	}
+}
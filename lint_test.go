@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newFullMockBatteryServer serves every endpoint the collector reads, so that
+// as many metrics as possible are emitted
+func newFullMockBatteryServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	latestData, err := os.ReadFile("testdata/latestdata.json")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	configurations, err := os.ReadFile("testdata/configurations.json")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	cellVoltage, current, cosPhi, active := 3.3, 12.5, 0.98, true
+	batteryData := BatteryData{
+		MinimumCellVoltage:  &cellVoltage,
+		MaximumCellVoltage:  &cellVoltage,
+		SystemCurrent:       &current,
+		BatteryHeaterActive: &active,
+		CoolingActive:       &active,
+		Modules:             []BatteryModule{{ModuleID: 1, Status: "ready", Voltage: 53.4, Temperature: 24}},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v2/latestdata":
+			_, _ = w.Write(latestData)
+		case "/api/v2/status":
+			_ = json.NewEncoder(w).Encode(Status{BatteryCharging: true, PacTotalW: -1500, ProductionW: 2100, Uac: 231.2, Ubat: 53.4, Fac: 50})
+		case "/api/v2/configurations":
+			_, _ = w.Write(configurations)
+		case "/api/v2/battery":
+			_ = json.NewEncoder(w).Encode(batteryData)
+		case "/api/v2/inverter":
+			_ = json.NewEncoder(w).Encode(InverterData{CosPhi: &cosPhi, SacTotal: &current})
+		case "/api/v2/powermeter":
+			_ = json.NewEncoder(w).Encode([]PowermeterReading{{Channel: 1, KwhPos: 1200, KwhNeg: 300}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// lintCollector scrapes twice, so that counters incremented on a scrape are
+// present, and returns the names of the emitted metric families
+func lintCollector(t *testing.T, collector *Collector) map[string]bool {
+	t.Helper()
+
+	collectAll(collector)
+	problems, err := testutil.CollectAndLint(collector)
+	if err != nil {
+		t.Fatalf("CollectAndLint() error = %v", err)
+	}
+	for _, p := range problems {
+		t.Errorf("%s: %s", p.Metric, p.Text)
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(collector)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	names := make(map[string]bool, len(families))
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	return names
+}
+
+func TestCollector_Lint(t *testing.T) {
+	server := newFullMockBatteryServer(t)
+	collector := NewCollector([]Battery{
+		{Name: "battery1", Address: server.URL[7:], AuthToken: "test-token", Group: "house", DesignCapacityWh: 11000},
+		{Name: "battery2", Address: server.URL[7:], AuthToken: "test-token", Group: "house"},
+	}, CollectorOptions{SOCJumpThreshold: 10, StaleTTL: time.Minute})
+
+	names := lintCollector(t, collector)
+	for _, name := range []string{"sonnenbatterie_consumption_watts", "sonnenbatterie_battery_module_temperature_celsius", "sonnenbatterie_parallel_system_capacity_wh"} {
+		if !names[name] {
+			t.Errorf("%s not emitted, the lint does not cover it", name)
+		}
+	}
+	for _, name := range []string{"sonnenbatterie_consumption_mw", "sonnenbatterie_ac_voltage"} {
+		if names[name] {
+			t.Errorf("deprecated %s emitted without the compatibility flags", name)
+		}
+	}
+}
+
+func TestCollector_LintCompat(t *testing.T) {
+	server := newFullMockBatteryServer(t)
+	collector := NewCollector([]Battery{
+		{Name: "battery1", Address: server.URL[7:], AuthToken: "test-token", Group: "house"},
+		{Name: "battery2", Address: server.URL[7:], AuthToken: "test-token", Group: "house"},
+	}, CollectorOptions{LegacyMilliwatts: true, Compat: true})
+
+	names := lintCollector(t, collector)
+	for _, name := range []string{"sonnenbatterie_consumption_mw", "sonnenbatterie_parallel_system_battery_power_mw", "sonnenbatterie_ac_voltage", "sonnenbatterie_ac_frequency"} {
+		if !names[name] {
+			t.Errorf("deprecated %s not emitted with the compatibility flags", name)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeServerCertificate writes a self-signed certificate for 127.0.0.1 with
+// the given serial to cert.pem and key.pem in dir and returns it
+func writeServerCertificate(t *testing.T, dir string, serial int64) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "sonnenbatterie-exporter"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("encoding key: %v", err)
+	}
+	writePEM(t, dir, "cert.pem", "CERTIFICATE", der)
+	writePEM(t, dir, "key.pem", "EC PRIVATE KEY", keyDER)
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing certificate: %v", err)
+	}
+	return cert
+}
+
+func TestServe_TLS(t *testing.T) {
+	dir := t.TempDir()
+	first := writeServerCertificate(t, dir, 1)
+	certs, err := newCertReloader(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	if err != nil {
+		t.Fatalf("newCertReloader() error = %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error = %v", err)
+	}
+	server := &http.Server{
+		Handler:   metricsHandler(newRegistry(NewCollector(nil, CollectorOptions{}), false)),
+		TLSConfig: certs.tlsConfig(),
+	}
+	stop := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- serve([]httpListener{{server, listener}}, stop, time.Second, func() {})
+	}()
+	defer func() {
+		stop <- syscall.SIGTERM
+		if err := <-served; err != nil {
+			t.Errorf("serve() error = %v", err)
+		}
+	}()
+
+	// scrape fetches /metrics over HTTPS trusting cert and returns the
+	// serial of the certificate the exporter presented
+	scrape := func(cert *x509.Certificate) int64 {
+		t.Helper()
+		roots := x509.NewCertPool()
+		roots.AddCert(cert)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+		defer client.CloseIdleConnections()
+
+		resp, err := client.Get("https://" + listener.Addr().String() + "/metrics")
+		if err != nil {
+			t.Fatalf("GET /metrics over HTTPS error = %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("reading /metrics error = %v", err)
+		}
+		if !strings.Contains(string(body), "sonnenbatterie_exporter_build_info{") {
+			t.Error("sonnenbatterie_exporter_build_info missing")
+		}
+		return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	if got := scrape(first); got != 1 {
+		t.Errorf("certificate serial = %d, want 1", got)
+	}
+
+	// A renewed certificate is served after a reload
+	renewed := writeServerCertificate(t, dir, 2)
+	if err := certs.reload(); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	if got := scrape(renewed); got != 2 {
+		t.Errorf("certificate serial after reload = %d, want 2", got)
+	}
+
+	// A broken file keeps the current certificate
+	if err := os.WriteFile(filepath.Join(dir, "cert.pem"), []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := certs.reload(); err == nil {
+		t.Error("reload() of a broken certificate expected error but got none")
+	}
+	if got := scrape(renewed); got != 2 {
+		t.Errorf("certificate serial after failed reload = %d, want 2", got)
+	}
+}
+
+func TestNewCertReloader_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	garbage := filepath.Join(dir, "garbage.pem")
+	if err := os.WriteFile(garbage, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+	}{
+		{name: "missing files", certFile: filepath.Join(dir, "missing.crt"), keyFile: filepath.Join(dir, "missing.key")},
+		{name: "unparsable files", certFile: garbage, keyFile: garbage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newCertReloader(tt.certFile, tt.keyFile); err == nil {
+				t.Error("newCertReloader() expected error but got none")
+			}
+		})
+	}
+}
@@ -1,64 +1,390 @@
 package main
 
 import (
-	"fmt"
+	"flag"
 	"log"
+	"net"
 	"net/http"
-	"strings"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	_ "time/tzdata" // Battery time zones must resolve in the scratch image
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
+	legacyMilliwatts := flag.Bool("metrics.legacy-milliwatts", false,
+		"Also emit the deprecated _mw power metrics alongside the _watts ones")
+	compat := flag.Bool("metrics.compat", false,
+		"Also emit the deprecated voltage and frequency metric names without unit suffix")
+	dropStateLabels := flag.Bool("metrics.drop-state-labels", false,
+		"Keep bms_state and inverter_state off the value metrics; the states stay on sonnenbatterie_info")
+	keepInfo := flag.Bool("metrics.keep-info", false,
+		"Keep serving sonnenbatterie_info with the last known labels while a battery cannot be scraped")
+	useDeviceTimestamps := flag.Bool("metrics.use-device-timestamps", false,
+		"Stamp the latestdata and status metrics with the battery's measurement time if it is within 5 minutes of the exporter's clock")
+	dryRun := flag.Bool("dry-run", false,
+		"Validate the configuration and battery connectivity, then exit with 0 if all batteries are reachable and 1 otherwise")
+	flag.Parse()
+
 	port := getPort()
 
 	// Parse battery configurations
-	batteries, err := parseBatteries()
+	config, err := parseBatteriesDetailed()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	logWarnings(config.Warnings)
+	batteries := config.Batteries
+
+	co2Intensity, err := getCO2Intensity()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	maxLabelValues, err := getMaxLabelValues()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	firmwareGracePeriod, err := getFirmwareGracePeriod()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	configurationsMaxAge, err := getConfigurationsMaxAge()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	currency, err := getCurrency()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	offPeakImport, err := getOffPeakPrice("SONNENBATTERIE_OFFPEAK_PRICE_IMPORT")
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	offPeakExport, err := getOffPeakPrice("SONNENBATTERIE_OFFPEAK_PRICE_EXPORT")
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	warrantyYears, err := getWarrantyYears()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	runtimeMetrics, err := getRuntimeMetrics()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	batteryDebug, err := getBatteryDebug()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	hideAddresses, err := getHideAddresses()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	telemetryAddress, err := getTelemetryAddress()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	tlsCheckInterval, err := getTLSCheckInterval()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	sanityChecks, err := getSanityChecks()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	maxPowerW, err := getMaxPowerW()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	staleTTL, err := getStaleTTL()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	scrapeTimeout, err := getScrapeTimeout()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	shutdownGrace, err := getShutdownGrace()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	authUsername, authPasswordHash, err := getBasicAuth()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	tlsCertFile, tlsKeyFile, err := getListenerTLS()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	var certs *certReloader
+	if tlsCertFile != "" {
+		if certs, err = newCertReloader(tlsCertFile, tlsKeyFile); err != nil {
+			log.Fatalf("Configuration error: %v", err)
+		}
+	}
+
+	readyMode, err := getReadyMode()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	readyWindow, err := getReadyWindow()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	powerEMAAlpha, err := getPowerEMAAlpha()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	socJumpThreshold, err := getSOCJumpThreshold()
 	if err != nil {
 		log.Fatalf("Configuration error: %v", err)
 	}
 
-	log.Printf("Starting SonnenBatterie Prometheus Exporter on port %s", port)
+	authModules, err := getAuthModules()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	feedInSign, err := getFeedInSign()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	forecastURL, err := getForecastURL()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	remoteWriteURL, err := getRemoteWriteURL()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	pushInterval, err := getPushInterval()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	var forecast ForecastProvider
+	if forecastURL != "" {
+		forecast = newHTTPForecastProvider(forecastURL)
+	}
+
+	if *legacyMilliwatts {
+		log.Printf("Deprecated: --metrics.legacy-milliwatts emits the _mw power metrics, which will be removed; switch to the _watts metrics")
+	}
+	if *compat {
+		log.Printf("Deprecated: --metrics.compat emits the voltage and frequency metrics without unit suffix, which will be removed; switch to the _volts and _hertz metrics")
+	}
+
+	if *dryRun {
+		os.Exit(runDryRun(os.Stdout, batteries, dryRunTimeout))
+	}
+
+	log.Printf("Starting SonnenBatterie Prometheus Exporter %s (%s) on port %s", version, revision, port)
 	log.Printf("Monitoring %d battery/batteries:", len(batteries))
 	for _, b := range batteries {
-		log.Printf("  - %s: %s", b.Name, b.IP)
+		log.Printf("  - %s: %s", b.Name, b.Address)
 	}
 
 	// Create and register collector
-	collector := NewCollector(batteries)
-	prometheus.MustRegister(collector)
+	options := CollectorOptions{
+		CO2IntensityGPerKWh:  co2Intensity,
+		MaxLabelValues:       maxLabelValues,
+		FirmwareGracePeriod:  firmwareGracePeriod,
+		ConfigurationsMaxAge: configurationsMaxAge,
+		LegacyMilliwatts:     *legacyMilliwatts,
+		Compat:               *compat,
+		DropStateLabels:      *dropStateLabels,
+		WarrantyYears:        warrantyYears,
+		Currency:             currency,
+		OffPeakPriceImport:   offPeakImport,
+		OffPeakPriceExport:   offPeakExport,
+		SanityChecks:         sanityChecks,
+		MaxPowerW:            maxPowerW,
+		StaleTTL:             staleTTL,
+		ScrapeTimeout:        scrapeTimeout,
+		SOCJumpThreshold:     socJumpThreshold,
+		FeedInSign:           feedInSign,
+		KeepInfo:             *keepInfo,
+		UseDeviceTimestamps:  *useDeviceTimestamps,
+		Forecast:             forecast,
+		PowerEMAAlpha:        powerEMAAlpha,
+	}
+	collector := NewCollector(batteries, options)
+	collector.SetConfigWarnings(len(config.Warnings))
+	collector.Enrich()
+	registry, internalRegistry := newRegistries(collector, runtimeMetrics, telemetryAddress != "")
+	go batteryTransport.watchLeaks(leakAge / 2)
+	go exporterRuntime.run(selfMonitorInterval)
+	go collector.runIntervalEnergy(energyTick)
+	if tlsCheckInterval > 0 {
+		tlsMonitor := newTLSExpiryMonitor(collector.Batteries)
+		registry.MustRegister(tlsMonitor)
+		go tlsMonitor.run(tlsCheckInterval)
+	}
+	// Push the same metrics for deployments that cannot be scraped; /metrics
+	// keeps working
+	if remoteWriteURL != "" {
+		writer := newRemoteWriter(remoteWriteURL, registry, pushInterval)
+		registry.MustRegister(writer)
+		log.Printf("Pushing metrics with remote write every %s", pushInterval)
+		go writer.run(pushInterval)
+	}
+
+	// Re-read the battery configuration and the TLS certificate on SIGHUP, e.g.
+	// after editing the IP or token files or renewing the certificate
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if certs != nil {
+				if err := certs.reload(); err != nil {
+					log.Printf("Reload failed, keeping current TLS certificate: %v", err)
+				} else {
+					log.Printf("Reloaded TLS certificate")
+				}
+			}
+			updated, err := parseBatteriesDetailed()
+			if err != nil {
+				log.Printf("Reload failed, keeping current configuration: %v", err)
+				continue
+			}
+			logWarnings(updated.Warnings)
+			collector.SetBatteries(updated.Batteries)
+			collector.SetConfigWarnings(len(updated.Warnings))
+			log.Printf("Reloaded configuration: monitoring %d battery/batteries", len(updated.Batteries))
+		}
+	}()
+
+	mux := http.NewServeMux()
+	registerEndpoints(mux, registry, collector, options, authModules, readyMode, readyWindow, !hideAddresses)
+
+	// Troubleshooting details, pprof and the internal metrics move to their
+	// own listener if one is configured
+	telemetryMux := mux
+	if telemetryAddress != "" {
+		telemetryMux = http.NewServeMux()
+	}
+	registerTelemetry(telemetryMux, internalRegistry, collector, batteryDebug)
+
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatal(err)
+	}
 
+	// Let running scrapes finish on SIGTERM, e.g. when the container is stopped
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	if authUsername != "" {
+		log.Printf("Basic auth enabled for user %s, except on /health and /ready", authUsername)
+	}
+	if certs != nil {
+		log.Printf("Serving HTTPS with %s", tlsCertFile)
+	}
+	// Both listeners share basic auth and the certificate
+	newServer := func(mux *http.ServeMux) *http.Server {
+		server := &http.Server{Handler: mux}
+		if authUsername != "" {
+			server.Handler = basicAuth(authUsername, authPasswordHash, mux)
+		}
+		if certs != nil {
+			server.TLSConfig = certs.tlsConfig()
+		}
+		return server
+	}
+	listeners := []httpListener{{newServer(mux), listener}}
+	if telemetryAddress != "" {
+		telemetryListener, err := net.Listen("tcp", telemetryAddress)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Serving telemetry on %s", telemetryAddress)
+		listeners = append(listeners, httpListener{newServer(telemetryMux), telemetryListener})
+	}
+	if err := serve(listeners, stop, shutdownGrace, collector.CancelScrapes); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Shutdown complete")
+}
+
+// newRegistry returns a registry with the exporter's collectors and, if
+// runtimeMetrics is set, the Go runtime and process collectors
+func newRegistry(collector *Collector, runtimeMetrics bool) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registerBatteryMetrics(registry, collector)
+	registerInternalMetrics(registry, runtimeMetrics)
+	return registry
+}
+
+// registerBatteryMetrics adds the battery collector and the metrics about
+// querying the batteries to registry
+func registerBatteryMetrics(registry *prometheus.Registry, collector *Collector) {
+	registry.MustRegister(collector, newBuildInfoCollector(), batteryTransport)
+}
+
+// registerEndpoints adds the endpoints for Prometheus, probes and people to
+// mux: the battery metrics, /probe, /ready, /health and the landing page
+func registerEndpoints(mux *http.ServeMux, registry *prometheus.Registry, collector *Collector, options CollectorOptions,
+	authModules map[string]authModule, readyMode string, readyWindow time.Duration, showAddresses bool) {
 	// Expose metrics endpoint
-	http.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/metrics", metricsHandler(registry))
+
+	// Single batteries passed by Prometheus, blackbox exporter style
+	mux.Handle("/probe", probeHandler(options, authModules))
+
+	// Readiness check endpoint, failing while the batteries cannot be scraped
+	mux.Handle("/ready", readyHandler(collector, readyMode, readyWindow))
 
 	// Health check endpoint
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("OK"))
 	})
 
-	// Root endpoint with info
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-		html := `<!DOCTYPE html>
-<html>
-<head><title>SonnenBatterie Exporter</title></head>
-<body>
-<h1>SonnenBatterie Prometheus Exporter</h1>
-<p>Monitoring %d battery/batteries</p>
-<ul>
-%s
-</ul>
-<p><a href="/metrics">Metrics</a></p>
-</body>
-</html>`
-		var batteriesList strings.Builder
-		for _, b := range batteries {
-			batteriesList.WriteString(fmt.Sprintf("<li>%s: %s</li>\n", b.Name, b.IP))
-		}
-		_, _ = fmt.Fprintf(w, html, len(batteries), batteriesList.String())
-	})
+	// Root endpoint with a status table of the batteries; other paths are not
+	// found rather than showing it
+	mux.Handle("/{$}", landingHandler(collector, showAddresses))
+}
+
+// metricsHandler serves the registry, instrumented like promhttp.Handler.
+// Collection errors are logged and answered with a 500 carrying the error, so
+// Prometheus marks the target down instead of silently missing series.
+func metricsHandler(registry *prometheus.Registry) http.Handler {
+	return promhttp.InstrumentMetricHandler(registry, promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+		ErrorLog:      log.Default(),
+		ErrorHandling: promhttp.HTTPErrorOnError,
+	}))
+}
 
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+// logWarnings logs non-fatal configuration issues
+func logWarnings(warnings []Warning) {
+	for _, w := range warnings {
+		log.Printf("Configuration warning [%s]: %s", w.Code, w.Message)
+	}
 }
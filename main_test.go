@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetricsHandler_RuntimeMetrics(t *testing.T) {
+	tests := []struct {
+		name           string
+		runtimeMetrics bool
+		wantGo         bool
+	}{
+		{name: "enabled", runtimeMetrics: true, wantGo: true},
+		{name: "disabled", runtimeMetrics: false, wantGo: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// No batteries, so the handler only serves exporter-wide metrics
+			collector := NewCollector(nil, CollectorOptions{})
+			server := httptest.NewServer(metricsHandler(newRegistry(collector, tt.runtimeMetrics)))
+			defer server.Close()
+
+			resp, err := server.Client().Get(server.URL)
+			if err != nil {
+				t.Fatalf("GET /metrics error = %v", err)
+			}
+			defer func() { _ = resp.Body.Close() }()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("reading /metrics error = %v", err)
+			}
+
+			if got := strings.Contains(string(body), "\ngo_goroutines "); got != tt.wantGo {
+				t.Errorf("go_goroutines present = %v, want %v", got, tt.wantGo)
+			}
+			if !strings.Contains(string(body), "sonnenbatterie_exporter_build_info{") {
+				t.Error("sonnenbatterie_exporter_build_info missing")
+			}
+			if !strings.Contains(string(body), "\nsonnenbatterie_exporter_goroutines ") {
+				t.Error("sonnenbatterie_exporter_goroutines missing")
+			}
+		})
+	}
+}
+
+func TestMetricsHandler_CollectionError(t *testing.T) {
+	server := newMockBatteryServer(
+		&LatestData{RSOC: 85, ICStatus: ICStatus{StateBMS: "ready", StateInverter: "running"}},
+		&Status{},
+	)
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+	// One label more than the collector sends, so building the metric fails
+	collector.chargeLevel = prometheus.NewDesc(
+		"sonnenbatterie_charge_level_percent",
+		"Relative state of charge",
+		[]string{"battery_name", "bms_state", "inverter_state", "extra"},
+		nil,
+	)
+
+	handler := httptest.NewServer(metricsHandler(newRegistry(collector, false)))
+	defer handler.Close()
+
+	resp, err := handler.Client().Get(handler.URL)
+	if err != nil {
+		t.Fatalf("GET /metrics error = %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading /metrics error = %v", err)
+	}
+
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusInternalServerError)
+	}
+	if !strings.Contains(string(body), "sonnenbatterie_charge_level_percent") {
+		t.Errorf("body = %q, want the failing metric named", body)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// alwaysEmitted is kept for every battery regardless of its metric filter, so
+// a filtered battery can still be alerted on when it becomes unreachable
+var alwaysEmitted = []string{"sonnenbatterie_scrape_success", "sonnenbatterie_up"}
+
+// shouldEmitMetric returns whether a metric passes a battery's filter: any
+// metric if the filter is empty, otherwise only the listed names
+func shouldEmitMetric(name string, filter []string) bool {
+	return len(filter) == 0 || slices.Contains(alwaysEmitted, name) || slices.Contains(filter, name)
+}
+
+// parseMetricFilter parses a battery's entry of SONNENBATTERIE_METRIC_FILTERS,
+// metric names separated by "|", returning nil for an empty entry
+func parseMetricFilter(raw string) []string {
+	var filter []string
+	for _, name := range strings.Split(raw, "|") {
+		if name = strings.TrimSpace(name); name != "" {
+			filter = append(filter, name)
+		}
+	}
+	return filter
+}
+
+// metricName is the name of a descriptor and whether it has a battery_name
+// label
+type metricName struct {
+	name         string
+	batteryLabel bool
+}
+
+// metricNames records the names of the descriptors of a Collector as they are
+// created, since prometheus.Desc does not expose them
+type metricNames map[*prometheus.Desc]metricName
+
+// desc creates a descriptor like prometheus.NewDesc and records its name
+func (n metricNames) desc(fqName, help string, variableLabels []string, constLabels prometheus.Labels) *prometheus.Desc {
+	desc := prometheus.NewDesc(fqName, help, variableLabels, constLabels)
+	n[desc] = metricName{name: fqName, batteryLabel: slices.Contains(variableLabels, "battery_name")}
+	return desc
+}
+
+// counter creates a counter like prometheus.NewCounter and records its name
+func (n metricNames) counter(opts prometheus.CounterOpts) prometheus.Counter {
+	counter := prometheus.NewCounter(opts)
+	n.record(counter, opts.Name, nil)
+	return counter
+}
+
+// counterVec creates a vector like prometheus.NewCounterVec and records its name
+func (n metricNames) counterVec(opts prometheus.CounterOpts, labels []string) *prometheus.CounterVec {
+	vec := prometheus.NewCounterVec(opts, labels)
+	n.record(vec, opts.Name, labels)
+	return vec
+}
+
+// gaugeVec creates a vector like prometheus.NewGaugeVec and records its name
+func (n metricNames) gaugeVec(opts prometheus.GaugeOpts, labels []string) *prometheus.GaugeVec {
+	vec := prometheus.NewGaugeVec(opts, labels)
+	n.record(vec, opts.Name, labels)
+	return vec
+}
+
+// histogramVec creates a vector like prometheus.NewHistogramVec and records its name
+func (n metricNames) histogramVec(opts prometheus.HistogramOpts, labels []string) *prometheus.HistogramVec {
+	vec := prometheus.NewHistogramVec(opts, labels)
+	n.record(vec, opts.Name, labels)
+	return vec
+}
+
+// summaryVec creates a vector like prometheus.NewSummaryVec and records its name
+func (n metricNames) summaryVec(opts prometheus.SummaryOpts, labels []string) *prometheus.SummaryVec {
+	vec := prometheus.NewSummaryVec(opts, labels)
+	n.record(vec, opts.Name, labels)
+	return vec
+}
+
+// record adds the descriptor of a single-metric collector under name
+func (n metricNames) record(collector prometheus.Collector, name string, labels []string) {
+	ch := make(chan *prometheus.Desc, 1)
+	collector.Describe(ch)
+	close(ch)
+	for desc := range ch {
+		n[desc] = metricName{name: name, batteryLabel: slices.Contains(labels, "battery_name")}
+	}
+}
+
+// warnUnknownFilterMetrics logs the names in metric filters that match no
+// metric of the collector, which are most likely typos
+func (c *Collector) warnUnknownFilterMetrics(batteries []Battery) {
+	for battery, names := range c.unknownFilterMetrics(batteries) {
+		log.Printf("Warning: metric filter of battery %s lists unknown metrics %s", battery, strings.Join(names, ", "))
+	}
+}
+
+// unknownFilterMetrics returns the names in each battery's metric filter that
+// match no metric of the collector
+func (c *Collector) unknownFilterMetrics(batteries []Battery) map[string][]string {
+	known := make(map[string]bool, len(c.names))
+	for _, n := range c.names {
+		known[n.name] = true
+	}
+	unknown := map[string][]string{}
+	for _, b := range batteries {
+		for _, name := range b.MetricFilter {
+			if !known[name] {
+				unknown[b.Name] = append(unknown[b.Name], name)
+			}
+		}
+	}
+	return unknown
+}
+
+// withBatteryFilter returns a channel that forwards the metrics of one
+// battery to ch, dropping those its MetricFilter does not list, and a function
+// to call once all metrics are sent. Metrics are matched by the name recorded
+// for their descriptor, so metrics of a MetricProvider are dropped unless the
+// filter is empty. Without a filter, ch itself is returned.
+func (c *Collector) withBatteryFilter(battery Battery, ch chan<- prometheus.Metric) (chan<- prometheus.Metric, func()) {
+	if len(battery.MetricFilter) == 0 {
+		return ch, func() {}
+	}
+	return forwardMetrics(ch, func(m prometheus.Metric) bool {
+		return shouldEmitMetric(c.names[m.Desc()].name, battery.MetricFilter)
+	})
+}
+
+// withMetricFilters returns a channel that forwards the exporter-wide
+// metrics to ch, dropping those of batteries with a MetricFilter that does not
+// list them, and a function to call once all metrics are sent. Only metrics
+// with a battery_name label that some filter drops are written to read the
+// label. Without any filters, ch itself is returned.
+func (c *Collector) withMetricFilters(batteries []Battery, ch chan<- prometheus.Metric) (chan<- prometheus.Metric, func()) {
+	filters := map[string][]string{}
+	for _, b := range batteries {
+		if len(b.MetricFilter) > 0 {
+			filters[b.Name] = b.MetricFilter
+		}
+	}
+	if len(filters) == 0 {
+		return ch, func() {}
+	}
+
+	keptByAll := func(name string) bool {
+		for _, filter := range filters {
+			if !shouldEmitMetric(name, filter) {
+				return false
+			}
+		}
+		return true
+	}
+	return forwardMetrics(ch, func(m prometheus.Metric) bool {
+		n := c.names[m.Desc()]
+		if !n.batteryLabel || keptByAll(n.name) {
+			return true
+		}
+		return shouldEmitMetric(n.name, filters[metricBatteryName(m)])
+	})
+}
+
+// forwardMetrics returns a channel that forwards the metrics keep accepts to
+// ch, and a function to call once all metrics are sent
+func forwardMetrics(ch chan<- prometheus.Metric, keep func(prometheus.Metric) bool) (chan<- prometheus.Metric, func()) {
+	filtered := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for m := range filtered {
+			if keep(m) {
+				ch <- m
+			}
+		}
+	}()
+	return filtered, func() {
+		close(filtered)
+		<-done
+	}
+}
+
+// metricBatteryName returns the battery_name label of m, or "" if it has none
+func metricBatteryName(m prometheus.Metric) string {
+	pb := &dto.Metric{}
+	if err := m.Write(pb); err != nil {
+		return ""
+	}
+	for _, lp := range pb.GetLabel() {
+		if lp.GetName() == "battery_name" {
+			return lp.GetValue()
+		}
+	}
+	return ""
+}
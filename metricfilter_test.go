@@ -0,0 +1,94 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestShouldEmitMetric(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric string
+		filter []string
+		want   bool
+	}{
+		{name: "no filter", metric: "sonnenbatterie_battery_power_watts", want: true},
+		{name: "listed", metric: "sonnenbatterie_charge_level_percent", filter: []string{"sonnenbatterie_charge_level_percent"}, want: true},
+		{name: "not listed", metric: "sonnenbatterie_battery_power_watts", filter: []string{"sonnenbatterie_charge_level_percent"}},
+		{name: "prefix is not a match", metric: "sonnenbatterie_charge_level_percent", filter: []string{"sonnenbatterie_charge"}},
+		{name: "scrape success always emitted", metric: "sonnenbatterie_scrape_success", filter: []string{"sonnenbatterie_charge_level_percent"}, want: true},
+		{name: "up always emitted", metric: "sonnenbatterie_up", filter: []string{"sonnenbatterie_charge_level_percent"}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shouldEmitMetric(tt.metric, tt.filter); got != tt.want {
+				t.Errorf("shouldEmitMetric(%q, %q) = %v, want %v", tt.metric, tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollector_MetricFilter(t *testing.T) {
+	server := newMockBatteryServer(&LatestData{RSOC: 75}, &Status{PacTotalW: 500})
+	defer server.Close()
+
+	collector := NewCollector([]Battery{
+		{Name: "filtered", Address: server.URL[7:], AuthToken: "test-token", MetricFilter: []string{"sonnenbatterie_charge_level_percent"}},
+		{Name: "full", Address: server.URL[7:], AuthToken: "test-token"},
+	}, CollectorOptions{})
+
+	metrics := map[string][]string{}
+	for _, m := range collectAll(collector) {
+		name := labelValue(writeMetric(t, m), "battery_name")
+		metrics[name] = append(metrics[name], collector.names[m.Desc()].name)
+	}
+
+	filtered := metrics["filtered"]
+	if len(filtered) != 3 {
+		t.Fatalf("metrics of the filtered battery = %q, want charge level, scrape success and up", filtered)
+	}
+	for _, want := range []string{"sonnenbatterie_charge_level_percent", "sonnenbatterie_scrape_success", "sonnenbatterie_up"} {
+		if !slices.Contains(filtered, want) {
+			t.Errorf("metrics of the filtered battery = %q, missing %s", filtered, want)
+		}
+	}
+	if len(metrics["full"]) <= 2 {
+		t.Errorf("metrics of the unfiltered battery = %q, want all", metrics["full"])
+	}
+	// Exporter-wide metrics are not affected
+	if len(metrics[""]) == 0 {
+		t.Errorf("no exporter-wide metrics emitted")
+	}
+}
+
+func TestCollector_MetricNames(t *testing.T) {
+	collector := NewCollector(nil, CollectorOptions{})
+
+	// Every described metric has its name recorded, except for the
+	// cardinality guard's, which has no battery_name label
+	guard := make(chan *prometheus.Desc, 1)
+	collector.guard.Describe(guard)
+	guardDesc := <-guard
+	ch := make(chan *prometheus.Desc, 1000)
+	collector.Describe(ch)
+	close(ch)
+	for desc := range ch {
+		if desc != guardDesc && collector.names[desc].name == "" {
+			t.Errorf("no name recorded for %s", desc)
+		}
+	}
+	if n := collector.names[collector.client.requestDurationHistogram.WithLabelValues("b", "e").(prometheus.Metric).Desc()]; n.name != "sonnenbatterie_request_latency_seconds" || !n.batteryLabel {
+		t.Errorf("request latency recorded as %+v", n)
+	}
+
+	unknown := collector.unknownFilterMetrics([]Battery{
+		{Name: "typo", MetricFilter: []string{"sonnenbatterie_charge_level_percent", "sonnenbatterie_charge_level"}},
+		{Name: "known", MetricFilter: []string{"sonnenbatterie_up"}},
+	})
+	if len(unknown) != 1 || !slices.Equal(unknown["typo"], []string{"sonnenbatterie_charge_level"}) {
+		t.Errorf("unknownFilterMetrics() = %v, want typo: [sonnenbatterie_charge_level]", unknown)
+	}
+}
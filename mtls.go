@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// clientTLS is the TLS setup of a battery queried over HTTPS, e.g. through a
+// proxy requiring client certificates
+type clientTLS struct {
+	transport  *http.Transport
+	certExpiry time.Time   // NotAfter of the client certificate, zero without one
+	modTimes   []time.Time // Modification times of the files it was loaded from
+}
+
+// clientTLSCache holds the TLS setups by their files, so connections are
+// reused across scrapes. A setup is reloaded once one of its files changes.
+var clientTLSCache = struct {
+	mu     sync.Mutex
+	setups map[string]*clientTLS
+}{setups: make(map[string]*clientTLS)}
+
+// usesTLS reports whether the battery is queried over HTTPS
+func (b Battery) usesTLS() bool {
+	return b.HTTPS || b.hasTLSFiles()
+}
+
+// hasTLSFiles reports whether the battery has its own client certificate or CA
+func (b Battery) hasTLSFiles() bool {
+	return b.ClientCertFile != "" || b.CACertFile != ""
+}
+
+// scheme returns the URL scheme of the battery API
+func (b Battery) scheme() string {
+	if b.usesTLS() {
+		return "https"
+	}
+	return apiScheme
+}
+
+// roundTripper returns the transport the battery's requests go through
+func (b Battery) roundTripper() (http.RoundTripper, error) {
+	setup, err := clientTLSFor(b)
+	if err != nil {
+		return nil, err
+	}
+	if setup == nil {
+		return batteryTransport, nil
+	}
+	return batteryTransport.via(setup.transport), nil
+}
+
+// clientTLSFor returns the TLS setup of a battery, loading it on first use and
+// after its files changed. It returns nil for batteries without certificate
+// files, which use the shared transport and the system roots for HTTPS.
+func clientTLSFor(b Battery) (*clientTLS, error) {
+	if !b.hasTLSFiles() {
+		return nil, nil
+	}
+	files := []string{b.ClientCertFile, b.ClientKeyFile, b.CACertFile}
+	modTimes, err := fileModTimes(files)
+	if err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprint(files)
+	clientTLSCache.mu.Lock()
+	defer clientTLSCache.mu.Unlock()
+	cached := clientTLSCache.setups[key]
+	if cached != nil && sameTimes(cached.modTimes, modTimes) {
+		return cached, nil
+	}
+
+	setup, err := loadClientTLS(b.ClientCertFile, b.ClientKeyFile, b.CACertFile)
+	if err != nil {
+		return nil, err
+	}
+	setup.modTimes = modTimes
+	if cached != nil {
+		cached.transport.CloseIdleConnections()
+	}
+	clientTLSCache.setups[key] = setup
+	return setup, nil
+}
+
+// collectClientCertExpiry emits the time until the battery's client
+// certificate expires, if it has one
+func (c *Collector) collectClientCertExpiry(battery Battery, ch chan<- prometheus.Metric) {
+	setup, err := clientTLSFor(battery)
+	if err != nil || setup == nil || setup.certExpiry.IsZero() {
+		return
+	}
+	c.gauge(ch, c.clientCertExpiry, setup.certExpiry.Sub(c.now()).Seconds(), battery.Name)
+}
+
+// loadClientTLS reads the client certificate and key, if set, and the CA
+// certificates to verify the battery with, if set; otherwise the system
+// roots are used
+func loadClientTLS(certFile, keyFile, caFile string) (*clientTLS, error) {
+	setup := &clientTLS{}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+		setup.certExpiry = leaf.NotAfter
+	}
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificates: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no CA certificates found in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	setup.transport = http.DefaultTransport.(*http.Transport).Clone()
+	setup.transport.TLSClientConfig = config
+	return setup, nil
+}
+
+// fileModTimes returns the modification time of each file, zero for empty names
+func fileModTimes(files []string) ([]time.Time, error) {
+	modTimes := make([]time.Time, len(files))
+	for i, file := range files {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS file: %w", err)
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// sameTimes reports whether a and b hold the same times
+func sameTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePEM writes a PEM block to a file in dir and returns its path
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("writing %s: %v", name, err)
+	}
+	return path
+}
+
+// newClientCertificate creates a CA and a client certificate signed by it,
+// expiring at notAfter. It returns the CA pool and the certificate and key files.
+func newClientCertificate(t *testing.T, dir string, notAfter time.Time) (*x509.CertPool, string, string) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating CA key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("creating CA certificate: %v", err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("parsing CA certificate: %v", err)
+	}
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating client key: %v", err)
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "sonnenbatterie-exporter"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("creating client certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		t.Fatalf("encoding client key: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return pool, writePEM(t, dir, "client.crt", "CERTIFICATE", clientDER), writePEM(t, dir, "client.key", "EC PRIVATE KEY", keyDER)
+}
+
+func TestFetchJSON_ClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	notAfter := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second)
+	clientCAs, certFile, keyFile := newClientCertificate(t, dir, notAfter)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"RSOC": 80}`))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+	caFile := writePEM(t, dir, "server-ca.crt", "CERTIFICATE", server.Certificate().Raw)
+
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		wantErr  bool
+	}{
+		{name: "valid client certificate", certFile: certFile, keyFile: keyFile},
+		{name: "no client certificate", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			battery := Battery{
+				Name:           "test-battery",
+				Address:        server.Listener.Addr().String(),
+				AuthToken:      "test-token",
+				ClientCertFile: tt.certFile,
+				ClientKeyFile:  tt.keyFile,
+				CACertFile:     caFile,
+			}
+
+			data, err := fetchLatestData(context.Background(), battery)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("fetchLatestData() expected handshake error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fetchLatestData() error = %v", err)
+			}
+			if data.RSOC != 80 {
+				t.Errorf("RSOC = %d, want 80", data.RSOC)
+			}
+
+			collector := NewCollector([]Battery{battery}, CollectorOptions{})
+			now := notAfter.Add(-24 * time.Hour)
+			collector.now = func() time.Time { return now }
+			var expiry float64
+			for _, m := range collectAll(collector) {
+				if m.Desc() == collector.clientCertExpiry {
+					expiry = writeMetric(t, m).GetGauge().GetValue()
+				}
+			}
+			if expiry != 86400 {
+				t.Errorf("client certificate expiry = %v, want 86400", expiry)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// powerUtilization returns how much of limitW the battery power uses, clamped
+// to [0, 1]. It reports false without a positive limit.
+func powerUtilization(powerW, limitW float64) (float64, bool) {
+	if limitW <= 0 {
+		return 0, false
+	}
+	return math.Min(math.Abs(powerW)/limitW, 1), true
+}
+
+// collectPowerLimits emits the configured charging and discharging power
+// limits and how much of them the battery currently uses. The utilization of
+// the direction the battery is not moving in is 0.
+func (c *Collector) collectPowerLimits(battery Battery, status *Status, configurations *Configurations, ch chan<- prometheus.Metric) {
+	state, _ := chargeState(status)
+	limits := []struct {
+		limit       *flexFloat
+		state       string
+		limitDesc   *prometheus.Desc
+		utilization *prometheus.Desc
+	}{
+		{configurations.ChargeLimitW, "charging", c.chargePowerLimit, c.chargeUtilization},
+		{configurations.DischargeLimitW, "discharging", c.dischargePowerLimit, c.dischargeUtilization},
+	}
+	for _, l := range limits {
+		if l.limit == nil {
+			continue
+		}
+		c.gauge(ch, l.limitDesc, float64(*l.limit), battery.Name)
+
+		powerW := 0.0
+		if state == l.state {
+			powerW = status.PacTotalW
+		}
+		if utilization, ok := powerUtilization(powerW, float64(*l.limit)); ok {
+			c.gauge(ch, l.utilization, utilization, battery.Name)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPowerUtilization(t *testing.T) {
+	tests := []struct {
+		name   string
+		powerW float64
+		limitW float64
+		want   float64
+		wantOK bool
+	}{
+		{name: "half the limit", powerW: 2300, limitW: 4600, want: 0.5, wantOK: true},
+		{name: "negative power", powerW: -1150, limitW: 4600, want: 0.25, wantOK: true},
+		{name: "above the limit", powerW: 5000, limitW: 4600, want: 1, wantOK: true},
+		{name: "idle", powerW: 0, limitW: 4600, want: 0, wantOK: true},
+		{name: "zero limit", powerW: 2300, limitW: 0},
+		{name: "negative limit", powerW: 2300, limitW: -100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := powerUtilization(tt.powerW, tt.limitW)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("powerUtilization() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCollector_PowerLimits(t *testing.T) {
+	tests := []struct {
+		name           string
+		configurations string
+		status         string
+		want           map[string]float64 // Emitted metrics by short name
+	}{
+		{
+			name:           "charging at the limit",
+			configurations: `{"EM_ChargingLimitW": "3000", "EM_DischargingLimitW": "4000"}`,
+			status:         `{"BatteryCharging": true, "Pac_total_W": -3500}`,
+			want:           map[string]float64{"charge_limit": 3000, "discharge_limit": 4000, "charge_utilization": 1, "discharge_utilization": 0},
+		},
+		{
+			name:           "discharging",
+			configurations: `{"EM_ChargingLimitW": 3000, "EM_DischargingLimitW": 4000}`,
+			status:         `{"BatteryDischarging": true, "Pac_total_W": 1000}`,
+			want:           map[string]float64{"charge_limit": 3000, "discharge_limit": 4000, "charge_utilization": 0, "discharge_utilization": 0.25},
+		},
+		{
+			name:           "zero limit",
+			configurations: `{"EM_ChargingLimitW": "0"}`,
+			status:         `{"BatteryCharging": true, "Pac_total_W": -1000}`,
+			want:           map[string]float64{"charge_limit": 0},
+		},
+		{
+			name:           "limits not reported",
+			configurations: `{}`,
+			status:         `{"BatteryCharging": true, "Pac_total_W": -1000}`,
+			want:           map[string]float64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api/v2/latestdata":
+					_, _ = w.Write([]byte(`{}`))
+				case "/api/v2/status":
+					_, _ = w.Write([]byte(tt.status))
+				case "/api/v2/configurations":
+					_, _ = w.Write([]byte(tt.configurations))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			collector := NewCollector(
+				[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+				CollectorOptions{},
+			)
+			names := map[*prometheus.Desc]string{
+				collector.chargePowerLimit:     "charge_limit",
+				collector.dischargePowerLimit:  "discharge_limit",
+				collector.chargeUtilization:    "charge_utilization",
+				collector.dischargeUtilization: "discharge_utilization",
+			}
+			got := map[string]float64{}
+			for _, m := range collectAll(collector) {
+				if name, ok := names[m.Desc()]; ok {
+					got[name] = writeMetric(t, m).GetGauge().GetValue()
+				}
+			}
+
+			if len(got) != len(tt.want) {
+				t.Errorf("metrics = %v, want %v", got, tt.want)
+			}
+			for name, want := range tt.want {
+				if v, ok := got[name]; !ok || v != want {
+					t.Errorf("%s = %v (present %v), want %v", name, v, ok, want)
+				}
+			}
+		})
+	}
+}
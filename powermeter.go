@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+)
+
+// collectPowermeter advances the energy counters to the cumulative readings of
+// the optional /api/v2/powermeter endpoint. The first reading of a channel sets
+// the counter to the meter value; a reading below the previous one means the
+// meter was reset and is skipped, so the counter never goes backwards.
+func (c *Collector) collectPowermeter(ctx context.Context, battery Battery) {
+	readings, err := fetchPowermeter(ctx, battery)
+	if err != nil {
+		c.fetchFailed(battery, "powermeter", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := c.batteryState(battery.Name)
+	if state.powermeter == nil {
+		state.powermeter = make(map[[2]string]float64)
+	}
+	for _, reading := range readings {
+		channel := strconv.Itoa(reading.Channel)
+		c.advanceEnergy(battery.Name, state, channel, "consumed", reading.KwhPos)
+		c.advanceEnergy(battery.Name, state, channel, "produced", reading.KwhNeg)
+	}
+}
+
+// advanceEnergy adds the increase since the previous reading to the counter;
+// the caller holds c.mu
+func (c *Collector) advanceEnergy(name string, state *batteryState, channel, direction string, kwh float64) {
+	key := [2]string{channel, direction}
+	previous, seen := state.powermeter[key]
+	state.powermeter[key] = kwh
+
+	if seen && kwh < previous {
+		log.Printf("Warning: powermeter channel %s (%s) of %s went back from %.3f to %.3f kWh, assuming a meter reset",
+			channel, direction, name, previous, kwh)
+		return
+	}
+	if increase := kwh - previous; increase > 0 {
+		c.powermeterEnergy.WithLabelValues(name, channel, direction).Add(increase)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector_PowermeterEnergy(t *testing.T) {
+	var powermeter atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/latestdata", "/api/v2/status":
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/v2/powermeter":
+			_, _ = w.Write([]byte(powermeter.Load().(string)))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+	energy := func(channel, direction string) float64 {
+		return testutil.ToFloat64(collector.powermeterEnergy.WithLabelValues("test-battery", channel, direction))
+	}
+
+	tests := []struct {
+		name         string
+		powermeter   string
+		wantConsumed float64
+		wantProduced float64
+	}{
+		{
+			name:         "first reading",
+			powermeter:   `[{"channel": 1, "kwh_pos": 100.5, "kwh_neg": 20}]`,
+			wantConsumed: 100.5,
+			wantProduced: 20,
+		},
+		{
+			name:         "normal increment",
+			powermeter:   `[{"channel": 1, "kwh_pos": 101, "kwh_neg": 22}]`,
+			wantConsumed: 101,
+			wantProduced: 22,
+		},
+		{
+			name:         "meter reset is skipped",
+			powermeter:   `[{"channel": 1, "kwh_pos": 0.5, "kwh_neg": 23}]`,
+			wantConsumed: 101,
+			wantProduced: 23,
+		},
+		{
+			name:         "counting resumes after reset",
+			powermeter:   `[{"channel": 1, "kwh_pos": 2, "kwh_neg": 23}]`,
+			wantConsumed: 102.5,
+			wantProduced: 23,
+		},
+	}
+
+	// Steps build on each other, so they run in order against the same collector
+	for _, tt := range tests {
+		powermeter.Store(tt.powermeter)
+		collectAll(collector)
+		if got := energy("1", "consumed"); got != tt.wantConsumed {
+			t.Errorf("%s: consumed = %f, want %f", tt.name, got, tt.wantConsumed)
+		}
+		if got := energy("1", "produced"); got != tt.wantProduced {
+			t.Errorf("%s: produced = %f, want %f", tt.name, got, tt.wantProduced)
+		}
+	}
+}
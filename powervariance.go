@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// powerWindowSize is the number of battery power readings kept per battery
+const powerWindowSize = 60
+
+// powerWindow is a ring buffer of the latest battery power readings
+type powerWindow struct {
+	samples [powerWindowSize]float64
+	next    int // Slot the next reading is written to
+	count   int // Readings in samples
+}
+
+// add stores a reading, replacing the oldest once the window is full
+func (w *powerWindow) add(powerW float64) {
+	w.samples[w.next] = powerW
+	w.next = (w.next + 1) % powerWindowSize
+	w.count = min(w.count+1, powerWindowSize)
+}
+
+// variance returns the population variance of the readings in the window
+func (w *powerWindow) variance() float64 {
+	if w.count == 0 {
+		return 0
+	}
+	samples := w.samples[:w.count]
+
+	var sum float64
+	for _, x := range samples {
+		sum += x
+	}
+	mean := sum / float64(w.count)
+
+	var squares float64
+	for _, x := range samples {
+		squares += (x - mean) * (x - mean)
+	}
+	return squares / float64(w.count)
+}
+
+// collectPowerVariance adds the current battery power to the window and
+// emits its variance and standard deviation once there are two readings
+func (c *Collector) collectPowerVariance(battery Battery, status *Status, ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	window := &c.batteryState(battery.Name).power
+	window.add(status.PacTotalW)
+	count, variance := window.count, window.variance()
+	c.mu.Unlock()
+
+	if count < 2 {
+		return
+	}
+	c.gauge(ch, c.powerVariance, variance, battery.Name)
+	c.gauge(ch, c.powerStdDev, math.Sqrt(variance), battery.Name)
+}
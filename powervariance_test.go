@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPowerWindow_Variance(t *testing.T) {
+	arithmetic := make([]float64, 10)
+	for i := range arithmetic {
+		arithmetic[i] = float64(100 * (i + 1))
+	}
+	// More readings than the window holds, the first 20 drop out
+	overflow := make([]float64, powerWindowSize+20)
+	for i := range overflow {
+		overflow[i] = 5000
+		if i >= 20 {
+			overflow[i] = 1000
+		}
+	}
+
+	tests := []struct {
+		name     string
+		readings []float64
+		want     float64
+	}{
+		{name: "no readings", want: 0},
+		{name: "constant power", readings: []float64{1500, 1500, 1500, 1500}, want: 0},
+		// Variance of 1..n is (n²-1)/12, scaled by the 100 W step
+		{name: "arithmetic sequence", readings: arithmetic, want: 100 * 100 * (10*10 - 1) / 12.0},
+		{name: "alternating charge and discharge", readings: []float64{-2000, 2000, -2000, 2000}, want: 4e6},
+		{name: "oldest readings rotate out", readings: overflow, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var window powerWindow
+			for _, r := range tt.readings {
+				window.add(r)
+			}
+			if got := window.variance(); math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("variance() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollector_PowerVariance(t *testing.T) {
+	status := &Status{PacTotalW: 1000}
+	server := newMockBatteryServer(&LatestData{}, status)
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+
+	values := func() map[string]float64 {
+		got := map[string]float64{}
+		for _, m := range collectAll(collector) {
+			switch m.Desc() {
+			case collector.powerVariance:
+				got["variance"] = writeMetric(t, m).GetGauge().GetValue()
+			case collector.powerStdDev:
+				got["stddev"] = writeMetric(t, m).GetGauge().GetValue()
+			}
+		}
+		return got
+	}
+
+	if got := values(); len(got) != 0 {
+		t.Errorf("metrics after one reading = %v, want none", got)
+	}
+	status.PacTotalW = 3000
+	if got := values(); got["variance"] != 1e6 || got["stddev"] != 1000 {
+		t.Errorf("metrics after two readings = %v, want variance 1e6 and stddev 1000", got)
+	}
+}
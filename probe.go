@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// probeTokenParams are query parameters that would carry a token; they are
+// rejected so tokens never end up in Prometheus configs or access logs
+var probeTokenParams = []string{"token", "auth_token", "auth-token"}
+
+var (
+	probeSuccessDesc = prometheus.NewDesc(
+		"probe_success",
+		"Whether the battery could be scraped",
+		nil,
+		nil,
+	)
+	probeDurationDesc = prometheus.NewDesc(
+		"probe_duration_seconds",
+		"Duration of the probe in seconds",
+		nil,
+		nil,
+	)
+)
+
+// authModule is an Auth-Token usable with /probe and the targets it may be
+// sent to, so a probe cannot hand the token to an arbitrary host
+type authModule struct {
+	token   string
+	targets []string
+}
+
+// allows reports whether the module's token may be sent to target
+func (m authModule) allows(target string) bool {
+	for _, allowed := range m.targets {
+		if strings.EqualFold(allowed, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// validTarget reports whether target is a plain host or host:port
+func validTarget(target string) bool {
+	if target == "" {
+		return false
+	}
+	u, err := url.Parse(apiScheme + "://" + target)
+	return err == nil && u.Host == target && u.Hostname() != "" && u.User == nil &&
+		u.Path == "" && u.RawQuery == "" && u.Fragment == ""
+}
+
+// probeCollector scrapes a single battery on each Collect
+type probeCollector struct {
+	collector *Collector
+	battery   Battery
+	request   *http.Request
+}
+
+// Describe sends nothing, making the collector unchecked
+func (p probeCollector) Describe(chan<- *prometheus.Desc) {}
+
+// Collect scrapes the battery and emits its metrics, including the request
+// metrics of this probe only, along with the probe outcome and duration
+func (p probeCollector) Collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	ctx := p.request.Context()
+	if timeout := p.collector.options.ScrapeTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	reading := p.collector.collectBattery(ctx, p.battery, ch)
+	p.collector.client.Collect(ch)
+	ch <- prometheus.MustNewConstMetric(probeSuccessDesc, prometheus.GaugeValue, boolToFloat(reading != nil))
+	ch <- prometheus.MustNewConstMetric(probeDurationDesc, prometheus.GaugeValue, time.Since(start).Seconds())
+}
+
+// probeHandler serves the metrics of the battery at the target parameter,
+// blackbox exporter style. The Auth-Token is looked up by the auth_module
+// parameter and never taken from the URL, and only sent to the targets listed
+// for the module. Every probe starts with a fresh collector, so values
+// accumulated across scrapes are not available and no series of probed
+// targets remain after the response.
+func probeHandler(options CollectorOptions, modules map[string]authModule) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		for _, param := range probeTokenParams {
+			if query.Has(param) {
+				http.Error(w, fmt.Sprintf("%s is not accepted, configure the token in SONNENBATTERIE_AUTH_MODULES and pass auth_module", param), http.StatusBadRequest)
+				return
+			}
+		}
+
+		target := query.Get("target")
+		if !validTarget(target) {
+			http.Error(w, fmt.Sprintf("invalid target %q, must be host or host:port", target), http.StatusBadRequest)
+			return
+		}
+		module := query.Get("auth_module")
+		auth, ok := modules[module]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown auth_module %q", module), http.StatusBadRequest)
+			return
+		}
+		if !auth.allows(target) {
+			http.Error(w, fmt.Sprintf("target %q is not allowed for auth_module %q", target, module), http.StatusForbidden)
+			return
+		}
+
+		collector := NewCollector([]Battery{{Name: target, Address: target, AuthToken: auth.token}}, options)
+		defer collector.CancelScrapes()
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(probeCollector{
+			collector: collector,
+			battery:   collector.Batteries()[0],
+			request:   r,
+		})
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError}).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestValidTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{target: "192.168.1.50", want: true},
+		{target: "battery.local:8080", want: true},
+		{target: "[fe80::1]:80", want: true},
+		{target: ""},
+		{target: "http://192.168.1.50"},
+		{target: "192.168.1.50/api"},
+		{target: "user@192.168.1.50"},
+		{target: "192.168.1.50:port"},
+		{target: "192.168.1.50?x=1"},
+	}
+
+	for _, tt := range tests {
+		if got := validTarget(tt.target); got != tt.want {
+			t.Errorf("validTarget(%q) = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestProbeHandler(t *testing.T) {
+	battery := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Auth-Token") != "house-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v2/latestdata":
+			_, _ = w.Write([]byte(`{"RSOC": 64}`))
+		case "/api/v2/status":
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer battery.Close()
+
+	offline := httptest.NewServer(http.NotFoundHandler())
+	offlineTarget := offline.URL[7:]
+	offline.Close()
+
+	handler := probeHandler(CollectorOptions{}, map[string]authModule{
+		"house": {token: "house-token", targets: []string{battery.URL[7:], offlineTarget}},
+	})
+
+	// Receives the token if the allowlist is not enforced
+	attacker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Auth-Token") != "" {
+			t.Errorf("token sent to a target not allowed for the module")
+		}
+	}))
+	defer attacker.Close()
+
+	tests := []struct {
+		name       string
+		query      url.Values
+		wantStatus int
+		wantBody   []string
+	}{
+		{
+			name:       "successful probe",
+			query:      url.Values{"target": {battery.URL[7:]}, "auth_module": {"house"}},
+			wantStatus: http.StatusOK,
+			wantBody: []string{"probe_success 1", "probe_duration_seconds", "sonnenbatterie_charge_level_percent{",
+				// Request metrics of this probe only
+				"sonnenbatterie_request_latency_seconds_count{battery_name=\"" + battery.URL[7:] + "\",endpoint=\"latestdata\"} 1"},
+		},
+		{
+			name:       "failing target",
+			query:      url.Values{"target": {offlineTarget}, "auth_module": {"house"}},
+			wantStatus: http.StatusOK,
+			wantBody:   []string{"probe_success 0", "sonnenbatterie_scrape_success{battery_name=\"" + offlineTarget + "\"} 0"},
+		},
+		{
+			name:       "token in query string",
+			query:      url.Values{"target": {battery.URL[7:]}, "auth_module": {"house"}, "token": {"house-token"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown auth module",
+			query:      url.Values{"target": {battery.URL[7:]}, "auth_module": {"garage"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "target not allowed for the module",
+			query:      url.Values{"target": {attacker.URL[7:]}, "auth_module": {"house"}},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "malformed target",
+			query:      url.Values{"target": {"http://" + battery.URL[7:] + "/api"}, "auth_module": {"house"}},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/probe?"+tt.query.Encode(), nil))
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			body, _ := io.ReadAll(recorder.Body)
+			for _, want := range tt.wantBody {
+				if !strings.Contains(string(body), want) {
+					t.Errorf("body does not contain %q:\n%s", want, body)
+				}
+			}
+			if strings.Contains(string(body), "house-token") {
+				t.Errorf("body contains the token:\n%s", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// recordProtocol records the protocol a battery API response was served with.
+// http.DefaultTransport, which batteryTransport wraps, already attempts HTTP/2
+// on TLS connections, so a battery or proxy offering h2 is used automatically.
+func (m *clientMetrics) recordProtocol(batteryName string, resp *http.Response) {
+	if m == nil {
+		return
+	}
+	m.protocolMu.Lock()
+	defer m.protocolMu.Unlock()
+
+	m.httpProtocolInfo.DeletePartialMatch(prometheus.Labels{"battery_name": batteryName})
+	m.httpProtocolInfo.WithLabelValues(batteryName, resp.Proto).Set(1)
+	m.http2InUse.WithLabelValues(batteryName).Set(boolToFloat(resp.ProtoMajor == 2))
+}
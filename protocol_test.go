@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecordProtocol(t *testing.T) {
+	metrics := newClientMetrics(metricNames{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(Status{})
+	})
+
+	// Plain HTTP, as used by fetchJSON
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	battery := Battery{Name: "protocol-test", Address: plain.URL[7:], AuthToken: "test-token", metrics: metrics}
+	if _, err := fetchStatus(context.Background(), battery); err != nil {
+		t.Fatalf("fetchStatus() error = %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.httpProtocolInfo.WithLabelValues("protocol-test", "HTTP/1.1")); got != 1 {
+		t.Errorf("protocol info HTTP/1.1 = %f, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.http2InUse.WithLabelValues("protocol-test")); got != 0 {
+		t.Errorf("http2 in use = %f, want 0", got)
+	}
+
+	// HTTPS with h2 negotiated through ALPN
+	h2 := httptest.NewUnstartedServer(handler)
+	h2.EnableHTTP2 = true
+	h2.StartTLS()
+	defer h2.Close()
+
+	resp, err := metrics.instrumentedDo(context.Background(), h2.Client(), "protocol-test", "status", h2.URL+"/api/v2/status", "test-token")
+	if err != nil {
+		t.Fatalf("instrumentedDo() error = %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.TLS == nil || resp.TLS.NegotiatedProtocol != "h2" {
+		t.Fatalf("negotiated protocol = %v, want h2", resp.TLS)
+	}
+	metrics.recordProtocol("protocol-test", resp)
+
+	if got := testutil.ToFloat64(metrics.http2InUse.WithLabelValues("protocol-test")); got != 1 {
+		t.Errorf("http2 in use = %f, want 1", got)
+	}
+	// The previous protocol series is replaced
+	if got := testutil.CollectAndCount(metrics.httpProtocolInfo); got != 1 {
+		t.Errorf("protocol info series = %d, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.httpProtocolInfo.With(prometheus.Labels{"battery_name": "protocol-test", "protocol": "HTTP/2.0"})); got != 1 {
+		t.Errorf("protocol info HTTP/2.0 = %f, want 1", got)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// powerRamp returns how fast the battery power changed between two readings
+// elapsed apart, in watts per second, and the direction of the change: 1 for
+// increasing, -1 for decreasing and 0 for stable
+func powerRamp(previousW, currentW float64, elapsed time.Duration) (rate, direction float64) {
+	delta := currentW - previousW
+	switch {
+	case delta > 0:
+		direction = 1
+	case delta < 0:
+		direction = -1
+	}
+	return math.Abs(delta) / elapsed.Seconds(), direction
+}
+
+// collectPowerRamp emits the change of the battery power since the previous
+// successful scrape. Nothing is emitted after a failed scrape or an
+// implausible power reading, as the interval to the last reading is unknown.
+func (c *Collector) collectPowerRamp(battery Battery, status *Status, elapsed time.Duration, dropped droppedReadings, ch chan<- prometheus.Metric) {
+	var current *float64
+	if dropped.usable("battery_power") {
+		current = &status.PacTotalW
+	}
+
+	c.mu.Lock()
+	state := c.batteryState(battery.Name)
+	previous := state.lastPowerW
+	state.lastPowerW = current
+	c.mu.Unlock()
+
+	if previous == nil || current == nil || elapsed <= 0 {
+		return
+	}
+	rate, direction := powerRamp(*previous, *current, elapsed)
+	c.gauge(ch, c.powerRampRate, rate, battery.Name)
+	c.gauge(ch, c.powerRampDirection, direction, battery.Name)
+}
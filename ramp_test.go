@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPowerRamp(t *testing.T) {
+	tests := []struct {
+		name          string
+		previousW     float64
+		currentW      float64
+		elapsed       time.Duration
+		wantRate      float64
+		wantDirection float64
+	}{
+		{name: "increasing", previousW: 500, currentW: 2000, elapsed: 30 * time.Second, wantRate: 50, wantDirection: 1},
+		{name: "decreasing", previousW: 1000, currentW: -500, elapsed: 15 * time.Second, wantRate: 100, wantDirection: -1},
+		{name: "stable", previousW: 800, currentW: 800, elapsed: time.Minute, wantRate: 0, wantDirection: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate, direction := powerRamp(tt.previousW, tt.currentW, tt.elapsed)
+			if rate != tt.wantRate || direction != tt.wantDirection {
+				t.Errorf("powerRamp() = %v, %v, want %v, %v", rate, direction, tt.wantRate, tt.wantDirection)
+			}
+		})
+	}
+}
+
+func TestCollector_PowerRamp(t *testing.T) {
+	status := &Status{PacTotalW: 1000}
+	server := newMockBatteryServer(&LatestData{}, status)
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	collector.now = func() time.Time { return now }
+
+	values := func() map[string]float64 {
+		got := map[string]float64{}
+		for _, m := range collectAll(collector) {
+			switch m.Desc() {
+			case collector.powerRampRate:
+				got["rate"] = writeMetric(t, m).GetGauge().GetValue()
+			case collector.powerRampDirection:
+				got["direction"] = writeMetric(t, m).GetGauge().GetValue()
+			}
+		}
+		return got
+	}
+
+	if got := values(); len(got) != 0 {
+		t.Errorf("metrics after one reading = %v, want none", got)
+	}
+
+	// 1000 W down to -2000 W in 20 seconds
+	now = now.Add(20 * time.Second)
+	status.PacTotalW = -2000
+	if got := values(); got["rate"] != 150 || got["direction"] != -1 {
+		t.Errorf("metrics after a 3000 W drop in 20s = %v, want rate 150 and direction -1", got)
+	}
+}
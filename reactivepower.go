@@ -0,0 +1,42 @@
+package main
+
+import (
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// reactivePower returns the reactive and apparent power of the inverter. A
+// reactive power reported by the inverter keeps its sign, negative for
+// capacitive and positive for inductive. Otherwise its magnitude is derived
+// from the apparent and active power, which cannot tell the two apart.
+func reactivePower(activeW float64, data *InverterData) (reactiveVar, apparentVA float64, ok bool) {
+	switch {
+	case data.QacTotal != nil:
+		reactiveVar = *data.QacTotal
+		apparentVA = math.Hypot(activeW, reactiveVar)
+		if data.SacTotal != nil {
+			apparentVA = *data.SacTotal
+		}
+	case data.SacTotal != nil && *data.SacTotal != 0:
+		// Readings taken at slightly different times can put the active
+		// power above the apparent power
+		apparentVA = *data.SacTotal
+		reactiveVar = math.Sqrt(math.Max(0, apparentVA*apparentVA-activeW*activeW))
+	default:
+		return 0, 0, false
+	}
+	return reactiveVar, apparentVA, true
+}
+
+// collectReactivePower emits the reactive and apparent power of the inverter
+// and the angle between active and reactive power
+func (c *Collector) collectReactivePower(battery Battery, status *Status, data *InverterData, ch chan<- prometheus.Metric) {
+	reactiveVar, apparentVA, ok := reactivePower(status.PacTotalW, data)
+	if !ok {
+		return
+	}
+	c.gauge(ch, c.reactivePower, reactiveVar, battery.Name)
+	c.gauge(ch, c.apparentPower, apparentVA, battery.Name)
+	c.gauge(ch, c.powerAngle, math.Atan2(reactiveVar, status.PacTotalW), battery.Name)
+}
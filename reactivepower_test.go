@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestReactivePower(t *testing.T) {
+	value := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name         string
+		activeW      float64
+		data         InverterData
+		wantReactive float64
+		wantApparent float64
+		wantOK       bool
+	}{
+		{name: "reported inductive", activeW: 3000, data: InverterData{QacTotal: value(4000)}, wantReactive: 4000, wantApparent: 5000, wantOK: true},
+		{name: "reported capacitive", activeW: 3000, data: InverterData{QacTotal: value(-4000)}, wantReactive: -4000, wantApparent: 5000, wantOK: true},
+		{name: "reported with apparent power", activeW: 3000, data: InverterData{QacTotal: value(-4000), SacTotal: value(5100)}, wantReactive: -4000, wantApparent: 5100, wantOK: true},
+		{name: "derived from apparent power", activeW: 1000, data: InverterData{SacTotal: value(1250)}, wantReactive: 750, wantApparent: 1250, wantOK: true},
+		{name: "derived while discharging", activeW: -1000, data: InverterData{SacTotal: value(1250)}, wantReactive: 750, wantApparent: 1250, wantOK: true},
+		{name: "active above apparent power", activeW: 2100, data: InverterData{SacTotal: value(2000)}, wantReactive: 0, wantApparent: 2000, wantOK: true},
+		{name: "apparent power zero", activeW: 1000, data: InverterData{SacTotal: value(0)}},
+		{name: "not reported", activeW: 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reactive, apparent, ok := reactivePower(tt.activeW, &tt.data)
+			if ok != tt.wantOK || math.Abs(reactive-tt.wantReactive) > 1e-9 || math.Abs(apparent-tt.wantApparent) > 1e-9 {
+				t.Errorf("reactivePower() = %v, %v, %v, want %v, %v, %v", reactive, apparent, ok, tt.wantReactive, tt.wantApparent, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCollector_PowerAngle(t *testing.T) {
+	value := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name      string
+		activeW   float64
+		reactive  float64
+		wantAngle float64
+	}{
+		{name: "purely active", activeW: 1000, reactive: 0, wantAngle: 0},
+		{name: "purely reactive", activeW: 0, reactive: 500, wantAngle: math.Pi / 2},
+		{name: "capacitive", activeW: 1000, reactive: -1000, wantAngle: -math.Pi / 4},
+		{name: "inductive while discharging", activeW: -1000, reactive: 1000, wantAngle: 3 * math.Pi / 4},
+	}
+
+	collector := NewCollector(nil, CollectorOptions{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := make(chan prometheus.Metric, 10)
+			collector.collectReactivePower(Battery{Name: "test-battery"}, &Status{PacTotalW: tt.activeW}, &InverterData{QacTotal: value(tt.reactive)}, ch)
+			close(ch)
+
+			angle := math.NaN()
+			for m := range ch {
+				if m.Desc() == collector.powerAngle {
+					angle = writeMetric(t, m).GetGauge().GetValue()
+				}
+			}
+			if math.Abs(angle-tt.wantAngle) > 1e-9 {
+				t.Errorf("power angle = %v, want %v", angle, tt.wantAngle)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Readiness modes: whether one or every battery must have been scraped recently
+const (
+	readyAny = "any"
+	readyAll = "all"
+)
+
+// defaultReadyWindow is how recent a successful scrape must be to count as ready
+const defaultReadyWindow = 5 * time.Minute
+
+// failingBatteries returns the names of the batteries without a successful
+// scrape within window, in configuration order, and the number of batteries
+func (c *Collector) failingBatteries(window time.Duration) ([]string, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	failing := []string{}
+	now := c.now()
+	for _, b := range c.batteries {
+		lastSuccess := c.batteryState(b.Name).lastSuccess
+		if lastSuccess.IsZero() || now.Sub(lastSuccess) > window {
+			failing = append(failing, b.Name)
+		}
+	}
+	return failing, len(c.batteries)
+}
+
+// readyHandler serves 200 while any or all batteries, depending on mode, had a
+// successful scrape within window and 503 otherwise. The body lists the
+// failing batteries as JSON. Unlike /health it depends on Prometheus scraping
+// the exporter, as only scrapes update the last success.
+func readyHandler(collector *Collector, mode string, window time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failing, total := collector.failingBatteries(window)
+		ready := len(failing) == 0
+		if mode == readyAny {
+			ready = len(failing) < total
+		}
+
+		status := "ready"
+		w.Header().Set("Content-Type", "application/json")
+		if !ready {
+			status = "not ready"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(struct {
+			Status           string   `json:"status"`
+			FailingBatteries []string `json:"failing_batteries"`
+		}{status, failing})
+	})
+}
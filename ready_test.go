@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadyHandler(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		lastSuccess map[string]time.Duration // Age of the last successful scrape per battery, absent for never
+		mode        string
+		wantStatus  int
+		wantFailing []string
+	}{
+		{
+			name:        "fresh start",
+			mode:        readyAny,
+			wantStatus:  http.StatusServiceUnavailable,
+			wantFailing: []string{"garage", "house"},
+		},
+		{
+			name:        "all healthy",
+			lastSuccess: map[string]time.Duration{"garage": 30 * time.Second, "house": time.Minute},
+			mode:        readyAll,
+			wantStatus:  http.StatusOK,
+			wantFailing: []string{},
+		},
+		{
+			name:        "partial failure with any",
+			lastSuccess: map[string]time.Duration{"garage": 30 * time.Second, "house": time.Hour},
+			mode:        readyAny,
+			wantStatus:  http.StatusOK,
+			wantFailing: []string{"house"},
+		},
+		{
+			name:        "partial failure with all",
+			lastSuccess: map[string]time.Duration{"garage": 30 * time.Second},
+			mode:        readyAll,
+			wantStatus:  http.StatusServiceUnavailable,
+			wantFailing: []string{"house"},
+		},
+		{
+			name:        "full failure",
+			lastSuccess: map[string]time.Duration{"garage": 6 * time.Minute, "house": time.Hour},
+			mode:        readyAny,
+			wantStatus:  http.StatusServiceUnavailable,
+			wantFailing: []string{"garage", "house"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := NewCollector([]Battery{
+				{Name: "garage", Address: "192.0.2.1", AuthToken: "test-token"},
+				{Name: "house", Address: "192.0.2.2", AuthToken: "test-token"},
+			}, CollectorOptions{})
+			collector.now = func() time.Time { return now }
+			for name, age := range tt.lastSuccess {
+				collector.batteryState(name).lastSuccess = now.Add(-age)
+			}
+
+			rec := httptest.NewRecorder()
+			readyHandler(collector, tt.mode, 5*time.Minute).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var body struct {
+				FailingBatteries []string `json:"failing_batteries"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding body %q: %v", rec.Body.String(), err)
+			}
+			if len(body.FailingBatteries) != len(tt.wantFailing) {
+				t.Fatalf("failing batteries = %v, want %v", body.FailingBatteries, tt.wantFailing)
+			}
+			for i := range tt.wantFailing {
+				if body.FailingBatteries[i] != tt.wantFailing[i] {
+					t.Errorf("failing batteries = %v, want %v", body.FailingBatteries, tt.wantFailing)
+				}
+			}
+		})
+	}
+}
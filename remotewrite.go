@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// defaultPushInterval is how often metrics are pushed with remote write
+const defaultPushInterval = 60 * time.Second
+
+// remoteWriter periodically gathers the metrics and pushes them to a
+// Prometheus remote write endpoint, for exporters that cannot be scraped
+type remoteWriter struct {
+	url      string
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	now      func() time.Time
+
+	requests prometheus.Counter
+	errors   prometheus.Counter
+}
+
+// newRemoteWriter returns a remoteWriter pushing the metrics of gatherer to
+// url, giving up on a push after timeout
+func newRemoteWriter(url string, gatherer prometheus.Gatherer, timeout time.Duration) *remoteWriter {
+	return &remoteWriter{
+		url:      url,
+		gatherer: gatherer,
+		client:   &http.Client{Timeout: timeout},
+		now:      time.Now,
+		requests: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "sonnenbatterie_remote_write_requests_total",
+			Help: "Number of remote write requests sent",
+		}),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "sonnenbatterie_remote_write_errors_total",
+			Help: "Number of remote write requests that failed or were rejected",
+		}),
+	}
+}
+
+// run pushes the metrics every interval, forever
+func (w *remoteWriter) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := w.push(context.Background()); err != nil {
+			log.Printf("Remote write failed: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// push gathers the metrics and sends them as one snappy-compressed
+// WriteRequest
+func (w *remoteWriter) push(ctx context.Context) error {
+	families, err := w.gatherer.Gather()
+	if err != nil {
+		// Gather returns what it could collect along with the error
+		log.Printf("Remote write gathered incomplete metrics: %v", err)
+	}
+	req := &prompb.WriteRequest{Timeseries: timeSeries(families, w.now())}
+	data, err := req.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to encode write request: %w", err)
+	}
+
+	w.requests.Inc()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		w.errors.Inc()
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("User-Agent", "sonnenbatterie-exporter/"+version)
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := w.client.Do(httpReq)
+	if err != nil {
+		w.errors.Inc()
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 != 2 {
+		w.errors.Inc()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// timeSeries converts metric families into remote write time series, one per
+// sample as in the text exposition format. Samples without their own
+// timestamp are stamped with now.
+func timeSeries(families []*dto.MetricFamily, now time.Time) []prompb.TimeSeries {
+	var series []prompb.TimeSeries
+	for _, family := range families {
+		name := family.GetName()
+		for _, m := range family.GetMetric() {
+			timestamp := now.UnixMilli()
+			if m.TimestampMs != nil {
+				timestamp = m.GetTimestampMs()
+			}
+			add := func(suffix string, value float64, extra ...string) {
+				labels := []prompb.Label{{Name: "__name__", Value: name + suffix}}
+				for _, lp := range m.GetLabel() {
+					labels = append(labels, prompb.Label{Name: lp.GetName(), Value: lp.GetValue()})
+				}
+				for i := 0; i+1 < len(extra); i += 2 {
+					labels = append(labels, prompb.Label{Name: extra[i], Value: extra[i+1]})
+				}
+				// Remote write requires labels sorted by name
+				sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+				series = append(series, prompb.TimeSeries{
+					Labels:  labels,
+					Samples: []prompb.Sample{{Value: value, Timestamp: timestamp}},
+				})
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				summary := m.GetSummary()
+				for _, q := range summary.GetQuantile() {
+					add("", q.GetValue(), "quantile", formatFloat(q.GetQuantile()))
+				}
+				add("_sum", summary.GetSampleSum())
+				add("_count", float64(summary.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				histogram := m.GetHistogram()
+				infSeen := false
+				for _, b := range histogram.GetBucket() {
+					infSeen = infSeen || math.IsInf(b.GetUpperBound(), 1)
+					add("_bucket", float64(b.GetCumulativeCount()), "le", formatFloat(b.GetUpperBound()))
+				}
+				if !infSeen {
+					add("_bucket", float64(histogram.GetSampleCount()), "le", "+Inf")
+				}
+				add("_sum", histogram.GetSampleSum())
+				add("_count", float64(histogram.GetSampleCount()))
+			}
+		}
+	}
+	return series
+}
+
+// formatFloat formats a quantile or bucket bound like the text exposition format
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Describe implements prometheus.Collector
+func (w *remoteWriter) Describe(ch chan<- *prometheus.Desc) {
+	w.requests.Describe(ch)
+	w.errors.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (w *remoteWriter) Collect(ch chan<- prometheus.Metric) {
+	w.requests.Collect(ch)
+	w.errors.Collect(ch)
+}
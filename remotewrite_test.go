@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestRemoteWriter_Push(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		wantErr    bool
+		wantErrors float64
+	}{
+		{name: "accepted", status: http.StatusNoContent},
+		{name: "rejected", status: http.StatusBadRequest, wantErr: true, wantErrors: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(chan *prompb.WriteRequest, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost {
+					t.Errorf("method = %s, want POST", r.Method)
+				}
+				for header, want := range map[string]string{
+					"Content-Encoding":                  "snappy",
+					"Content-Type":                      "application/x-protobuf",
+					"X-Prometheus-Remote-Write-Version": "0.1.0",
+				} {
+					if got := r.Header.Get(header); got != want {
+						t.Errorf("%s = %q, want %q", header, got, want)
+					}
+				}
+
+				compressed, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Errorf("reading body error = %v", err)
+				}
+				data, err := snappy.Decode(nil, compressed)
+				if err != nil {
+					t.Errorf("body is not snappy-compressed: %v", err)
+				}
+				var req prompb.WriteRequest
+				if err := req.Unmarshal(data); err != nil {
+					t.Errorf("body is not a WriteRequest: %v", err)
+				}
+				received <- &req
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			registry := prometheus.NewRegistry()
+			charge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "sonnenbatterie_charge_level_percent", Help: "test"}, []string{"battery_name"})
+			charge.WithLabelValues("garage").Set(75)
+			registry.MustRegister(charge)
+
+			now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+			writer := newRemoteWriter(server.URL, registry, time.Second)
+			writer.now = func() time.Time { return now }
+
+			err := writer.push(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("push() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			req := <-received
+			if len(req.Timeseries) != 1 {
+				t.Fatalf("time series = %v, want 1", req.Timeseries)
+			}
+			series := req.Timeseries[0]
+			wantLabels := []prompb.Label{{Name: "__name__", Value: "sonnenbatterie_charge_level_percent"}, {Name: "battery_name", Value: "garage"}}
+			if len(series.Labels) != len(wantLabels) ||
+				series.Labels[0].Name != wantLabels[0].Name || series.Labels[0].Value != wantLabels[0].Value ||
+				series.Labels[1].Name != wantLabels[1].Name || series.Labels[1].Value != wantLabels[1].Value {
+				t.Errorf("labels = %v, want %v", series.Labels, wantLabels)
+			}
+			if len(series.Samples) != 1 || series.Samples[0].Value != 75 || series.Samples[0].Timestamp != now.UnixMilli() {
+				t.Errorf("samples = %v, want 75 at %d", series.Samples, now.UnixMilli())
+			}
+
+			if got := testutil.ToFloat64(writer.requests); got != 1 {
+				t.Errorf("remote_write_requests_total = %v, want 1", got)
+			}
+			if got := testutil.ToFloat64(writer.errors); got != tt.wantErrors {
+				t.Errorf("remote_write_errors_total = %v, want %v", got, tt.wantErrors)
+			}
+		})
+	}
+}
+
+func TestTimeSeries_Histogram(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "request_seconds", Help: "test", Buckets: []float64{0.5, 1}})
+	histogram.Observe(0.2)
+	histogram.Observe(2)
+	registry.MustRegister(histogram)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+
+	got := map[string]float64{}
+	for _, series := range timeSeries(families, time.Now()) {
+		key := ""
+		for _, l := range series.Labels {
+			key += l.Name + "=" + l.Value + ";"
+		}
+		got[key] = series.Samples[0].Value
+	}
+	want := map[string]float64{
+		"__name__=request_seconds_bucket;le=0.5;":  1,
+		"__name__=request_seconds_bucket;le=1;":    1,
+		"__name__=request_seconds_bucket;le=+Inf;": 2,
+		"__name__=request_seconds_sum;":            2.2,
+		"__name__=request_seconds_count;":          2,
+	}
+	if len(got) != len(want) {
+		t.Errorf("series = %v, want %v", got, want)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("series %s = %v, want %v", key, got[key], value)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import "log"
+
+// readingBounds is the plausible range of a reading, inclusive
+type readingBounds struct {
+	min, max float64
+}
+
+// Fixed bounds of the readings checked with SanityChecks; power readings use
+// CollectorOptions.MaxPowerW
+var (
+	chargeBounds         = readingBounds{0, 100}
+	acVoltageBounds      = readingBounds{100, 300} // Covers 120 V and 230 V grids
+	batteryVoltageBounds = readingBounds{1, 1000}
+)
+
+// powerBounds returns the plausible range of consumption and production
+func (c *Collector) powerBounds() readingBounds {
+	return readingBounds{0, c.options.MaxPowerW}
+}
+
+// batteryPowerBounds returns the plausible range of the battery power, which
+// is negative while charging
+func (c *Collector) batteryPowerBounds() readingBounds {
+	return readingBounds{-c.options.MaxPowerW, c.options.MaxPowerW}
+}
+
+// droppedReadings holds the readings of a scrape that failed the sanity
+// checks, by metric label. They are neither emitted nor used for any value
+// derived from the scrape.
+type droppedReadings map[string]bool
+
+// usable reports whether none of the readings were dropped
+func (d droppedReadings) usable(readings ...string) bool {
+	for _, r := range readings {
+		if d[r] {
+			return false
+		}
+	}
+	return true
+}
+
+// sanitize checks the readings of a scrape once, right after fetching, and
+// returns the dropped ones. Without status only latestdata was fetched, and
+// its power flows are checked instead, as they are emitted then.
+func (c *Collector) sanitize(battery Battery, latestData *LatestData, status *Status) droppedReadings {
+	dropped := droppedReadings{}
+	check := func(reading string, value float64, bounds readingBounds) {
+		if !c.plausible(battery, reading, value, bounds) {
+			dropped[reading] = true
+		}
+	}
+
+	check("charge_level", float64(latestData.RSOC), chargeBounds)
+	check("user_charge_level", float64(latestData.USOC), chargeBounds)
+	consumptionW, productionW, batteryPowerW := latestData.ConsumptionW, latestData.ProductionW, latestData.PacTotalW
+	if status != nil {
+		consumptionW, productionW, batteryPowerW = status.ConsumptionW, status.ProductionW, status.PacTotalW
+		check("ac_voltage", status.Uac, acVoltageBounds)
+		check("battery_voltage", status.Ubat, batteryVoltageBounds)
+	}
+	check("consumption", consumptionW, c.powerBounds())
+	check("production", productionW, c.powerBounds())
+	check("battery_power", batteryPowerW, c.batteryPowerBounds())
+	return dropped
+}
+
+// plausible reports whether value is within bounds. With SanityChecks enabled
+// an implausible value is logged and counted; without it every value is
+// plausible.
+func (c *Collector) plausible(battery Battery, metric string, value float64, bounds readingBounds) bool {
+	if !c.options.SanityChecks || (value >= bounds.min && value <= bounds.max) {
+		return true
+	}
+	log.Printf("Dropping implausible %s reading of %s: %v outside [%v, %v]", metric, battery.Name, value, bounds.min, bounds.max)
+	c.anomalousReadings.WithLabelValues(battery.Name, metric).Inc()
+	return false
+}
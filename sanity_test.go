@@ -0,0 +1,154 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector_SanityChecks(t *testing.T) {
+	plausibleData := func() (LatestData, Status) {
+		return LatestData{RSOC: 80, USOC: 75, ICStatus: ICStatus{StateBMS: "ready", StateInverter: "running"}},
+			Status{ConsumptionW: 500, ProductionW: 1200, Uac: 230, Ubat: 52}
+	}
+
+	tests := []struct {
+		name        string
+		modify      func(*LatestData, *Status)
+		disabled    bool
+		wantDropped string // Metric name omitted from the scrape, empty if none
+		wantLabel   string // metric label of the anomalous reading counter
+	}{
+		{name: "plausible readings", modify: func(*LatestData, *Status) {}},
+		{
+			name:        "negative consumption",
+			modify:      func(_ *LatestData, s *Status) { s.ConsumptionW = -65535 },
+			wantDropped: "sonnenbatterie_consumption_watts",
+			wantLabel:   "consumption",
+		},
+		{
+			name:        "production above maximum",
+			modify:      func(_ *LatestData, s *Status) { s.ProductionW = 20001 },
+			wantDropped: "sonnenbatterie_production_watts",
+			wantLabel:   "production",
+		},
+		{
+			name:        "charge level above 100%",
+			modify:      func(l *LatestData, _ *Status) { l.RSOC = 300 },
+			wantDropped: "sonnenbatterie_charge_level_percent",
+			wantLabel:   "charge_level",
+		},
+		{
+			name:        "negative user charge level",
+			modify:      func(l *LatestData, _ *Status) { l.USOC = -1 },
+			wantDropped: "sonnenbatterie_user_charge_level_percent",
+			wantLabel:   "user_charge_level",
+		},
+		{
+			name:        "battery power beyond maximum",
+			modify:      func(_ *LatestData, s *Status) { s.PacTotalW = -65535 },
+			wantDropped: "sonnenbatterie_battery_power_watts",
+			wantLabel:   "battery_power",
+		},
+		{
+			name:        "ac voltage out of band",
+			modify:      func(_ *LatestData, s *Status) { s.Uac = 0 },
+			wantDropped: "sonnenbatterie_ac_voltage_volts",
+			wantLabel:   "ac_voltage",
+		},
+		{
+			name:        "battery voltage out of band",
+			modify:      func(_ *LatestData, s *Status) { s.Ubat = 6553.5 },
+			wantDropped: "sonnenbatterie_battery_voltage_volts",
+			wantLabel:   "battery_voltage",
+		},
+		{
+			name:     "disabled keeps raw values",
+			modify:   func(l *LatestData, s *Status) { l.RSOC, s.ConsumptionW = 300, -65535 },
+			disabled: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			latestData, status := plausibleData()
+			tt.modify(&latestData, &status)
+			server := newMockBatteryServer(&latestData, &status)
+			defer server.Close()
+
+			collector := NewCollector(
+				[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+				CollectorOptions{SanityChecks: !tt.disabled, MaxPowerW: 20000},
+			)
+
+			checked := []string{
+				"sonnenbatterie_consumption_watts",
+				"sonnenbatterie_production_watts",
+				"sonnenbatterie_charge_level_percent",
+				"sonnenbatterie_user_charge_level_percent",
+				"sonnenbatterie_battery_power_watts",
+				"sonnenbatterie_ac_voltage_volts",
+				"sonnenbatterie_battery_voltage_volts",
+			}
+			for _, name := range checked {
+				want := 1
+				if name == tt.wantDropped {
+					want = 0
+				}
+				if got := testutil.CollectAndCount(collector, name); got != want {
+					t.Errorf("%s has %d series, want %d", name, got, want)
+				}
+			}
+
+			anomalies := testutil.CollectAndCount(collector.anomalousReadings)
+			if tt.wantLabel == "" {
+				if anomalies != 0 {
+					t.Errorf("anomalous readings has %d series, want 0", anomalies)
+				}
+				return
+			}
+			// One increment per collection above
+			got := testutil.ToFloat64(collector.anomalousReadings.WithLabelValues("test-battery", tt.wantLabel))
+			if anomalies != 1 || got != float64(len(checked)) {
+				t.Errorf("anomalous readings = %d series, %v for %s, want 1 series, %d", anomalies, got, tt.wantLabel, len(checked))
+			}
+		})
+	}
+}
+
+func TestCollector_SanityChecks_DerivedValues(t *testing.T) {
+	latestData := LatestData{RSOC: 80, USOC: 75, FullChargeCapacity: 10000}
+	status := Status{ProductionW: 1200, PacTotalW: 500, BatteryDischarging: true, Uac: 230, Ubat: 52}
+	server := newMockBatteryServer(&latestData, &status)
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{SanityChecks: true, MaxPowerW: 20000, CO2IntensityGPerKWh: 400, SOCJumpThreshold: 10},
+	)
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	collector.now = func() time.Time { return now }
+	testutil.CollectAndCount(collector)
+
+	// A restarting battery reports garbage; nothing may be derived from it.
+	// Each collection below scrapes again, the first one a minute later.
+	latestData.RSOC, status.ProductionW, status.PacTotalW = 300, 65535, 65535
+	now = now.Add(time.Minute)
+	derived := []string{
+		"sonnenbatterie_battery_power_ramp_rate_watts_per_second",
+		"sonnenbatterie_battery_time_to_empty_seconds",
+		"sonnenbatterie_battery_health_score",
+	}
+	for _, name := range derived {
+		if got := testutil.CollectAndCount(collector, name); got != 0 {
+			t.Errorf("%s has %d series, want 0", name, got)
+		}
+	}
+	if got := testutil.ToFloat64(collector.socJumps.WithLabelValues("test-battery")); got != 0 {
+		t.Errorf("charge level jumps = %v, want 0", got)
+	}
+	if got := testutil.CollectAndCount(collector.co2Avoided); got != 0 {
+		t.Errorf("CO2 avoided has %d series, want 0", got)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	selfDischargeWindow     = 6 * time.Hour // Span of charge readings used for the estimate
+	selfDischargeMinSpan    = time.Hour     // Idle time needed before an estimate is reported
+	selfDischargeResolution = 1.0           // Percentage points RSOC is reported in
+	selfDischargeIdleW      = 10.0          // Battery power below which the battery counts as idle
+)
+
+// socSample is a charge reading taken while the battery was idle
+type socSample struct {
+	soc float64 // Relative state of charge in percent
+	at  time.Time
+}
+
+// collectSelfDischarge emits the estimated self-discharge of an idle battery,
+// or -1 while the idle readings do not allow an estimate yet
+func (c *Collector) collectSelfDischarge(battery Battery, latestData *LatestData, status *Status, ch chan<- prometheus.Metric) {
+	watts, ok := c.recordSOC(battery.Name, latestData, status)
+	if !ok {
+		watts = -1
+	}
+	c.gauge(ch, c.selfDischarge, watts, battery.Name)
+}
+
+// recordSOC adds the current charge to the idle window of the battery and
+// returns the self-discharge estimate over that window. Any charging,
+// discharging or battery power clears the window, since the change in charge
+// would no longer be caused by self-discharge alone.
+func (c *Collector) recordSOC(name string, latestData *LatestData, status *Status) (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := c.batteryState(name)
+	if !batteryIdle(status) {
+		state.socSamples = nil
+		return 0, false
+	}
+
+	now := c.now()
+	samples := append(state.socSamples, socSample{soc: float64(latestData.RSOC), at: now})
+	for len(samples) > 0 && now.Sub(samples[0].at) > selfDischargeWindow {
+		samples = samples[1:]
+	}
+	state.socSamples = samples
+
+	return selfDischargeWatts(samples, float64(latestData.FullChargeCapacity))
+}
+
+// batteryIdle reports whether the battery is neither charging nor discharging
+func batteryIdle(status *Status) bool {
+	return !status.BatteryCharging && !status.BatteryDischarging && math.Abs(status.PacTotalW) < selfDischargeIdleW
+}
+
+// selfDischargeWatts fits a line through the charge readings across samples
+// and converts its slope into a power using the full charge capacity in
+// watt-hours. RSOC is a whole percent, so a single step could mean almost any
+// rate: the samples must span selfDischargeMinSpan and the charge must have
+// dropped by more than selfDischargeResolution before an estimate is reported.
+func selfDischargeWatts(samples []socSample, capacityWh float64) (float64, bool) {
+	if len(samples) < 2 || capacityWh <= 0 {
+		return 0, false
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	span := last.at.Sub(first.at)
+	if span < selfDischargeMinSpan || first.soc-last.soc <= selfDischargeResolution {
+		return 0, false
+	}
+
+	// Least squares slope in percentage points per hour
+	var meanHours, meanSOC float64
+	for _, s := range samples {
+		meanHours += s.at.Sub(first.at).Hours()
+		meanSOC += s.soc
+	}
+	meanHours /= float64(len(samples))
+	meanSOC /= float64(len(samples))
+	var covariance, variance float64
+	for _, s := range samples {
+		dx := s.at.Sub(first.at).Hours() - meanHours
+		covariance += dx * (s.soc - meanSOC)
+		variance += dx * dx
+	}
+	slope := covariance / variance
+
+	return max(-slope/100*capacityWh, 0), true
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCollector_SelfDischarge(t *testing.T) {
+	latestData := &LatestData{FullChargeCapacity: 10000}
+	status := &Status{}
+	server := newMockBatteryServer(latestData, status)
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+	now := time.Date(2025, 11, 29, 2, 0, 0, 0, time.UTC)
+	collector.now = func() time.Time { return now }
+
+	// One scrape every 30 minutes while the battery is idle
+	steps := []struct {
+		rsoc        int
+		discharging bool
+		want        float64
+	}{
+		{80, false, -1},
+		{80, false, -1},
+		// A steady charge shows no measurable self-discharge
+		{80, false, -1},
+		// A single step is within the resolution
+		{79, false, -1},
+		{79, false, -1},
+		// Fitted slope of 0.8% per hour of 10 kWh
+		{78, false, 80},
+		// Discharging into the house restarts the estimate
+		{70, true, -1},
+		{70, false, -1},
+	}
+	for i, step := range steps {
+		latestData.RSOC = step.rsoc
+		status.BatteryDischarging = step.discharging
+
+		got := 0.0
+		for _, m := range collectAll(collector) {
+			if m.Desc() == collector.selfDischarge {
+				got = writeMetric(t, m).GetGauge().GetValue()
+			}
+		}
+		if math.Abs(got-step.want) > 1e-9 {
+			t.Errorf("step %d: self discharge = %v, want %v", i, got, step.want)
+		}
+		now = now.Add(30 * time.Minute)
+	}
+}
+
+func TestSelfDischargeWatts(t *testing.T) {
+	start := time.Date(2025, 11, 29, 2, 0, 0, 0, time.UTC)
+	// Hourly readings
+	samples := func(socs ...float64) []socSample {
+		s := make([]socSample, len(socs))
+		for i, soc := range socs {
+			s[i] = socSample{soc: soc, at: start.Add(time.Duration(i) * time.Hour)}
+		}
+		return s
+	}
+
+	tests := []struct {
+		name       string
+		samples    []socSample
+		capacityWh float64
+		want       float64
+		wantOK     bool
+	}{
+		{name: "too short", samples: []socSample{{80, start}, {78, start.Add(5 * time.Minute)}}, capacityWh: 10000},
+		{name: "unknown capacity", samples: samples(80, 79, 78, 77)},
+		{name: "steady charge", samples: samples(80, 80, 80, 80), capacityWh: 10000},
+		{name: "single step", samples: samples(80, 80, 79, 79), capacityWh: 10000},
+		{name: "rising charge", samples: samples(80, 81, 82, 83), capacityWh: 10000},
+		{name: "steady decline", samples: samples(80, 79, 78, 77), capacityWh: 10000, want: 100, wantOK: true},
+		{name: "decline over six hours", samples: samples(80, 80, 79, 79, 79, 78, 78), capacityWh: 5000, want: 17.857142857142858, wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := selfDischargeWatts(tt.samples, tt.capacityWh)
+			if ok != tt.wantOK || math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("selfDischargeWatts() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// selfMonitorInterval is how often the exporter's own runtime stats are read
+const selfMonitorInterval = 30 * time.Second
+
+// selfMonitor exports a few runtime stats of the exporter to spot goroutine
+// and memory leaks without enabling the full Go runtime metrics
+type selfMonitor struct {
+	mu          sync.Mutex
+	lastPauseNs uint64 // PauseTotalNs at the previous update
+
+	goroutines prometheus.Gauge
+	heapBytes  prometheus.Gauge
+	gcPause    prometheus.Counter
+}
+
+// exporterRuntime is registered in main and updated every selfMonitorInterval
+var exporterRuntime = newSelfMonitor()
+
+func newSelfMonitor() *selfMonitor {
+	return &selfMonitor{
+		goroutines: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "sonnenbatterie_exporter_goroutines",
+			Help: "Number of goroutines of the exporter",
+		}),
+		heapBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "sonnenbatterie_exporter_heap_bytes",
+			Help: "Bytes of allocated heap objects of the exporter",
+		}),
+		gcPause: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "sonnenbatterie_exporter_gc_pause_seconds_total",
+			Help: "Cumulative time the exporter was paused for garbage collection",
+		}),
+	}
+}
+
+// update reads the current runtime stats
+func (m *selfMonitor) update() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.goroutines.Set(float64(runtime.NumGoroutine()))
+	m.heapBytes.Set(float64(stats.HeapAlloc))
+	m.gcPause.Add(float64(stats.PauseTotalNs-m.lastPauseNs) / 1e9)
+	m.lastPauseNs = stats.PauseTotalNs
+}
+
+// run updates the stats immediately and then every interval
+func (m *selfMonitor) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.update()
+		<-ticker.C
+	}
+}
+
+// Describe implements prometheus.Collector
+func (m *selfMonitor) Describe(ch chan<- *prometheus.Desc) {
+	m.goroutines.Describe(ch)
+	m.heapBytes.Describe(ch)
+	m.gcPause.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (m *selfMonitor) Collect(ch chan<- prometheus.Metric) {
+	m.goroutines.Collect(ch)
+	m.heapBytes.Collect(ch)
+	m.gcPause.Collect(ch)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSelfMonitor_Update(t *testing.T) {
+	monitor := newSelfMonitor()
+	runtime.GC()
+	monitor.update()
+
+	if got := testutil.ToFloat64(monitor.goroutines); got <= 0 {
+		t.Errorf("goroutines = %v, want > 0", got)
+	}
+	if got := testutil.ToFloat64(monitor.heapBytes); got <= 0 {
+		t.Errorf("heap bytes = %v, want > 0", got)
+	}
+
+	// The counter only grows, also across further collections
+	first := testutil.ToFloat64(monitor.gcPause)
+	runtime.GC()
+	monitor.update()
+	if got := testutil.ToFloat64(monitor.gcPause); got < first {
+		t.Errorf("gc pause = %v after update, want at least %v", got, first)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// defaultShutdownGrace is how long in-flight requests may run after SIGTERM
+const defaultShutdownGrace = 10 * time.Second
+
+// httpListener is a server and the listener it accepts connections on
+type httpListener struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+// serve serves HTTP requests on each listener, over TLS if its server's
+// TLSConfig is set, until a signal arrives on stop. All servers then stop
+// accepting connections together and wait up to grace for in-flight requests;
+// if any are still running, cancel is called to abort their scrapes and the
+// remaining connections are closed. It returns nil after a shutdown and the
+// first server error otherwise, closing the other servers.
+func serve(listeners []httpListener, stop <-chan os.Signal, grace time.Duration, cancel func()) error {
+	served := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			if l.server.TLSConfig != nil {
+				served <- l.server.ServeTLS(l.listener, "", "")
+				return
+			}
+			served <- l.server.Serve(l.listener)
+		}()
+	}
+
+	select {
+	case err := <-served:
+		for _, l := range listeners {
+			_ = l.server.Close()
+		}
+		return err
+	case sig := <-stop:
+		log.Printf("Received %s, shutting down", sig)
+	}
+
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), grace)
+	defer cancelShutdown()
+	shutdown := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() { shutdown <- l.server.Shutdown(ctx) }()
+	}
+	timedOut := false
+	for range listeners {
+		if err := <-shutdown; err != nil {
+			timedOut = true
+		}
+	}
+	if timedOut {
+		log.Printf("Requests still running after %s, cancelling them", grace)
+		cancel()
+		for _, l := range listeners {
+			_ = l.server.Close()
+		}
+	}
+
+	var err error
+	for range listeners {
+		if serveErr := <-served; !errors.Is(serveErr, http.ErrServerClosed) && err == nil {
+			err = serveErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServe_Shutdown(t *testing.T) {
+	tests := []struct {
+		name          string
+		batteryDelay  time.Duration
+		grace         time.Duration
+		wantCompleted bool
+	}{
+		{name: "scrape completes within grace period", batteryDelay: 100 * time.Millisecond, grace: 5 * time.Second, wantCompleted: true},
+		{name: "scrape cancelled after grace period", batteryDelay: time.Minute, grace: 200 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requested := make(chan struct{}, 1)
+			battery := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case requested <- struct{}{}:
+				default:
+				}
+				select {
+				case <-time.After(tt.batteryDelay):
+					_, _ = w.Write([]byte(`{}`))
+				case <-r.Context().Done():
+				}
+			}))
+			defer battery.Close()
+
+			collector := NewCollector(
+				[]Battery{{Name: "slow-battery", Address: battery.URL[7:], AuthToken: "test-token"}},
+				CollectorOptions{},
+			)
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("listen error = %v", err)
+			}
+			stop := make(chan os.Signal, 1)
+			served := make(chan error, 1)
+			go func() {
+				served <- serve([]httpListener{{&http.Server{Handler: metricsHandler(newRegistry(collector, false))}, listener}}, stop, tt.grace, collector.CancelScrapes)
+			}()
+
+			type result struct {
+				body string
+				err  error
+			}
+			scraped := make(chan result, 1)
+			go func() {
+				resp, err := http.Get("http://" + listener.Addr().String())
+				if err != nil {
+					scraped <- result{err: err}
+					return
+				}
+				defer func() { _ = resp.Body.Close() }()
+				body, err := io.ReadAll(resp.Body)
+				scraped <- result{body: string(body), err: err}
+			}()
+
+			// Signal once the scrape is waiting for the battery
+			<-requested
+			start := time.Now()
+			stop <- syscall.SIGTERM
+
+			select {
+			case err := <-served:
+				if err != nil {
+					t.Fatalf("serve() error = %v", err)
+				}
+			case <-time.After(tt.grace + 5*time.Second):
+				t.Fatal("serve() did not return after the grace period")
+			}
+			if elapsed := time.Since(start); elapsed > tt.grace+time.Second {
+				t.Errorf("shutdown took %s, want within the %s grace period", elapsed, tt.grace)
+			}
+
+			got := <-scraped
+			completed := got.err == nil && strings.Contains(got.body, `sonnenbatterie_scrape_success{battery_name="slow-battery"} 1`)
+			if completed != tt.wantCompleted {
+				t.Errorf("scrape completed = %v, want %v (error %v)", completed, tt.wantCompleted, got.err)
+			}
+		})
+	}
+}
+
+func TestServe_ShutdownTogether(t *testing.T) {
+	var listeners []httpListener
+	for range 2 {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen error = %v", err)
+		}
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("OK"))
+		})}
+		listeners = append(listeners, httpListener{server, listener})
+	}
+
+	stop := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(listeners, stop, time.Second, func() {})
+	}()
+
+	for _, l := range listeners {
+		resp, err := http.Get("http://" + l.listener.Addr().String())
+		if err != nil {
+			t.Fatalf("GET %s before shutdown error = %v", l.listener.Addr(), err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	stop <- syscall.SIGTERM
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("serve() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve() did not return after the signal")
+	}
+
+	// A single signal stops both listeners
+	for _, l := range listeners {
+		if resp, err := http.Get("http://" + l.listener.Addr().String()); err == nil {
+			_ = resp.Body.Close()
+			t.Errorf("GET %s after shutdown succeeded, want connection refused", l.listener.Addr())
+		}
+	}
+}
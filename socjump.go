@@ -0,0 +1,27 @@
+package main
+
+import "math"
+
+// collectSOCJump counts a charge level change of more than SOCJumpThreshold
+// percentage points since the previous scrape. Only consecutive successful
+// scrapes with plausible charge levels are compared, as a failure or an
+// implausible reading clears the previous charge level.
+func (c *Collector) collectSOCJump(battery Battery, latestData *LatestData, dropped droppedReadings) {
+	if c.options.SOCJumpThreshold <= 0 {
+		return
+	}
+	var current *int
+	if dropped.usable("charge_level") {
+		current = &latestData.RSOC
+	}
+
+	c.mu.Lock()
+	state := c.batteryState(battery.Name)
+	previous := state.lastRSOC
+	state.lastRSOC = current
+	c.mu.Unlock()
+
+	if previous != nil && current != nil && math.Abs(float64(*current-*previous)) > c.options.SOCJumpThreshold {
+		c.socJumps.WithLabelValues(battery.Name).Inc()
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector_SOCJump(t *testing.T) {
+	type step struct {
+		rsoc int
+		fail bool
+	}
+	tests := []struct {
+		name      string
+		threshold float64
+		steps     []step
+		want      float64
+	}{
+		{
+			name:      "steady charging",
+			threshold: 10,
+			steps:     []step{{50, false}, {55, false}, {60, false}, {70, false}},
+			want:      0,
+		},
+		{
+			name:      "jumps up and down",
+			threshold: 10,
+			steps:     []step{{50, false}, {80, false}, {79, false}, {40, false}},
+			want:      2,
+		},
+		{
+			name:      "failed scrape in between",
+			threshold: 10,
+			steps:     []step{{50, false}, {0, true}, {80, false}, {81, false}},
+			want:      0,
+		},
+		{
+			name:      "jump after recovery",
+			threshold: 10,
+			steps:     []step{{50, false}, {0, true}, {80, false}, {60, false}},
+			want:      1,
+		},
+		{
+			name:      "lower threshold",
+			threshold: 3,
+			steps:     []step{{50, false}, {55, false}, {57, false}},
+			want:      1,
+		},
+		{
+			name:      "disabled",
+			threshold: 0,
+			steps:     []step{{0, false}, {100, false}},
+			want:      0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			latestData := &LatestData{}
+			failing := false
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if failing {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				switch r.URL.Path {
+				case "/api/v2/latestdata":
+					_ = json.NewEncoder(w).Encode(latestData)
+				case "/api/v2/status":
+					_ = json.NewEncoder(w).Encode(Status{})
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			collector := NewCollector(
+				[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+				CollectorOptions{SOCJumpThreshold: tt.threshold},
+			)
+			for _, s := range tt.steps {
+				latestData.RSOC = s.rsoc
+				failing = s.fail
+				collectAll(collector)
+			}
+
+			if got := testutil.ToFloat64(collector.socJumps.WithLabelValues("test-battery")); got != tt.want {
+				t.Errorf("soc jumps = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// cachePayloads keeps the payloads of a successful scrape for emitStale
+func (c *Collector) cachePayloads(battery Battery, latestData *LatestData, status *Status, dropped droppedReadings, ch chan<- prometheus.Metric) {
+	if c.options.StaleTTL <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	state := c.batteryState(battery.Name)
+	state.lastLatestData, state.lastStatus, state.lastDropped = latestData, status, dropped
+	c.mu.Unlock()
+
+	c.gauge(ch, c.dataStale, 0, battery.Name)
+}
+
+// emitStale re-emits the values of the last successful scrape after a failed
+// one, as long as it is no older than StaleTTL. Scrape success stays 0, so
+// the outage remains visible while graphs and alerts on the values do not gap.
+// It reports whether the values were re-emitted.
+func (c *Collector) emitStale(battery Battery, ch chan<- prometheus.Metric) bool {
+	if c.options.StaleTTL <= 0 {
+		return false
+	}
+
+	c.mu.Lock()
+	state := c.batteryState(battery.Name)
+	latestData, status, dropped, configurations := state.lastLatestData, state.lastStatus, state.lastDropped, state.configurations
+	fresh := latestData != nil && c.now().Sub(state.lastSuccess) <= c.options.StaleTTL
+	if !fresh {
+		state.lastLatestData, state.lastStatus, state.lastDropped = nil, nil, nil
+	}
+	c.mu.Unlock()
+
+	if !fresh {
+		c.gauge(ch, c.dataStale, 0, battery.Name)
+		return false
+	}
+
+	if configurations == nil {
+		configurations = &Configurations{}
+	}
+	c.gauge(ch, c.dataStale, 1, battery.Name)
+	labels := c.valueLabelValues(battery, latestData)
+	c.emitLatestData(battery, latestData, configurations, labels, dropped, ch)
+	c.emitStatus(battery, status, labels, dropped, ch)
+	return true
+}
+
+// emitCachedInfo emits sonnenbatterie_info with the labels of the last
+// successful scrape after a failed one, so that joins on it keep working.
+// The labels are served for StaleTTL, or for as long as the battery fails
+// with KeepInfo.
+func (c *Collector) emitCachedInfo(battery Battery, ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	state := c.batteryState(battery.Name)
+	labels := state.infoLabels
+	fresh := c.options.KeepInfo || (c.options.StaleTTL > 0 && c.now().Sub(state.lastSuccess) <= c.options.StaleTTL)
+	c.mu.Unlock()
+
+	if labels != nil && fresh {
+		c.gauge(ch, c.info, 1, labels...)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCollector_StaleTTL(t *testing.T) {
+	latestData := LatestData{RSOC: 80, ICStatus: ICStatus{StateBMS: "ready", StateInverter: "running"}}
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v2/latestdata":
+			_ = json.NewEncoder(w).Encode(latestData)
+		case "/api/v2/status":
+			_ = json.NewEncoder(w).Encode(Status{ConsumptionW: 500})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{StaleTTL: 5 * time.Minute},
+	)
+	now := time.Date(2025, 11, 29, 3, 0, 0, 0, time.UTC)
+	collector.now = func() time.Time { return now }
+
+	steps := []struct {
+		name        string
+		advance     time.Duration
+		fail        bool
+		rsoc        int
+		wantStale   float64
+		wantSuccess float64
+		wantCharge  float64 // -1 if the charge level must be omitted
+	}{
+		{name: "success", rsoc: 80, wantStale: 0, wantSuccess: 1, wantCharge: 80},
+		{name: "within TTL", advance: 90 * time.Second, fail: true, wantStale: 1, wantSuccess: 0, wantCharge: 80},
+		{name: "at TTL", advance: 210 * time.Second, fail: true, wantStale: 1, wantSuccess: 0, wantCharge: 80},
+		{name: "beyond TTL", advance: time.Second, fail: true, wantStale: 0, wantSuccess: 0, wantCharge: -1},
+		{name: "recovery", advance: time.Minute, rsoc: 78, wantStale: 0, wantSuccess: 1, wantCharge: 78},
+		{name: "within TTL after recovery", advance: time.Minute, fail: true, wantStale: 1, wantSuccess: 0, wantCharge: 78},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			now = now.Add(step.advance)
+			failing = step.fail
+			latestData.RSOC = step.rsoc
+
+			stale, success, charge, consumption := -1.0, -1.0, -1.0, -1.0
+			for _, m := range collectAll(collector) {
+				switch m.Desc() {
+				case collector.dataStale:
+					stale = writeMetric(t, m).GetGauge().GetValue()
+				case collector.scrapeSuccess:
+					success = writeMetric(t, m).GetGauge().GetValue()
+				case collector.chargeLevel:
+					charge = writeMetric(t, m).GetGauge().GetValue()
+				case collector.consumption:
+					consumption = writeMetric(t, m).GetGauge().GetValue()
+				}
+			}
+			if stale != step.wantStale || success != step.wantSuccess || charge != step.wantCharge {
+				t.Errorf("data_stale = %v, scrape_success = %v, charge level = %v, want %v, %v, %v",
+					stale, success, charge, step.wantStale, step.wantSuccess, step.wantCharge)
+			}
+			// Status values are served from the cache along with latestdata
+			if wantConsumption := map[bool]float64{true: 500, false: -1}[step.wantCharge >= 0]; consumption != wantConsumption {
+				t.Errorf("consumption = %v, want %v", consumption, wantConsumption)
+			}
+		})
+	}
+}
+
+func TestCollector_CachedInfo(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  CollectorOptions
+		neverUp  bool
+		advance  time.Duration
+		wantInfo bool
+	}{
+		{name: "no cache by default", wantInfo: false},
+		{name: "within stale TTL", options: CollectorOptions{StaleTTL: 5 * time.Minute}, advance: time.Minute, wantInfo: true},
+		{name: "beyond stale TTL", options: CollectorOptions{StaleTTL: 5 * time.Minute}, advance: 10 * time.Minute, wantInfo: false},
+		{name: "keep info", options: CollectorOptions{KeepInfo: true}, advance: 24 * time.Hour, wantInfo: true},
+		{name: "keep info without success", options: CollectorOptions{KeepInfo: true}, neverUp: true, wantInfo: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failing := tt.neverUp
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if failing {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				switch r.URL.Path {
+				case "/api/v2/latestdata":
+					_ = json.NewEncoder(w).Encode(LatestData{ICStatus: ICStatus{StateBMS: "ready"}})
+				case "/api/v2/status":
+					_ = json.NewEncoder(w).Encode(Status{})
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			collector := NewCollector(
+				[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+				tt.options,
+			)
+			now := time.Date(2025, 11, 29, 3, 0, 0, 0, time.UTC)
+			collector.now = func() time.Time { return now }
+
+			collectAll(collector)
+			now = now.Add(tt.advance)
+			failing = true
+
+			var info []string
+			for _, m := range collectAll(collector) {
+				if m.Desc() == collector.info {
+					info = append(info, labelValue(writeMetric(t, m), "bms_state"))
+				}
+			}
+			if tt.wantInfo {
+				if len(info) != 1 || info[0] != "ready" {
+					t.Errorf("info bms_state = %v, want [ready]", info)
+				}
+			} else if len(info) != 0 {
+				t.Errorf("info emitted with bms_state %v, want none", info)
+			}
+		})
+	}
+}
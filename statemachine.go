@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// combinedState joins the BMS, core control module and inverter states into
+// the state of the battery as a whole, e.g. "ready/ongrid/running"
+func combinedState(bms, coreControl, inverter string) string {
+	return bms + "/" + coreControl + "/" + inverter
+}
+
+// collectStateMachine emits the combined ICStatus state and counts changes
+// from the last known one. As with the core control module, serving the same
+// data again never counts as a change.
+func (c *Collector) collectStateMachine(battery Battery, icStatus ICStatus, ch chan<- prometheus.Metric) {
+	states := c.guard.Check("sonnenbatterie_battery_state_machine_info",
+		icStatus.StateBMS,
+		icStatus.StateCoreControlModule,
+		icStatus.StateInverter,
+	)
+	combined := combinedState(states[0], states[1], states[2])
+
+	c.mu.Lock()
+	state := c.batteryState(battery.Name)
+	previous := state.combinedState
+	state.combinedState = combined
+	c.mu.Unlock()
+
+	if previous != "" && combined != previous {
+		c.stateTransitions.WithLabelValues(battery.Name, previous, combined).Inc()
+	}
+	c.gauge(ch, c.stateMachineInfo, 1, battery.Name, states[0], states[1], states[2], combined)
+}
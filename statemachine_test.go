@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector_StateMachine(t *testing.T) {
+	latestData := &LatestData{}
+	server := newMockBatteryServer(latestData, &Status{})
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+
+	steps := []struct {
+		icStatus     ICStatus
+		wantCombined string
+	}{
+		{icStatus: ICStatus{StateBMS: "ready", StateCoreControlModule: "ongrid", StateInverter: "running"}, wantCombined: "ready/ongrid/running"},
+		{icStatus: ICStatus{StateBMS: "ready", StateCoreControlModule: "offgrid", StateInverter: "running"}, wantCombined: "ready/offgrid/running"},
+		{icStatus: ICStatus{StateBMS: "error", StateCoreControlModule: "offgrid", StateInverter: "stopped"}, wantCombined: "error/offgrid/stopped"},
+	}
+	for i, step := range steps {
+		latestData.ICStatus = step.icStatus
+
+		combined := ""
+		for _, m := range collectAll(collector) {
+			if m.Desc() == collector.stateMachineInfo {
+				pb := writeMetric(t, m)
+				combined = labelValue(pb, "combined_state")
+				if got := labelValue(pb, "bms_state"); got != step.icStatus.StateBMS {
+					t.Errorf("step %d: bms_state = %q, want %q", i, got, step.icStatus.StateBMS)
+				}
+			}
+		}
+		if combined != step.wantCombined {
+			t.Errorf("step %d: combined_state = %q, want %q", i, combined, step.wantCombined)
+		}
+	}
+
+	// The first reading is not a transition
+	if got := testutil.CollectAndCount(collector.stateTransitions); got != 2 {
+		t.Errorf("transition series = %d, want 2", got)
+	}
+	transitions := []struct{ from, to string }{
+		{"ready/ongrid/running", "ready/offgrid/running"},
+		{"ready/offgrid/running", "error/offgrid/stopped"},
+	}
+	for _, tr := range transitions {
+		if got := testutil.ToFloat64(collector.stateTransitions.WithLabelValues("test-battery", tr.from, tr.to)); got != 1 {
+			t.Errorf("transitions from %s to %s = %v, want 1", tr.from, tr.to, got)
+		}
+	}
+}
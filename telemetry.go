@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// newRegistries returns the registry served on /metrics of the main listener
+// and, for a separate telemetry listener, the one with the exporter-internal
+// metrics: its own runtime and, if runtimeMetrics is set, the Go runtime and
+// process collectors. Without a separate listener, the main registry holds
+// all metrics and the internal one is nil.
+func newRegistries(collector *Collector, runtimeMetrics, separate bool) (*prometheus.Registry, *prometheus.Registry) {
+	if !separate {
+		return newRegistry(collector, runtimeMetrics), nil
+	}
+	registry := prometheus.NewRegistry()
+	registerBatteryMetrics(registry, collector)
+	internal := prometheus.NewRegistry()
+	registerInternalMetrics(internal, runtimeMetrics)
+	return registry, internal
+}
+
+// registerInternalMetrics adds the metrics about the exporter process itself
+// to registry
+func registerInternalMetrics(registry *prometheus.Registry, runtimeMetrics bool) {
+	registry.MustRegister(exporterRuntime)
+	if runtimeMetrics {
+		registry.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+	}
+}
+
+// registerTelemetry adds the exporter-internal endpoints to mux: the
+// troubleshooting details on /debug and, if batteryDebug is set,
+// /debug/battery/{name}. On a separate telemetry listener internal is non-nil
+// and served on /metrics, along with pprof under /debug/pprof/; neither
+// pprof nor the internal metrics are ever served on the main listener then.
+func registerTelemetry(mux *http.ServeMux, internal *prometheus.Registry, collector *Collector, batteryDebug bool) {
+	mux.Handle("/debug", debugHandler(collector))
+	if batteryDebug {
+		mux.Handle("GET /debug/battery/{name}", batteryDebugHandler(collector))
+	}
+	if internal == nil {
+		return
+	}
+
+	mux.Handle("/metrics", metricsHandler(internal))
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
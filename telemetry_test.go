@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEndpointPlacement(t *testing.T) {
+	type endpoint struct {
+		path       string
+		wantStatus int
+		want       []string // Substrings of the body
+		notWant    []string
+	}
+	tests := []struct {
+		name      string
+		separate  bool
+		main      []endpoint
+		telemetry []endpoint
+	}{
+		{
+			name: "single listener",
+			main: []endpoint{
+				{path: "/metrics", wantStatus: http.StatusOK, want: []string{"sonnenbatterie_exporter_build_info{", "\nsonnenbatterie_exporter_goroutines ", "\ngo_goroutines "}},
+				{path: "/health", wantStatus: http.StatusOK},
+				{path: "/ready", wantStatus: http.StatusServiceUnavailable},
+				{path: "/", wantStatus: http.StatusOK, want: []string{"<table"}},
+				{path: "/debug", wantStatus: http.StatusOK, want: []string{"decode_errors"}},
+				{path: "/debug/pprof/", wantStatus: http.StatusNotFound},
+			},
+		},
+		{
+			name:     "separate telemetry listener",
+			separate: true,
+			main: []endpoint{
+				{path: "/metrics", wantStatus: http.StatusOK, want: []string{"sonnenbatterie_exporter_build_info{"}, notWant: []string{"sonnenbatterie_exporter_goroutines", "go_goroutines"}},
+				{path: "/health", wantStatus: http.StatusOK},
+				{path: "/ready", wantStatus: http.StatusServiceUnavailable},
+				{path: "/", wantStatus: http.StatusOK, want: []string{"<table"}},
+				{path: "/debug", wantStatus: http.StatusNotFound},
+				{path: "/debug/battery/test-battery", wantStatus: http.StatusNotFound},
+				{path: "/debug/pprof/", wantStatus: http.StatusNotFound},
+			},
+			telemetry: []endpoint{
+				{path: "/metrics", wantStatus: http.StatusOK, want: []string{"\nsonnenbatterie_exporter_goroutines ", "\ngo_goroutines "}, notWant: []string{"sonnenbatterie_exporter_build_info"}},
+				{path: "/debug", wantStatus: http.StatusOK, want: []string{"decode_errors"}},
+				{path: "/debug/pprof/", wantStatus: http.StatusOK, want: []string{"goroutine"}},
+				{path: "/health", wantStatus: http.StatusNotFound},
+				{path: "/ready", wantStatus: http.StatusNotFound},
+				{path: "/", wantStatus: http.StatusNotFound},
+			},
+		},
+	}
+
+	check := func(t *testing.T, listener string, mux *http.ServeMux, endpoints []endpoint) {
+		t.Helper()
+		for _, e := range endpoints {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, e.path, nil))
+			if rec.Code != e.wantStatus {
+				t.Errorf("%s listener GET %s status = %d, want %d", listener, e.path, rec.Code, e.wantStatus)
+				continue
+			}
+			body := rec.Body.String()
+			for _, s := range e.want {
+				if !strings.Contains(body, s) {
+					t.Errorf("%s listener GET %s does not contain %q", listener, e.path, s)
+				}
+			}
+			for _, s := range e.notWant {
+				if strings.Contains(body, s) {
+					t.Errorf("%s listener GET %s contains %q", listener, e.path, s)
+				}
+			}
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// No batteries, so nothing is queried and /ready fails
+			collector := NewCollector(nil, CollectorOptions{})
+			registry, internal := newRegistries(collector, true, tt.separate)
+
+			mux := http.NewServeMux()
+			registerEndpoints(mux, registry, collector, CollectorOptions{}, nil, readyAny, defaultReadyWindow, true)
+			telemetryMux := mux
+			if tt.separate {
+				telemetryMux = http.NewServeMux()
+			}
+			registerTelemetry(telemetryMux, internal, collector, true)
+
+			check(t, "main", mux, tt.main)
+			check(t, "telemetry", telemetryMux, tt.telemetry)
+		})
+	}
+}
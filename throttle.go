@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrapeLockWait is how long a scrape waits for the previous scrape of the
+// same battery to finish before it is counted as missed
+const scrapeLockWait = 100 * time.Millisecond
+
+// collectExclusive scrapes the battery unless its previous scrape is still
+// running after scrapeLockWait, e.g. because Prometheus started the next scrape
+// before a slow battery answered. The overlapping scrape is counted as missed
+// and serves the metrics and reading of the last completed scrape again, so
+// the battery is never queried twice at the same time and its up metrics do
+// not vanish meanwhile.
+func (c *Collector) collectExclusive(ctx context.Context, battery Battery, ch chan<- prometheus.Metric) *batteryReading {
+	c.mu.Lock()
+	state := c.batteryState(battery.Name)
+	if state.scraping == nil {
+		state.scraping = make(chan struct{}, 1)
+	}
+	scraping := state.scraping
+	c.mu.Unlock()
+
+	select {
+	case scraping <- struct{}{}:
+	case <-time.After(scrapeLockWait):
+		c.mu.Lock()
+		started := c.batteryState(battery.Name).scrapeStarted
+		c.mu.Unlock()
+		log.Printf("Skipping scrape of %s, the previous scrape is still running since %s", battery.Name, started.Format(time.RFC3339))
+		c.scrapeMissed.WithLabelValues(battery.Name).Inc()
+		return c.serveCached(battery, ch)
+	}
+	defer func() { <-scraping }()
+
+	c.mu.Lock()
+	c.batteryState(battery.Name).scrapeStarted = c.now()
+	c.mu.Unlock()
+	return c.collectThrottled(ctx, battery, ch)
+}
+
+// collectThrottled scrapes the battery unless its last scrape was less than
+// MinScrapeInterval ago, in which case the metrics and readings of that scrape
+// are served again. The results of every actual scrape are kept for that and
+// for missed scrapes.
+func (c *Collector) collectThrottled(ctx context.Context, battery Battery, ch chan<- prometheus.Metric) *batteryReading {
+	if battery.MinScrapeInterval > 0 {
+		c.mu.Lock()
+		last := c.batteryState(battery.Name).lastActualScrape
+		c.mu.Unlock()
+
+		if !last.IsZero() && c.now().Sub(last) < battery.MinScrapeInterval {
+			c.scrapeThrottled.WithLabelValues(battery.Name).Inc()
+			return c.serveCached(battery, ch)
+		}
+	}
+
+	// Forward the metrics while keeping a copy for throttled and missed scrapes
+	now := c.now()
+	metrics := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	var sent []prometheus.Metric
+	go func() {
+		defer close(done)
+		for m := range metrics {
+			sent = append(sent, m)
+			ch <- m
+		}
+	}()
+	reading := c.collectBattery(ctx, battery, metrics)
+	close(metrics)
+	<-done
+
+	c.mu.Lock()
+	state := c.batteryState(battery.Name)
+	state.lastActualScrape, state.cachedMetrics, state.cachedReading = now, sent, reading
+	c.mu.Unlock()
+
+	if battery.MinScrapeInterval > 0 {
+		c.gauge(ch, c.lastActualScrape, float64(now.UnixNano())/1e9, battery.Name)
+	}
+	return reading
+}
+
+// serveCached sends the metrics of the last actual scrape of the battery again
+// and returns its reading, nil if the battery has not been scraped yet
+func (c *Collector) serveCached(battery Battery, ch chan<- prometheus.Metric) *batteryReading {
+	c.mu.Lock()
+	state := c.batteryState(battery.Name)
+	last, cached, cachedReading := state.lastActualScrape, state.cachedMetrics, state.cachedReading
+	c.mu.Unlock()
+
+	for _, m := range cached {
+		ch <- m
+	}
+	if battery.MinScrapeInterval > 0 && !last.IsZero() {
+		c.gauge(ch, c.lastActualScrape, float64(last.UnixNano())/1e9, battery.Name)
+	}
+	return cachedReading
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector_MinScrapeInterval(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/latestdata":
+			requests.Add(1)
+			_ = json.NewEncoder(w).Encode(LatestData{RSOC: 80})
+		case "/api/v2/status":
+			_ = json.NewEncoder(w).Encode(Status{})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token", MinScrapeInterval: 30 * time.Second}},
+		CollectorOptions{},
+	)
+	start := time.Date(2025, 11, 29, 12, 0, 0, 0, time.UTC)
+	now := start
+	collector.now = func() time.Time { return now }
+
+	steps := []struct {
+		advance       time.Duration
+		wantRequests  int32
+		wantThrottled float64
+		wantLast      time.Time
+	}{
+		{0, 1, 0, start},
+		{10 * time.Second, 1, 1, start},
+		{10 * time.Second, 1, 2, start},
+		{15 * time.Second, 2, 2, start.Add(35 * time.Second)},
+	}
+	var firstCount int
+	for i, step := range steps {
+		now = now.Add(step.advance)
+		metrics := collectAll(collector)
+
+		last := 0.0
+		for _, m := range metrics {
+			if m.Desc() == collector.lastActualScrape {
+				last = writeMetric(t, m).GetGauge().GetValue()
+			}
+		}
+		if got := requests.Load(); got != step.wantRequests {
+			t.Errorf("step %d: %d latestdata requests, want %d", i, got, step.wantRequests)
+		}
+		if got := testutil.ToFloat64(collector.scrapeThrottled.WithLabelValues("test-battery")); got != step.wantThrottled {
+			t.Errorf("step %d: throttled scrapes = %v, want %v", i, got, step.wantThrottled)
+		}
+		if want := float64(step.wantLast.Unix()); last != want {
+			t.Errorf("step %d: last actual scrape = %v, want %v", i, last, want)
+		}
+
+		// Throttled scrapes serve the same series as the scrape they repeat,
+		// apart from the throttled counter appearing after the first one
+		throttled := i > 0 && step.wantThrottled > steps[i-1].wantThrottled
+		if i == 0 {
+			firstCount = len(metrics)
+		} else if throttled && len(metrics) != firstCount+1 {
+			t.Errorf("step %d: %d metrics, want %d", i, len(metrics), firstCount+1)
+		}
+	}
+}
+
+func TestCollector_ScrapeMissed(t *testing.T) {
+	var requests atomic.Int32
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/latestdata":
+			if requests.Add(1) == 1 {
+				started <- struct{}{}
+				<-release
+			}
+			_ = json.NewEncoder(w).Encode(LatestData{RSOC: 80})
+		case "/api/v2/status":
+			_ = json.NewEncoder(w).Encode(Status{})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "slow", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+
+	// The first scrape hangs in latestdata until released
+	first := make(chan struct{})
+	go func() {
+		defer close(first)
+		collectAll(collector)
+	}()
+	<-started
+
+	// The overlapping scrape gives up without querying the battery
+	for _, m := range collectAll(collector) {
+		if m.Desc() == collector.scrapeSuccess {
+			t.Error("overlapping scrape emitted scrape_success")
+		}
+	}
+	if got := testutil.ToFloat64(collector.scrapeMissed.WithLabelValues("slow")); got != 1 {
+		t.Errorf("scrape_missed_total = %v, want 1", got)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("latestdata requests = %d, want 1", got)
+	}
+
+	close(release)
+	<-first
+
+	// Once the first scrape is done the battery is scraped again
+	collectAll(collector)
+	if got := testutil.ToFloat64(collector.scrapeMissed.WithLabelValues("slow")); got != 1 {
+		t.Errorf("scrape_missed_total after the first scrape finished = %v, want 1", got)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("latestdata requests = %d, want 2", got)
+	}
+}
+
+func TestCollector_ScrapeMissedServesLastScrape(t *testing.T) {
+	var hang atomic.Bool
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/latestdata":
+			if hang.Swap(false) {
+				started <- struct{}{}
+				<-release
+			}
+			_ = json.NewEncoder(w).Encode(LatestData{RSOC: 80})
+		case "/api/v2/status":
+			_ = json.NewEncoder(w).Encode(Status{})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector(
+		[]Battery{{Name: "slow", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{},
+	)
+
+	// up and the reachable count of one scrape
+	scrape := func() (up, reachable float64, count int) {
+		up, reachable = -1, -1
+		metrics := collectAll(collector)
+		for _, m := range metrics {
+			switch m.Desc() {
+			case collector.up:
+				up = writeMetric(t, m).GetGauge().GetValue()
+			case collector.reachableBatteries:
+				reachable = writeMetric(t, m).GetGauge().GetValue()
+			}
+		}
+		return up, reachable, len(metrics)
+	}
+
+	up, reachable, firstCount := scrape()
+	if up != 1 || reachable != 1 {
+		t.Fatalf("first scrape: up = %v, reachable = %v, want 1 and 1", up, reachable)
+	}
+
+	// The next scrape hangs in latestdata until released
+	hang.Store(true)
+	second := make(chan struct{})
+	go func() {
+		defer close(second)
+		collectAll(collector)
+	}()
+	<-started
+
+	// Overlapping scrapes repeat the completed scrape, plus the missed counter
+	for i := 0; i < 2; i++ {
+		up, reachable, count := scrape()
+		if up != 1 || reachable != 1 {
+			t.Errorf("overlapping scrape %d: up = %v, reachable = %v, want 1 and 1", i, up, reachable)
+		}
+		if count != firstCount+1 {
+			t.Errorf("overlapping scrape %d: %d metrics, want %d", i, count, firstCount+1)
+		}
+	}
+	if got := testutil.ToFloat64(collector.scrapeMissed.WithLabelValues("slow")); got != 2 {
+		t.Errorf("scrape_missed_total = %v, want 2", got)
+	}
+
+	close(release)
+	<-second
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultPowerEMAAlpha is the weight of the newest reading in the smoothed
+// battery power
+const defaultPowerEMAAlpha = 0.1
+
+// maxTimeRemaining caps the time to empty and full, which grows without bound
+// as the power approaches zero
+const maxTimeRemaining = 999999
+
+// powerEMA is an exponential moving average of the battery power while
+// charging or discharging. It restarts whenever the charge state changes, so
+// charging power is never averaged into discharging power.
+type powerEMA struct {
+	state  string  // Charge state the average belongs to, "" until seen
+	powerW float64 // Smoothed absolute battery power
+}
+
+// add folds a reading taken in state into the average and returns it
+func (e *powerEMA) add(state string, powerW, alpha float64) float64 {
+	powerW = math.Abs(powerW)
+	if state != e.state {
+		e.state, e.powerW = state, powerW
+		return powerW
+	}
+	e.powerW += alpha * (powerW - e.powerW)
+	return e.powerW
+}
+
+// timeRemaining returns the seconds to move energyWh at powerW, capped at
+// maxTimeRemaining, or -1 without power
+func timeRemaining(energyWh, powerW float64) float64 {
+	if powerW <= 0 {
+		return -1
+	}
+	return math.Min(math.Max(energyWh, 0)*3600/powerW, maxTimeRemaining)
+}
+
+// collectTimeRemaining emits the time to empty while discharging and the time
+// to full while charging, based on the smoothed battery power. The other
+// metric, and both while idle, are -1.
+func (c *Collector) collectTimeRemaining(battery Battery, latestData *LatestData, status *Status, ch chan<- prometheus.Metric) {
+	alpha := c.options.PowerEMAAlpha
+	if alpha == 0 {
+		alpha = defaultPowerEMAAlpha
+	}
+	state, _ := chargeState(status)
+
+	c.mu.Lock()
+	powerW := c.batteryState(battery.Name).powerEMA.add(state, status.PacTotalW, alpha)
+	c.mu.Unlock()
+
+	capacityWh := float64(latestData.FullChargeCapacity)
+	remainingWh := capacityWh * float64(latestData.RSOC) / 100
+	toEmpty, toFull := -1.0, -1.0
+	switch state {
+	case "discharging":
+		toEmpty = timeRemaining(remainingWh, powerW)
+	case "charging":
+		toFull = timeRemaining(capacityWh-remainingWh, powerW)
+	}
+	c.gauge(ch, c.timeToEmpty, toEmpty, battery.Name)
+	c.gauge(ch, c.timeToFull, toFull, battery.Name)
+}
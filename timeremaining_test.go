@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPowerEMA(t *testing.T) {
+	var ema powerEMA
+	if got := ema.add("discharging", 3000, 0.1); got != 3000 {
+		t.Errorf("first reading = %v, want 3000", got)
+	}
+
+	// Converges to a steady reading
+	var got float64
+	for i := 0; i < 200; i++ {
+		got = ema.add("discharging", 1000, 0.1)
+	}
+	if math.Abs(got-1000) > 0.01 {
+		t.Errorf("after 200 readings of 1000 W = %v, want 1000", got)
+	}
+
+	// A change of charge state restarts the average
+	if got := ema.add("charging", -2500, 0.1); got != 2500 {
+		t.Errorf("first charging reading = %v, want 2500", got)
+	}
+	if got := ema.add("charging", -1500, 0.1); got != 2400 {
+		t.Errorf("second charging reading = %v, want 2400", got)
+	}
+}
+
+func TestTimeRemaining(t *testing.T) {
+	tests := []struct {
+		name     string
+		energyWh float64
+		powerW   float64
+		want     float64
+	}{
+		{name: "half capacity to fill at 2.5 kW", energyWh: 5000, powerW: 2500, want: 7200},
+		{name: "full battery", energyWh: 0, powerW: 1000, want: 0},
+		{name: "capped", energyWh: 10000, powerW: 1, want: maxTimeRemaining},
+		{name: "no power", energyWh: 5000, powerW: 0, want: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := timeRemaining(tt.energyWh, tt.powerW); got != tt.want {
+				t.Errorf("timeRemaining() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollector_TimeRemaining(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      Status
+		wantToEmpty float64
+		wantToFull  float64
+	}{
+		// 10 kWh at 40 %: 6 kWh missing at 2 kW
+		{name: "charging", status: Status{BatteryCharging: true, PacTotalW: -2000}, wantToEmpty: -1, wantToFull: 10800},
+		// 4 kWh left at 1 kW
+		{name: "discharging", status: Status{BatteryDischarging: true, PacTotalW: 1000}, wantToEmpty: 14400, wantToFull: -1},
+		{name: "idle", status: Status{}, wantToEmpty: -1, wantToFull: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMockBatteryServer(&LatestData{RSOC: 40, FullChargeCapacity: 10000}, &tt.status)
+			defer server.Close()
+
+			collector := NewCollector(
+				[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+				CollectorOptions{},
+			)
+			got := map[string]float64{}
+			for _, m := range collectAll(collector) {
+				switch m.Desc() {
+				case collector.timeToEmpty:
+					got["empty"] = writeMetric(t, m).GetGauge().GetValue()
+				case collector.timeToFull:
+					got["full"] = writeMetric(t, m).GetGauge().GetValue()
+				}
+			}
+			if got["empty"] != tt.wantToEmpty || got["full"] != tt.wantToFull {
+				t.Errorf("time to empty, full = %v, %v, want %v, %v", got["empty"], got["full"], tt.wantToEmpty, tt.wantToFull)
+			}
+		})
+	}
+}
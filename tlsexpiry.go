@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// tlsExpiryWarning is how close to expiry a certificate counts as a warning
+const tlsExpiryWarning = 14 * 24 * time.Hour
+
+// checkTLSExpiry connects to the battery over TLS and returns the time until
+// its certificate expires. Addresses without a port are tried on 443.
+func checkTLSExpiry(battery Battery) (time.Duration, error) {
+	address := battery.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "443")
+	}
+
+	// Only the certificate dates are inspected, so self-signed certificates
+	// of batteries and proxies must not fail the handshake
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to %s over TLS: %w", address, err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	certificates := conn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return 0, fmt.Errorf("%s presented no certificate", address)
+	}
+	return time.Until(certificates[0].NotAfter), nil
+}
+
+// tlsExpiryMonitor periodically checks the certificates of the batteries
+// queried over HTTPS and exports the time until they expire
+type tlsExpiryMonitor struct {
+	batteries func() []Battery
+
+	mu         sync.Mutex
+	expiry     map[string]float64 // Seconds until expiry by battery name
+	configured map[string]bool    // Batteries of the last check
+
+	warnings *prometheus.CounterVec
+}
+
+func newTLSExpiryMonitor(batteries func() []Battery) *tlsExpiryMonitor {
+	return &tlsExpiryMonitor{
+		batteries: batteries,
+		expiry:    make(map[string]float64),
+		warnings: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "sonnenbatterie_tls_cert_expiry_warnings_total",
+				Help: "Number of certificate checks that found the certificate expiring within 14 days",
+			},
+			[]string{"battery_name"},
+		),
+	}
+}
+
+// check refreshes the expiry of every configured battery queried over HTTPS;
+// plain HTTP batteries are never dialed. Batteries that do not answer TLS or
+// are no longer checked are dropped.
+func (m *tlsExpiryMonitor) check() {
+	var batteries []Battery
+	for _, b := range m.batteries() {
+		if b.usesTLS() {
+			batteries = append(batteries, b)
+		}
+	}
+	expiry := make(map[string]float64, len(batteries))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, battery := range batteries {
+		wg.Add(1)
+		go func(b Battery) {
+			defer wg.Done()
+			remaining, err := checkTLSExpiry(b)
+			if err != nil {
+				log.Printf("Error checking TLS certificate of %s: %v", b.Name, err)
+				return
+			}
+			if remaining < tlsExpiryWarning {
+				log.Printf("Warning: TLS certificate of %s expires in %s", b.Name, remaining.Round(time.Minute))
+				m.warnings.WithLabelValues(b.Name).Inc()
+			}
+			mu.Lock()
+			expiry[b.Name] = remaining.Seconds()
+			mu.Unlock()
+		}(battery)
+	}
+	wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expiry = expiry
+	configured := make(map[string]bool, len(batteries))
+	for _, b := range batteries {
+		configured[b.Name] = true
+	}
+	for name := range m.configured {
+		if !configured[name] {
+			m.warnings.DeleteLabelValues(name)
+		}
+	}
+	m.configured = configured
+}
+
+// run checks the certificates immediately and then every interval
+func (m *tlsExpiryMonitor) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.check()
+		<-ticker.C
+	}
+}
+
+// Describe implements prometheus.Collector
+func (m *tlsExpiryMonitor) Describe(ch chan<- *prometheus.Desc) {
+	ch <- tlsCertExpiryDesc
+	m.warnings.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (m *tlsExpiryMonitor) Collect(ch chan<- prometheus.Metric) {
+	m.mu.Lock()
+	for name, seconds := range m.expiry {
+		ch <- prometheus.MustNewConstMetric(tlsCertExpiryDesc, prometheus.GaugeValue, seconds, name)
+	}
+	m.mu.Unlock()
+	m.warnings.Collect(ch)
+}
+
+var tlsCertExpiryDesc = prometheus.NewDesc(
+	"sonnenbatterie_tls_cert_expiry_seconds",
+	"Seconds until the TLS certificate presented by the battery expires, negative once expired",
+	[]string{"battery_name"},
+	nil,
+)
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTLSServerWithExpiry starts a TLS server whose certificate expires after validFor
+func newTLSServerWithExpiry(t *testing.T, validFor time.Duration) *httptest.Server {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "battery.local"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validFor),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCheckTLSExpiry(t *testing.T) {
+	server := newTLSServerWithExpiry(t, 7*24*time.Hour)
+
+	remaining, err := checkTLSExpiry(Battery{Name: "tls-test", Address: server.Listener.Addr().String()})
+	if err != nil {
+		t.Fatalf("checkTLSExpiry() error = %v", err)
+	}
+	if want := 7 * 24 * time.Hour; remaining > want || remaining < want-time.Minute {
+		t.Errorf("checkTLSExpiry() = %s, want about %s", remaining, want)
+	}
+
+	// Plain HTTP does not complete a TLS handshake
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	if _, err := checkTLSExpiry(Battery{Name: "plain", Address: plain.Listener.Addr().String()}); err == nil {
+		t.Error("checkTLSExpiry() expected error for plain HTTP")
+	}
+}
+
+func TestTLSExpiryMonitor(t *testing.T) {
+	shortLived := newTLSServerWithExpiry(t, 3*24*time.Hour)
+	longLived := newTLSServerWithExpiry(t, 90*24*time.Hour)
+
+	// Counts connections to a battery queried over plain HTTP
+	plain, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = plain.Close() }()
+	var plainDials atomic.Int32
+	go func() {
+		for {
+			conn, err := plain.Accept()
+			if err != nil {
+				return
+			}
+			plainDials.Add(1)
+			_ = conn.Close()
+		}
+	}()
+
+	batteries := []Battery{
+		{Name: "short", Address: shortLived.Listener.Addr().String(), CACertFile: "ca.pem"},
+		{Name: "long", Address: longLived.Listener.Addr().String(), CACertFile: "ca.pem"},
+		{Name: "plain", Address: plain.Addr().String()},
+	}
+	monitor := newTLSExpiryMonitor(func() []Battery { return batteries })
+	monitor.check()
+	monitor.check()
+
+	if got := plainDials.Load(); got != 0 {
+		t.Errorf("plain HTTP battery dialed %d times, want 0", got)
+	}
+
+	if got := testutil.ToFloat64(monitor.warnings.WithLabelValues("short")); got != 2 {
+		t.Errorf("warnings for short-lived certificate = %f, want 2", got)
+	}
+	if got := testutil.ToFloat64(monitor.warnings.WithLabelValues("long")); got != 0 {
+		t.Errorf("warnings for long-lived certificate = %f, want 0", got)
+	}
+
+	metricCh := make(chan prometheus.Metric, 10)
+	monitor.Collect(metricCh)
+	close(metricCh)
+	expiry := map[string]float64{}
+	for m := range metricCh {
+		if m.Desc() == tlsCertExpiryDesc {
+			pb := writeMetric(t, m)
+			expiry[labelValue(pb, "battery_name")] = pb.GetGauge().GetValue()
+		}
+	}
+	if short := expiry["short"]; short <= 0 || short > (3*24*time.Hour).Seconds() {
+		t.Errorf("short-lived expiry = %f seconds, want up to 3 days", short)
+	}
+	if long := expiry["long"]; long <= (14 * 24 * time.Hour).Seconds() {
+		t.Errorf("long-lived expiry = %f seconds, want more than 14 days", long)
+	}
+
+	// Removed batteries disappear on the next check; the long-lived series
+	// was created by the lookup above
+	batteries = batteries[1:2]
+	monitor.check()
+	if got := testutil.CollectAndCount(monitor, "sonnenbatterie_tls_cert_expiry_seconds"); got != 1 {
+		t.Errorf("expiry series after removal = %d, want 1", got)
+	}
+	if got := testutil.CollectAndCount(monitor, "sonnenbatterie_tls_cert_expiry_warnings_total"); got != 1 {
+		t.Errorf("warning series after removal = %d, want 1", got)
+	}
+}
+
+func TestTLSExpiryMonitor_HTTPSAddress(t *testing.T) {
+	// A battery behind a proxy with a publicly trusted certificate needs no
+	// certificate files, only an https:// address
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	address, https := splitAddressScheme("https://" + server.Listener.Addr().String())
+	battery := Battery{Name: "proxied", Address: address, AuthToken: "test-token", HTTPS: https}
+	if got := battery.scheme(); got != "https" {
+		t.Fatalf("scheme() = %q, want https", got)
+	}
+
+	// The request is made over HTTPS and verified with the system roots,
+	// which do not include the test server's certificate
+	_, err := fetchLatestData(context.Background(), battery)
+	var unknownAuthority x509.UnknownAuthorityError
+	if !errors.As(err, &unknownAuthority) {
+		t.Errorf("fetchLatestData() error = %v, want unknown certificate authority", err)
+	}
+
+	monitor := newTLSExpiryMonitor(func() []Battery { return []Battery{battery} })
+	monitor.check()
+	if got := testutil.CollectAndCount(monitor, "sonnenbatterie_tls_cert_expiry_seconds"); got != 1 {
+		t.Errorf("expiry series = %d, want 1", got)
+	}
+}
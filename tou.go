@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectElectricityPrices emits the import and export prices of the active
+// time-of-use window, or the configured off-peak prices outside all windows
+func (c *Collector) collectElectricityPrices(battery Battery, latestData *LatestData, configurations *Configurations, ch chan<- prometheus.Metric) {
+	now := c.now()
+	if loc, ok := batteryLocation(configurations.TimeZone, latestData.UTCOffset); ok {
+		now = now.In(loc)
+	}
+
+	importPrice, exportPrice := c.options.OffPeakPriceImport, c.options.OffPeakPriceExport
+	slot, ok, err := activeTOUSlot(configurations.TOUSchedule, now)
+	if err != nil {
+		log.Printf("Warning: ignoring time-of-use schedule of %s: %v", battery.Name, err)
+	} else if ok {
+		importPrice, exportPrice = (*float64)(slot.PriceImport), (*float64)(slot.PriceExport)
+	}
+
+	if importPrice != nil {
+		c.gauge(ch, c.priceImport, *importPrice, battery.Name)
+	}
+	if exportPrice != nil {
+		c.gauge(ch, c.priceExport, *exportPrice, battery.Name)
+	}
+}
+
+// activeTOUSlot returns the first window of the schedule containing the local
+// time of now. It reports false outside all windows.
+func activeTOUSlot(schedule touSchedule, now time.Time) (TOUSlot, bool, error) {
+	minute := now.Hour()*60 + now.Minute()
+	for _, slot := range schedule {
+		start, err := minuteOfDay(slot.Start)
+		if err != nil {
+			return TOUSlot{}, false, err
+		}
+		stop, err := minuteOfDay(slot.Stop)
+		if err != nil {
+			return TOUSlot{}, false, err
+		}
+
+		inside := start <= minute && minute < stop
+		if stop <= start {
+			inside = minute >= start || minute < stop
+		}
+		if inside {
+			return slot, true, nil
+		}
+	}
+	return TOUSlot{}, false, nil
+}
+
+// minuteOfDay parses "HH:MM" into minutes since midnight, allowing "24:00"
+func minuteOfDay(value string) (int, error) {
+	hours, minutes, found := strings.Cut(strings.TrimSpace(value), ":")
+	if !found {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	h, err := strconv.Atoi(hours)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+
+	total := h*60 + m
+	if h < 0 || m < 0 || m > 59 || total > 24*60 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return total, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOUSchedule_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		wantSlots int
+		wantErr   bool
+	}{
+		{name: "array", input: `[{"start": "06:00", "stop": "10:00"}]`, wantSlots: 1},
+		{name: "string encoded", input: `"[{\"start\":\"06:00\",\"stop\":\"10:00\"},{\"start\":\"17:00\",\"stop\":\"21:00\"}]"`, wantSlots: 2},
+		{name: "empty string", input: `""`, wantSlots: 0},
+		{name: "empty array", input: `[]`, wantSlots: 0},
+		{name: "invalid", input: `"not a schedule"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var schedule touSchedule
+			err := json.Unmarshal([]byte(tt.input), &schedule)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(schedule) != tt.wantSlots {
+				t.Errorf("Unmarshal() slots = %d, want %d", len(schedule), tt.wantSlots)
+			}
+		})
+	}
+}
+
+func TestActiveTOUSlot_Fixture(t *testing.T) {
+	content, err := os.ReadFile("testdata/tou_schedule.json")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	var schedule touSchedule
+	if err := json.Unmarshal(content, &schedule); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	tests := []struct {
+		clock      string
+		wantOK     bool
+		wantImport float64
+	}{
+		{clock: "05:59", wantOK: false},
+		{clock: "06:00", wantOK: true, wantImport: 0.35},
+		{clock: "09:59", wantOK: true, wantImport: 0.35},
+		{clock: "10:00", wantOK: false},
+		{clock: "16:59", wantOK: false},
+		{clock: "17:00", wantOK: true, wantImport: 0.42},
+		{clock: "21:00", wantOK: false},
+		{clock: "22:00", wantOK: true, wantImport: 0.18},
+		{clock: "00:00", wantOK: true, wantImport: 0.18},
+		{clock: "04:59", wantOK: true, wantImport: 0.18},
+		{clock: "05:00", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.clock, func(t *testing.T) {
+			now, err := time.Parse("15:04", tt.clock)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			slot, ok, err := activeTOUSlot(schedule, now)
+			if err != nil {
+				t.Fatalf("activeTOUSlot() error = %v", err)
+			}
+			if ok != tt.wantOK {
+				t.Fatalf("activeTOUSlot() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && float64(*slot.PriceImport) != tt.wantImport {
+				t.Errorf("import price = %f, want %f", float64(*slot.PriceImport), tt.wantImport)
+			}
+		})
+	}
+}
+
+func TestMinuteOfDay(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int
+		wantErr bool
+	}{
+		{input: "00:00", want: 0},
+		{input: "06:30", want: 390},
+		{input: "24:00", want: 1440},
+		{input: "24:01", wantErr: true},
+		{input: "12:60", wantErr: true},
+		{input: "noon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := minuteOfDay(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("minuteOfDay() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("minuteOfDay() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollector_ElectricityPrices(t *testing.T) {
+	content, err := os.ReadFile("testdata/tou_schedule.json")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	configurations, err := json.Marshal(map[string]string{
+		"TimeZone":        "Europe/Berlin",
+		"EM_ToU_Schedule": string(content),
+	})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/latestdata", "/api/v2/status":
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/v2/configurations":
+			_, _ = w.Write(configurations)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	offPeakImport, offPeakExport := 0.28, 0.07
+	collector := NewCollector(
+		[]Battery{{Name: "test-battery", Address: server.URL[7:], AuthToken: "test-token"}},
+		CollectorOptions{Currency: "chf", OffPeakPriceImport: &offPeakImport, OffPeakPriceExport: &offPeakExport},
+	)
+
+	tests := []struct {
+		name       string
+		now        time.Time
+		wantImport string
+		wantExport string
+	}{
+		// 07:59 UTC is 09:59 in Berlin, inside the morning window
+		{name: "morning window", now: time.Date(2025, 6, 3, 7, 59, 0, 0, time.UTC), wantImport: "0.35", wantExport: "0.08"},
+		{name: "after morning window", now: time.Date(2025, 6, 3, 8, 0, 0, 0, time.UTC), wantImport: "0.28", wantExport: "0.07"},
+		{name: "night window without export price", now: time.Date(2025, 6, 3, 20, 0, 0, 0, time.UTC), wantImport: "0.18"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector.now = func() time.Time { return tt.now }
+
+			var importPrice, exportPrice string
+			for _, m := range collectAll(collector) {
+				switch m.Desc() {
+				case collector.priceImport:
+					importPrice = strconv.FormatFloat(writeMetric(t, m).GetGauge().GetValue(), 'f', -1, 64)
+				case collector.priceExport:
+					exportPrice = strconv.FormatFloat(writeMetric(t, m).GetGauge().GetValue(), 'f', -1, 64)
+				}
+			}
+			if importPrice != tt.wantImport || exportPrice != tt.wantExport {
+				t.Errorf("prices = %q, %q, want %q, %q", importPrice, exportPrice, tt.wantImport, tt.wantExport)
+			}
+		})
+	}
+
+	if got := collector.priceImport.String(); !strings.Contains(got, `"sonnenbatterie_electricity_price_import_chf_kwh"`) {
+		t.Errorf("import price desc = %s, want the currency in the name", got)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// leakAge is how long a response body may stay open before it counts as
+// leaked; requests time out after 10s, so anything older was never closed
+const leakAge = time.Minute
+
+// trackingTransport counts responses whose body has not been closed yet and
+// reports bodies that stay open longer than leakAge
+type trackingTransport struct {
+	next http.RoundTripper
+	open atomic.Int64
+
+	mu     sync.Mutex
+	bodies map[*wrappedReadCloser]time.Time // Open bodies by time the response arrived
+
+	leaked prometheus.Counter
+}
+
+func newTrackingTransport(next http.RoundTripper) *trackingTransport {
+	return &trackingTransport{
+		next:   next,
+		bodies: make(map[*wrappedReadCloser]time.Time),
+		leaked: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "sonnenbatterie_leaked_connections_total",
+			Help: "Number of battery API responses whose body was not closed within a minute",
+		}),
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *trackingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.track(t.next.RoundTrip(req))
+}
+
+// via returns a RoundTripper sending requests through next instead, with the
+// response bodies tracked by t
+func (t *trackingTransport) via(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return t.track(next.RoundTrip(req))
+	})
+}
+
+// track wraps the body of a response so it is counted as open until closed
+func (t *trackingTransport) track(resp *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	body := &wrappedReadCloser{ReadCloser: resp.Body, transport: t}
+	t.open.Add(1)
+	t.mu.Lock()
+	t.bodies[body] = time.Now()
+	t.mu.Unlock()
+
+	resp.Body = body
+	return resp, nil
+}
+
+// roundTripperFunc adapts a function to http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// release marks a body as closed
+func (t *trackingTransport) release(body *wrappedReadCloser) {
+	t.open.Add(-1)
+	t.mu.Lock()
+	delete(t.bodies, body)
+	t.mu.Unlock()
+}
+
+// detectLeaks counts and forgets bodies opened before now minus leakAge. They
+// stay in the open connection count until they are closed.
+func (t *trackingTransport) detectLeaks(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for body, opened := range t.bodies {
+		if now.Sub(opened) < leakAge {
+			continue
+		}
+		log.Printf("Warning: response body opened at %s was never closed", opened.Format(time.RFC3339))
+		t.leaked.Inc()
+		delete(t.bodies, body)
+	}
+}
+
+// watchLeaks runs detectLeaks every interval
+func (t *trackingTransport) watchLeaks(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		t.detectLeaks(now)
+	}
+}
+
+// Describe implements prometheus.Collector
+func (t *trackingTransport) Describe(ch chan<- *prometheus.Desc) {
+	ch <- openConnectionsDesc
+	t.leaked.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (t *trackingTransport) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(openConnectionsDesc, prometheus.GaugeValue, float64(t.open.Load()))
+	t.leaked.Collect(ch)
+}
+
+var openConnectionsDesc = prometheus.NewDesc(
+	"sonnenbatterie_open_connections",
+	"Number of battery API responses whose body has not been closed yet",
+	nil,
+	nil,
+)
+
+// wrappedReadCloser reports to its transport when the body is closed
+type wrappedReadCloser struct {
+	io.ReadCloser
+	transport *trackingTransport
+	closed    atomic.Bool
+}
+
+// Close implements io.Closer; only the first call is counted
+func (w *wrappedReadCloser) Close() error {
+	if w.closed.CompareAndSwap(false, true) {
+		w.transport.release(w)
+	}
+	return w.ReadCloser.Close()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTrackingTransport_LeakDetection(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	transport := newTrackingTransport(http.DefaultTransport)
+	client := &http.Client{Transport: transport}
+
+	// A well-behaved request closes its body
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	_ = resp.Body.Close()
+	_ = resp.Body.Close() // Closing twice must not be counted twice
+
+	// A leaking request never closes its body
+	leaking, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	if got := transport.open.Load(); got != 1 {
+		t.Fatalf("open connections = %d, want 1", got)
+	}
+
+	// Still within the request lifetime, nothing is reported
+	transport.detectLeaks(time.Now())
+	if got := testutil.ToFloat64(transport.leaked); got != 0 {
+		t.Errorf("leaked connections before leakAge = %f, want 0", got)
+	}
+
+	// Once leakAge has passed the detector fires, and only once per body
+	later := time.Now().Add(2 * leakAge)
+	transport.detectLeaks(later)
+	transport.detectLeaks(later)
+	if got := testutil.ToFloat64(transport.leaked); got != 1 {
+		t.Errorf("leaked connections = %f, want 1", got)
+	}
+
+	// Closing late still brings the open count back down
+	_ = leaking.Body.Close()
+	if got := transport.open.Load(); got != 0 {
+		t.Errorf("open connections after close = %d, want 0", got)
+	}
+}
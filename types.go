@@ -1,10 +1,73 @@
 package main
 
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
 // Battery represents a single SonnenBatterie instance
 type Battery struct {
 	Name      string
-	IP        string
+	Address   string // IP address or hostname, optionally with a port
 	AuthToken string
+	Group     string // Parallel system the battery belongs to, empty if standalone
+	Location  string // Site the battery is installed at, empty if unknown
+
+	// DesignCapacityWh is the configured installed capacity, used when the
+	// battery does not report it. 0 if unknown
+	DesignCapacityWh float64
+
+	// CapacityUnit is the unit of FullChargeCapacity, "wh" or "mwh", or
+	// empty to detect it from the reported value
+	CapacityUnit string
+
+	// Expectation holds the configuration values the battery should report;
+	// a difference is reported as configuration drift
+	Expectation ConfigExpectation
+
+	// MinScrapeInterval is the shortest time between two queries of the
+	// battery API; scrapes in between serve the previous results. 0 disables it
+	MinScrapeInterval time.Duration
+
+	// MetricFilter lists the names of the metrics emitted for the battery,
+	// besides sonnenbatterie_scrape_success. Empty emits all metrics
+	MetricFilter []string
+
+	// HTTPS queries the battery over HTTPS, verified with the system roots
+	// unless CACertFile is set. Set by an https:// prefix on the address
+	HTTPS bool
+
+	// Client certificate and key presented to the battery, and the CA
+	// certificates it is verified with. Setting a client certificate or CA
+	// also queries the battery over HTTPS
+	ClientCertFile string
+	ClientKeyFile  string
+	CACertFile     string
+
+	// TokenRefreshFunc, if set, is called to obtain a new Auth-Token when the
+	// battery rejects the current one
+	TokenRefreshFunc func(ctx context.Context) (string, error)
+
+	auth    *authState     // Shared by all copies of the battery
+	metrics *clientMetrics // Request metrics of the collector, nil records none
+}
+
+// ConfigExpectation holds expected configuration values, nil where any value
+// is accepted
+type ConfigExpectation struct {
+	ExpectedOperatingMode *int // EM_OperatingMode
+	ExpectedBackupReserve *int // EM_USOC in percent
+}
+
+// authState holds an Auth-Token obtained through TokenRefreshFunc
+type authState struct {
+	mu    sync.RWMutex
+	token string
 }
 
 // ICStatus contains internal component status information
@@ -13,19 +76,25 @@ type ICStatus struct {
 	StateCoreControlModule string `json:"statecorecontrolmodule"`
 	StateInverter          string `json:"stateinverter"`
 	NrBatteryModules       int    `json:"nrbatterymodules"`
+	NrCellsPerModule       int    `json:"nrcellspermodule"` // 0 if not reported by the firmware
+
+	// Raw holds the full ic_status document, including nested flag objects
+	Raw map[string]any `json:"-"`
 }
 
 // LatestData represents the response from /api/v2/latestdata
 // This endpoint combines status and system information
 type LatestData struct {
 	ConsumptionW       float64  `json:"Consumption_W"`
+	ConsumptionAvg     *float64 `json:"Consumption_Avg"` // Smoothed consumption used by the energy manager
 	FullChargeCapacity int      `json:"FullChargeCapacity"`
 	GridFeedInW        float64  `json:"GridFeedIn_W"`
 	PacTotalW          float64  `json:"Pac_total_W"`
 	ProductionW        float64  `json:"Production_W"`
-	RSOC               int      `json:"RSOC"` // Relative State of Charge
-	USOC               int      `json:"USOC"` // User State of Charge
-	Timestamp          string   `json:"Timestamp"`
+	RSOC               int      `json:"RSOC"`      // Relative State of Charge
+	USOC               int      `json:"USOC"`      // User State of Charge
+	Timestamp          string   `json:"Timestamp"` // Local time of the battery
+	UTCOffset          *float64 `json:"UTC_Offet"` // Hours east of UTC, misspelled by the API
 	ICStatus           ICStatus `json:"ic_status"`
 }
 
@@ -42,4 +111,223 @@ type Status struct {
 	Uac                float64 `json:"Uac"`  // AC Voltage
 	Ubat               float64 `json:"Ubat"` // Battery Voltage
 	Fac                float64 `json:"Fac"`  // AC Frequency
+
+	// DC-coupled solar input, only reported by DC-coupled installations
+	DCInputPowerW   *float64 `json:"DCPower"`
+	DCInputVoltage  *float64 `json:"DCVoltage"`
+	DCInputCurrentA *float64 `json:"DCCurrent"`
+}
+
+// BatteryData represents the response from /api/v2/battery
+// This endpoint provides battery module details; fields are pointers
+// because not every firmware reports all of them
+type BatteryData struct {
+	MinimumCellVoltage *float64 `json:"minimumcellvoltage"`
+	MaximumCellVoltage *float64 `json:"maximumcellvoltage"`
+	SystemCurrent      *float64 `json:"systemcurrent"` // Pack current, sign varies by firmware
+
+	BatteryHeaterActive *bool `json:"batteryheateractive"` // Module heating for cold temperatures
+	CoolingActive       *bool `json:"coolingactive"`
+
+	Modules []BatteryModule `json:"modules"` // Per-module status, empty if not reported
+}
+
+// BatteryModule is the status of a single module in /api/v2/battery
+type BatteryModule struct {
+	ModuleID    int     `json:"moduleid"`
+	Status      string  `json:"status"`
+	Voltage     float64 `json:"voltage"`
+	Temperature float64 `json:"temperature"` // Degrees Celsius
+}
+
+// InverterData represents the response from /api/v2/inverter
+// Fields are pointers because not every firmware reports all of them
+type InverterData struct {
+	CosPhi   *float64 `json:"cosphi"`    // Power factor, if reported directly
+	SacTotal *float64 `json:"sac_total"` // Apparent power in volt-amperes
+	QacTotal *float64 `json:"qac_total"` // Reactive power in var, negative when capacitive
+}
+
+// PowermeterReading is a single meter channel from /api/v2/powermeter
+type PowermeterReading struct {
+	Channel int     `json:"channel"`
+	KwhPos  float64 `json:"kwh_pos"` // Cumulative energy imported by the meter
+	KwhNeg  float64 `json:"kwh_neg"` // Cumulative energy exported by the meter
+}
+
+// Configurations represents the response from /api/v2/configurations
+// The endpoint reports most values as strings and omits keys the firmware does not know
+type Configurations struct {
+	UpdateAvailable  *flexBool   `json:"UpdateAvailable"`
+	UpdateInProgress *flexBool   `json:"UpdateInProgress"`
+	TimeZone         *string     `json:"TimeZone"`              // IANA zone name, e.g. "Europe/Berlin"
+	SerialNumber     *string     `json:"DE_Ticket_Number"`      // Unit serial used for support cases
+	CommissionedAt   *string     `json:"DE_Commissioning_Date"` // Installation date, local to the battery
+	BatteryModules   *flexFloat  `json:"IC_BatteryModules"`
+	ModuleCapacityWh *flexFloat  `json:"CM_MarketingModuleCapacity"` // Usable capacity per module
+	SoftwareVersion  *string     `json:"DE_Software"`
+	Model            *string     `json:"ERP_ArticleName"`    // Product name, e.g. "sonnenBatterie 10"
+	HardwareVersion  *string     `json:"IC_HardwareVersion"` // Revision of the system board
+	InverterType     *string     `json:"IC_InverterType"`
+	InverterMaxPower *flexFloat  `json:"IC_InverterMaxPower_w"` // Rated inverter power in watts
+	OperatingMode    *flexFloat  `json:"EM_OperatingMode"`
+	BackupReservePct *flexFloat  `json:"EM_USOC"`              // Charge kept back for grid outages
+	MinSOCPct        *flexFloat  `json:"EM_MinSOC"`            // Lowest charge the energy manager discharges to
+	ChargeLimitW     *flexFloat  `json:"EM_ChargingLimitW"`    // Highest allowed charging power
+	DischargeLimitW  *flexFloat  `json:"EM_DischargingLimitW"` // Highest allowed discharging power
+	TOUSchedule      touSchedule `json:"EM_ToU_Schedule"`      // Time-of-use windows, empty if none are set
+	PVConfiguration
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Blank strings, which firmware
+// reports for settings it does not have, leave the field unset.
+func (c *Configurations) UnmarshalJSON(data []byte) error {
+	data, err := withoutBlankStrings(data)
+	if err != nil {
+		return err
+	}
+	type plain Configurations
+	return json.Unmarshal(data, (*plain)(c))
+}
+
+// PVConfiguration is the solar installation entered during commissioning.
+// Firmware without PV configuration omits the keys.
+type PVConfiguration struct {
+	PeakPowerW  *flexFloat `json:"PV_PeakPower_w"` // Installed peak power in watts
+	StringCount *flexFloat `json:"PV_StringCount"`
+	Orientation *string    `json:"PV_Orientation"` // e.g. "south"
+}
+
+// TOUSlot is a time-of-use window in the battery's local time. Stop before
+// start wraps past midnight. Prices are optional and per kWh.
+type TOUSlot struct {
+	Start       string     `json:"start"` // "HH:MM", inclusive
+	Stop        string     `json:"stop"`  // "HH:MM", exclusive
+	PriceImport *flexFloat `json:"price_import"`
+	PriceExport *flexFloat `json:"price_export"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler. A blank price leaves it unset.
+func (s *TOUSlot) UnmarshalJSON(data []byte) error {
+	data, err := withoutBlankStrings(data)
+	if err != nil {
+		return err
+	}
+	type plain TOUSlot
+	return json.Unmarshal(data, (*plain)(s))
+}
+
+// withoutBlankStrings removes the members of a JSON object whose value is a
+// blank string, so they decode like missing keys
+func withoutBlankStrings(data []byte) ([]byte, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil || members == nil {
+		// Not an object; let the caller's decoding report it
+		return data, nil
+	}
+	blank := false
+	for key, value := range members {
+		var str string
+		if json.Unmarshal(value, &str) == nil && strings.TrimSpace(str) == "" {
+			delete(members, key)
+			blank = true
+		}
+	}
+	if !blank {
+		return data, nil
+	}
+	return json.Marshal(members)
+}
+
+// touSchedule decodes the time-of-use schedule, which firmware reports either
+// as a JSON array or as a string holding one
+type touSchedule []TOUSlot
+
+// UnmarshalJSON implements json.Unmarshaler
+func (s *touSchedule) UnmarshalJSON(data []byte) error {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err == nil {
+		if strings.TrimSpace(encoded) == "" {
+			*s = nil
+			return nil
+		}
+		data = []byte(encoded)
+	}
+
+	var slots []TOUSlot
+	if err := json.Unmarshal(data, &slots); err != nil {
+		return fmt.Errorf("invalid time-of-use schedule: %w", err)
+	}
+	*s = slots
+	return nil
+}
+
+// flexFloat decodes numbers reported as JSON numbers or strings. A blank
+// string decodes as 0.
+type flexFloat float64
+
+// UnmarshalJSON implements json.Unmarshaler
+func (f *flexFloat) UnmarshalJSON(data []byte) error {
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	switch v := raw.(type) {
+	case float64:
+		*f = flexFloat(v)
+	case string:
+		if strings.TrimSpace(v) == "" {
+			*f = 0
+			return nil
+		}
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", v)
+		}
+		*f = flexFloat(parsed)
+	default:
+		return fmt.Errorf("invalid number %s", data)
+	}
+	return nil
+}
+
+// formatFlexFloat formats a number for use as a label value, "" if unknown
+func formatFlexFloat(f *flexFloat) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(float64(*f), 'f', -1, 64)
+}
+
+// flexBool decodes booleans reported as JSON booleans, numbers or strings. A
+// blank string decodes as false.
+type flexBool bool
+
+// UnmarshalJSON implements json.Unmarshaler
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	switch v := raw.(type) {
+	case bool:
+		*b = flexBool(v)
+	case float64:
+		*b = v != 0
+	case string:
+		if strings.TrimSpace(v) == "" {
+			*b = false
+			return nil
+		}
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", v)
+		}
+		*b = flexBool(parsed)
+	default:
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+	return nil
 }